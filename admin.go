@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// startAdminServer 启动管理HTTP服务，提供连接池统计、命令耗时统计和Prometheus指标
+func (proxy *RedisClusterProxy) startAdminServer(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pool", proxy.handlePoolStats)
+	mux.HandleFunc("/latency", proxy.handleLatencyStats)
+	mux.Handle("/metrics", promhttp.HandlerFor(proxy.metrics, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	proxy.adminServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := proxy.adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			LogError("管理服务异常退出: %v", err)
+		}
+	}()
+
+	LogInfo("管理服务启动成功，监听地址: %s", address)
+	return nil
+}
+
+// handlePoolStats 处理GET /pool，返回各节点连接池的统计信息
+func (proxy *RedisClusterProxy) handlePoolStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"nodes": proxy.pool.Stats()})
+}
+
+// handleLatencyStats 处理GET /latency，返回各命令最近耗时的百分位和重置以来的最大耗时，?reset=1时返回后清空统计
+func (proxy *RedisClusterProxy) handleLatencyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reset := r.URL.Query().Get("reset") == "1"
+	writeJSON(w, map[string]interface{}{"commands": proxy.latency.Snapshot(reset)})
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		LogWarn("输出管理接口响应失败: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// handleBitOpFanOut 处理key分布在多个slot的BITOP：逐个读取源key，在代理中完成位运算，
+// 再将结果写入目标key所在的节点，返回结果字符串的长度。
+// 与Redis一致，不存在的源key视为空字符串，较短的字符串按0补齐，结果为空时删除目标key
+func (proxy *RedisClusterProxy) handleBitOpFanOut(clientConn net.Conn, command []string, keys []string) error {
+	operation := strings.ToUpper(command[1])
+	destKey, sourceKeys := keys[0], keys[1:]
+
+	switch operation {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(sourceKeys) != 1 {
+			return fmt.Errorf("BITOP NOT只能指定一个源key")
+		}
+	default:
+		return fmt.Errorf("跨slot的BITOP不支持操作 %s，只支持AND、OR、XOR和NOT", command[1])
+	}
+
+	// 每个源key单独读取，非字符串类型的key由后端返回WRONGTYPE
+	groups := make([]*slotGroup, len(sourceKeys))
+	for i, key := range sourceKeys {
+		groups[i] = &slotGroup{slot: CalculateSlot(key), keys: []string{key}, indexes: []int{i}}
+	}
+	results := proxy.executeOnSlots("BITOP", groups, func(group *slotGroup) []string {
+		return []string{"GET", group.keys[0]}
+	})
+	for _, result := range results {
+		if result.value != nil && result.value.IsError() {
+			_, err := clientConn.Write([]byte(result.value.Format()))
+			return err
+		}
+	}
+	if err := failedNodesError("BITOP", results); err != nil {
+		return err
+	}
+
+	sources := make([][]byte, len(results))
+	for i, result := range results {
+		if !result.value.IsNil {
+			sources[i] = []byte(result.value.Str)
+		}
+	}
+	value := bitOp(operation, sources)
+
+	destCommand := []string{"SET", destKey, string(value)}
+	if len(value) == 0 {
+		destCommand = []string{"DEL", destKey}
+	}
+	destResult := proxy.executeWithMoved(proxy.selectNodeByKey("BITOP", destKey), destCommand)
+	if destResult.err != nil {
+		return fmt.Errorf("BITOP写入目标key失败: %v", destResult.err)
+	}
+
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(int64(len(value)))))
+	return err
+}
+
+// bitOp 对源字符串执行AND/OR/XOR/NOT位运算，结果长度为最长源字符串的长度
+func bitOp(operation string, sources [][]byte) []byte {
+	length := 0
+	for _, source := range sources {
+		if len(source) > length {
+			length = len(source)
+		}
+	}
+
+	result := make([]byte, length)
+	if operation == "NOT" {
+		for i, b := range sources[0] {
+			result[i] = ^b
+		}
+		return result
+	}
+
+	for i := 0; i < length; i++ {
+		var value byte
+		for j, source := range sources {
+			var b byte
+			if i < len(source) {
+				b = source[i]
+			}
+			if j == 0 {
+				value = b
+				continue
+			}
+			switch operation {
+			case "AND":
+				value &= b
+			case "OR":
+				value |= b
+			case "XOR":
+				value ^= b
+			}
+		}
+		result[i] = value
+	}
+	return result
+}
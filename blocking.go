@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	blockingDialTimeout   = 5 * time.Second // 阻塞命令建立独占后端连接的超时时间
+	blockingDeadlineSlack = 5 * time.Second // 阻塞命令读取响应的超时时间比命令自身的超时时间多出的部分
+)
+
+// errBlockingClientGone 阻塞期间客户端断开了连接，不需要再向客户端返回错误
+var errBlockingClientGone = errors.New("客户端已断开连接")
+
+// isBlockingCommand 判断命令是否需要使用独占的后端连接：命令表中的阻塞命令，以及带BLOCK选项的XREAD/XREADGROUP
+func isBlockingCommand(spec *commandSpec, command []string) bool {
+	if spec.hasFlag(cmdBlocking) {
+		return true
+	}
+	switch strings.ToUpper(command[0]) {
+	case "XREAD", "XREADGROUP":
+		return streamBlockPos(command) > 0
+	}
+	return false
+}
+
+// streamBlockPos 返回XREAD/XREADGROUP在STREAMS之前的BLOCK参数值的位置，没有BLOCK时返回-1。
+// GROUP、COUNT、NOACK等其他选项原样转发，这里只跳过它们的参数
+func streamBlockPos(command []string) int {
+	for i := 1; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "GROUP":
+			i += 2
+		case "COUNT":
+			i++
+		case "BLOCK":
+			if i+1 < len(command) {
+				return i + 1
+			}
+			return -1
+		case "STREAMS":
+			return -1
+		}
+	}
+	return -1
+}
+
+// blockingTimeout 取出阻塞命令的timeout参数（秒，可以是小数），XREAD/XREADGROUP为BLOCK的毫秒数，0表示一直阻塞
+func blockingTimeout(command []string) (time.Duration, error) {
+	pos := len(command) - 1
+	switch strings.ToUpper(command[0]) {
+	case "BLMPOP", "BZMPOP":
+		pos = 1
+	case "XREAD", "XREADGROUP":
+		millis, err := strconv.ParseInt(command[streamBlockPos(command)], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("timeout is not an integer or out of range")
+		}
+		if millis < 0 {
+			return 0, fmt.Errorf("timeout is negative")
+		}
+		return time.Duration(millis) * time.Millisecond, nil
+	}
+	if pos < 1 || pos >= len(command) {
+		return 0, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(command[0]))
+	}
+
+	seconds, err := strconv.ParseFloat(command[pos], 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// executeBlocking 在独占的后端连接上执行BLPOP、BLMOVE、BZPOPMIN、XREAD BLOCK等阻塞命令。连接不来自连接池，
+// 长时间阻塞不会占用其他客户端的连接；读取响应的超时时间按命令自身的timeout设置，timeout为0时不超时。
+// 阻塞期间客户端断开时关闭后端连接，取消阻塞，避免弹出的元素没有客户端接收
+func (proxy *RedisClusterProxy) executeBlocking(session *clientSession, command []string, backendAddr string) error {
+	timeout, err := blockingTimeout(command)
+	if err != nil {
+		return err
+	}
+
+	// 阻塞之前写出流水线中之前命令的响应
+	if err := session.conn.Flush(); err != nil {
+		return err
+	}
+
+	asking := false
+	for redirect := 0; ; redirect++ {
+		response, err := proxy.executeBlockingOnNode(session, command, backendAddr, timeout, asking)
+		if err == errBlockingClientGone {
+			// 由handleConnection读取客户端命令时结束连接
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if redirect < 5 && proxy.shouldAutoRedirect(command) {
+			if isMoved, slot, redirectAddr := proxy.protocol.IsMovedError(response); isMoved {
+				session.log.Info("阻塞命令收到MOVED重定向: slot=%s，改为在节点 %s 执行", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("MOVED")
+				backendAddr, asking = redirectAddr, false
+				continue
+			}
+			if isAsk, slot, redirectAddr := proxy.protocol.IsAskError(response); isAsk {
+				session.log.Info("阻塞命令收到ASK重定向: slot=%s，改为在节点 %s 执行", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("ASK")
+				backendAddr, asking = redirectAddr, true
+				continue
+			}
+		}
+		return proxy.writeClient(session, response)
+	}
+}
+
+// executeBlockingOnNode 建立到nodeAddr的独占连接执行阻塞命令并返回响应，asking为true时先发送ASKING
+func (proxy *RedisClusterProxy) executeBlockingOnNode(session *clientSession, command []string, nodeAddr string, timeout time.Duration, asking bool) (string, error) {
+	backendConn, err := net.DialTimeout("tcp", nodeAddr, blockingDialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	defer backendConn.Close()
+
+	if err := proxy.applyClientName(session, backendConn); err != nil {
+		return "", err
+	}
+
+	batch := [][]string{command}
+	if asking {
+		batch = [][]string{{"ASKING"}, command}
+	}
+	for _, cmd := range batch {
+		if err := proxy.sendCommandToBackend(backendConn, cmd); err != nil {
+			return "", fmt.Errorf("发送命令到后端失败: %v", err)
+		}
+	}
+
+	if timeout > 0 {
+		backendConn.SetReadDeadline(time.Now().Add(timeout + blockingDeadlineSlack))
+	}
+
+	// 阻塞期间由另一个协程等待客户端的数据，客户端断开时关闭后端连接。
+	// 收到阻塞命令的响应后设置客户端连接的读超时使等待的协程返回，之后恢复
+	clientGone := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		if _, err := session.reader.Peek(1); err != nil && !isTimeoutError(err) {
+			close(clientGone)
+			backendConn.Close()
+		}
+	}()
+	defer func() {
+		session.conn.SetReadDeadline(time.Now())
+		<-watchDone
+		session.conn.SetReadDeadline(time.Time{})
+	}()
+
+	reader := bufio.NewReaderSize(backendConn, proxy.currentConfig().GetReadBufferSize())
+	var response string
+	for range batch {
+		if response, err = proxy.readResponse(reader); err != nil {
+			select {
+			case <-clientGone:
+				session.log.Info("客户端 %s 在阻塞命令 %s 执行期间断开连接，已取消", session.describe(), command[0])
+				return "", errBlockingClientGone
+			default:
+			}
+			return "", fmt.Errorf("读取后端响应失败: %v", err)
+		}
+		if asking && !strings.HasPrefix(response, "+OK") && len(batch) > 1 {
+			return "", fmt.Errorf("ASKING命令响应错误: %s", strings.TrimSpace(response))
+		}
+		asking = false
+	}
+	return response, nil
+}
+
+// isTimeoutError 判断是否为读写超时错误
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBlockingZeroTimeout timeout为0的BLPOP一直阻塞，直到其他客户端写入元素，不受固定读取超时的限制
+func TestBlockingZeroTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("阻塞5秒")
+	}
+	tc := newTestCluster(t, 3, nil)
+	blocked := tc.client(t)
+	pusher := tc.client(t)
+
+	start := time.Now()
+	blocked.send("BLPOP", "queue", "0")
+	time.Sleep(5 * time.Second)
+	pusher.expectReply(":1\r\n", "LPUSH", "queue", "job-1")
+
+	if got := blocked.readWithin(5 * time.Second); got != "*2\r\n"+bulk("queue")+bulk("job-1") {
+		t.Fatalf("BLPOP响应 = %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Second {
+		t.Errorf("BLPOP应阻塞到LPUSH之后，实际 %v 后返回", elapsed)
+	}
+
+	// 阻塞期间连接池中的连接不被占用，其他命令正常执行
+	pusher.expectReply(":0\r\n", "LLEN", "queue")
+}
+
+// TestBlockingTimeout 读取超时按命令的timeout设置，超时后返回nil
+func TestBlockingTimeout(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	client := tc.client(t)
+
+	start := time.Now()
+	if got := client.do("BLPOP", "empty", "0.3"); got != "*-1\r\n" {
+		t.Fatalf("BLPOP超时应返回nil，实际为 %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("BLPOP应在timeout之后返回，实际耗时 %v", elapsed)
+	}
+	client.expectErrorPrefix("ERR timeout is negative", "BLPOP", "empty", "-1")
+	client.expectErrorPrefix("ERR timeout is not a float or out of range", "BLPOP", "empty", "abc")
+}
+
+// TestBlockingClientDisconnect 客户端在阻塞期间断开时关闭独占的后端连接，之后写入的元素留在列表中
+func TestBlockingClientDisconnect(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	node := tc.nodes[0]
+	client := tc.client(t)
+	client.expectReply("+PONG\r\n", "PING")
+	idle := node.CurrentConnectionCount()
+
+	client.send("BLPOP", "queue", "0")
+	if !waitFor(t, 2*time.Second, func() bool { return node.CurrentConnectionCount() == idle+1 }) {
+		t.Fatalf("BLPOP应使用独占的后端连接，节点连接数为 %d，之前为 %d", node.CurrentConnectionCount(), idle)
+	}
+	// miniredis在阻塞结束前不会发现连接关闭，等待代理关闭后端连接；没有关闭时之后写入的元素会被弹出到已断开的连接
+	client.conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	other := tc.client(t)
+	other.expectReply(":1\r\n", "LPUSH", "queue", "job-1")
+	other.expectReply(":1\r\n", "LLEN", "queue")
+	other.expectReply(bulk("job-1"), "LINDEX", "queue", "0")
+}
+
+// clusterNodesRequests 返回节点收到的CLUSTER NODES次数之和
+func clusterNodesRequests(nodes ...*fakeNode) int {
+	count := 0
+	for _, node := range nodes {
+		for _, command := range node.received("CLUSTER") {
+			if len(command) > 1 && command[1] == "NODES" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// TestBlockingMovedRefresh 阻塞命令收到MOVED时在重定向的节点上执行，并请求刷新集群信息
+func TestBlockingMovedRefresh(t *testing.T) {
+	target := startFakeNode(t, func(command []string) string {
+		return "*2\r\n" + bulk("queue") + bulk("job-1")
+	})
+	source := startFakeNode(t, func(command []string) string {
+		if command[0] == "BLPOP" {
+			return "-MOVED 5808 " + target.addr + "\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	client := startFakeMasters(t, []*fakeNode{source}, func(config *Config) {
+		config.AutoRedirect = true
+	}).client(t)
+	before := clusterNodesRequests(source, target)
+
+	client.expectReply("*2\r\n"+bulk("queue")+bulk("job-1"), "BLPOP", "queue", "0")
+	if len(target.received("BLPOP")) != 1 {
+		t.Error("BLPOP应在重定向的节点上执行")
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return clusterNodesRequests(source, target) > before }) {
+		t.Error("阻塞命令收到MOVED后应刷新集群信息")
+	}
+}
+
+// TestBlockingXReadGroup XREADGROUP BLOCK在独占的后端连接上阻塞，其他客户端XADD之后返回新的条目
+func TestBlockingXReadGroup(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	node := tc.nodes[0]
+	reader, writer := tc.client(t), tc.client(t)
+	reader.expectReply("+OK\r\n", "XGROUP", "CREATE", "events", "workers", "$", "MKSTREAM")
+	idle := node.CurrentConnectionCount()
+
+	reader.send("XREADGROUP", "GROUP", "workers", "alice", "BLOCK", "0", "STREAMS", "events", ">")
+	if !waitFor(t, 2*time.Second, func() bool { return node.CurrentConnectionCount() == idle+1 }) {
+		t.Fatalf("XREADGROUP BLOCK应使用独占的后端连接，节点连接数为 %d，之前为 %d", node.CurrentConnectionCount(), idle)
+	}
+	writer.expectReply(bulk("1-1"), "XADD", "events", "1-1", "type", "signup")
+
+	want := "*1\r\n*2\r\n" + bulk("events") + "*1\r\n*2\r\n" + bulk("1-1") + "*2\r\n" + bulk("type") + bulk("signup")
+	if got := reader.read(); got != want {
+		t.Fatalf("XREADGROUP应返回XADD写入的条目，实际为 %q", got)
+	}
+	// 条目已投递给alice，等待确认
+	writer.expectReply("*4\r\n:1\r\n"+bulk("1-1")+bulk("1-1")+"*1\r\n*2\r\n"+bulk("alice")+bulk("1"), "XPENDING", "events", "workers")
+}
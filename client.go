@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientRegistry 记录代理当前的客户端连接，用于CLIENT LIST
+type clientRegistry struct {
+	mutex    sync.Mutex
+	nextID   int64
+	sessions map[int64]*clientSession
+	closed   bool // 代理已停止，不再接受新的会话
+}
+
+// newClientRegistry 创建客户端连接记录
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{sessions: make(map[int64]*clientSession)}
+}
+
+// add 为会话分配客户端ID并记录，代理已停止时返回false
+func (r *clientRegistry) add(session *clientSession) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return false
+	}
+	r.nextID++
+	session.id = r.nextID
+	r.sessions[session.id] = session
+	return true
+}
+
+// open 重新接受新的会话，用于Stop之后再次Start
+func (r *clientRegistry) open() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = false
+}
+
+// closeAll 断开所有会话的客户端连接，之后add返回false
+func (r *clientRegistry) closeAll() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	for _, session := range r.sessions {
+		session.conn.Conn.Close()
+	}
+}
+
+// remove 删除已断开的会话
+func (r *clientRegistry) remove(session *clientSession) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, session.id)
+}
+
+// list 按客户端ID顺序返回当前的所有会话
+func (r *clientRegistry) list() []*clientSession {
+	r.mutex.Lock()
+	sessions := make([]*clientSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+// handleClientCommand 处理CLIENT SETNAME/GETNAME/LIST/INFO/KILL/TRACKING/CACHING，返回命令是否已被处理。
+// 后端连接由所有客户端共用，这些子命令改为使用代理保存的客户端状态，其他子命令转发到后端
+func (proxy *RedisClusterProxy) handleClientCommand(session *clientSession, command []string) (bool, error) {
+	if len(command) < 2 {
+		return false, nil
+	}
+
+	switch strings.ToUpper(command[1]) {
+	case "SETNAME":
+		if len(command) != 3 {
+			return true, fmt.Errorf("wrong number of arguments for 'client|setname' command")
+		}
+		if !validClientName(command[2]) {
+			return true, fmt.Errorf("Client names cannot contain spaces, newlines or special characters.")
+		}
+		LogInfo("客户端 %s 设置名称为 %q", session.describe(), command[2])
+		session.setName(command[2])
+		return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+	case "GETNAME":
+		if len(command) != 2 {
+			return true, fmt.Errorf("wrong number of arguments for 'client|getname' command")
+		}
+		name := session.clientName()
+		if name == "" {
+			return true, proxy.writeClient(session, "$-1\r\n")
+		}
+		return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(name))
+	case "INFO":
+		if len(command) != 2 {
+			return true, fmt.Errorf("wrong number of arguments for 'client|info' command")
+		}
+		return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(formatClientInfo(session)))
+	case "LIST":
+		sessions := proxy.clients.list()
+		if len(command) > 2 {
+			// 只支持CLIENT LIST ID id [id ...]
+			if !strings.EqualFold(command[2], "ID") || len(command) == 3 {
+				return true, fmt.Errorf("syntax error")
+			}
+			ids := make(map[int64]bool)
+			for _, arg := range command[3:] {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil || id <= 0 {
+					return true, fmt.Errorf("Invalid client ID")
+				}
+				ids[id] = true
+			}
+			filtered := sessions[:0]
+			for _, s := range sessions {
+				if ids[s.id] {
+					filtered = append(filtered, s)
+				}
+			}
+			sessions = filtered
+		}
+
+		var builder strings.Builder
+		for _, s := range sessions {
+			builder.WriteString(formatClientInfo(s))
+		}
+		return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(builder.String()))
+	case "KILL":
+		return true, proxy.handleClientKill(session, command)
+	case "TRACKING":
+		return true, proxy.handleClientTracking(session, command)
+	case "CACHING":
+		return true, proxy.handleClientCaching(session, command)
+	}
+	return false, nil
+}
+
+// clientKillFilter CLIENT KILL的过滤条件，空字段表示不按该条件过滤
+type clientKillFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	skipMe bool
+}
+
+// matches 判断会话是否满足所有过滤条件
+func (filter *clientKillFilter) matches(self, session *clientSession) bool {
+	if filter.skipMe && session == self {
+		return false
+	}
+	if filter.id != 0 && session.id != filter.id {
+		return false
+	}
+	if filter.addr != "" && session.conn.RemoteAddr().String() != filter.addr {
+		return false
+	}
+	if filter.laddr != "" && session.conn.LocalAddr().String() != filter.laddr {
+		return false
+	}
+	return true
+}
+
+// handleClientKill 断开代理上的客户端连接，支持旧格式CLIENT KILL ip:port
+// 和CLIENT KILL [ID id] [ADDR ip:port] [LADDR ip:port] [SKIPME yes|no]
+func (proxy *RedisClusterProxy) handleClientKill(session *clientSession, command []string) error {
+	if len(command) < 3 {
+		return fmt.Errorf("wrong number of arguments for 'client|kill' command")
+	}
+
+	// 旧格式只按地址匹配，可以断开自身，没有匹配的连接时返回错误
+	legacy := len(command) == 3
+	filter := &clientKillFilter{addr: command[2]}
+	if !legacy {
+		filter = &clientKillFilter{skipMe: true}
+		if len(command)%2 != 0 {
+			return fmt.Errorf("syntax error")
+		}
+		for i := 2; i < len(command); i += 2 {
+			value := command[i+1]
+			switch strings.ToUpper(command[i]) {
+			case "ID":
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || id <= 0 {
+					return fmt.Errorf("client-id should be greater than 0")
+				}
+				filter.id = id
+			case "ADDR":
+				filter.addr = value
+			case "LADDR":
+				filter.laddr = value
+			case "SKIPME":
+				switch strings.ToLower(value) {
+				case "yes":
+					filter.skipMe = true
+				case "no":
+					filter.skipMe = false
+				default:
+					return fmt.Errorf("syntax error")
+				}
+			default:
+				return fmt.Errorf("syntax error")
+			}
+		}
+	}
+
+	killed := 0
+	killSelf := false
+	for _, s := range proxy.clients.list() {
+		if !filter.matches(session, s) {
+			continue
+		}
+		killed++
+		if s == session {
+			// 先写出响应再断开自身
+			killSelf = true
+			continue
+		}
+		LogInfo("客户端 %s 被 %s 执行CLIENT KILL断开", s.describe(), session.describe())
+		s.kill()
+	}
+
+	var err error
+	if legacy {
+		if killed == 0 {
+			return fmt.Errorf("No such client")
+		}
+		err = proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+	} else {
+		err = proxy.writeClient(session, proxy.protocol.FormatInteger(int64(killed)))
+	}
+	if killSelf {
+		LogInfo("客户端 %s 执行CLIENT KILL断开自身", session.describe())
+		session.conn.Flush()
+		session.kill()
+	}
+	return err
+}
+
+// validClientName 与Redis一致，名称只能包含'!'到'~'之间的字符
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatClientInfo 按CLIENT LIST的格式生成一行客户端信息，字段取自代理的客户端连接而不是后端连接
+func formatClientInfo(session *clientSession) string {
+	stats := &session.stats
+	lastCommand, _ := stats.lastCommand.Load().(string)
+	if lastCommand == "" {
+		lastCommand = "NULL"
+	}
+	lastActive := time.Unix(0, stats.lastActive.Load())
+
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=N db=0 tot-cmds=%d tot-net-in=%d tot-net-out=%d cmd=%s\n",
+		session.id, session.conn.RemoteAddr(), session.conn.LocalAddr(), session.clientName(),
+		int64(now.Sub(session.created).Seconds()), int64(now.Sub(lastActive).Seconds()),
+		stats.commands.Load(), stats.bytesIn.Load(), stats.bytesOut.Load(), lastCommand)
+}
+
+// applyClientName 在后端连接上设置客户端的名称，使后端看到的连接名称与客户端一致。
+// 连接池中的连接记录最近一次设置的名称，与客户端的名称相同时不重复设置；客户端没有名称时
+// 用CLIENT SETNAME ""清除上一个客户端留下的名称。新建的独占连接没有名称。
+// 返回错误时连接的名称不确定，调用方应关闭连接而不是放回连接池
+func (proxy *RedisClusterProxy) applyClientName(session *clientSession, backendConn net.Conn) error {
+	name := session.clientName()
+	pooled, _ := backendConn.(*pooledConn)
+	current := ""
+	if pooled != nil {
+		current = pooled.clientName
+	}
+	if name == current {
+		return nil
+	}
+
+	if err := proxy.sendCommandToBackend(backendConn, []string{"CLIENT", "SETNAME", name}); err != nil {
+		return fmt.Errorf("发送CLIENT SETNAME失败: %v", err)
+	}
+	reply, err := bufio.NewReader(backendConn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("读取CLIENT SETNAME响应失败: %v", err)
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		return fmt.Errorf("CLIENT SETNAME响应错误: %s", strings.TrimSpace(reply))
+	}
+	if pooled != nil {
+		pooled.clientName = name
+	}
+	return nil
+}
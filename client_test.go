@@ -0,0 +1,302 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientNameNode 应答CLIENT SETNAME和GET，failName不为空时对该名称的CLIENT SETNAME返回错误
+func clientNameNode(t *testing.T, failName string) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "CLIENT":
+			if failName != "" && len(command) == 3 && command[2] == failName {
+				return "-ERR setname failed\r\n"
+			}
+			return "+OK\r\n"
+		case "GET":
+			return bulk("v")
+		}
+		return "-ERR unknown command\r\n"
+	})
+}
+
+// nameCommands 返回节点收到的CLIENT SETNAME和GET命令
+func nameCommands(node *fakeNode) [][]string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	var commands [][]string
+	for _, command := range node.commands {
+		if strings.EqualFold(command[0], "CLIENT") || strings.EqualFold(command[0], "GET") {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// TestClientNameOnPooledConnection 名称只在连接池中的连接名称不同时设置，没有名称的客户端清除上一个客户端的名称
+func TestClientNameOnPooledConnection(t *testing.T) {
+	node := clientNameNode(t, "")
+	fc := startFakeMasters(t, []*fakeNode{node}, nil)
+	named := fc.client(t)
+	unnamed := fc.client(t)
+
+	named.expectReply("+OK\r\n", "CLIENT", "SETNAME", "app1")
+	named.expectReply(bulk("v"), "GET", "foo")
+	named.expectReply(bulk("v"), "GET", "foo")
+	unnamed.expectReply(bulk("v"), "GET", "foo")
+	unnamed.expectReply(bulk("v"), "GET", "foo")
+	named.expectReply(bulk("v"), "GET", "foo")
+
+	// 命令依次执行，始终使用连接池中同一个连接
+	want := [][]string{
+		{"CLIENT", "SETNAME", "app1"},
+		{"GET", "foo"},
+		{"GET", "foo"},
+		{"CLIENT", "SETNAME", ""},
+		{"GET", "foo"},
+		{"GET", "foo"},
+		{"CLIENT", "SETNAME", "app1"},
+		{"GET", "foo"},
+	}
+	if got := nameCommands(node); !reflect.DeepEqual(got, want) {
+		t.Errorf("节点收到的命令 = %q\n期望 %q", got, want)
+	}
+}
+
+// TestClientNameLocal CLIENT SETNAME/GETNAME由代理应答，不转发到后端
+func TestClientNameLocal(t *testing.T) {
+	node := clientNameNode(t, "")
+	client := startFakeMasters(t, []*fakeNode{node}, nil).client(t)
+
+	client.expectReply("$-1\r\n", "CLIENT", "GETNAME")
+	client.expectReply("+OK\r\n", "client", "setname", "worker-1")
+	client.expectReply(bulk("worker-1"), "CLIENT", "GETNAME")
+	client.expectErrorPrefix("ERR Client names cannot contain spaces", "CLIENT", "SETNAME", "bad name")
+	client.expectReply(bulk("worker-1"), "CLIENT", "GETNAME")
+	if got := nameCommands(node); len(got) != 0 {
+		t.Errorf("没有执行其他命令时不应向后端发送CLIENT命令: %q", got)
+	}
+}
+
+// TestClientNameFailureDiscardsConnection CLIENT SETNAME失败时关闭该连接，不放回连接池
+func TestClientNameFailureDiscardsConnection(t *testing.T) {
+	node := clientNameNode(t, "broken")
+	fc := startFakeMasters(t, []*fakeNode{node}, nil)
+	client := fc.client(t)
+
+	client.expectReply(bulk("v"), "GET", "foo")
+	client.expectReply("+OK\r\n", "CLIENT", "SETNAME", "broken")
+	client.expectErrorPrefix("ERR CLIENT SETNAME响应错误", "GET", "foo")
+
+	stats := fc.proxy.pool.Stats()
+	if len(stats) != 1 || stats[0].Connections != 0 || stats[0].TotalReturned != 1 {
+		t.Fatalf("设置名称失败的连接应被关闭而不是归还，连接池统计: %+v", stats)
+	}
+
+	// 名称恢复正常后新建连接
+	client.expectReply("+OK\r\n", "CLIENT", "SETNAME", "fixed")
+	client.expectReply(bulk("v"), "GET", "foo")
+	if stats := fc.proxy.pool.Stats(); stats[0].TotalCreated != 2 || stats[0].Connections != 1 {
+		t.Errorf("应新建一个连接，连接池统计: %+v", stats)
+	}
+}
+
+// TestClientNameRoundTrip CLIENT SETNAME的名称保存在代理的客户端连接上，每个连接的名称互不影响
+func TestClientNameRoundTrip(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	first := tc.client(t)
+	second := tc.client(t)
+
+	first.expectReply("+OK\r\n", "CLIENT", "SETNAME", "app-1")
+	second.expectReply("$-1\r\n", "CLIENT", "GETNAME")
+	second.expectReply("+OK\r\n", "CLIENT", "SETNAME", "app-2")
+	first.expectReply("+OK\r\n", "SET", "foo", "bar")
+	first.expectReply(bulk("app-1"), "CLIENT", "GETNAME")
+	second.expectReply(bulk("app-2"), "CLIENT", "GETNAME")
+
+	// 名称可以修改，CLIENT SETNAME ""清除名称
+	first.expectReply("+OK\r\n", "CLIENT", "SETNAME", "app-1b")
+	first.expectReply(bulk("app-1b"), "CLIENT", "GETNAME")
+	first.expectReply("+OK\r\n", "CLIENT", "SETNAME", "")
+	first.expectReply("$-1\r\n", "CLIENT", "GETNAME")
+	first.expectErrorPrefix("ERR wrong number of arguments for 'client|setname' command", "CLIENT", "SETNAME")
+}
+
+// TestClientNameInLogs 设置了名称的连接在代理日志中显示为"地址(名称)"
+func TestClientNameInLogs(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "proxy.log")
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.LogLevel = "info"
+		config.LogFile = logFile
+	})
+	client := tc.client(t)
+	described := client.conn.LocalAddr().String() + "(billing-worker)"
+
+	client.expectReply("+OK\r\n", "CLIENT", "SETNAME", "billing-worker")
+	client.conn.Close()
+
+	var logs string
+	found := waitFor(t, 2*time.Second, func() bool {
+		data, _ := os.ReadFile(logFile)
+		logs = string(data)
+		return strings.Contains(logs, "客户端断开连接: "+described)
+	})
+	if !found {
+		t.Fatalf("日志中应出现断开连接的 %s，日志内容:\n%s", described, logs)
+	}
+	if !strings.Contains(logs, "客户端 "+client.conn.LocalAddr().String()+" 设置名称为 \"billing-worker\"") {
+		t.Errorf("日志中应记录设置名称，日志内容:\n%s", logs)
+	}
+}
+
+// parseClientList 解析CLIENT LIST格式的输出，按id返回每行的字段
+func parseClientList(t *testing.T, output string) map[string]map[string]string {
+	t.Helper()
+	clients := make(map[string]map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		fields := make(map[string]string)
+		for _, pair := range strings.Fields(line) {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				t.Fatalf("无效的字段 %q，行: %q", pair, line)
+			}
+			fields[name] = value
+		}
+		clients[fields["id"]] = fields
+	}
+	return clients
+}
+
+// TestClientList CLIENT LIST列出代理上的客户端连接，字段与Redis的格式一致
+func TestClientList(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	first := tc.client(t)
+	second := tc.client(t)
+
+	first.expectReply("+OK\r\n", "CLIENT", "SETNAME", "app-1")
+	first.expectReply("+OK\r\n", "SET", "foo", "bar")
+	second.expectReply(bulk("bar"), "GET", "foo")
+
+	clients := parseClientList(t, second.doValue("CLIENT", "LIST").Str)
+	if len(clients) != 2 {
+		t.Fatalf("应列出2个客户端连接，实际为 %d 个: %v", len(clients), clients)
+	}
+	byAddr := make(map[string]map[string]string)
+	for _, fields := range clients {
+		for _, name := range []string{"id", "addr", "laddr", "name", "age", "idle", "flags", "db", "tot-cmds", "tot-net-in", "tot-net-out", "cmd"} {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("缺少字段 %s: %v", name, fields)
+			}
+		}
+		byAddr[fields["addr"]] = fields
+	}
+
+	one := byAddr[first.conn.LocalAddr().String()]
+	two := byAddr[second.conn.LocalAddr().String()]
+	if one == nil || two == nil {
+		t.Fatalf("addr应为客户端地址: %v", clients)
+	}
+	if one["laddr"] != tc.addr || two["laddr"] != tc.addr {
+		t.Errorf("laddr应为代理的监听地址 %s: %s %s", tc.addr, one["laddr"], two["laddr"])
+	}
+	if one["name"] != "app-1" || two["name"] != "" {
+		t.Errorf("name = %q, %q, 期望 app-1和空", one["name"], two["name"])
+	}
+	if one["tot-cmds"] != "2" || one["cmd"] != "set" {
+		t.Errorf("第一个连接 tot-cmds=%s cmd=%s, 期望 2 set", one["tot-cmds"], one["cmd"])
+	}
+	// 执行CLIENT LIST的连接计入当前命令
+	if two["tot-cmds"] != "2" || two["cmd"] != "client" {
+		t.Errorf("第二个连接 tot-cmds=%s cmd=%s", two["tot-cmds"], two["cmd"])
+	}
+	if one["id"] == two["id"] {
+		t.Errorf("每个连接应有不同的id: %s", one["id"])
+	}
+	if one["tot-net-in"] == "0" || one["tot-net-out"] == "0" {
+		t.Errorf("应统计读写的字节数: in=%s out=%s", one["tot-net-in"], one["tot-net-out"])
+	}
+
+	// ID过滤
+	filtered := parseClientList(t, second.doValue("CLIENT", "LIST", "ID", one["id"]).Str)
+	if len(filtered) != 1 || filtered[one["id"]]["name"] != "app-1" {
+		t.Errorf("CLIENT LIST ID应只返回指定的连接: %v", filtered)
+	}
+	second.expectErrorPrefix("ERR Invalid client ID", "CLIENT", "LIST", "ID", "abc")
+
+	// CLIENT INFO返回当前连接
+	info := parseClientList(t, first.doValue("CLIENT", "INFO").Str)
+	if len(info) != 1 || info[one["id"]]["name"] != "app-1" || info[one["id"]]["tot-cmds"] != "3" {
+		t.Errorf("CLIENT INFO应返回当前连接: %v", info)
+	}
+
+	// 断开的连接不再列出
+	first.conn.Close()
+	waitFor(t, 2*time.Second, func() bool {
+		return len(parseClientList(t, second.doValue("CLIENT", "LIST").Str)) == 1
+	})
+	if clients := parseClientList(t, second.doValue("CLIENT", "LIST").Str); len(clients) != 1 || clients[two["id"]] == nil {
+		t.Errorf("断开后只应列出第二个连接: %v", clients)
+	}
+}
+
+// expectClosed 检查代理已关闭客户端连接
+func expectClosed(t *testing.T, client *testClient) {
+	t.Helper()
+	client.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Errorf("连接 %s 应被断开", client.conn.LocalAddr())
+	}
+}
+
+// TestClientKill CLIENT KILL按地址、ID和LADDR断开代理上的客户端连接，其他连接不受影响
+func TestClientKill(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	admin := tc.client(t)
+	idOf := func(client *testClient) string {
+		for id, fields := range parseClientList(t, admin.doValue("CLIENT", "LIST").Str) {
+			if fields["addr"] == client.conn.LocalAddr().String() {
+				return id
+			}
+		}
+		t.Fatalf("CLIENT LIST中没有连接 %s", client.conn.LocalAddr())
+		return ""
+	}
+
+	// 旧格式按地址断开
+	victim, bystander := tc.client(t), tc.client(t)
+	victim.expectReply("+PONG\r\n", "PING")
+	bystander.expectReply("+PONG\r\n", "PING")
+	admin.expectReply("+OK\r\n", "CLIENT", "KILL", victim.conn.LocalAddr().String())
+	expectClosed(t, victim)
+	bystander.expectReply("+PONG\r\n", "PING")
+	admin.expectReply("-ERR No such client\r\n", "CLIENT", "KILL", "127.0.0.1:1")
+
+	// 新格式返回断开的连接数
+	victim = tc.client(t)
+	victim.expectReply("+PONG\r\n", "PING")
+	admin.expectReply(":1\r\n", "CLIENT", "KILL", "ADDR", victim.conn.LocalAddr().String())
+	expectClosed(t, victim)
+
+	victim = tc.client(t)
+	admin.expectReply(":1\r\n", "CLIENT", "KILL", "ID", idOf(victim))
+	expectClosed(t, victim)
+	admin.expectReply(":0\r\n", "CLIENT", "KILL", "ID", "999999")
+	bystander.expectReply("+PONG\r\n", "PING")
+
+	// LADDR匹配所有连接到该监听地址的客户端，默认SKIPME yes不断开自身
+	admin.expectReply(":1\r\n", "CLIENT", "KILL", "LADDR", tc.addr)
+	expectClosed(t, bystander)
+	admin.expectReply("+PONG\r\n", "PING")
+
+	admin.expectErrorPrefix("ERR syntax error", "CLIENT", "KILL", "ADDR", tc.addr, "SKIPME")
+	admin.expectErrorPrefix("ERR client-id should be greater than 0", "CLIENT", "KILL", "ID", "0")
+
+	// SKIPME no时可以断开自身，先收到响应再断开
+	admin.expectReply(":1\r\n", "CLIENT", "KILL", "ID", idOf(admin), "SKIPME", "no")
+	expectClosed(t, admin)
+}
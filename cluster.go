@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ClusterManager Redis集群管理器
@@ -17,6 +21,13 @@ type ClusterManager struct {
 	mutex     sync.RWMutex
 	config    *Config
 	lastUpdate time.Time
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+
+	topologyNotifier *topologyNotifier // 配置了topology_change_webhook_url时通知拓扑变化
+
+	refreshEvents chan string            // MOVED/ASK/CLUSTERDOWN触发的刷新请求，缓冲区为1，多余的请求被合并
+	refreshes     *prometheus.CounterVec // 按触发方式统计的刷新次数
 }
 
 // ClusterNode Redis集群节点信息
@@ -29,6 +40,9 @@ type ClusterNode struct {
 	Master   string // 如果是slave，指向master的ID
 	Health   bool
 	LastPing time.Time
+
+	Migrating map[int]string // 正在迁出的slot -> 目标节点ID
+	Importing map[int]string // 正在迁入的slot -> 源节点ID
 }
 
 // SlotRange slot范围
@@ -39,10 +53,119 @@ type SlotRange struct {
 
 // NewClusterManager 创建集群管理器
 func NewClusterManager(config *Config) *ClusterManager {
-	return &ClusterManager{
-		nodes:  make(map[string]*ClusterNode),
-		config: config,
+	cm := &ClusterManager{
+		nodes:         make(map[string]*ClusterNode),
+		config:        config,
+		stopChan:      make(chan struct{}),
+		refreshEvents: make(chan string, 1),
+		refreshes:     newRefreshCounter(),
+	}
+
+	if config.TopologyChangeWebhookURL != "" {
+		cm.topologyNotifier = newTopologyNotifier(config.TopologyChangeWebhookURL, cm.stopChan)
 	}
+
+	// 启动节点健康检查
+	if config.HealthCheckInterval > 0 {
+		go cm.healthChecker(config.HealthCheckInterval)
+	}
+
+	// 收到MOVED/ASK/CLUSTERDOWN时立即刷新集群信息
+	go cm.eventRefresher()
+
+	return cm
+}
+
+// Close 停止集群管理器的后台任务
+func (cm *ClusterManager) Close() {
+	cm.stopOnce.Do(func() {
+		close(cm.stopChan)
+	})
+}
+
+// healthChecker 定期PING所有节点并更新节点健康状态
+func (cm *ClusterManager) healthChecker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.stopChan:
+			return
+		case <-ticker.C:
+			cm.checkNodesHealth()
+		}
+	}
+}
+
+// checkNodesHealth 检查所有节点的健康状态，状态变化时输出告警日志
+func (cm *ClusterManager) checkNodesHealth() {
+	cm.mutex.RLock()
+	addresses := make(map[string]string, len(cm.nodes))
+	for id, node := range cm.nodes {
+		addresses[id] = node.Address
+	}
+	cm.mutex.RUnlock()
+
+	// 不持有锁进行网络检查，避免阻塞路由
+	results := make(map[string]bool, len(addresses))
+	var wg sync.WaitGroup
+	var resultMutex sync.Mutex
+	for id, address := range addresses {
+		wg.Add(1)
+		go func(id, address string) {
+			defer wg.Done()
+			healthy := pingNode(address, 2*time.Second) == nil
+			resultMutex.Lock()
+			results[id] = healthy
+			resultMutex.Unlock()
+		}(id, address)
+	}
+	wg.Wait()
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	for id, healthy := range results {
+		node, exists := cm.nodes[id]
+		if !exists {
+			continue
+		}
+		if node.Health != healthy {
+			if healthy {
+				LogWarn("节点 %s 恢复健康", node.Address)
+			} else {
+				LogWarn("节点 %s 健康检查失败，标记为不健康", node.Address)
+			}
+		}
+		node.Health = healthy
+		if healthy {
+			node.LastPing = time.Now()
+		}
+	}
+}
+
+// pingNode 向节点发送PING并等待PONG
+func pingNode(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+PONG") {
+		return fmt.Errorf("无效的PING响应: %s", strings.TrimSpace(line))
+	}
+	return nil
 }
 
 // RefreshClusterInfo 刷新集群信息
@@ -52,11 +175,21 @@ func (cm *ClusterManager) RefreshClusterInfo() error {
 
 	LogDebug("正在刷新Redis集群信息...")
 
+	oldNodes, oldSlots := cm.nodeAddresses(), cm.slots
+
 	// 尝试从任意一个节点获取集群信息
 	for _, nodeAddr := range cm.config.RedisNodes {
 		if err := cm.fetchClusterInfoFromNode(nodeAddr); err == nil {
 			cm.lastUpdate = time.Now()
 			LogInfo("成功从节点 %s 获取集群信息", nodeAddr)
+			// 第一次获取拓扑时没有可比较的旧拓扑
+			if cm.topologyNotifier != nil && len(oldNodes) > 0 {
+				if change := diffTopology(oldNodes, cm.nodeAddresses(), &oldSlots, &cm.slots); !change.empty() {
+					LogInfo("集群拓扑发生变化: 新增节点 %v，移除节点 %v，%d 段slot转移",
+						change.AddedNodes, change.RemovedNodes, len(change.SlotChanges))
+					cm.topologyNotifier.notify(change)
+				}
+			}
 			return nil
 		} else {
 			LogWarn("从节点 %s 获取集群信息失败: %v", nodeAddr, err)
@@ -127,7 +260,9 @@ func (cm *ClusterManager) readClusterNodesResponse(reader *bufio.Reader) (string
 func (cm *ClusterManager) parseClusterNodes(response string) error {
 	lines := strings.Split(strings.TrimSpace(response), "\n")
 	
-	// 清空现有信息
+	// 清空现有信息。开启健康检查时健康状态由健康检查维护，按节点ID保留，
+	// 否则一个PING失败的节点在下次刷新后又会被视为健康
+	previous := cm.nodes
 	cm.nodes = make(map[string]*ClusterNode)
 	cm.slots = [16384]string{}
 
@@ -141,6 +276,10 @@ func (cm *ClusterManager) parseClusterNodes(response string) error {
 			LogWarn("解析节点信息失败: %v, line: %s", err, line)
 			continue
 		}
+		if known, exists := previous[node.ID]; exists && node.Health && cm.config.HealthCheckInterval > 0 {
+			node.Health = known.Health
+			node.LastPing = known.LastPing
+		}
 
 		cm.nodes[node.ID] = node
 
@@ -165,11 +304,15 @@ func (cm *ClusterManager) parseNodeLine(line string) (*ClusterNode, error) {
 		return nil, fmt.Errorf("节点信息格式错误")
 	}
 
-	// 处理节点地址，去掉集群总线端口
+	// 处理节点地址，去掉集群总线端口（Redis 7以后总线端口后还可能带有",hostname"）
 	address := parts[1]
 	if atIndex := strings.Index(address, "@"); atIndex != -1 {
 		address = address[:atIndex]
 	}
+	address, err := normalizeNodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
 
 	node := &ClusterNode{
 		ID:       parts[0],
@@ -180,17 +323,25 @@ func (cm *ClusterManager) parseNodeLine(line string) (*ClusterNode, error) {
 		LastPing: time.Now(),
 	}
 
-	// 判断是否是master
+	// 判断是否是master，被集群标记为fail的节点视为不健康
 	for _, flag := range node.Flags {
-		if flag == "master" {
+		switch flag {
+		case "master":
 			node.IsMaster = true
-			break
+		case "fail":
+			node.Health = false
 		}
 	}
 
 	// 解析slot范围（从第8个字段开始）
 	if node.IsMaster && len(parts) > 8 {
 		for i := 8; i < len(parts); i++ {
+			if strings.HasPrefix(parts[i], "[") {
+				if err := node.parseMigratingSlot(parts[i]); err != nil {
+					LogWarn("解析slot迁移状态失败: %v", err)
+				}
+				continue
+			}
 			slotRange, err := cm.parseSlotRange(parts[i])
 			if err != nil {
 				LogWarn("解析slot范围失败: %v", err)
@@ -206,6 +357,52 @@ func (cm *ClusterManager) parseNodeLine(line string) (*ClusterNode, error) {
 	return node, nil
 }
 
+// normalizeNodeAddress 将Redis返回的节点地址规范化为可以直接拨号的host:port。
+// CLUSTER NODES和MOVED/ASK中的IPv6地址不带方括号（如::1:7000），需要按最后一个冒号拆分
+func normalizeNodeAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		index := strings.LastIndex(address, ":")
+		if index == -1 {
+			return "", fmt.Errorf("无效的节点地址: %s", address)
+		}
+		host, port = address[:index], address[index+1:]
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("无效的节点地址: %s", address)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// parseMigratingSlot 解析resharding期间的slot迁移状态，
+// 格式为[slot->-nodeId]（迁出到目标节点）或[slot-<-nodeId]（从源节点迁入）
+func (node *ClusterNode) parseMigratingSlot(field string) error {
+	state := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+	if slotStr, target, ok := strings.Cut(state, "->-"); ok {
+		slot, err := strconv.Atoi(slotStr)
+		if err != nil || slot < 0 || slot >= 16384 {
+			return fmt.Errorf("无效的slot迁移状态: %s", field)
+		}
+		if node.Migrating == nil {
+			node.Migrating = make(map[int]string)
+		}
+		node.Migrating[slot] = target
+		return nil
+	}
+	if slotStr, source, ok := strings.Cut(state, "-<-"); ok {
+		slot, err := strconv.Atoi(slotStr)
+		if err != nil || slot < 0 || slot >= 16384 {
+			return fmt.Errorf("无效的slot迁移状态: %s", field)
+		}
+		if node.Importing == nil {
+			node.Importing = make(map[int]string)
+		}
+		node.Importing[slot] = source
+		return nil
+	}
+	return fmt.Errorf("无效的slot迁移状态: %s", field)
+}
+
 // parseSlotRange 解析slot范围
 func (cm *ClusterManager) parseSlotRange(slotStr string) (SlotRange, error) {
 	if strings.Contains(slotStr, "-") {
@@ -235,7 +432,7 @@ func (cm *ClusterManager) GetNodeForKey(key string) string {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
 
-	slot := cm.calculateSlot(key)
+	slot := CalculateSlot(key)
 	nodeAddr := cm.slots[slot]
 	
 	if nodeAddr == "" {
@@ -248,6 +445,82 @@ func (cm *ClusterManager) GetNodeForKey(key string) string {
 	return nodeAddr
 }
 
+// healthyNodeFor 如果master节点不健康，返回它的一个健康replica，调用方需持有读锁。
+// replica拒绝写命令，只能用于只读命令
+func (cm *ClusterManager) healthyNodeFor(nodeAddr string) string {
+	var master *ClusterNode
+	for _, node := range cm.nodes {
+		if node.Address == nodeAddr {
+			master = node
+			break
+		}
+	}
+	if master == nil || master.Health {
+		return nodeAddr
+	}
+
+	for _, node := range cm.nodes {
+		if !node.IsMaster && node.Master == master.ID && node.Health {
+			LogDebug("master节点 %s 不健康，改用replica节点 %s", nodeAddr, node.Address)
+			return node.Address
+		}
+	}
+
+	// 没有可用的replica，仍然返回原节点
+	return nodeAddr
+}
+
+// GetReadNode 按读取模式为只读命令选择节点。mode为replica时返回nodeAddr的一个随机的健康replica，
+// 为both时在nodeAddr和它的健康replica中随机选择，为master时只在nodeAddr不健康时改用它的健康replica；
+// 没有健康的replica或nodeAddr不是master时返回nodeAddr
+func (cm *ClusterManager) GetReadNode(nodeAddr string, mode string) string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if mode != "replica" && mode != "both" {
+		return cm.healthyNodeFor(nodeAddr)
+	}
+
+	var master *ClusterNode
+	for _, node := range cm.nodes {
+		if node.Address == nodeAddr {
+			master = node
+			break
+		}
+	}
+	if master == nil || !master.IsMaster {
+		return nodeAddr
+	}
+
+	var candidates []string
+	if mode == "both" && master.Health {
+		candidates = append(candidates, master.Address)
+	}
+	for _, node := range cm.nodes {
+		if !node.IsMaster && node.Master == master.ID && node.Health {
+			candidates = append(candidates, node.Address)
+		}
+	}
+	if len(candidates) == 0 {
+		LogDebug("master节点 %s 没有健康的replica，读命令仍发送到master", nodeAddr)
+		return nodeAddr
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// IsReplica 判断节点是否为replica
+func (cm *ClusterManager) IsReplica(nodeAddr string) bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	for _, node := range cm.nodes {
+		if node.Address == nodeAddr {
+			return !node.IsMaster
+		}
+	}
+	return false
+}
+
 // GetNodeForSlot 根据slot获取对应的节点地址
 func (cm *ClusterManager) GetNodeForSlot(slot int) string {
 	cm.mutex.RLock()
@@ -259,37 +532,19 @@ func (cm *ClusterManager) GetNodeForSlot(slot int) string {
 	return ""
 }
 
-// calculateSlot 计算key对应的slot
-func (cm *ClusterManager) calculateSlot(key string) int {
-	// 检查是否有hash tag
-	start := strings.Index(key, "{")
-	if start != -1 {
-		end := strings.Index(key[start+1:], "}")
-		if end != -1 {
-			key = key[start+1 : start+1+end]
-		}
+// firstCrossSlotKey 返回第一个与keys[0]不在同一个slot的key的位置，全部在同一个slot时返回-1
+func (cm *ClusterManager) firstCrossSlotKey(keys []string) int {
+	if len(keys) == 0 {
+		return -1
 	}
 
-	// 使用CRC16算法计算slot
-	return int(crc16CCITT([]byte(key))) % 16384
-}
-
-// crc16CCITT 实现CRC16-CCITT算法
-func crc16CCITT(data []byte) uint16 {
-	var crc uint16 = 0x0000
-	polynomial := uint16(0x1021)
-
-	for _, b := range data {
-		crc ^= uint16(b) << 8
-		for i := 0; i < 8; i++ {
-			if crc&0x8000 != 0 {
-				crc = (crc << 1) ^ polynomial
-			} else {
-				crc <<= 1
-			}
+	slot := CalculateSlot(keys[0])
+	for i, key := range keys[1:] {
+		if CalculateSlot(key) != slot {
+			return i + 1
 		}
 	}
-	return crc
+	return -1
 }
 
 // GetRandomNode 获取随机节点（用于不需要特定slot的命令）
@@ -312,12 +567,72 @@ func (cm *ClusterManager) GetRandomNode() string {
 	return ""
 }
 
+// GetMasterNodes 获取所有master节点地址（按地址排序），集群信息不可用时返回配置中的节点
+func (cm *ClusterManager) GetMasterNodes() []string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	var masters []string
+	for _, node := range cm.nodes {
+		if node.IsMaster && len(node.Slots) > 0 {
+			masters = append(masters, node.Address)
+		}
+	}
+
+	if len(masters) == 0 {
+		masters = append(masters, cm.config.RedisNodes...)
+	}
+
+	sort.Strings(masters)
+	return masters
+}
+
+// IsSlotMigratingTo 判断slot是否正在从当前负责的节点迁移到address，
+// 迁出节点的migrating状态和迁入节点的importing状态任一存在即可
+func (cm *ClusterManager) IsSlotMigratingTo(slot int, address string) bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if slot < 0 || slot >= len(cm.slots) {
+		return false
+	}
+	for _, node := range cm.nodes {
+		if target, ok := node.Migrating[slot]; ok && node.Address == cm.slots[slot] {
+			if targetNode := cm.nodes[target]; targetNode != nil && targetNode.Address == address {
+				return true
+			}
+		}
+		if _, ok := node.Importing[slot]; ok && node.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllNodes 获取所有节点地址（包括slave，按地址排序），集群信息不可用时返回配置中的节点
+func (cm *ClusterManager) GetAllNodes() []string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	var nodes []string
+	for _, node := range cm.nodes {
+		nodes = append(nodes, node.Address)
+	}
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, cm.config.RedisNodes...)
+	}
+
+	sort.Strings(nodes)
+	return nodes
+}
+
 // IsClusterInfoStale 检查集群信息是否过期
 func (cm *ClusterManager) IsClusterInfoStale() bool {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
 
-	return time.Since(cm.lastUpdate) > 30*time.Second
+	return time.Since(cm.lastUpdate) > cm.config.GetClusterStaleAfter()
 }
 
 // GetClusterStats 获取集群统计信息
@@ -341,6 +656,36 @@ func (cm *ClusterManager) GetClusterStats() map[string]interface{} {
 	
 	stats["master_nodes"] = masterCount
 	stats["slave_nodes"] = slaveCount
+
+	// 统计slot覆盖情况，不健康节点上的slot计为失败
+	healthByAddr := make(map[string]bool, len(cm.nodes))
+	for _, node := range cm.nodes {
+		if node.IsMaster {
+			healthByAddr[node.Address] = node.Health
+		}
+	}
+	slotsAssigned := 0
+	slotsOK := 0
+	for _, nodeAddr := range cm.slots {
+		if nodeAddr == "" {
+			continue
+		}
+		slotsAssigned++
+		if healthByAddr[nodeAddr] {
+			slotsOK++
+		}
+	}
+	stats["slots_assigned"] = slotsAssigned
+	stats["slots_ok"] = slotsOK
+	stats["slots_fail"] = slotsAssigned - slotsOK
+
+	clusterSize := 0
+	for _, node := range cm.nodes {
+		if node.IsMaster && len(node.Slots) > 0 {
+			clusterSize++
+		}
+	}
+	stats["cluster_size"] = clusterSize
 	
 	return stats
 }
\ No newline at end of file
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTopology 一个master负责所有slot，带有一个replica
+var testTopology = clusterNodesLine(1, "127.0.0.1:7000", "master", "0-16383") + "\n" +
+	clusterNodesLine(2, "127.0.0.1:7001", "slave", "1")
+
+// newParsedClusterManager 创建解析了topology的集群管理器，不启动后台任务
+func newParsedClusterManager(config *Config, topology string) *ClusterManager {
+	cm := &ClusterManager{nodes: make(map[string]*ClusterNode), config: config}
+	cm.parseClusterNodes(topology)
+	return cm
+}
+
+// setNodeHealth 设置节点的健康状态
+func setNodeHealth(cm *ClusterManager, address string, healthy bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	for _, node := range cm.nodes {
+		if node.Address == address {
+			node.Health = healthy
+		}
+	}
+}
+
+// nodeByAddress 返回地址对应的节点
+func nodeByAddress(cm *ClusterManager, address string) *ClusterNode {
+	for _, node := range cm.nodes {
+		if node.Address == address {
+			return node
+		}
+	}
+	return nil
+}
+
+// TestParseClusterNodesKeepsHealth 开启健康检查时刷新拓扑保留健康检查得到的状态
+func TestParseClusterNodesKeepsHealth(t *testing.T) {
+	cm := newParsedClusterManager(&Config{HealthCheckInterval: time.Second}, testTopology)
+	lastPing := time.Now().Add(-time.Minute)
+	setNodeHealth(cm, "127.0.0.1:7000", false)
+	nodeByAddress(cm, "127.0.0.1:7001").LastPing = lastPing
+
+	cm.parseClusterNodes(testTopology)
+	if nodeByAddress(cm, "127.0.0.1:7000").Health {
+		t.Error("健康检查失败的节点在刷新后应仍为不健康")
+	}
+	if replica := nodeByAddress(cm, "127.0.0.1:7001"); !replica.Health || !replica.LastPing.Equal(lastPing) {
+		t.Errorf("刷新后应保留节点的健康状态和LastPing，实际为 %v %v", replica.Health, replica.LastPing)
+	}
+
+	// 被集群标记为fail的节点总是不健康
+	failed := strings.Replace(clusterNodesLine(1, "127.0.0.1:7000", "master", "0-16383"), " master ", " master,fail ", 1)
+	setNodeHealth(cm, "127.0.0.1:7000", true)
+	cm.parseClusterNodes(failed)
+	if nodeByAddress(cm, "127.0.0.1:7000").Health {
+		t.Error("标记为fail的节点应为不健康")
+	}
+
+	// 不开启健康检查时健康状态只取决于fail标记
+	cm = newParsedClusterManager(&Config{}, testTopology)
+	setNodeHealth(cm, "127.0.0.1:7000", false)
+	cm.parseClusterNodes(testTopology)
+	if !nodeByAddress(cm, "127.0.0.1:7000").Health {
+		t.Error("没有健康检查时刷新后节点应恢复为健康")
+	}
+}
+
+// TestUnhealthyMasterRouting master不健康时只有只读命令改用replica，key和slot仍然路由到master
+func TestUnhealthyMasterRouting(t *testing.T) {
+	cm := newParsedClusterManager(&Config{HealthCheckInterval: time.Second}, testTopology)
+	master, replica := "127.0.0.1:7000", "127.0.0.1:7001"
+
+	if got := cm.GetReadNode(master, "master"); got != master {
+		t.Errorf("master健康时读命令应发送到master，实际为 %s", got)
+	}
+
+	setNodeHealth(cm, master, false)
+	if got := cm.GetNodeForKey("foo"); got != master {
+		t.Errorf("GetNodeForKey应返回master，实际为 %s", got)
+	}
+	if got := cm.GetNodeForSlot(0); got != master {
+		t.Errorf("GetNodeForSlot应返回master，实际为 %s", got)
+	}
+	for _, mode := range []string{"", "master", "replica", "both"} {
+		if got := cm.GetReadNode(master, mode); got != replica {
+			t.Errorf("mode=%q: master不健康时读命令应发送到replica，实际为 %s", mode, got)
+		}
+	}
+
+	// replica也不健康时仍然返回master
+	setNodeHealth(cm, replica, false)
+	if got := cm.GetReadNode(master, "master"); got != master {
+		t.Errorf("没有健康的replica时应返回master，实际为 %s", got)
+	}
+}
+
+// TestUnhealthyMasterWrites master不健康时写命令仍发送到master，只读命令发送到replica
+func TestUnhealthyMasterWrites(t *testing.T) {
+	handler := func(command []string) string {
+		switch command[0] {
+		case "SET":
+			return "+OK\r\n"
+		case "GET":
+			return bulk("value")
+		}
+		return "-ERR unknown command\r\n"
+	}
+	master, replica := startFakeNode(t, handler), startFakeNode(t, handler)
+	topology := clusterNodesLine(1, master.addr, "master", "0-16383") + "\n" + clusterNodesLine(2, replica.addr, "slave", "1")
+	proxy, addr := startTestProxy(t, []string{master.addr}, topology, nil)
+	setNodeHealth(proxy.clusterManager, master.addr, false)
+	client := dialProxy(t, proxy, addr)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	client.expectReply(bulk("value"), "GET", "foo")
+	if len(master.received("SET")) != 1 || len(replica.received("SET")) != 0 {
+		t.Error("写命令应发送到master而不是replica")
+	}
+	if len(replica.received("GET")) != 1 {
+		t.Error("master不健康时只读命令应发送到replica")
+	}
+}
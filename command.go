@@ -0,0 +1,539 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 命令标志
+const (
+	cmdWrite    = 1 << iota // 写命令
+	cmdReadonly             // 只读命令
+	cmdBlocking             // 阻塞命令
+	cmdMultiKey             // 多key命令
+	cmdAdmin                // 管理命令，可发送到任意节点
+	cmdScript               // 脚本命令，所有key必须位于同一个slot
+)
+
+// commandSpec 命令路由规格，参考Redis命令表中的key位置定义
+type commandSpec struct {
+	firstKey int // 第一个key的位置，0表示命令不包含key
+	lastKey  int // 最后一个key的位置，负数表示从末尾倒数（-1为最后一个参数）
+	keyStep  int // key之间的间隔，例如MSET为2
+	flags    int // 命令标志
+
+	numKeysPos int // numkeys参数的位置，其后紧跟numkeys个key，0表示没有numkeys参数
+
+	keyFunc  func(command []string) []string // 自定义key提取函数，用于key位置不固定的命令
+	validate func(command []string) error    // 自定义参数校验，在路由之前执行
+}
+
+// commandTable 命令表，新增命令只需在此添加一行
+var commandTable = map[string]*commandSpec{
+	// 字符串操作命令
+	"GET":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SET":         {flags: cmdWrite, keyFunc: setKeys, validate: validateSetOptions},
+	"GETSET":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"GETDEL":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"GETEX":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"SETNX":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"SETEX":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"PSETEX":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"MGET":        {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"MSET":        {firstKey: 1, lastKey: -1, keyStep: 2, flags: cmdWrite | cmdMultiKey},
+	"MSETNX":      {firstKey: 1, lastKey: -1, keyStep: 2, flags: cmdWrite | cmdMultiKey},
+	"INCR":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"DECR":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"INCRBY":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"DECRBY":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"INCRBYFLOAT": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"APPEND":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"STRLEN":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GETRANGE":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SETRANGE":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"GETBIT":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SETBIT":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"BITCOUNT":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"BITPOS":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"BITOP":       {firstKey: 2, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"BITFIELD":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"BITFIELD_RO": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"LCS":         {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+
+	// 哈希操作命令
+	"HGET":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HSET":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HSETNX":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HMGET":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HMSET":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HGETALL":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HKEYS":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HVALS":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HLEN":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HSTRLEN":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HEXISTS":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HDEL":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HINCRBY":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HINCRBYFLOAT": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"HRANDFIELD":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"HSCAN":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+
+	// 列表操作命令
+	"LPUSH":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"RPUSH":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LPUSHX":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"RPUSHX":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LPOP":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"RPOP":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LLEN":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"LRANGE":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"LTRIM":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LINDEX":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"LSET":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LREM":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LINSERT":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"LPOS":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"BLPOP":      {firstKey: 1, lastKey: -2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"BRPOP":      {firstKey: 1, lastKey: -2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"BRPOPLPUSH": {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"RPOPLPUSH":  {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"LMOVE":      {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"BLMOVE":     {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"LMPOP":      {flags: cmdWrite | cmdMultiKey, numKeysPos: 1},
+	"BLMPOP":     {flags: cmdWrite | cmdBlocking | cmdMultiKey, numKeysPos: 2},
+
+	// 集合操作命令
+	"SADD":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"SREM":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"SMEMBERS":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SCARD":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SISMEMBER":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SMISMEMBER":  {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SRANDMEMBER": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"SPOP":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"SMOVE":       {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"SINTER":      {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"SINTERSTORE": {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"SUNION":      {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"SUNIONSTORE": {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"SDIFF":       {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"SDIFFSTORE":  {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"SINTERCARD":  {flags: cmdReadonly | cmdMultiKey, numKeysPos: 1},
+	"SSCAN":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+
+	// 有序集合操作命令
+	"ZADD":             {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZREM":             {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZSCORE":           {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZMSCORE":          {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZINCRBY":          {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZCARD":            {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZCOUNT":           {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZLEXCOUNT":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZRANGE":           {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZREVRANGE":        {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZRANGEBYSCORE":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZREVRANGEBYSCORE": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZRANGEBYLEX":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZREVRANGEBYLEX":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZRANK":            {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZREVRANK":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZREMRANGEBYRANK":  {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZREMRANGEBYSCORE": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZREMRANGEBYLEX":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZPOPMIN":          {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"ZPOPMAX":          {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"BZPOPMIN":         {firstKey: 1, lastKey: -2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"BZPOPMAX":         {firstKey: 1, lastKey: -2, keyStep: 1, flags: cmdWrite | cmdBlocking | cmdMultiKey},
+	"ZRANDMEMBER":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"ZRANGESTORE":      {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"ZMPOP":            {flags: cmdWrite | cmdMultiKey, numKeysPos: 1},
+	"BZMPOP":           {flags: cmdWrite | cmdBlocking | cmdMultiKey, numKeysPos: 2},
+	"ZUNIONSTORE":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite | cmdMultiKey, numKeysPos: 2},
+	"ZINTERSTORE":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite | cmdMultiKey, numKeysPos: 2},
+	"ZDIFFSTORE":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite | cmdMultiKey, numKeysPos: 2},
+	"ZUNION":           {flags: cmdReadonly | cmdMultiKey, numKeysPos: 1},
+	"ZINTER":           {flags: cmdReadonly | cmdMultiKey, numKeysPos: 1},
+	"ZDIFF":            {flags: cmdReadonly | cmdMultiKey, numKeysPos: 1},
+	"ZINTERCARD":       {flags: cmdReadonly | cmdMultiKey, numKeysPos: 1},
+	"ZSCAN":            {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+
+	// 通用key操作命令
+	"DEL":       {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"UNLINK":    {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"EXISTS":    {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"TOUCH":     {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"EXPIRE":    {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"PEXPIRE":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"EXPIREAT":  {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"PEXPIREAT": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"TTL":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"PTTL":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"PERSIST":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"TYPE":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"RENAME":    {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"RENAMENX":  {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+	"COPY":      {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey, validate: validateCopyOptions},
+	"MOVE":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"DUMP":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"RESTORE":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"MIGRATE":   {flags: cmdWrite | cmdMultiKey, keyFunc: migrateKeys},
+	"OBJECT":    {flags: cmdReadonly, keyFunc: subcommandKey("ENCODING", "FREQ", "IDLETIME", "REFCOUNT")},
+	"SORT":      {flags: cmdWrite | cmdMultiKey, keyFunc: sortKeys, validate: validateSortPatterns},
+	"SORT_RO":   {flags: cmdReadonly, keyFunc: sortKeys, validate: validateSortPatterns},
+
+	// HyperLogLog命令
+	"PFADD":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"PFCOUNT": {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdReadonly | cmdMultiKey},
+	"PFMERGE": {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+
+	// 地理位置命令
+	"GEOADD":               {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"GEODIST":              {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEOPOS":               {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEOHASH":              {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEORADIUS":            {flags: cmdWrite | cmdMultiKey, keyFunc: geoRadiusKeys(6)},
+	"GEORADIUSBYMEMBER":    {flags: cmdWrite | cmdMultiKey, keyFunc: geoRadiusKeys(5)},
+	"GEORADIUS_RO":         {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEORADIUSBYMEMBER_RO": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEOSEARCH":            {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"GEOSEARCHSTORE":       {firstKey: 1, lastKey: 2, keyStep: 1, flags: cmdWrite | cmdMultiKey},
+
+	// 流操作命令
+	"XADD":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"XREAD":      {flags: cmdReadonly | cmdMultiKey, keyFunc: streamsKeys},
+	"XREADGROUP": {flags: cmdWrite | cmdMultiKey, keyFunc: streamsKeys},
+	"XPENDING":   {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"XCLAIM":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"XAUTOCLAIM": {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"XACK":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"XGROUP":     {flags: cmdWrite, keyFunc: subcommandKey("CREATE", "DESTROY", "CREATECONSUMER", "DELCONSUMER", "SETID")},
+	"XINFO":      {flags: cmdReadonly, keyFunc: subcommandKey("STREAM", "GROUPS", "CONSUMERS")},
+	"XLEN":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"XRANGE":     {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"XREVRANGE":  {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdReadonly},
+	"XTRIM":      {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+	"XDEL":       {firstKey: 1, lastKey: 1, keyStep: 1, flags: cmdWrite},
+
+	// 集群管理和信息命令，这些命令可以发送到任意节点，MEMORY USAGE和DEBUG OBJECT按key路由
+	"CLUSTER":  {flags: cmdAdmin},
+	"INFO":     {flags: cmdAdmin},
+	"PING":     {flags: cmdAdmin},
+	"TIME":     {flags: cmdAdmin},
+	"COMMAND":  {flags: cmdAdmin},
+	"CONFIG":   {flags: cmdAdmin},
+	"CLIENT":   {flags: cmdAdmin},
+	"MEMORY":   {flags: cmdAdmin, keyFunc: subcommandKey("USAGE")},
+	"LATENCY":  {flags: cmdAdmin},
+	"SLOWLOG":  {flags: cmdAdmin},
+	"MONITOR":  {flags: cmdAdmin},
+	"DEBUG":    {flags: cmdAdmin, keyFunc: subcommandKey("OBJECT")},
+	"SHUTDOWN": {flags: cmdAdmin},
+
+	// 事务命令，由代理维护事务状态并在同一个连接上执行（见transaction.go）
+	"MULTI":   {},
+	"EXEC":    {},
+	"DISCARD": {},
+	"WATCH":   {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdMultiKey},
+	"UNWATCH": {},
+
+	// 发布订阅命令
+	"PUBLISH":      {},
+	"SPUBLISH":     {firstKey: 1, lastKey: 1, keyStep: 1},
+	"SUBSCRIBE":    {},
+	"UNSUBSCRIBE":  {},
+	"PSUBSCRIBE":   {},
+	"PUNSUBSCRIBE": {},
+	"SSUBSCRIBE":   {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdMultiKey},
+	"SUNSUBSCRIBE": {firstKey: 1, lastKey: -1, keyStep: 1, flags: cmdMultiKey},
+	"PUBSUB":       {},
+
+	// 脚本命令，key列表由numkeys参数指定
+	"EVAL":       {flags: cmdWrite | cmdScript, numKeysPos: 2},
+	"EVALSHA":    {flags: cmdWrite | cmdScript, numKeysPos: 2},
+	"EVAL_RO":    {flags: cmdReadonly | cmdScript, numKeysPos: 2},
+	"EVALSHA_RO": {flags: cmdReadonly | cmdScript, numKeysPos: 2},
+	"SCRIPT":     {},
+
+	// 函数命令（Redis 7），FCALL的key列表由numkeys参数指定
+	"FCALL":    {flags: cmdWrite | cmdScript, numKeysPos: 2},
+	"FCALL_RO": {flags: cmdReadonly | cmdScript, numKeysPos: 2},
+	"FUNCTION": {flags: cmdAdmin},
+}
+
+// validateNumKeys 校验numkeys参数，错误信息与Redis保持一致
+func validateNumKeys(command []string, pos int) error {
+	if pos >= len(command) {
+		return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(command[0]))
+	}
+	numKeys, err := strconv.Atoi(command[pos])
+	if err != nil {
+		return fmt.Errorf("value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return fmt.Errorf("Number of keys can't be negative")
+	}
+	if pos+numKeys >= len(command) {
+		return fmt.Errorf("Number of keys can't be greater than number of args")
+	}
+	return nil
+}
+
+// geoRadiusKeys 返回GEORADIUS系列命令的key提取函数，除源key外还包括STORE/STOREDIST选项指定的key，
+// optionsPos为可选参数开始的位置
+func geoRadiusKeys(optionsPos int) func(command []string) []string {
+	return func(command []string) []string {
+		if len(command) < 2 {
+			return nil
+		}
+		keys := []string{command[1]}
+		for i := optionsPos; i+1 < len(command); i++ {
+			switch strings.ToUpper(command[i]) {
+			case "STORE", "STOREDIST":
+				i++
+				keys = append(keys, command[i])
+			}
+		}
+		return keys
+	}
+}
+
+// subcommandKey 返回带子命令的命令的key提取函数：子命令在command[1]，key在command[2]，
+// 只有列出的子命令带有key
+func subcommandKey(subcommands ...string) func(command []string) []string {
+	return func(command []string) []string {
+		if len(command) < 3 {
+			return nil
+		}
+		subCommand := strings.ToUpper(command[1])
+		for _, name := range subcommands {
+			if subCommand == name {
+				return command[2:3]
+			}
+		}
+		return nil
+	}
+}
+
+// streamsKeys 提取XREAD/XREADGROUP命令STREAMS之后的key列表：
+// STREAMS之后的参数前一半是key，后一半是对应的ID
+func streamsKeys(command []string) []string {
+	for i := 1; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "GROUP":
+			i += 2
+		case "COUNT", "BLOCK":
+			i++
+		case "STREAMS":
+			rest := command[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil
+			}
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// setKeys 提取SET命令的key：无论带有多少选项，key总是command[1]，command[2]是value
+func setKeys(command []string) []string {
+	if len(command) < 3 {
+		return nil
+	}
+	return command[1:2]
+}
+
+// migrateKeys 提取MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password]
+// [AUTH2 username password] [KEYS key...]的key：key参数为空字符串时为KEYS之后的所有参数
+func migrateKeys(command []string) []string {
+	if len(command) < 6 {
+		return nil
+	}
+	if command[3] != "" {
+		return command[3:4]
+	}
+	for i := 6; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			return command[i+1:]
+		}
+	}
+	return nil
+}
+
+// validateSetOptions 解析SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp|KEEPTTL]
+func validateSetOptions(command []string) error {
+	if len(command) < 3 {
+		return fmt.Errorf("wrong number of arguments for 'set' command")
+	}
+
+	condition := ""
+	expire := ""
+	for i := 3; i < len(command); i++ {
+		option := strings.ToUpper(command[i])
+		switch option {
+		case "NX", "XX":
+			if condition != "" && condition != option {
+				return fmt.Errorf("syntax error")
+			}
+			condition = option
+		case "GET":
+		case "KEEPTTL":
+			if expire != "" && expire != option {
+				return fmt.Errorf("syntax error")
+			}
+			expire = option
+		case "EX", "PX", "EXAT", "PXAT":
+			if expire != "" || i+1 >= len(command) {
+				return fmt.Errorf("syntax error")
+			}
+			i++
+			value, err := strconv.ParseInt(command[i], 10, 64)
+			if err != nil {
+				return fmt.Errorf("value is not an integer or out of range")
+			}
+			if value <= 0 {
+				return fmt.Errorf("invalid expire time in 'set' command")
+			}
+			expire = option
+		default:
+			return fmt.Errorf("syntax error")
+		}
+	}
+	return nil
+}
+
+// sortKeys 提取SORT命令的源key和STORE选项指定的目标key
+func sortKeys(command []string) []string {
+	if len(command) < 2 {
+		return nil
+	}
+	keys := []string{command[1]}
+	for i := 2; i+1 < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "STORE":
+			i++
+			keys = append(keys, command[i])
+		case "BY", "GET":
+			i++
+		case "LIMIT":
+			i += 2
+		}
+	}
+	return keys
+}
+
+// validateSortPatterns 校验SORT的BY/GET模式：集群模式下模式引用的key必须与源key位于同一个slot，
+// 因此要求模式带有与源key相同的hash tag
+func validateSortPatterns(command []string) error {
+	if len(command) < 2 {
+		return nil
+	}
+	key := command[1]
+	for i := 2; i+1 < len(command); i++ {
+		option := strings.ToUpper(command[i])
+		switch option {
+		case "BY", "GET":
+			i++
+			pattern := command[i]
+			// "#"表示元素本身，不含"*"的BY模式表示不排序，都不会访问其他key
+			if pattern == "#" || !strings.Contains(pattern, "*") {
+				continue
+			}
+			// "->"之后是hash字段名，只有之前的部分是key模式
+			keyPattern := pattern
+			if idx := strings.Index(keyPattern, "->"); idx != -1 {
+				keyPattern = keyPattern[:idx]
+			}
+			tag := hashKeyPart(keyPattern)
+			if tag == keyPattern || strings.Contains(tag, "*") || tag != hashKeyPart(key) {
+				return fmt.Errorf("集群模式下SORT的%s模式 '%s' 必须包含与key '%s' 相同的hash tag", option, pattern, key)
+			}
+		case "STORE":
+			i++
+		case "LIMIT":
+			i += 2
+		}
+	}
+	return nil
+}
+
+// lookupCommand 查找命令规格，未知命令返回nil
+func lookupCommand(cmdName string) *commandSpec {
+	return commandTable[strings.ToUpper(cmdName)]
+}
+
+// hasFlag 判断命令是否带有指定标志
+func (spec *commandSpec) hasFlag(flag int) bool {
+	return spec.flags&flag != 0
+}
+
+// validateArgs 在路由之前校验numkeys参数并执行自定义参数校验，事务中排队的命令使用相同的校验
+func (spec *commandSpec) validateArgs(command []string) error {
+	if spec.numKeysPos > 0 {
+		if err := validateNumKeys(command, spec.numKeysPos); err != nil {
+			return err
+		}
+	}
+	if spec.validate != nil {
+		return spec.validate(command)
+	}
+	return nil
+}
+
+// extractKeys 根据命令规格提取命令中的所有key
+func (spec *commandSpec) extractKeys(command []string) []string {
+	if spec.keyFunc != nil {
+		return spec.keyFunc(command)
+	}
+
+	keys := spec.fixedKeys(command)
+	if spec.numKeysPos > 0 {
+		keys = append(keys, spec.numKeys(command)...)
+	}
+	return keys
+}
+
+// numKeys 提取numkeys参数之后的key列表，numkeys无效时返回nil
+func (spec *commandSpec) numKeys(command []string) []string {
+	pos := spec.numKeysPos
+	if pos >= len(command) {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(command[pos])
+	if err != nil || numKeys <= 0 || pos+numKeys >= len(command) {
+		return nil
+	}
+	return command[pos+1 : pos+1+numKeys]
+}
+
+// fixedKeys 提取由firstKey/lastKey/keyStep定义的固定位置key
+func (spec *commandSpec) fixedKeys(command []string) []string {
+	if spec.firstKey <= 0 || spec.firstKey >= len(command) {
+		return nil
+	}
+
+	last := spec.lastKey
+	if last < 0 {
+		last = len(command) + last
+	}
+	if last >= len(command) {
+		last = len(command) - 1
+	}
+
+	step := spec.keyStep
+	if step <= 0 {
+		step = 1
+	}
+
+	var keys []string
+	for i := spec.firstKey; i <= last; i += step {
+		keys = append(keys, command[i])
+	}
+	return keys
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCommandTableSpecs 检查命令表中每一项的定义：命令名为大写，固定位置key的命令按规格提取到正确的参数
+func TestCommandTableSpecs(t *testing.T) {
+	for name, spec := range commandTable {
+		if name != strings.ToUpper(name) {
+			t.Errorf("命令名 %s 不是大写", name)
+		}
+		if lookupCommand(strings.ToLower(name)) != spec {
+			t.Errorf("lookupCommand(%q) 没有找到命令", strings.ToLower(name))
+		}
+		if spec.keyFunc != nil || spec.firstKey == 0 {
+			continue
+		}
+		if spec.keyStep <= 0 {
+			t.Errorf("%s: keyStep必须为正数", name)
+		}
+		if spec.lastKey > 0 && spec.lastKey < spec.firstKey {
+			t.Errorf("%s: lastKey %d 小于 firstKey %d", name, spec.lastKey, spec.firstKey)
+		}
+
+		// 构造足够长的命令，参数为a1、a2...，检查第一个key的位置
+		command := []string{name}
+		for i := 1; i <= 8; i++ {
+			command = append(command, "a"+string(rune('0'+i)))
+		}
+		keys := spec.extractKeys(command)
+		if len(keys) == 0 || keys[0] != command[spec.firstKey] {
+			t.Errorf("%s: 第一个key应为 %s，实际为 %v", name, command[spec.firstKey], keys)
+		}
+	}
+}
+
+// TestExtractKeys 检查代表性命令的key提取
+func TestExtractKeys(t *testing.T) {
+	tests := []struct {
+		command []string
+		keys    []string
+	}{
+		{[]string{"GET", "k"}, []string{"k"}},
+		{[]string{"get", "k"}, []string{"k"}},
+		{[]string{"HSET", "h", "f", "v", "f2", "v2"}, []string{"h"}},
+		{[]string{"ZADD", "z", "NX", "CH", "1", "m"}, []string{"z"}},
+		{[]string{"MGET", "a", "b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"DEL", "a", "b"}, []string{"a", "b"}},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}},
+		{[]string{"BZPOPMIN", "z1", "z2", "1.5"}, []string{"z1", "z2"}},
+		{[]string{"RENAME", "src", "dst"}, []string{"src", "dst"}},
+		{[]string{"GEORADIUS", "g", "0", "0", "10", "km"}, []string{"g"}},
+		{[]string{"GEORADIUS", "g", "0", "0", "10", "km", "STORE", "dst"}, []string{"g", "dst"}},
+		{[]string{"XADD", "s", "*", "f", "v"}, []string{"s"}},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, []string{"a", "b"}},
+		{[]string{"EVAL", "return 1", "0"}, nil},
+		{[]string{"PING"}, nil},
+		{[]string{"INFO", "server"}, nil},
+	}
+	for _, tt := range tests {
+		spec := lookupCommand(tt.command[0])
+		if spec == nil {
+			t.Fatalf("%s 不在命令表中", tt.command[0])
+		}
+		if keys := spec.extractKeys(tt.command); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+	}
+}
+
+// TestNumKeysCommands 检查目标key在前、源key由numkeys指定的有序集合和集合命令
+func TestNumKeysCommands(t *testing.T) {
+	tests := []struct {
+		command []string
+		keys    []string
+	}{
+		{[]string{"ZUNIONSTORE", "dst", "2", "k1", "k2"}, []string{"dst", "k1", "k2"}},
+		{[]string{"ZUNIONSTORE", "dst", "2", "k1", "k2", "WEIGHTS", "1", "2", "AGGREGATE", "MAX"}, []string{"dst", "k1", "k2"}},
+		{[]string{"ZINTERSTORE", "dst", "1", "k1"}, []string{"dst", "k1"}},
+		{[]string{"ZDIFFSTORE", "dst", "3", "k1", "k2", "k3"}, []string{"dst", "k1", "k2", "k3"}},
+		{[]string{"ZUNION", "2", "k1", "k2", "WITHSCORES"}, []string{"k1", "k2"}},
+		{[]string{"ZINTER", "2", "k1", "k2"}, []string{"k1", "k2"}},
+		{[]string{"ZDIFF", "2", "k1", "k2"}, []string{"k1", "k2"}},
+		{[]string{"ZINTERCARD", "2", "k1", "k2", "LIMIT", "10"}, []string{"k1", "k2"}},
+		{[]string{"SINTERCARD", "3", "k1", "k2", "k3", "LIMIT", "5"}, []string{"k1", "k2", "k3"}},
+	}
+	for _, tt := range tests {
+		if keys := lookupCommand(tt.command[0]).extractKeys(tt.command); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+	}
+
+	// numkeys不是正整数或超过参数个数时由validateArgs拒绝
+	for _, command := range [][]string{
+		{"ZUNIONSTORE", "dst", "x", "k1"},
+		{"ZUNIONSTORE", "dst", "3", "k1", "k2"},
+		{"SINTERCARD", "-1", "k1"},
+	} {
+		if err := lookupCommand(command[0]).validateArgs(command); err == nil {
+			t.Errorf("%v: 应返回numkeys错误", command)
+		}
+	}
+}
+
+// TestGeoStoreKeys 检查GEO命令STORE/STOREDIST选项和GEOSEARCHSTORE的key提取，以及key是否跨slot
+func TestGeoStoreKeys(t *testing.T) {
+	tests := []struct {
+		command   []string
+		keys      []string
+		crossSlot bool
+	}{
+		{[]string{"GEORADIUS", "{g}.src", "15", "37", "200", "km", "STORE", "{g}.dst"}, []string{"{g}.src", "{g}.dst"}, false},
+		{[]string{"GEORADIUS", "{g}.src", "15", "37", "200", "km", "WITHDIST", "storedist", "{g}.dist"}, []string{"{g}.src", "{g}.dist"}, false},
+		{[]string{"GEORADIUS", "{g}.src", "15", "37", "200", "km", "STORE", "{g}.a", "STOREDIST", "{g}.b"}, []string{"{g}.src", "{g}.a", "{g}.b"}, false},
+		{[]string{"GEORADIUS", "src", "15", "37", "200", "km", "STORE", "dst"}, []string{"src", "dst"}, true},
+		{[]string{"GEORADIUS", "src", "15", "37", "200", "km", "COUNT", "5"}, []string{"src"}, false},
+		// 成员名恰好为"store"时不是选项
+		{[]string{"GEORADIUSBYMEMBER", "{g}.src", "store", "200", "km", "STORE", "{g}.dst"}, []string{"{g}.src", "{g}.dst"}, false},
+		{[]string{"GEORADIUSBYMEMBER", "src", "m", "200", "km", "STOREDIST", "dst"}, []string{"src", "dst"}, true},
+		{[]string{"GEOSEARCHSTORE", "{g}.dst", "{g}.src", "FROMMEMBER", "m", "BYRADIUS", "10", "km"}, []string{"{g}.dst", "{g}.src"}, false},
+		{[]string{"GEOSEARCHSTORE", "dst", "src", "FROMLONLAT", "15", "37", "BYBOX", "10", "10", "km", "STOREDIST"}, []string{"dst", "src"}, true},
+		{[]string{"GEOSEARCH", "src", "FROMMEMBER", "m", "BYRADIUS", "10", "km"}, []string{"src"}, false},
+	}
+	cm := &ClusterManager{}
+	for _, tt := range tests {
+		keys := lookupCommand(tt.command[0]).extractKeys(tt.command)
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+		if crossSlot := cm.firstCrossSlotKey(keys) > 0; crossSlot != tt.crossSlot {
+			t.Errorf("%v: 跨slot应为 %v，实际为 %v", tt.command, tt.crossSlot, crossSlot)
+		}
+	}
+}
+
+// TestSortKeys 检查SORT的key提取和BY/GET模式校验
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		command   []string
+		keys      []string
+		crossSlot bool
+		invalid   bool
+	}{
+		{[]string{"SORT", "mylist"}, []string{"mylist"}, false, false},
+		{[]string{"SORT", "mylist", "LIMIT", "0", "10", "ALPHA", "DESC"}, []string{"mylist"}, false, false},
+		{[]string{"SORT", "{u}.list", "BY", "{u}.weight_*", "GET", "#", "GET", "{u}.obj_*->name", "STORE", "{u}.dst"}, []string{"{u}.list", "{u}.dst"}, false, false},
+		{[]string{"SORT", "{u}.list", "store", "{u}.dst"}, []string{"{u}.list", "{u}.dst"}, false, false},
+		{[]string{"SORT", "mylist", "STORE", "dst"}, []string{"mylist", "dst"}, true, false},
+		// LIMIT的参数和BY/GET的模式不是key
+		{[]string{"SORT", "mylist", "LIMIT", "store", "1", "BY", "store"}, []string{"mylist"}, false, false},
+		// 不含"*"的BY模式表示不排序
+		{[]string{"SORT", "mylist", "BY", "nosort"}, []string{"mylist"}, false, false},
+		{[]string{"SORT", "mylist", "BY", "weight_*"}, []string{"mylist"}, false, true},
+		{[]string{"SORT", "mylist", "GET", "obj_*"}, []string{"mylist"}, false, true},
+		{[]string{"SORT", "{u}.list", "GET", "{v}.obj_*"}, []string{"{u}.list"}, false, true},
+		{[]string{"SORT", "{u}.list", "BY", "{*}.weight"}, []string{"{u}.list"}, false, true},
+		{[]string{"SORT_RO", "{u}.list", "BY", "{u}.weight_*"}, []string{"{u}.list"}, false, false},
+		{[]string{"SORT_RO", "mylist", "GET", "obj_*"}, []string{"mylist"}, false, true},
+	}
+	cm := &ClusterManager{}
+	for _, tt := range tests {
+		spec := lookupCommand(tt.command[0])
+		keys := spec.extractKeys(tt.command)
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+		if crossSlot := cm.firstCrossSlotKey(keys) > 0; crossSlot != tt.crossSlot {
+			t.Errorf("%v: 跨slot应为 %v，实际为 %v", tt.command, tt.crossSlot, crossSlot)
+		}
+		if err := spec.validateArgs(tt.command); (err != nil) != tt.invalid {
+			t.Errorf("%v: 校验结果应为invalid=%v，实际为 %v", tt.command, tt.invalid, err)
+		}
+	}
+}
+
+// permutations 返回groups的所有排列，每个group是一个选项及其参数
+func permutations(groups [][]string) [][][]string {
+	if len(groups) <= 1 {
+		return [][][]string{groups}
+	}
+	var result [][][]string
+	for i := range groups {
+		rest := append(append([][]string{}, groups[:i]...), groups[i+1:]...)
+		for _, perm := range permutations(rest) {
+			result = append(result, append([][]string{groups[i]}, perm...))
+		}
+	}
+	return result
+}
+
+// TestSetOptions 检查SET所有选项组合和顺序下key都是command[1]，并且选项校验与Redis一致
+func TestSetOptions(t *testing.T) {
+	conditions := [][]string{nil, {"NX"}, {"xx"}}
+	gets := [][]string{nil, {"GET"}}
+	expires := [][]string{nil, {"EX", "10"}, {"px", "100"}, {"EXAT", "1700000000"}, {"PXAT", "1700000000000"}, {"KEEPTTL"}}
+
+	spec := lookupCommand("SET")
+	count := 0
+	for _, condition := range conditions {
+		for _, get := range gets {
+			for _, expire := range expires {
+				var groups [][]string
+				for _, group := range [][]string{condition, get, expire} {
+					if group != nil {
+						groups = append(groups, group)
+					}
+				}
+				for _, perm := range permutations(groups) {
+					// value与选项名相同时也不影响key的提取
+					command := []string{"SET", "mykey", "EX"}
+					for _, group := range perm {
+						command = append(command, group...)
+					}
+					count++
+					if keys := spec.extractKeys(command); !reflect.DeepEqual(keys, []string{"mykey"}) {
+						t.Errorf("%v: key应为 [mykey]，实际为 %v", command, keys)
+					}
+					if err := spec.validateArgs(command); err != nil {
+						t.Errorf("%v: 不应返回错误，实际为 %v", command, err)
+					}
+				}
+			}
+		}
+	}
+	// 3*2*6种选项组合，每种组合的所有顺序
+	if count != 103 {
+		t.Errorf("应检查103个命令，实际检查 %d 个", count)
+	}
+
+	for _, command := range [][]string{
+		{"SET", "mykey"},
+		{"SET", "mykey", "v", "NX", "XX"},
+		{"SET", "mykey", "v", "EX", "10", "PX", "100"},
+		{"SET", "mykey", "v", "KEEPTTL", "EX", "10"},
+		{"SET", "mykey", "v", "EX"},
+		{"SET", "mykey", "v", "EX", "ten"},
+		{"SET", "mykey", "v", "PX", "0"},
+		{"SET", "mykey", "v", "EX", "-1"},
+		{"SET", "mykey", "v", "NOPE"},
+	} {
+		if err := spec.validateArgs(command); err == nil {
+			t.Errorf("%v: 应返回错误", command)
+		}
+	}
+}
+
+// TestStreamKeys 检查XREAD/XREADGROUP按STREAMS提取key，以及XAUTOCLAIM、XINFO的key位置
+func TestStreamKeys(t *testing.T) {
+	tests := []struct {
+		command []string
+		keys    []string
+	}{
+		{[]string{"XREAD", "STREAMS", "s1", "0"}, []string{"s1"}},
+		{[]string{"XREAD", "COUNT", "10", "STREAMS", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{[]string{"xread", "count", "10", "block", "100", "streams", "{t}.a", "{t}.b", "{t}.c", "$", "$", "$"}, []string{"{t}.a", "{t}.b", "{t}.c"}},
+		// COUNT和BLOCK的参数不是STREAMS关键字
+		{[]string{"XREAD", "COUNT", "streams", "STREAMS", "s1", "0"}, []string{"s1"}},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "NOACK", "STREAMS", "s1", "s2", ">", ">"}, []string{"s1", "s2"}},
+		// 组名和消费者名为streams
+		{[]string{"XREADGROUP", "GROUP", "streams", "streams", "STREAMS", "s1", ">"}, []string{"s1"}},
+		// key和ID的个数不一致
+		{[]string{"XREAD", "STREAMS", "s1", "s2", "0"}, nil},
+		{[]string{"XREAD", "COUNT", "10"}, nil},
+		{[]string{"XAUTOCLAIM", "s1", "g", "c", "10", "0-0", "COUNT", "5"}, []string{"s1"}},
+		{[]string{"XINFO", "STREAM", "s1", "FULL"}, []string{"s1"}},
+		{[]string{"XINFO", "GROUPS", "s1"}, []string{"s1"}},
+		{[]string{"XINFO", "HELP"}, nil},
+	}
+	for _, tt := range tests {
+		if keys := lookupCommand(tt.command[0]).extractKeys(tt.command); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+	}
+}
+
+// TestObjectSubcommandKeys 检查OBJECT每个子命令的key提取：ENCODING/REFCOUNT/IDLETIME/FREQ的key在command[2]，
+// HELP没有key，发送到任意节点
+func TestObjectSubcommandKeys(t *testing.T) {
+	tests := []struct {
+		command []string
+		keys    []string
+	}{
+		{[]string{"OBJECT", "ENCODING", "mykey"}, []string{"mykey"}},
+		{[]string{"OBJECT", "REFCOUNT", "mykey"}, []string{"mykey"}},
+		{[]string{"OBJECT", "IDLETIME", "mykey"}, []string{"mykey"}},
+		{[]string{"OBJECT", "FREQ", "mykey"}, []string{"mykey"}},
+		{[]string{"object", "encoding", "mykey"}, []string{"mykey"}},
+		{[]string{"OBJECT", "freq", "{tag}.key"}, []string{"{tag}.key"}},
+		{[]string{"OBJECT", "HELP"}, nil},
+		{[]string{"OBJECT", "ENCODING"}, nil},
+		{[]string{"OBJECT"}, nil},
+		{[]string{"OBJECT", "NOSUCH", "mykey"}, nil},
+	}
+	for _, tt := range tests {
+		if keys := lookupCommand(tt.command[0]).extractKeys(tt.command); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: key应为 %v，实际为 %v", tt.command, tt.keys, keys)
+		}
+	}
+}
+
+// TestGeoRoutingKeys 检查GEO命令的第一个key都是command[1]，geoRadiusKeys提取的STORE目标key跨slot时被检测到
+func TestGeoRoutingKeys(t *testing.T) {
+	for _, name := range []string{"GEOADD", "GEODIST", "GEOPOS", "GEOHASH", "GEORADIUS", "GEORADIUSBYMEMBER",
+		"GEORADIUS_RO", "GEORADIUSBYMEMBER_RO", "GEOSEARCH"} {
+		command := []string{name, "mygeo", "a", "b", "c", "d", "e"}
+		if keys := lookupCommand(name).extractKeys(command); len(keys) == 0 || keys[0] != "mygeo" {
+			t.Errorf("%s: 第一个key应为 mygeo，实际为 %v", name, keys)
+		}
+	}
+
+	tests := []struct {
+		keyFunc   func([]string) []string
+		command   []string
+		crossSlot bool
+	}{
+		{geoRadiusKeys(6), []string{"GEORADIUS", "{g}.src", "0", "0", "1", "km", "STORE", "{g}.dst"}, false},
+		{geoRadiusKeys(6), []string{"GEORADIUS", "src", "0", "0", "1", "km", "STORE", "dst"}, true},
+		{geoRadiusKeys(6), []string{"GEORADIUS", "{g}.src", "0", "0", "1", "km", "STOREDIST", "dst"}, true},
+		{geoRadiusKeys(5), []string{"GEORADIUSBYMEMBER", "{g}.src", "m", "1", "km", "STORE", "{g}.dst"}, false},
+		{geoRadiusKeys(5), []string{"GEORADIUSBYMEMBER", "src", "m", "1", "km", "STORE", "dst"}, true},
+		{lookupCommand("GEOSEARCHSTORE").extractKeys, []string{"GEOSEARCHSTORE", "{g}.dst", "{g}.src", "FROMMEMBER", "m", "BYRADIUS", "1", "km"}, false},
+		{lookupCommand("GEOSEARCHSTORE").extractKeys, []string{"GEOSEARCHSTORE", "dst", "src", "FROMMEMBER", "m", "BYRADIUS", "1", "km"}, true},
+		{sortKeys, []string{"SORT", "{s}.src", "STORE", "{s}.dst"}, false},
+		{sortKeys, []string{"SORT", "src", "STORE", "dst"}, true},
+	}
+	cm := &ClusterManager{}
+	for _, tt := range tests {
+		if crossSlot := cm.firstCrossSlotKey(tt.keyFunc(tt.command)) > 0; crossSlot != tt.crossSlot {
+			t.Errorf("%v: 跨slot应为 %v，实际为 %v", tt.command, tt.crossSlot, crossSlot)
+		}
+	}
+}
+
+// TestCommandFlags 检查命令标志
+func TestCommandFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		flag int
+	}{
+		{"GET", cmdReadonly},
+		{"SET", cmdWrite},
+		{"BLPOP", cmdBlocking},
+		{"MGET", cmdMultiKey},
+		{"INFO", cmdAdmin},
+		{"EVAL", cmdScript},
+	}
+	for _, tt := range tests {
+		if !lookupCommand(tt.name).hasFlag(tt.flag) {
+			t.Errorf("%s 应带有标志 %d", tt.name, tt.flag)
+		}
+	}
+	if lookupCommand("GET").hasFlag(cmdWrite) {
+		t.Errorf("GET 不应为写命令")
+	}
+	if lookupCommand("NOSUCHCOMMAND") != nil {
+		t.Errorf("未知命令应返回nil")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxCommandKeysEntries key位置缓存的最大条目数
+const maxCommandKeysEntries = 1024
+
+// commandKeysEntry 缓存的命令key位置
+type commandKeysEntry struct {
+	signature string
+	positions []int // 空切片表示该命令没有key
+}
+
+// commandKeysCache 未知命令的key位置缓存（命令签名到key位置的LRU缓存），通过COMMAND GETKEYS获取
+type commandKeysCache struct {
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的签名在前
+	mutex   sync.Mutex
+}
+
+// newCommandKeysCache 创建key位置缓存
+func newCommandKeysCache() *commandKeysCache {
+	return &commandKeysCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// signature 生成命令签名：命令名加参数个数
+func (c *commandKeysCache) signature(command []string) string {
+	return fmt.Sprintf("%s/%d", strings.ToUpper(command[0]), len(command))
+}
+
+// firstKey 返回未知命令的第一个key，命令没有key或GETKEYS失败时返回false。
+// 只缓存后端明确的结果，连接失败、超时或LOADING等临时错误不缓存，下次重新获取
+func (c *commandKeysCache) firstKey(proxy *RedisClusterProxy, command []string) (string, bool) {
+	sig := c.signature(command)
+
+	positions, cached := c.get(sig)
+	if !cached {
+		var err error
+		positions, err = c.fetchKeyPositions(proxy, command)
+		if err != nil {
+			LogDebug("COMMAND GETKEYS %s 失败: %v", command[0], err)
+			return "", false
+		}
+		c.add(sig, positions)
+	}
+
+	if len(positions) == 0 || positions[0] >= len(command) {
+		return "", false
+	}
+	return command[positions[0]], true
+}
+
+// get 返回签名缓存的key位置
+func (c *commandKeysCache) get(sig string) ([]int, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[sig]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*commandKeysEntry).positions, true
+}
+
+// add 缓存签名的key位置，超过容量时淘汰最久未使用的签名
+func (c *commandKeysCache) add(sig string, positions []int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[sig]; exists {
+		element.Value.(*commandKeysEntry).positions = positions
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[sig] = c.order.PushFront(&commandKeysEntry{signature: sig, positions: positions})
+	if c.order.Len() > maxCommandKeysEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*commandKeysEntry).signature)
+	}
+}
+
+// fetchKeyPositions 向后端发送COMMAND GETKEYS，并将返回的key换算为参数位置
+func (c *commandKeysCache) fetchKeyPositions(proxy *RedisClusterProxy, command []string) ([]int, error) {
+	nodeAddr := proxy.clusterManager.GetRandomNode()
+	if nodeAddr == "" {
+		return nil, fmt.Errorf("没有可用节点")
+	}
+
+	getKeys := append([]string{"COMMAND", "GETKEYS"}, command...)
+	response, err := proxy.executeOnNode(nodeAddr, getKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := proxy.protocol.ParseResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	if value.IsError() {
+		// ERR表示命令没有key或参数无效，结果是确定的；LOADING、BUSY、CLUSTERDOWN等是节点的临时状态
+		if strings.HasPrefix(value.Str, "ERR ") {
+			LogDebug("命令 %s 没有key: %s", command[0], value.Str)
+			return []int{}, nil
+		}
+		return nil, fmt.Errorf("%s", value.Str)
+	}
+	if value.Type != '*' {
+		return nil, fmt.Errorf("无效的GETKEYS响应类型: %c", value.Type)
+	}
+
+	// 按顺序在参数中查找每个key的位置
+	positions := make([]int, 0, len(value.Array))
+	next := 1
+	for _, element := range value.Array {
+		for i := next; i < len(command); i++ {
+			if command[i] == element.Str {
+				positions = append(positions, i)
+				next = i + 1
+				break
+			}
+		}
+	}
+
+	LogDebug("命令 %s 的key位置: %v", command[0], positions)
+	return positions, nil
+}
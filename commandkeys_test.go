@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// startGetKeysCluster 启动两个假节点，各负责一半slot，getKeys应答COMMAND GETKEYS，其他命令返回OK
+func startGetKeysCluster(t *testing.T, getKeys func(command []string) string) (*testClient, *fakeNode, *fakeNode) {
+	t.Helper()
+	handler := func(command []string) string {
+		if strings.EqualFold(command[0], "COMMAND") {
+			return getKeys(command)
+		}
+		return "+OK\r\n"
+	}
+	low, high := startFakeNode(t, handler), startFakeNode(t, handler)
+	topology := clusterNodesLine(1, low.addr, "master", "0-8191") + "\n" + clusterNodesLine(2, high.addr, "master", "8192-16383")
+	proxy, addr := startTestProxy(t, []string{low.addr, high.addr}, topology, nil)
+	return dialProxy(t, proxy, addr), low, high
+}
+
+// TestGetKeysRouting 未知命令按COMMAND GETKEYS返回的第一个key路由到slot所在的节点
+func TestGetKeysRouting(t *testing.T) {
+	client, low, high := startGetKeysCluster(t, func(command []string) string {
+		// COMMAND GETKEYS JSON.SET <key> ...
+		return "*1\r\n" + bulk(command[3])
+	})
+
+	// mykey的slot为14687，由high负责；bar的slot为5061，由low负责
+	client.expectReply("+OK\r\n", "JSON.SET", "mykey", "$", "{}")
+	client.expectReply("+OK\r\n", "JSON.SET", "bar", "$", "{}")
+
+	if got := high.received("JSON.SET"); len(got) != 1 || got[0][1] != "mykey" {
+		t.Errorf("mykey应发送到slot所在的节点，实际收到: %v", got)
+	}
+	if got := low.received("JSON.SET"); len(got) != 1 || got[0][1] != "bar" {
+		t.Errorf("bar应发送到slot所在的节点，实际收到: %v", got)
+	}
+	// 参数个数相同的命令使用缓存的key位置
+	if calls := len(low.received("COMMAND")) + len(high.received("COMMAND")); calls != 1 {
+		t.Errorf("COMMAND GETKEYS应只执行1次，实际执行 %d 次", calls)
+	}
+}
+
+// TestGetKeysNoKeysCached 后端返回ERR（命令没有key）时缓存结果，不再重复发送GETKEYS
+func TestGetKeysNoKeysCached(t *testing.T) {
+	client, low, high := startGetKeysCluster(t, func(command []string) string {
+		return "-ERR The command has no key arguments\r\n"
+	})
+
+	client.expectReply("+OK\r\n", "MODULE.NOKEYS", "a")
+	client.expectReply("+OK\r\n", "MODULE.NOKEYS", "b")
+	if calls := len(low.received("COMMAND")) + len(high.received("COMMAND")); calls != 1 {
+		t.Errorf("确定没有key的命令应只执行1次GETKEYS，实际执行 %d 次", calls)
+	}
+}
+
+// TestGetKeysTransientErrorNotCached LOADING等临时错误不缓存，之后的命令重新获取key位置并正确路由
+func TestGetKeysTransientErrorNotCached(t *testing.T) {
+	var calls atomic.Int32
+	client, _, high := startGetKeysCluster(t, func(command []string) string {
+		if calls.Add(1) == 1 {
+			return "-LOADING Redis is loading the dataset in memory\r\n"
+		}
+		return "*1\r\n" + bulk(command[3])
+	})
+
+	client.expectReply("+OK\r\n", "JSON.SET", "mykey", "$", "{}")
+	client.expectReply("+OK\r\n", "JSON.SET", "mykey", "$", "{}")
+
+	if calls.Load() != 2 {
+		t.Errorf("临时错误之后应重新执行GETKEYS，实际执行 %d 次", calls.Load())
+	}
+	// 第一条命令发送到随机节点，第二条一定发送到mykey所在的节点
+	if got := high.received("JSON.SET"); len(got) == 0 {
+		t.Errorf("获取key位置之后mykey应发送到slot所在的节点")
+	}
+}
+
+// TestCommandKeysCacheEviction 缓存已满时淘汰最久未使用的签名，新的命令仍然可以缓存
+func TestCommandKeysCacheEviction(t *testing.T) {
+	cache := newCommandKeysCache()
+	for i := 0; i < maxCommandKeysEntries; i++ {
+		cache.add(fmt.Sprintf("CMD%d/2", i), []int{1})
+	}
+	// 访问CMD0之后最久未使用的是CMD1
+	if _, ok := cache.get("CMD0/2"); !ok {
+		t.Fatal("CMD0应在缓存中")
+	}
+	cache.add("NEW/2", []int{1})
+
+	if _, ok := cache.get("NEW/2"); !ok {
+		t.Error("缓存已满时新的签名应被缓存")
+	}
+	if _, ok := cache.get("CMD1/2"); ok {
+		t.Error("缓存已满时应淘汰最久未使用的签名CMD1")
+	}
+	if _, ok := cache.get("CMD0/2"); !ok {
+		t.Error("最近访问的CMD0不应被淘汰")
+	}
+	if got := len(cache.entries); got != maxCommandKeysEntries {
+		t.Errorf("缓存条目数应为 %d，实际为 %d", maxCommandKeysEntries, got)
+	}
+}
@@ -3,15 +3,103 @@ package main
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config 代理配置
 type Config struct {
-	ProxyPort    int      `yaml:"proxy_port"`    // 代理监听端口
-	RedisNodes   []string `yaml:"redis_nodes"`   // Redis集群节点地址列表
-	AutoRedirect bool     `yaml:"auto_redirect"` // 是否自动处理重定向
-	LogLevel     string   `yaml:"log_level"`     // 日志级别: debug, info, warn, error
-	LogFile      string   `yaml:"log_file"`      // 日志文件路径，为空则输出到控制台
+	ProxyPort        int      `yaml:"proxy_port"`         // 代理监听端口
+	ProxyBindAddress string   `yaml:"proxy_bind_address"` // 代理监听的IP地址，为空表示所有网卡，"::"表示只监听IPv6
+	RedisNodes       []string `yaml:"redis_nodes"`        // Redis集群节点地址列表
+	AutoRedirect     bool     `yaml:"auto_redirect"`      // 是否自动处理重定向
+	LogLevel         string   `yaml:"log_level"`          // 日志级别: debug, info, warn, error
+	LogFile          string   `yaml:"log_file"`           // 日志文件路径，为空则输出到控制台
+	LogFormat        string   `yaml:"log_format"`         // 日志格式: text, json
+
+	LogMaxSizeMB   int  `yaml:"log_max_size_mb"`   // 单个日志文件最大大小(MB)，0表示不按大小滚动
+	LogMaxBackups  int  `yaml:"log_max_backups"`   // 最多保留的历史日志文件数，0表示全部保留
+	LogRotateOnHUP bool `yaml:"log_rotate_on_hup"` // 收到SIGHUP信号时滚动日志文件
+
+	WatchConfig bool `yaml:"watch_config"` // 监听配置文件变化并自动重新加载，与SIGHUP效果相同
+
+	TraceRequests bool `yaml:"trace_requests"` // 为每个客户端命令生成请求ID并附加到该命令的所有日志中
+
+	AdminAddress string `yaml:"admin_address"` // 管理HTTP服务监听地址（/pool、/metrics），为空则不启动
+
+	MetricsHistogramBuckets []float64 `yaml:"metrics_histogram_buckets"` // proxy_command_duration_seconds直方图的分桶上界（秒）
+
+	TopologyChangeWebhookURL string `yaml:"topology_change_webhook_url"` // 集群拓扑变化时POST通知的地址，为空表示不通知
+
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"` // 节点健康检查间隔，0表示不检查
+
+	ClusterRefreshInterval time.Duration `yaml:"cluster_refresh_interval"` // 检查集群信息是否需要刷新的间隔，0表示使用默认的30秒
+	ClusterStaleAfter      time.Duration `yaml:"cluster_stale_after"`      // 集群信息超过该时间未更新时刷新，0表示使用默认的30秒
+
+	StartupHealthCheck bool `yaml:"startup_health_check"` // 启动时响应PING的节点少于min_healthy_nodes时是否退出，否则只输出警告
+	MinHealthyNodes    int  `yaml:"min_healthy_nodes"`    // 启动检查要求响应PING的最少节点数
+
+	PoolMaxWait time.Duration `yaml:"pool_max_wait"` // 连接池已满时等待可用连接的最长时间，0表示立即返回错误
+
+	ClusterDownMaxRetries   int           `yaml:"cluster_down_max_retries"`    // 后端返回CLUSTERDOWN时的最大重试次数，0表示不重试
+	ClusterDownMaxRetryWait time.Duration `yaml:"cluster_down_max_retry_wait"` // CLUSTERDOWN重试的最长退避时间
+
+	BlockedCommands          []string      `yaml:"blocked_commands"`           // 禁止客户端执行的命令，COMMAND系列命令的响应中也会去掉这些命令
+	AllowedDangerousCommands []string      `yaml:"allowed_dangerous_commands"` // 重新开启的危险命令，例如FLUSHALL、CLUSTER FAILOVER，危险命令默认禁止执行
+	CommandPolicy            CommandPolicy `yaml:"command_policy"`             // 命令允许/禁止列表
+
+	CommandRenames map[string]string `yaml:"command_renames"` // 客户端命令名到后端节点rename-command后名称的映射，代理发送到后端前替换命令名
+
+	EncodingCacheTTL     time.Duration `yaml:"encoding_cache_ttl"`      // OBJECT ENCODING结果的本地缓存时间，0表示不缓存
+	EncodingCacheMaxKeys int           `yaml:"encoding_cache_max_keys"` // OBJECT ENCODING缓存的最大key数量，0表示不限制
+
+	ReadBufferSize  int `yaml:"read_buffer_size"`  // 客户端和后端连接的读缓冲区字节数，0表示使用默认的4096
+	WriteBufferSize int `yaml:"write_buffer_size"` // 客户端连接的写缓冲区字节数，0表示使用默认的4096
+
+	KeysMaxResults  int           `yaml:"keys_max_results"`  // KEYS合并后最多返回的key数量，超过时返回错误，0表示不限制
+	KeysScanTimeout time.Duration `yaml:"keys_scan_timeout"` // KEYS在单个master节点上的超时时间，0表示使用默认的60秒
+	DisableKEYS     bool          `yaml:"disable_keys"`      // 禁用KEYS，客户端执行时返回错误并提示使用SCAN
+
+	MGetStrict       bool `yaml:"mget_strict"`         // 跨slot的MGET有节点失败时返回错误，否则对应的key返回nil
+	FanOutBestEffort bool `yaml:"fan_out_best_effort"` // 跨slot的DEL/UNLINK/EXISTS/TOUCH和PUBSUB统计有节点失败时只汇总成功节点的结果，否则返回错误
+
+	PFCountFanOut bool `yaml:"pfcount_fan_out"` // 跨slot的PFCOUNT是否在代理侧合并计数，否则返回CROSSSLOT
+
+	MaxKeySize   int `yaml:"max_key_size"`   // 命令第一个参数（通常为key）的最大字节数，超过时返回错误且不缓存请求，0表示不限制
+	MaxValueSize int `yaml:"max_value_size"` // 命令其余参数的最大字节数，0表示不限制
+
+	RateLimitCommandsPerSecond int           `yaml:"rate_limit_commands_per_second"` // 每个客户端IP每秒最多执行的命令数，0表示不限流
+	RateLimitBurstSize         int           `yaml:"rate_limit_burst_size"`          // 令牌桶容量，允许短时间内超过每秒命令数，0表示与每秒命令数相同
+	RateLimitIdleTimeout       time.Duration `yaml:"rate_limit_idle_timeout"`        // 客户端IP超过该时间没有命令时清理其令牌桶
+
+	WaitAggregate bool `yaml:"wait_aggregate"` // WAIT在所有master节点执行并返回确认的replica数量的最小值，否则发送到最近一次写入的节点
+
+	AllowSelectZero bool `yaml:"allow_select_zero"` // SELECT 0由代理直接返回OK，SELECT其他db返回错误，否则转发到后端
+
+	LocalPing bool `yaml:"local_ping"` // PING由代理直接应答，不访问后端，用于负载均衡器的健康检查
+
+	ClientTracking bool `yaml:"client_tracking"` // 允许客户端通过HELLO 3切换到RESP3并开启CLIENT TRACKING，失效通知以推送消息转发
+
+	StickySessions bool   `yaml:"sticky_sessions"` // 客户端连接默认使用粘性会话，每个连接独占一个后端连接，命令原样转发
+	StickyNode     string `yaml:"sticky_node"`     // 粘性会话连接的节点，为空时按第一条命令的key选择
+
+	KeyspaceRelayPatterns []string `yaml:"keyspace_relay_patterns"` // 代理在所有master节点上订阅的模式（如__keyevent@0__:expired），客户端PSUBSCRIBE相同的模式时收到所有节点的消息
+
+	ProxyNodeCommand bool `yaml:"proxy_node_command"` // 是否允许PROXY NODE在指定节点上执行任意命令
+
+	ConfigBroadcast bool `yaml:"config_broadcast"` // CONFIG SET/RESETSTAT/REWRITE发送到所有节点，CONFIG GET检查各节点是否一致，否则发送到随机节点
+
+	RequireFlushConfirmation bool `yaml:"require_flush_confirmation"` // FLUSHALL/FLUSHDB必须带有CONFIRMED参数，防止误操作
+
+	ReadFrom string `yaml:"read_from"` // 带key的只读命令发送到的节点：master（默认）、replica（优先replica）或both（master和replica中随机），客户端可用READONLY/READWRITE覆盖
+}
+
+// CommandPolicy 命令策略，列表项为命令名（匹配所有子命令）或"命令|子命令"（如CONFIG|SET），不区分大小写
+type CommandPolicy struct {
+	Deny  []string `yaml:"deny"`  // 禁止执行的命令，优先于allow
+	Allow []string `yaml:"allow"` // 不为空时只允许执行其中的命令
 }
 
 // LoadConfig 加载配置文件（在main.go中实现）
@@ -23,7 +111,64 @@ func (c *Config) GetRedisNodes() []string {
 
 // GetProxyAddress 获取代理服务地址
 func (c *Config) GetProxyAddress() string {
-	return fmt.Sprintf(":%d", c.ProxyPort)
+	return net.JoinHostPort(c.ProxyBindAddress, strconv.Itoa(c.ProxyPort))
+}
+
+// defaultBufferSize 读写缓冲区的默认大小，与bufio的默认值相同
+const defaultBufferSize = 4096
+
+// GetReadBufferSize 获取连接的读缓冲区大小
+func (c *Config) GetReadBufferSize() int {
+	if c.ReadBufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return c.ReadBufferSize
+}
+
+// GetWriteBufferSize 获取客户端连接的写缓冲区大小
+func (c *Config) GetWriteBufferSize() int {
+	if c.WriteBufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return c.WriteBufferSize
+}
+
+// defaultClusterRefreshInterval 集群信息刷新检查间隔和过期时间的默认值
+const defaultClusterRefreshInterval = 30 * time.Second
+
+// GetClusterRefreshInterval 获取检查集群信息是否需要刷新的间隔
+func (c *Config) GetClusterRefreshInterval() time.Duration {
+	if c.ClusterRefreshInterval <= 0 {
+		return defaultClusterRefreshInterval
+	}
+	return c.ClusterRefreshInterval
+}
+
+// GetClusterStaleAfter 获取集群信息的过期时间
+func (c *Config) GetClusterStaleAfter() time.Duration {
+	if c.ClusterStaleAfter > 0 {
+		return c.ClusterStaleAfter
+	}
+	return defaultClusterRefreshInterval
+}
+
+// defaultKeysScanTimeout KEYS在单个master节点上的默认超时时间
+const defaultKeysScanTimeout = 60 * time.Second
+
+// GetKeysScanTimeout 获取KEYS在单个master节点上的超时时间
+func (c *Config) GetKeysScanTimeout() time.Duration {
+	if c.KeysScanTimeout <= 0 {
+		return defaultKeysScanTimeout
+	}
+	return c.KeysScanTimeout
+}
+
+// GetProxyNetwork 获取代理监听的网络类型，绑定IPv6地址时只监听IPv6
+func (c *Config) GetProxyNetwork() string {
+	if ip := net.ParseIP(c.ProxyBindAddress); ip != nil && ip.To4() == nil {
+		return "tcp6"
+	}
+	return "tcp"
 }
 
 // 注意：已移除MapAddress方法，因为直接连接Redis节点，不需要地址映射
@@ -34,6 +179,124 @@ func (c *Config) ValidateConfig() error {
 		return fmt.Errorf("Redis节点列表不能为空")
 	}
 
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("无效的日志格式: %s", c.LogFormat)
+	}
+
+	switch c.ReadFrom {
+	case "", "master", "replica", "both":
+	default:
+		return fmt.Errorf("无效的read_from: %s，可选master、replica或both", c.ReadFrom)
+	}
+
+	if c.LogMaxSizeMB < 0 || c.LogMaxBackups < 0 {
+		return fmt.Errorf("日志滚动参数不能为负数")
+	}
+
+	if c.HealthCheckInterval < 0 {
+		return fmt.Errorf("健康检查间隔不能为负数")
+	}
+
+	// 间隔过短会频繁向集群发送CLUSTER NODES
+	for _, interval := range []time.Duration{c.ClusterRefreshInterval, c.ClusterStaleAfter} {
+		if interval != 0 && interval < time.Second {
+			return fmt.Errorf("集群信息刷新间隔和过期时间不能小于1秒")
+		}
+	}
+
+	if c.MinHealthyNodes < 0 {
+		return fmt.Errorf("最少健康节点数不能为负数")
+	}
+	if c.MinHealthyNodes > len(c.RedisNodes) {
+		return fmt.Errorf("最少健康节点数 %d 超过了配置的节点数 %d", c.MinHealthyNodes, len(c.RedisNodes))
+	}
+
+	if c.ClusterDownMaxRetries < 0 || c.ClusterDownMaxRetryWait < 0 {
+		return fmt.Errorf("CLUSTERDOWN重试参数不能为负数")
+	}
+
+	if c.KeysMaxResults < 0 {
+		return fmt.Errorf("KEYS最大结果数不能为负数")
+	}
+	if c.KeysScanTimeout < 0 {
+		return fmt.Errorf("KEYS超时时间不能为负数")
+	}
+
+	for _, entry := range append(append([]string(nil), c.CommandPolicy.Deny...), c.CommandPolicy.Allow...) {
+		name, subCommand, hasSub := strings.Cut(entry, "|")
+		if name == "" || (hasSub && subCommand == "") {
+			return fmt.Errorf("无效的命令策略项: %q", entry)
+		}
+	}
+
+	renamedTo := make(map[string]string)
+	for name, renamed := range c.CommandRenames {
+		if name == "" || renamed == "" || strings.ContainsAny(name+renamed, " |") {
+			return fmt.Errorf("无效的命令重命名: %q -> %q", name, renamed)
+		}
+		// 多个命令重命名为同一个名称时无法还原COMMAND响应中的命令名
+		if other, exists := renamedTo[strings.ToUpper(renamed)]; exists {
+			return fmt.Errorf("命令 %s 和 %s 重命名为同一个名称 %s", other, name, renamed)
+		}
+		renamedTo[strings.ToUpper(renamed)] = name
+	}
+
+	if c.EncodingCacheTTL < 0 || c.EncodingCacheMaxKeys < 0 {
+		return fmt.Errorf("OBJECT ENCODING缓存参数不能为负数")
+	}
+
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		return fmt.Errorf("缓冲区大小不能为负数")
+	}
+
+	if c.MaxKeySize < 0 || c.MaxValueSize < 0 {
+		return fmt.Errorf("key和value的大小限制不能为负数")
+	}
+
+	if c.RateLimitCommandsPerSecond < 0 || c.RateLimitBurstSize < 0 || c.RateLimitIdleTimeout < 0 {
+		return fmt.Errorf("限流参数不能为负数")
+	}
+
+	if len(c.MetricsHistogramBuckets) == 0 {
+		return fmt.Errorf("直方图分桶不能为空")
+	}
+	for i, bucket := range c.MetricsHistogramBuckets {
+		if bucket <= 0 {
+			return fmt.Errorf("直方图分桶必须为正数: %v", bucket)
+		}
+		if i > 0 && bucket <= c.MetricsHistogramBuckets[i-1] {
+			return fmt.Errorf("直方图分桶必须严格递增: %v", c.MetricsHistogramBuckets)
+		}
+	}
+
+	if c.PoolMaxWait < 0 {
+		return fmt.Errorf("连接池等待时间不能为负数")
+	}
+
+	if c.TopologyChangeWebhookURL != "" {
+		if u, err := url.Parse(c.TopologyChangeWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("无效的拓扑变化webhook地址: %s", c.TopologyChangeWebhookURL)
+		}
+	}
+
+	if c.ProxyBindAddress != "" && net.ParseIP(c.ProxyBindAddress) == nil {
+		return fmt.Errorf("无效的监听地址: %s", c.ProxyBindAddress)
+	}
+
+	for _, pattern := range c.KeyspaceRelayPatterns {
+		if pattern == "" {
+			return fmt.Errorf("keyspace通知转发的模式不能为空")
+		}
+	}
+
+	if c.StickyNode != "" {
+		if _, _, err := net.SplitHostPort(c.StickyNode); err != nil {
+			return fmt.Errorf("无效的粘性会话节点地址: %s", c.StickyNode)
+		}
+	}
+
 	for _, node := range c.RedisNodes {
 		if _, _, err := net.SplitHostPort(node); err != nil {
 			return fmt.Errorf("无效的Redis节点地址: %s", node)
@@ -41,4 +304,4 @@ func (c *Config) ValidateConfig() error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestClusterStaleAfter 读取cluster_stale_after，没有配置时使用默认的30秒
+func TestClusterStaleAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want time.Duration
+	}{
+		{"默认", "", 30 * time.Second},
+		{"cluster_stale_after", "cluster_stale_after: 5s\n", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(filename, []byte("redis_nodes: [\"127.0.0.1:7000\"]\n"+tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfigFromFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.GetClusterStaleAfter(); got != tt.want {
+				t.Errorf("过期时间应为 %v，实际为 %v", tt.want, got)
+			}
+		})
+	}
+
+	config := &Config{RedisNodes: []string{"127.0.0.1:7000"}, ClusterStaleAfter: 500 * time.Millisecond}
+	if err := config.ValidateConfig(); err == nil {
+		t.Error("cluster_stale_after小于1秒时应返回错误")
+	}
+}
+
+// TestWatchConfigFile 配置文件变化后重新加载配置，重复监听时关闭之前的监听，与Stop并发调用时没有数据竞争
+func TestWatchConfigFile(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(ttl string) {
+		content := fmt.Sprintf("redis_nodes: [%q]\nlog_level: error\nencoding_cache_ttl: %s\n", tc.nodes[0].Addr(), ttl)
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1s")
+
+	if err := tc.proxy.WatchConfigFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	first := tc.proxy.watcher
+	if err := tc.proxy.WatchConfigFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-first.stopChan:
+	default:
+		t.Error("重复监听时应关闭之前的监听")
+	}
+
+	write("7s")
+	if !waitFor(t, 3*time.Second, func() bool { return tc.proxy.currentConfig().EncodingCacheTTL == 7*time.Second }) {
+		t.Errorf("配置文件变化后应重新加载，encoding_cache_ttl为 %v", tc.proxy.currentConfig().EncodingCacheTTL)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tc.proxy.WatchConfigFile(filename)
+	}()
+	tc.proxy.Stop()
+	<-done
+}
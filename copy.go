@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// validateCopyOptions 校验COPY的选项，集群只有db 0，DB选项在路由之前拒绝
+func validateCopyOptions(command []string) error {
+	if len(command) < 3 {
+		return fmt.Errorf("wrong number of arguments for 'copy' command")
+	}
+	for i := 3; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "REPLACE":
+		case "DB":
+			return fmt.Errorf("集群模式下COPY不支持DB选项")
+		default:
+			return fmt.Errorf("syntax error")
+		}
+	}
+	return nil
+}
+
+// handleCopyCrossSlot 处理源key和目标key位于不同slot的COPY：在源key所在节点执行DUMP，
+// 再在目标key所在节点执行RESTORE，源key的过期时间一并复制。
+// 与COPY一致，源key不存在或目标key已存在且未指定REPLACE时返回0。两步之间不是原子的
+func (proxy *RedisClusterProxy) handleCopyCrossSlot(clientConn net.Conn, command []string) error {
+	source, destination := command[1], command[2]
+	replace := len(command) > 3
+
+	sourceAddr := proxy.selectNodeByKey("COPY", source)
+	dumped := proxy.executeWithMoved(sourceAddr, []string{"DUMP", source})
+	if dumped.err != nil {
+		return fmt.Errorf("COPY读取源key失败: %v", dumped.err)
+	}
+	if dumped.value.IsNil {
+		_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(0)))
+		return err
+	}
+
+	// PTTL为-1表示没有过期时间，RESTORE的ttl为0表示不设置过期时间
+	ttl := "0"
+	pttl := proxy.executeWithMoved(sourceAddr, []string{"PTTL", source})
+	if pttl.err != nil {
+		return fmt.Errorf("COPY读取源key的过期时间失败: %v", pttl.err)
+	}
+	if pttl.value.Int > 0 {
+		ttl = strconv.FormatInt(pttl.value.Int, 10)
+	} else if pttl.value.Int == -2 {
+		// DUMP之后源key已过期或被删除
+		_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(0)))
+		return err
+	}
+
+	restoreCommand := []string{"RESTORE", destination, ttl, dumped.value.Str}
+	if replace {
+		restoreCommand = append(restoreCommand, "REPLACE")
+	}
+	restored := proxy.executeWithMoved(proxy.selectNodeByKey("COPY", destination), restoreCommand)
+	if restored.err != nil {
+		if restored.value != nil && strings.HasPrefix(restored.value.Str, "BUSYKEY") {
+			_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(0)))
+			return err
+		}
+		return fmt.Errorf("COPY写入目标key失败: %v", restored.err)
+	}
+
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(1)))
+	return err
+}
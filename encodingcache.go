@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// encodingEntry 缓存的OBJECT ENCODING结果
+type encodingEntry struct {
+	encoding string
+	expiry   time.Time
+}
+
+// encodingCache OBJECT ENCODING结果的本地缓存，写命令修改key时删除对应的缓存
+type encodingCache struct {
+	entries  sync.Map // key -> *encodingEntry
+	size     atomic.Int64
+	disabled atomic.Bool
+}
+
+// newEncodingCache 创建OBJECT ENCODING缓存
+func newEncodingCache() *encodingCache {
+	return &encodingCache{}
+}
+
+// get 返回key未过期的缓存编码
+func (c *encodingCache) get(key string) (string, bool) {
+	value, ok := c.entries.Load(key)
+	if !ok {
+		return "", false
+	}
+	entry := value.(*encodingEntry)
+	if time.Now().After(entry.expiry) {
+		c.invalidate(key)
+		return "", false
+	}
+	return entry.encoding, true
+}
+
+// set 缓存key的编码，缓存已满时先清理过期的条目，仍然没有空间则不缓存
+func (c *encodingCache) set(key string, encoding string, ttl time.Duration, maxKeys int) {
+	if maxKeys > 0 && c.size.Load() >= int64(maxKeys) {
+		c.evictExpired()
+		if c.size.Load() >= int64(maxKeys) {
+			return
+		}
+	}
+	entry := &encodingEntry{encoding: encoding, expiry: time.Now().Add(ttl)}
+	if _, loaded := c.entries.Swap(key, entry); !loaded {
+		c.size.Add(1)
+	}
+}
+
+// invalidate 删除key的缓存
+func (c *encodingCache) invalidate(key string) {
+	if _, loaded := c.entries.LoadAndDelete(key); loaded {
+		c.size.Add(-1)
+	}
+}
+
+// clear 删除所有缓存，用于FLUSHALL/FLUSHDB
+func (c *encodingCache) clear() {
+	c.entries.Range(func(key, _ any) bool {
+		c.invalidate(key.(string))
+		return true
+	})
+}
+
+// evictExpired 删除所有已过期的缓存
+func (c *encodingCache) evictExpired() {
+	now := time.Now()
+	c.entries.Range(func(key, value any) bool {
+		if now.After(value.(*encodingEntry).expiry) {
+			c.invalidate(key.(string))
+		}
+		return true
+	})
+}
+
+// DisableEncodingCache 关闭OBJECT ENCODING缓存，对应命令行参数--disable-encoding-cache
+func (proxy *RedisClusterProxy) DisableEncodingCache() {
+	proxy.encodingCache.disabled.Store(true)
+	proxy.encodingCache.clear()
+}
+
+// encodingCacheEnabled 判断OBJECT ENCODING缓存是否开启
+func (proxy *RedisClusterProxy) encodingCacheEnabled() bool {
+	return !proxy.encodingCache.disabled.Load() && proxy.currentConfig().EncodingCacheTTL > 0
+}
+
+// handleObjectEncoding 优先从缓存返回OBJECT ENCODING的结果，未命中时发送到key所在的节点并缓存结果，
+// 返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleObjectEncoding(session *clientSession, command []string) (bool, error) {
+	if len(command) != 3 || !strings.EqualFold(command[1], "ENCODING") || !proxy.encodingCacheEnabled() {
+		return false, nil
+	}
+
+	key := command[2]
+	if encoding, ok := proxy.encodingCache.get(key); ok {
+		session.log.Debug("OBJECT ENCODING %s 命中缓存: %s", key, encoding)
+		return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(encoding))
+	}
+
+	result := proxy.executeWithMoved(proxy.selectBackendNode(command), command)
+	if result.err != nil {
+		if result.value != nil {
+			return true, proxy.writeClient(session, result.value.Format())
+		}
+		return true, result.err
+	}
+	// key不存在时返回NULL，不缓存
+	if result.value.Type == '$' && !result.value.IsNil {
+		config := proxy.currentConfig()
+		proxy.encodingCache.set(key, result.value.Str, config.EncodingCacheTTL, config.EncodingCacheMaxKeys)
+	}
+	return true, proxy.writeClient(session, result.value.Format())
+}
+
+// invalidateEncodingCache 写命令修改key之前删除这些key的编码缓存
+func (proxy *RedisClusterProxy) invalidateEncodingCache(cmdName string, command []string) {
+	if proxy.encodingCache.size.Load() == 0 {
+		return
+	}
+	if cmdName == "FLUSHALL" || cmdName == "FLUSHDB" {
+		proxy.encodingCache.clear()
+		return
+	}
+
+	spec := lookupCommand(cmdName)
+	if spec == nil || !spec.hasFlag(cmdWrite|cmdScript) {
+		return
+	}
+	for _, key := range spec.extractKeys(command) {
+		proxy.encodingCache.invalidate(key)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	flushNodeTimeout = 60 * time.Second // FLUSHALL/FLUSHDB在单个节点上的超时时间，同步清空大数据集可能较慢
+
+	debugReloadTimeout = 5 * time.Minute // DEBUG RELOAD/LOADAOF在单个节点上的超时时间
+)
+
+// nodeResult 单个节点的执行结果
+type nodeResult struct {
+	address string
+	value   *RespValue
+	err     error
+}
+
+// executeOnNodes 在多个节点上并发执行同一命令，结果顺序与nodes一致
+func (proxy *RedisClusterProxy) executeOnNodes(nodes []string, command []string) []nodeResult {
+	results := make([]nodeResult, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, nodeAddr := range nodes {
+		wg.Add(1)
+		go func(i int, nodeAddr string) {
+			defer wg.Done()
+			results[i] = proxy.executeParsedOnNode(nodeAddr, command)
+		}(i, nodeAddr)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// executeOnNodesBounded 在多个节点上执行同一命令，最多同时在limit个节点上执行，
+// timeout为每个节点的超时时间，结果顺序与nodes一致
+func (proxy *RedisClusterProxy) executeOnNodesBounded(nodes []string, command []string, limit int, timeout time.Duration) []nodeResult {
+	results := make([]nodeResult, len(nodes))
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i, nodeAddr := range nodes {
+		wg.Add(1)
+		go func(i int, nodeAddr string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			response, err := proxy.executeOnNodeWithin(nodeAddr, command, timeout)
+			results[i] = proxy.parseNodeResponse(nodeAddr, response, err)
+		}(i, nodeAddr)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// executeParsedOnNode 在指定节点执行命令并解析响应，错误响应也视为执行失败
+func (proxy *RedisClusterProxy) executeParsedOnNode(nodeAddr string, command []string) nodeResult {
+	response, err := proxy.executeOnNode(nodeAddr, command)
+	return proxy.parseNodeResponse(nodeAddr, response, err)
+}
+
+// parseNodeResponse 解析节点的响应，错误响应也视为执行失败
+func (proxy *RedisClusterProxy) parseNodeResponse(nodeAddr string, response string, err error) nodeResult {
+	result := nodeResult{address: nodeAddr}
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	value, err := proxy.protocol.ParseResponse(response)
+	if err != nil {
+		result.err = fmt.Errorf("解析响应失败: %v", err)
+		return result
+	}
+	if value.IsError() {
+		result.err = fmt.Errorf("%s", value.Str)
+	}
+	result.value = value
+	return result
+}
+
+// failedNodesError 汇总执行失败的节点，同一节点只列出一次，全部成功时返回nil
+func failedNodesError(cmdName string, results []nodeResult) error {
+	var failures []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if result.err != nil && !seen[result.address] {
+			seen[result.address] = true
+			failures = append(failures, fmt.Sprintf("%s (%v)", result.address, result.err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s 在以下节点执行失败: %s", cmdName, strings.Join(failures, ", "))
+}
+
+// handleFanOutCommand 处理需要发送到所有master节点的命令，返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleFanOutCommand(clientConn net.Conn, cmdName string, command []string) (bool, error) {
+	switch cmdName {
+	case "FLUSHALL", "FLUSHDB":
+		return true, proxy.handleFlush(clientConn, cmdName, command)
+	case "KEYS":
+		return true, proxy.handleKeys(clientConn, command)
+	case "RANDOMKEY":
+		return true, proxy.handleRandomKey(clientConn, command)
+	case "DBSIZE":
+		return true, proxy.handleDbSize(clientConn, command)
+	case "INFO":
+		return true, proxy.handleInfo(clientConn, command)
+	case "SCRIPT":
+		if len(command) < 2 {
+			return false, nil
+		}
+		switch strings.ToUpper(command[1]) {
+		case "LOAD":
+			return true, proxy.handleScriptLoad(clientConn, command)
+		case "EXISTS":
+			return true, proxy.handleScriptExists(clientConn, command)
+		case "FLUSH":
+			return true, proxy.broadcastToMasters(clientConn, "SCRIPT FLUSH", command)
+		}
+	case "DEBUG":
+		// 重新加载数据集需要在每个master节点上执行，依次执行避免所有节点同时不可用
+		if len(command) == 2 {
+			switch subCommand := strings.ToUpper(command[1]); subCommand {
+			case "RELOAD", "LOADAOF":
+				results := proxy.executeOnNodesBounded(proxy.clusterManager.GetMasterNodes(), command, 1, debugReloadTimeout)
+				if err := failedNodesError("DEBUG "+subCommand, results); err != nil {
+					return true, err
+				}
+				_, err := clientConn.Write([]byte(proxy.protocol.FormatSimpleString("OK")))
+				return true, err
+			}
+		}
+	case "CONFIG":
+		if len(command) < 2 || !proxy.currentConfig().ConfigBroadcast {
+			return false, nil
+		}
+		// 配置需要在所有节点（包括slave）保持一致，避免只修改了随机的一个节点
+		switch subCommand := strings.ToUpper(command[1]); subCommand {
+		case "GET":
+			return true, proxy.handleConfigGet(clientConn, command)
+		case "SET", "RESETSTAT", "REWRITE":
+			return true, proxy.broadcastToNodes(clientConn, "CONFIG "+subCommand, proxy.clusterManager.GetAllNodes(), command)
+		}
+	case "SLOWLOG":
+		if len(command) < 2 {
+			return false, nil
+		}
+		// 每个节点只记录自己的慢查询，需要汇总所有节点
+		switch strings.ToUpper(command[1]) {
+		case "GET":
+			return true, proxy.handleSlowlogGet(clientConn, command)
+		case "LEN":
+			return true, proxy.handleSlowlogLen(clientConn, command)
+		case "RESET":
+			return true, proxy.broadcastToNodes(clientConn, "SLOWLOG RESET", proxy.clusterManager.GetAllNodes(), command)
+		}
+	case "PUBSUB":
+		return proxy.handlePubSubIntrospection(clientConn, command)
+	case "FUNCTION":
+		if len(command) < 2 {
+			return false, nil
+		}
+		// 函数库需要在所有master节点保持一致，LIST/STATS/DUMP等只读子命令发送到任意节点
+		switch subCommand := strings.ToUpper(command[1]); subCommand {
+		case "LOAD", "DELETE", "FLUSH", "RESTORE":
+			return true, proxy.broadcastToMasters(clientConn, "FUNCTION "+subCommand, command)
+		}
+	}
+	return false, nil
+}
+
+// broadcastToMasters 将命令发送到所有master节点，全部成功时返回第一个节点的响应
+func (proxy *RedisClusterProxy) broadcastToMasters(clientConn net.Conn, name string, command []string) error {
+	return proxy.broadcastToNodes(clientConn, name, proxy.clusterManager.GetMasterNodes(), command)
+}
+
+// broadcastToNodes 将命令发送到nodes中的每个节点，全部成功时返回第一个节点的响应
+func (proxy *RedisClusterProxy) broadcastToNodes(clientConn net.Conn, name string, nodes []string, command []string) error {
+	results := proxy.executeOnNodes(nodes, command)
+	if err := failedNodesError(name, results); err != nil {
+		return err
+	}
+
+	_, err := clientConn.Write([]byte(results[0].value.Format()))
+	return err
+}
+
+// handleScriptLoad 在所有master节点加载脚本，全部成功时返回sha1
+func (proxy *RedisClusterProxy) handleScriptLoad(clientConn net.Conn, command []string) error {
+	proxy.scripts.Remember(command)
+	return proxy.broadcastToMasters(clientConn, "SCRIPT LOAD", command)
+}
+
+// handleScriptExists 查询所有master节点，只有所有节点都存在的脚本才返回1
+func (proxy *RedisClusterProxy) handleScriptExists(clientConn net.Conn, command []string) error {
+	results := proxy.executeOnNodes(proxy.clusterManager.GetMasterNodes(), command)
+	if err := failedNodesError("SCRIPT EXISTS", results); err != nil {
+		return err
+	}
+
+	count := len(command) - 2
+	merged := &RespValue{Type: '*', Array: make([]*RespValue, count)}
+	for i := 0; i < count; i++ {
+		exists := int64(1)
+		for _, result := range results {
+			if result.value.Type != '*' || i >= len(result.value.Array) || result.value.Array[i].Int == 0 {
+				exists = 0
+				break
+			}
+		}
+		merged.Array[i] = &RespValue{Type: ':', Int: exists}
+	}
+
+	_, err := clientConn.Write([]byte(merged.Format()))
+	return err
+}
+
+// handleConfigGet 在所有节点执行CONFIG GET，各节点的配置一致时返回第一个节点的响应，
+// 不一致时返回错误并列出每个不一致的参数在各节点上的值
+func (proxy *RedisClusterProxy) handleConfigGet(clientConn net.Conn, command []string) error {
+	nodes := proxy.clusterManager.GetAllNodes()
+	results := proxy.executeOnNodes(nodes, command)
+	if err := failedNodesError("CONFIG GET", results); err != nil {
+		return err
+	}
+
+	// 各节点返回参数的顺序可能不同，按参数名比较
+	values := make([]map[string]string, len(results))
+	names := make(map[string]bool)
+	for i, result := range results {
+		values[i] = make(map[string]string)
+		for j := 0; j+1 < len(result.value.Array); j += 2 {
+			name := result.value.Array[j].Str
+			values[i][name] = result.value.Array[j+1].Str
+			names[name] = true
+		}
+	}
+
+	var divergent []string
+	for name := range names {
+		value, exists := values[0][name]
+		consistent := true
+		for i := 1; i < len(values); i++ {
+			if other, ok := values[i][name]; ok != exists || other != value {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			continue
+		}
+
+		nodeValues := make([]string, len(results))
+		for i, result := range results {
+			if nodeValue, ok := values[i][name]; ok {
+				nodeValues[i] = fmt.Sprintf("%s=%q", result.address, nodeValue)
+			} else {
+				nodeValues[i] = fmt.Sprintf("%s=(不存在)", result.address)
+			}
+		}
+		divergent = append(divergent, fmt.Sprintf("%s [%s]", name, strings.Join(nodeValues, ", ")))
+	}
+	if len(divergent) > 0 {
+		sort.Strings(divergent)
+		return fmt.Errorf("CONFIG GET 各节点的配置不一致: %s", strings.Join(divergent, "; "))
+	}
+
+	_, err := clientConn.Write([]byte(results[0].value.Format()))
+	return err
+}
+
+// handleSlowlogGet 在所有节点执行SLOWLOG GET，按时间戳从新到旧合并，
+// 每条记录末尾追加来源节点的地址，返回的记录数与单节点的count参数含义一致
+func (proxy *RedisClusterProxy) handleSlowlogGet(clientConn net.Conn, command []string) error {
+	if len(command) > 3 {
+		return fmt.Errorf("wrong number of arguments for 'slowlog|get' command")
+	}
+	count := int64(10)
+	if len(command) == 3 {
+		var err error
+		if count, err = strconv.ParseInt(command[2], 10, 64); err != nil || count < -1 {
+			return fmt.Errorf("count should be greater than or equal to -1")
+		}
+	}
+
+	results := proxy.executeOnNodes(proxy.clusterManager.GetAllNodes(), command)
+	if err := failedNodesError("SLOWLOG GET", results); err != nil {
+		return err
+	}
+
+	var entries []*RespValue
+	for _, result := range results {
+		for _, entry := range result.value.Array {
+			if entry.Type != '*' || len(entry.Array) < 2 {
+				continue
+			}
+			entry.Array = append(entry.Array, &RespValue{Type: '$', Str: result.address})
+			entries = append(entries, entry)
+		}
+	}
+
+	// 时间戳相同时按记录ID从大到小排列
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Array[1].Int != entries[j].Array[1].Int {
+			return entries[i].Array[1].Int > entries[j].Array[1].Int
+		}
+		return entries[i].Array[0].Int > entries[j].Array[0].Int
+	})
+	if count >= 0 && int64(len(entries)) > count {
+		entries = entries[:count]
+	}
+
+	merged := &RespValue{Type: '*', Array: entries}
+	_, err := clientConn.Write([]byte(merged.Format()))
+	return err
+}
+
+// handleSlowlogLen 在所有节点执行SLOWLOG LEN，返回各节点慢查询记录数之和
+func (proxy *RedisClusterProxy) handleSlowlogLen(clientConn net.Conn, command []string) error {
+	results := proxy.executeOnNodes(proxy.clusterManager.GetAllNodes(), command)
+	if err := failedNodesError("SLOWLOG LEN", results); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, result := range results {
+		total += result.value.Int
+	}
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(total)))
+	return err
+}
+
+// handleWait 默认将WAIT发送到会话最近一条写命令所在的节点，没有写命令时发送到随机节点；
+// 开启wait_aggregate时在所有master节点执行，返回各节点确认的replica数量的最小值
+func (proxy *RedisClusterProxy) handleWait(session *clientSession, command []string) error {
+	if !proxy.currentConfig().WaitAggregate {
+		nodeAddr := session.lastWriteNode
+		if nodeAddr == "" {
+			nodeAddr = proxy.clusterManager.GetRandomNode()
+		}
+		session.log.Debug("WAIT路由到最近一次写入的节点: %s", nodeAddr)
+		return proxy.executeCommandWithRedirect(session, command, nodeAddr, 0, 0)
+	}
+
+	results := proxy.executeOnNodes(proxy.clusterManager.GetMasterNodes(), command)
+	if err := failedNodesError("WAIT", results); err != nil {
+		return err
+	}
+
+	acknowledged := results[0].value.Int
+	for _, result := range results[1:] {
+		if result.value.Int < acknowledged {
+			acknowledged = result.value.Int
+		}
+	}
+	return proxy.writeClient(session, proxy.protocol.FormatInteger(acknowledged))
+}
+
+// handleFlush 依次在每个master节点执行FLUSHALL/FLUSHDB，ASYNC/SYNC参数原样传递，全部成功时返回OK。
+// 逐个节点执行，每个节点的执行时间有限制，避免同时清空所有节点、阻塞连接池。
+// 开启require_flush_confirmation时命令最后必须带有代理扩展的CONFIRMED参数，该参数不发送到后端
+func (proxy *RedisClusterProxy) handleFlush(clientConn net.Conn, cmdName string, command []string) error {
+	confirmed := len(command) > 1 && strings.EqualFold(command[len(command)-1], "CONFIRMED")
+	if confirmed {
+		command = command[:len(command)-1]
+	}
+	if len(command) > 2 {
+		return fmt.Errorf("syntax error")
+	}
+	if len(command) == 2 {
+		switch strings.ToUpper(command[1]) {
+		case "ASYNC", "SYNC":
+		default:
+			return fmt.Errorf("syntax error")
+		}
+	}
+	if proxy.currentConfig().RequireFlushConfirmation && !confirmed {
+		return fmt.Errorf("代理要求确认%s，请使用 %s CONFIRMED", cmdName, cmdName)
+	}
+
+	masters := proxy.clusterManager.GetMasterNodes()
+	results := proxy.executeOnNodesBounded(masters, command, 1, flushNodeTimeout)
+	if err := failedNodesError(cmdName, results); err != nil {
+		return err
+	}
+
+	LogInfo("%v 已在 %d 个master节点执行", command, len(masters))
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatSimpleString("OK")))
+	return err
+}
+
+// handleRandomKey 在所有master节点并发执行RANDOMKEY，从返回了key的节点中等概率选择一个结果；
+// 所有节点都没有key时返回nil。部分节点失败时只在成功的节点中选择，全部失败时返回错误
+func (proxy *RedisClusterProxy) handleRandomKey(clientConn net.Conn, command []string) error {
+	if len(command) != 1 {
+		return fmt.Errorf("wrong number of arguments for 'randomkey' command")
+	}
+
+	results := proxy.executeOnNodes(proxy.clusterManager.GetMasterNodes(), command)
+	var keys []*RespValue
+	succeeded := 0
+	for _, result := range results {
+		if result.err != nil {
+			LogDebug("节点 %s 执行RANDOMKEY失败: %v", result.address, result.err)
+			continue
+		}
+		succeeded++
+		if !result.value.IsNil {
+			keys = append(keys, result.value)
+		}
+	}
+	if succeeded == 0 {
+		if err := failedNodesError("RANDOMKEY", results); err != nil {
+			return err
+		}
+	}
+
+	reply := "$-1\r\n"
+	if len(keys) > 0 {
+		reply = keys[rand.Intn(len(keys))].Format()
+	}
+	_, err := clientConn.Write([]byte(reply))
+	return err
+}
+
+// handleDbSize 在所有master节点并发执行DBSIZE并返回key数量之和。部分节点失败时记录警告并返回成功节点之和，
+// 调用方得到近似的数量；全部失败时返回错误
+func (proxy *RedisClusterProxy) handleDbSize(clientConn net.Conn, command []string) error {
+	if len(command) != 1 {
+		return fmt.Errorf("wrong number of arguments for 'dbsize' command")
+	}
+
+	results := proxy.executeOnNodes(proxy.clusterManager.GetMasterNodes(), command)
+	var total int64
+	succeeded := 0
+	for _, result := range results {
+		if result.err == nil && result.value.Type == ':' {
+			total += result.value.Int
+			succeeded++
+		}
+	}
+	if succeeded == 0 {
+		if err := failedNodesError("DBSIZE", results); err != nil {
+			return err
+		}
+	} else if succeeded < len(results) {
+		LogWarn("%v，返回其余 %d 个节点的key数量之和", failedNodesError("DBSIZE", results), succeeded)
+	}
+
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(total)))
+	return err
+}
+
+// handleKeys 在所有master节点并发执行KEYS并合并结果，去掉resharding期间在两个节点上重复出现的key。
+// 任一节点失败或超过keys_scan_timeout时返回错误，结果总数超过keys_max_results时返回错误，提示使用SCAN
+func (proxy *RedisClusterProxy) handleKeys(clientConn net.Conn, command []string) error {
+	if len(command) != 2 {
+		return fmt.Errorf("wrong number of arguments for 'keys' command")
+	}
+
+	config := proxy.currentConfig()
+	if config.DisableKEYS {
+		return fmt.Errorf("KEYS已被代理禁用，请使用SCAN")
+	}
+	LogWarn("执行KEYS %s: KEYS会遍历所有节点的全部key并阻塞节点，生产环境请使用SCAN", command[1])
+
+	masters := proxy.clusterManager.GetMasterNodes()
+	results := proxy.executeOnNodesBounded(masters, command, max(len(masters), 1), config.GetKeysScanTimeout())
+	if err := failedNodesError("KEYS", results); err != nil {
+		return err
+	}
+
+	maxResults := config.KeysMaxResults
+	merged := &RespValue{Type: '*', Array: []*RespValue{}}
+	seen := make(map[string]bool)
+	for _, result := range results {
+		for _, key := range result.value.Array {
+			if !seen[key.Str] {
+				seen[key.Str] = true
+				merged.Array = append(merged.Array, key)
+			}
+		}
+		if maxResults > 0 && len(merged.Array) > maxResults {
+			return fmt.Errorf("KEYS匹配的key超过%d个，请使用SCAN分批遍历", maxResults)
+		}
+	}
+
+	_, err := clientConn.Write([]byte(merged.Format()))
+	return err
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// allowScriptFlush 重新开启默认禁止的SCRIPT FLUSH
+func allowScriptFlush(config *Config) {
+	config.AllowedDangerousCommands = []string{"SCRIPT FLUSH"}
+}
+
+// TestScriptFanOut SCRIPT LOAD/EXISTS/FLUSH在所有master节点执行
+func TestScriptFanOut(t *testing.T) {
+	tc := newTestCluster(t, 3, allowScriptFlush)
+	client := tc.client(t)
+	script := "return 'fan-out'"
+	sha := scriptSHA1(script)
+
+	client.expectReply(bulk(sha), "SCRIPT", "LOAD", script)
+	for _, node := range tc.nodes {
+		direct := dialProxy(t, tc.proxy, node.Addr())
+		direct.expectReply("*1\r\n:1\r\n", "SCRIPT", "EXISTS", sha)
+	}
+
+	// 只在一个节点上加载的脚本，SCRIPT EXISTS返回0
+	partial := "return 'one node'"
+	dialProxy(t, tc.proxy, tc.nodes[1].Addr()).expectReply(bulk(scriptSHA1(partial)), "SCRIPT", "LOAD", partial)
+	client.expectReply("*3\r\n:1\r\n:0\r\n:0\r\n", "SCRIPT", "EXISTS", sha, scriptSHA1(partial), scriptSHA1("return 'unknown'"))
+
+	client.expectReply("+OK\r\n", "SCRIPT", "FLUSH")
+	for _, node := range tc.nodes {
+		direct := dialProxy(t, tc.proxy, node.Addr())
+		direct.expectReply("*2\r\n:0\r\n:0\r\n", "SCRIPT", "EXISTS", sha, scriptSHA1(partial))
+	}
+}
+
+// TestScriptFanOutPartialFailure 部分节点失败时返回代理错误并列出失败的节点
+func TestScriptFanOutPartialFailure(t *testing.T) {
+	tc := newTestCluster(t, 3, allowScriptFlush)
+	client := tc.client(t)
+	failed := tc.nodes[2].Addr()
+	tc.nodes[2].Close()
+
+	for _, command := range [][]string{
+		{"SCRIPT", "LOAD", "return 1"},
+		{"SCRIPT", "EXISTS", scriptSHA1("return 1")},
+		{"SCRIPT", "FLUSH"},
+	} {
+		got := client.do(command...)
+		if !strings.HasPrefix(got, "-ERR ") || !strings.Contains(got, failed) {
+			t.Errorf("%v: 应返回列出失败节点 %s 的错误，实际为 %q", command, failed, got)
+		}
+		for _, node := range tc.nodes[:2] {
+			if strings.Contains(got, node.Addr()) {
+				t.Errorf("%v: 成功的节点 %s 不应出现在错误中: %q", command, node.Addr(), got)
+			}
+		}
+	}
+}
+
+// functionHandler 应答FUNCTION和FCALL的假节点
+func functionHandler(command []string) string {
+	switch strings.ToUpper(command[0]) {
+	case "FUNCTION":
+		switch strings.ToUpper(command[1]) {
+		case "LOAD":
+			return bulk("mylib")
+		case "LIST":
+			return "*0\r\n"
+		}
+	case "FCALL", "FCALL_RO":
+		return bulk("called")
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// TestFunctionCommands FCALL按numkeys之后的key路由，FUNCTION LOAD发送到所有master节点，FUNCTION LIST发送到任意节点
+func TestFunctionCommands(t *testing.T) {
+	fc := newFakeCluster(t, 3, functionHandler, nil)
+	client := fc.client(t)
+
+	client.expectReply(bulk("mylib"), "FUNCTION", "LOAD", "#!lua name=mylib\nredis.register_function('myfunc', function(keys) return 1 end)")
+	for _, node := range fc.nodes {
+		if len(node.received("FUNCTION")) != 1 {
+			t.Errorf("FUNCTION LOAD应发送到节点 %s", node.addr)
+		}
+	}
+
+	client.expectReply("*0\r\n", "FUNCTION", "LIST")
+	total := 0
+	for _, node := range fc.nodes {
+		total += len(node.received("FUNCTION"))
+	}
+	if total != 4 {
+		t.Errorf("FUNCTION LIST应只发送到一个节点，FUNCTION命令共收到 %d 次", total)
+	}
+
+	client.expectReply(bulk("called"), "FCALL", "myfunc", "2", "{user1000}.a", "{user1000}.b", "arg")
+	client.expectReply(bulk("called"), "FCALL_RO", "myfunc", "1", "{user1000}.a")
+	owner := fc.nodeFor("{user1000}.a")
+	for _, node := range fc.nodes {
+		want := 0
+		if node == owner {
+			want = 1
+		}
+		if got := len(node.received("FCALL")); got != want {
+			t.Errorf("节点 %s 收到 %d 次FCALL，应为 %d", node.addr, got, want)
+		}
+		if got := len(node.received("FCALL_RO")); got != want {
+			t.Errorf("节点 %s 收到 %d 次FCALL_RO，应为 %d", node.addr, got, want)
+		}
+	}
+
+	client.expectErrorPrefix("CROSSSLOT", "FCALL", "myfunc", "2", "foo", "bar")
+}
+
+// TestFunctionLoadPartialFailure FUNCTION LOAD在部分节点失败时返回列出失败节点的错误
+func TestFunctionLoadPartialFailure(t *testing.T) {
+	good := startFakeNode(t, functionHandler)
+	bad := startFakeNode(t, func(command []string) string {
+		return "-ERR Library 'mylib' already exists\r\n"
+	})
+	client := startFakeMasters(t, []*fakeNode{good, bad}, nil).client(t)
+
+	got := client.do("FUNCTION", "LOAD", "#!lua name=mylib\n")
+	if !strings.HasPrefix(got, "-ERR ") || !strings.Contains(got, bad.addr) || strings.Contains(got, good.addr) {
+		t.Errorf("应返回只列出失败节点 %s 的错误，实际为 %q", bad.addr, got)
+	}
+}
+
+// TestKeysFanOut KEYS合并所有master节点的结果，超过keys_max_results时提示使用SCAN，节点不可用时返回错误
+func TestKeysFanOut(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	want := make(map[string]bool)
+	for i := range tc.nodes {
+		for j := 0; j < 2; j++ {
+			key := tc.keyOn(i, fmt.Sprintf("user:%d:", j))
+			tc.nodes[i].Set(key, "v")
+			want[key] = true
+		}
+		tc.nodes[i].Set(fmt.Sprintf("other:%d", i), "v")
+	}
+
+	got := client.doValue("KEYS", "user:*")
+	if len(got.Array) != len(want) {
+		t.Fatalf("KEYS应返回所有节点上的 %d 个key，实际为 %d 个", len(want), len(got.Array))
+	}
+	for _, key := range got.Array {
+		if !want[key.Str] {
+			t.Errorf("KEYS返回了不匹配的key %s", key.Str)
+		}
+	}
+
+	tc.updateConfig(func(config *Config) { config.KeysMaxResults = 5 })
+	client.expectErrorPrefix("ERR KEYS匹配的key超过5个，请使用SCAN", "KEYS", "*")
+	if got := client.doValue("KEYS", "other:*"); len(got.Array) != 3 {
+		t.Errorf("未超过上限时应返回全部结果，实际为 %v", got.Array)
+	}
+
+	down := tc.nodes[2].Addr()
+	tc.nodes[2].Close()
+	if reply := client.do("KEYS", "user:*"); !strings.HasPrefix(reply, "-ERR ") || !strings.Contains(reply, down) {
+		t.Errorf("节点不可用时应返回列出该节点的错误而不是部分结果，实际为 %q", reply)
+	}
+}
+
+// allowFlush 重新开启默认禁止的FLUSHALL和FLUSHDB
+func allowFlush(config *Config) {
+	config.AllowedDangerousCommands = []string{"FLUSHALL", "FLUSHDB"}
+}
+
+// TestFlushFanOut FLUSHALL/FLUSHDB连同ASYNC/SYNC参数发送到所有master节点，部分失败时错误中列出失败的节点
+func TestFlushFanOut(t *testing.T) {
+	ok := func(command []string) string { return "+OK\r\n" }
+	nodes := []*fakeNode{startFakeNode(t, ok), startFakeNode(t, ok), startFakeNode(t, func(command []string) string {
+		if strings.EqualFold(command[0], "FLUSHDB") {
+			return "-READONLY You can't write against a read only replica.\r\n"
+		}
+		return "+OK\r\n"
+	})}
+	client := startFakeMasters(t, nodes, allowFlush).client(t)
+
+	client.expectReply("+OK\r\n", "FLUSHALL", "ASYNC")
+	for _, node := range nodes {
+		if got := node.received("FLUSHALL"); len(got) != 1 || !reflect.DeepEqual(got[0], []string{"FLUSHALL", "ASYNC"}) {
+			t.Errorf("节点 %s 应收到FLUSHALL ASYNC，实际为 %v", node.addr, got)
+		}
+	}
+
+	reply := client.do("FLUSHDB", "SYNC")
+	if !strings.HasPrefix(reply, "-ERR FLUSHDB 在以下节点执行失败") || !strings.Contains(reply, nodes[2].addr) || !strings.Contains(reply, "READONLY") {
+		t.Errorf("应返回列出失败节点 %s 的错误，实际为 %q", nodes[2].addr, reply)
+	}
+	for _, node := range nodes[:2] {
+		if strings.Contains(reply, node.addr) {
+			t.Errorf("成功的节点 %s 不应出现在错误中", node.addr)
+		}
+		if got := node.received("FLUSHDB"); len(got) != 1 || got[0][1] != "SYNC" {
+			t.Errorf("节点 %s 应收到FLUSHDB SYNC，实际为 %v", node.addr, got)
+		}
+	}
+
+	client.expectErrorPrefix("ERR syntax error", "FLUSHALL", "LATER")
+}
+
+// TestFlushDisabledByDefault FLUSHALL默认被禁止，不发送到任何节点
+func TestFlushDisabledByDefault(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string { return "+OK\r\n" }, nil)
+	fc.client(t).expectErrorPrefix("ERR", "FLUSHALL")
+	for _, node := range fc.nodes {
+		if len(node.received("FLUSHALL")) != 0 {
+			t.Errorf("禁止的FLUSHALL不应发送到节点 %s", node.addr)
+		}
+	}
+}
+
+// TestRandomKeyAllMasters 多次RANDOMKEY返回所有master节点上的key，空节点不会导致返回nil
+func TestRandomKeyAllMasters(t *testing.T) {
+	tc := newTestCluster(t, 4, nil)
+	client := tc.client(t)
+	client.expectReply("$-1\r\n", "RANDOMKEY")
+
+	// 第4个节点没有key
+	want := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		key := tc.keyOn(i, "random")
+		tc.nodes[i].Set(key, "v")
+		want[key] = true
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 200 && len(seen) < len(want); i++ {
+		reply := client.doValue("RANDOMKEY")
+		if reply.IsNil || !want[reply.Str] {
+			t.Fatalf("RANDOMKEY应返回已有的key，实际为 %+v", reply)
+		}
+		seen[reply.Str] = true
+	}
+	if len(seen) != len(want) {
+		t.Errorf("多次RANDOMKEY应返回所有节点上的key，实际只返回了 %v", seen)
+	}
+}
+
+// configNode 应答CONFIG GET/SET的假节点，policy为maxmemory-policy的值，setError不为空时CONFIG SET返回该错误
+func configNode(t *testing.T, policy string, setError string) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		if !strings.EqualFold(command[0], "CONFIG") {
+			return "-ERR unknown command\r\n"
+		}
+		switch strings.ToUpper(command[1]) {
+		case "GET":
+			return "*4\r\n" + bulk("maxmemory-policy") + bulk(policy) + bulk("maxmemory") + bulk("0")
+		case "SET":
+			if setError != "" {
+				return "-" + setError + "\r\n"
+			}
+		}
+		return "+OK\r\n"
+	})
+}
+
+// startConfigCluster 启动由nodes组成的集群，nodes[0]为master，其余为它的replica
+func startConfigCluster(t *testing.T, nodes []*fakeNode, broadcast bool) *testClient {
+	t.Helper()
+	topology := clusterNodesLine(1, nodes[0].addr, "master", "0-16383")
+	for i, node := range nodes[1:] {
+		topology += "\n" + clusterNodesLine(i+2, node.addr, "slave", "1")
+	}
+	proxy, addr := startTestProxy(t, []string{nodes[0].addr}, topology, func(config *Config) {
+		config.AllowedDangerousCommands = []string{"CONFIG"}
+		config.ConfigBroadcast = broadcast
+	})
+	return dialProxy(t, proxy, addr)
+}
+
+// TestConfigBroadcast CONFIG GET在各节点一致时返回结果，不一致时列出各节点的值；CONFIG SET发送到所有节点
+func TestConfigBroadcast(t *testing.T) {
+	nodes := []*fakeNode{configNode(t, "noeviction", ""), configNode(t, "noeviction", ""), configNode(t, "noeviction", "")}
+	client := startConfigCluster(t, nodes, true)
+
+	client.expectReply("*4\r\n"+bulk("maxmemory-policy")+bulk("noeviction")+bulk("maxmemory")+bulk("0"), "CONFIG", "GET", "*")
+	client.expectReply("+OK\r\n", "CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	client.expectReply("+OK\r\n", "CONFIG", "RESETSTAT")
+	for _, node := range nodes {
+		if got := node.received("CONFIG"); len(got) != 3 {
+			t.Errorf("节点 %s 应收到3条CONFIG命令，实际为 %v", node.addr, got)
+		}
+	}
+}
+
+// TestConfigGetDivergence 各节点的配置不一致时返回错误，列出不一致的参数在每个节点上的值
+func TestConfigGetDivergence(t *testing.T) {
+	nodes := []*fakeNode{configNode(t, "noeviction", ""), configNode(t, "allkeys-lru", ""), configNode(t, "noeviction", "")}
+	client := startConfigCluster(t, nodes, true)
+
+	reply := client.do("CONFIG", "GET", "*")
+	if !strings.HasPrefix(reply, "-ERR CONFIG GET 各节点的配置不一致: maxmemory-policy [") {
+		t.Fatalf("应返回列出不一致参数的错误，实际为 %q", reply)
+	}
+	for i, policy := range []string{"noeviction", "allkeys-lru", "noeviction"} {
+		if want := fmt.Sprintf(`%s="%s"`, nodes[i].addr, policy); !strings.Contains(reply, want) {
+			t.Errorf("错误中应包含 %s，实际为 %q", want, reply)
+		}
+	}
+	if strings.Contains(reply, "maxmemory [") {
+		t.Errorf("一致的参数不应出现在错误中: %q", reply)
+	}
+}
+
+// TestConfigNodeFailure 有节点失败时CONFIG SET和CONFIG GET返回列出该节点的错误
+func TestConfigNodeFailure(t *testing.T) {
+	nodes := []*fakeNode{configNode(t, "noeviction", ""), configNode(t, "noeviction", "ERR Unsupported CONFIG parameter"), configNode(t, "noeviction", "")}
+	client := startConfigCluster(t, nodes, true)
+
+	reply := client.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	if !strings.HasPrefix(reply, "-ERR CONFIG SET 在以下节点执行失败") || !strings.Contains(reply, nodes[1].addr) || strings.Contains(reply, nodes[0].addr) {
+		t.Errorf("应返回只列出失败节点 %s 的错误，实际为 %q", nodes[1].addr, reply)
+	}
+
+	nodes[2].Close()
+	if reply := client.do("CONFIG", "GET", "maxmemory"); !strings.HasPrefix(reply, "-ERR CONFIG GET 在以下节点执行失败") || !strings.Contains(reply, nodes[2].addr) {
+		t.Errorf("节点不可用时应返回列出该节点的错误，实际为 %q", reply)
+	}
+}
+
+// TestConfigPassthrough 关闭config_broadcast时CONFIG发送到单个节点
+func TestConfigPassthrough(t *testing.T) {
+	nodes := []*fakeNode{configNode(t, "noeviction", ""), configNode(t, "allkeys-lru", ""), configNode(t, "noeviction", "")}
+	client := startConfigCluster(t, nodes, false)
+
+	client.expectReply("+OK\r\n", "CONFIG", "SET", "maxmemory", "100mb")
+	total := 0
+	for _, node := range nodes {
+		total += len(node.received("CONFIG"))
+	}
+	if total != 1 {
+		t.Errorf("CONFIG SET应只发送到一个节点，实际发送 %d 次", total)
+	}
+}
+
+// slowlogEntry 格式化一条SLOWLOG GET记录
+func slowlogEntry(id int, timestamp int, command string) string {
+	return fmt.Sprintf("*6\r\n:%d\r\n:%d\r\n:100\r\n*1\r\n%s%s%s", id, timestamp, bulk(command), bulk("127.0.0.1:1"), bulk(""))
+}
+
+// slowlogNode 应答SLOWLOG的假节点，entries为按时间从新到旧排列的记录
+func slowlogNode(t *testing.T, entries ...string) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[1]) {
+		case "GET":
+			return fmt.Sprintf("*%d\r\n%s", len(entries), strings.Join(entries, ""))
+		case "LEN":
+			return fmt.Sprintf(":%d\r\n", len(entries))
+		case "RESET":
+			return "+OK\r\n"
+		}
+		return "-ERR unknown subcommand\r\n"
+	})
+}
+
+// TestSlowlogAggregation SLOWLOG GET合并所有节点的记录并按时间戳从新到旧排列，每条记录末尾追加来源节点；
+// SLOWLOG LEN返回各节点之和，SLOWLOG RESET发送到所有节点
+func TestSlowlogAggregation(t *testing.T) {
+	nodes := []*fakeNode{
+		slowlogNode(t, slowlogEntry(2, 300, "a2"), slowlogEntry(1, 100, "a1")),
+		slowlogNode(t, slowlogEntry(7, 400, "b7"), slowlogEntry(6, 200, "b6"), slowlogEntry(5, 50, "b5")),
+		slowlogNode(t),
+	}
+	client := startFakeMasters(t, nodes, nil).client(t)
+
+	got := client.doValue("SLOWLOG", "GET", "-1")
+	want := []struct {
+		command string
+		node    *fakeNode
+	}{{"b7", nodes[1]}, {"a2", nodes[0]}, {"b6", nodes[1]}, {"a1", nodes[0]}, {"b5", nodes[1]}}
+	if len(got.Array) != len(want) {
+		t.Fatalf("应返回 %d 条记录，实际为 %d 条", len(want), len(got.Array))
+	}
+	for i, entry := range got.Array {
+		if len(entry.Array) != 7 {
+			t.Fatalf("记录 %d 应在末尾追加来源节点，实际有 %d 个字段", i, len(entry.Array))
+		}
+		if entry.Array[3].Array[0].Str != want[i].command || entry.Array[6].Str != want[i].node.addr {
+			t.Errorf("第 %d 条记录应为节点 %s 的 %s，实际为节点 %s 的 %s", i, want[i].node.addr, want[i].command, entry.Array[6].Str, entry.Array[3].Array[0].Str)
+		}
+	}
+
+	// 默认返回10条，count限制合并后的总数
+	if got := client.doValue("SLOWLOG", "GET"); len(got.Array) != 5 {
+		t.Errorf("SLOWLOG GET应返回全部5条记录，实际为 %d 条", len(got.Array))
+	}
+	if got := client.doValue("SLOWLOG", "GET", "2"); len(got.Array) != 2 || got.Array[1].Array[3].Array[0].Str != "a2" {
+		t.Errorf("SLOWLOG GET 2应返回最新的2条记录，实际为 %v", got.Array)
+	}
+	client.expectErrorPrefix("ERR count should be greater than or equal to -1", "SLOWLOG", "GET", "-2")
+
+	client.expectReply(":5\r\n", "SLOWLOG", "LEN")
+	client.expectReply("+OK\r\n", "SLOWLOG", "RESET")
+	for _, node := range nodes {
+		if got := node.received("SLOWLOG"); got[len(got)-1][1] != "RESET" {
+			t.Errorf("SLOWLOG RESET应发送到节点 %s", node.addr)
+		}
+	}
+}
+
+// waitNode 应答SET和GET，WAIT返回固定的replica确认数量
+func waitNode(t *testing.T, acknowledged int) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "SET":
+			return "+OK\r\n"
+		case "GET":
+			return "$-1\r\n"
+		case "WAIT":
+			return fmt.Sprintf(":%d\r\n", acknowledged)
+		}
+		return "-ERR unknown command\r\n"
+	})
+}
+
+// TestWaitFollowsLastWrite WAIT发送到会话最近一条写命令所在的节点，读命令不改变WAIT的目标
+func TestWaitFollowsLastWrite(t *testing.T) {
+	nodes := []*fakeNode{waitNode(t, 1), waitNode(t, 2), waitNode(t, 3)}
+	fc := startFakeMasters(t, nodes, nil)
+	client := fc.client(t)
+	other := fc.client(t)
+
+	// foo在第三个节点，bar在第一个节点
+	client.expectReply("+OK\r\n", "SET", "foo", "1")
+	client.expectReply(":3\r\n", "WAIT", "1", "0")
+	client.expectReply("$-1\r\n", "GET", "bar")
+	client.expectReply(":3\r\n", "WAIT", "1", "0")
+	client.expectReply("+OK\r\n", "SET", "bar", "1")
+	client.expectReply(":1\r\n", "WAIT", "1", "100")
+
+	// 其他会话的写入不影响当前会话
+	other.expectReply("+OK\r\n", "SET", "foo", "2")
+	client.expectReply(":1\r\n", "WAIT", "1", "0")
+
+	if got := len(nodes[1].received("WAIT")); got != 0 {
+		t.Errorf("没有写入的节点不应收到WAIT，实际收到 %d 次", got)
+	}
+	if got := nodes[0].received("WAIT"); len(got) != 2 || !reflect.DeepEqual(got[0], []string{"WAIT", "1", "100"}) {
+		t.Errorf("WAIT应原样发送到最近一次写入的节点，实际为 %q", got)
+	}
+
+	// 没有写命令的会话发送到任意节点
+	if got := fc.client(t).doValue("WAIT", "0", "0"); got.Type != ':' {
+		t.Errorf("没有写命令时WAIT应返回整数，实际为 %+v", got)
+	}
+}
+
+// TestWaitAggregate 开启wait_aggregate时WAIT在所有master节点执行，返回确认数量的最小值
+func TestWaitAggregate(t *testing.T) {
+	nodes := []*fakeNode{waitNode(t, 2), waitNode(t, 1), waitNode(t, 3)}
+	client := startFakeMasters(t, nodes, func(config *Config) {
+		config.WaitAggregate = true
+	}).client(t)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "1")
+	client.expectReply(":1\r\n", "WAIT", "2", "50")
+	for _, node := range nodes {
+		if got := node.received("WAIT"); len(got) != 1 || !reflect.DeepEqual(got[0], []string{"WAIT", "2", "50"}) {
+			t.Errorf("节点 %s 应收到一次WAIT，实际为 %q", node.addr, got)
+		}
+	}
+
+	// 有节点执行失败时返回错误，不返回其余节点的最小值
+	nodes[1].Close()
+	client.expectErrorPrefix("ERR WAIT", "WAIT", "2", "50")
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testCluster 测试用的集群：n个miniredis节点平均分配所有slot，代理使用手工设置的拓扑
+type testCluster struct {
+	proxy *RedisClusterProxy
+	nodes []*miniredis.Miniredis
+	addr  string
+}
+
+// newTestCluster 启动n个miniredis节点和连接它们的代理，configure可以在启动前修改配置
+func newTestCluster(t *testing.T, n int, configure func(config *Config)) *testCluster {
+	t.Helper()
+	tc := &testCluster{}
+	var addrs, lines []string
+	for i := 0; i < n; i++ {
+		node := miniredis.RunT(t)
+		tc.nodes = append(tc.nodes, node)
+		addrs = append(addrs, node.Addr())
+		start, end := i*16384/n, (i+1)*16384/n-1
+		lines = append(lines, clusterNodesLine(i+1, node.Addr(), "master", fmt.Sprintf("%d-%d", start, end)))
+	}
+	tc.proxy, tc.addr = startTestProxy(t, addrs, strings.Join(lines, "\n"), configure)
+	return tc
+}
+
+// fakeCluster 测试用的集群：n个假节点平均分配所有slot
+type fakeCluster struct {
+	proxy *RedisClusterProxy
+	nodes []*fakeNode
+	addr  string
+}
+
+// newFakeCluster 启动n个使用同一handler的假节点和连接它们的代理
+func newFakeCluster(t *testing.T, n int, handler func(command []string) string, configure func(config *Config)) *fakeCluster {
+	t.Helper()
+	nodes := make([]*fakeNode, n)
+	for i := range nodes {
+		nodes[i] = startFakeNode(t, handler)
+	}
+	return startFakeMasters(t, nodes, configure)
+}
+
+// startFakeMasters 启动连接nodes的代理，nodes依次平均分配所有slot
+func startFakeMasters(t *testing.T, nodes []*fakeNode, configure func(config *Config)) *fakeCluster {
+	t.Helper()
+	fc := &fakeCluster{nodes: nodes}
+	var addrs, lines []string
+	for i, node := range nodes {
+		addrs = append(addrs, node.addr)
+		start, end := i*16384/len(nodes), (i+1)*16384/len(nodes)-1
+		lines = append(lines, clusterNodesLine(i+1, node.addr, "master", fmt.Sprintf("%d-%d", start, end)))
+	}
+	fc.proxy, fc.addr = startTestProxy(t, addrs, strings.Join(lines, "\n"), configure)
+	return fc
+}
+
+// client 建立一个到代理的连接
+func (fc *fakeCluster) client(t *testing.T) *testClient {
+	return dialProxy(t, fc.proxy, fc.addr)
+}
+
+// nodeFor 返回key所在的假节点
+func (fc *fakeCluster) nodeFor(key string) *fakeNode {
+	addr := fc.proxy.clusterManager.GetNodeForKey(key)
+	for _, node := range fc.nodes {
+		if node.addr == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// startTestProxy 使用给定的拓扑启动代理，返回代理和监听地址。miniredis和假节点不支持CLUSTER NODES，
+// 启动时的刷新失败后保留这里设置的拓扑。日志按config.LogLevel和config.LogFile输出
+func startTestProxy(t *testing.T, nodes []string, topology string, configure func(config *Config)) (*RedisClusterProxy, string) {
+	t.Helper()
+	port := freePort(t)
+	config := &Config{ProxyPort: port, ProxyBindAddress: "127.0.0.1", RedisNodes: nodes, AutoRedirect: true, LogLevel: "error"}
+	if configure != nil {
+		configure(config)
+	}
+	InitLogger(config.LogLevel, config.LogFile, "text", 0, 0)
+
+	proxy := NewRedisClusterProxy(config)
+	setTopology(proxy, topology)
+	go proxy.Start()
+	t.Cleanup(proxy.Stop)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return proxy, addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("代理没有在 %s 上启动", addr)
+	return nil, ""
+}
+
+// setTopology 用CLUSTER NODES格式的拓扑替换代理的集群信息
+func setTopology(proxy *RedisClusterProxy, topology string) {
+	cm := proxy.clusterManager
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.parseClusterNodes(topology)
+	cm.lastUpdate = time.Now()
+}
+
+// clusterNodesLine 生成一行CLUSTER NODES，role为master时slots为负责的slot范围，为slave时为master的编号
+func clusterNodesLine(id int, addr string, role string, slots string) string {
+	if role == "master" {
+		return fmt.Sprintf("%040d %s@1 master - 0 0 %d connected %s", id, addr, id, slots)
+	}
+	masterID, _ := strconv.Atoi(slots)
+	return fmt.Sprintf("%040d %s@1 slave %040d 0 0 %d connected", id, addr, masterID, id)
+}
+
+// freePort 返回一个空闲的本地端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// nodeFor 返回key所在的miniredis节点
+func (tc *testCluster) nodeFor(key string) *miniredis.Miniredis {
+	addr := tc.proxy.clusterManager.GetNodeForKey(key)
+	for _, node := range tc.nodes {
+		if node.Addr() == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// keyOn 返回一个位于第i个节点上、以prefix开头的key
+func (tc *testCluster) keyOn(i int, prefix string) string {
+	for n := 0; ; n++ {
+		key := fmt.Sprintf("%s%d", prefix, n)
+		if tc.nodeFor(key) == tc.nodes[i] {
+			return key
+		}
+	}
+}
+
+// updateConfig 修改代理当前生效的配置
+func (tc *testCluster) updateConfig(update func(config *Config)) {
+	updateProxyConfig(tc.proxy, update)
+}
+
+// updateProxyConfig 复制代理当前的配置，修改后整体替换
+func updateProxyConfig(proxy *RedisClusterProxy, update func(config *Config)) {
+	config := *proxy.currentConfig()
+	update(&config)
+	proxy.config.Store(&config)
+}
+
+// testClient 连接代理的测试客户端
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	proxy  *RedisClusterProxy
+}
+
+// client 建立一个到代理的连接
+func (tc *testCluster) client(t *testing.T) *testClient {
+	return dialProxy(t, tc.proxy, tc.addr)
+}
+
+// dialProxy 建立一个到代理的连接，测试结束时关闭
+func dialProxy(t *testing.T, proxy *RedisClusterProxy, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn), proxy: proxy}
+}
+
+// send 发送一条命令，不等待响应
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(formatTestCommand(args))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read 读取一个完整的原始RESP响应
+func (c *testClient) read() string {
+	c.t.Helper()
+	return c.readWithin(3 * time.Second)
+}
+
+// readWithin 在timeout内读取一个完整的原始RESP响应
+func (c *testClient) readWithin(timeout time.Duration) string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	response, err := c.proxy.readResponse(c.reader)
+	if err != nil {
+		c.t.Fatalf("读取响应失败: %v", err)
+	}
+	return response
+}
+
+// do 发送一条命令并返回原始RESP响应
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// doValue 发送一条命令并返回解析后的响应
+func (c *testClient) doValue(args ...string) *RespValue {
+	c.t.Helper()
+	return parseTestResponse(c.t, c.do(args...))
+}
+
+// parseTestResponse 解析原始RESP响应
+func parseTestResponse(t *testing.T, response string) *RespValue {
+	t.Helper()
+	value, err := (&RedisProtocol{}).ParseResponse(response)
+	if err != nil {
+		t.Fatalf("解析响应 %q 失败: %v", response, err)
+	}
+	return value
+}
+
+// formatTestCommand 将参数格式化为RESP数组
+func formatTestCommand(args []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+// expectReply 检查命令的原始响应
+func (c *testClient) expectReply(want string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); got != want {
+		c.t.Errorf("%v: 响应应为 %q，实际为 %q", args, want, got)
+	}
+}
+
+// expectErrorPrefix 检查命令返回以prefix开头的错误
+func (c *testClient) expectErrorPrefix(prefix string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); !strings.HasPrefix(got, "-"+prefix) {
+		c.t.Errorf("%v: 应返回以 %q 开头的错误，实际为 %q", args, prefix, got)
+	}
+}
+
+// fakeNode 按handler应答命令的假后端节点，记录收到的所有命令
+type fakeNode struct {
+	addr     string
+	listener net.Listener
+	handler  func(conn net.Conn, command []string) string
+
+	mutex    sync.Mutex
+	commands [][]string
+	conns    []net.Conn
+	open     int // 还没有断开的连接数
+}
+
+// startFakeNode 启动假后端节点。handler返回原始RESP响应，返回空字符串时不应答；
+// 不带参数的PING（代理的启动检查和连接池的连接检查）和READONLY（到replica的连接初始化）在handler之前固定应答
+func startFakeNode(t *testing.T, handler func(command []string) string) *fakeNode {
+	t.Helper()
+	if handler == nil {
+		return startFakeConnNode(t, nil)
+	}
+	return startFakeConnNode(t, func(conn net.Conn, command []string) string { return handler(command) })
+}
+
+// startFakeConnNode 与startFakeNode相同，handler额外得到收到命令的连接，用于需要按连接区分状态或主动推送消息的假节点
+func startFakeConnNode(t *testing.T, handler func(conn net.Conn, command []string) string) *fakeNode {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{addr: listener.Addr().String(), listener: listener, handler: handler}
+	t.Cleanup(node.Close)
+	go node.serve()
+	return node
+}
+
+// serve 接受连接并应答命令
+func (node *fakeNode) serve() {
+	for {
+		conn, err := node.listener.Accept()
+		if err != nil {
+			return
+		}
+		node.mutex.Lock()
+		node.conns = append(node.conns, conn)
+		node.open++
+		node.mutex.Unlock()
+		go node.serveConn(conn)
+	}
+}
+
+// serveConn 读取一个连接上的命令并应答
+func (node *fakeNode) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		node.mutex.Lock()
+		node.open--
+		node.mutex.Unlock()
+	}()
+	reader := bufio.NewReader(conn)
+	protocol := &RedisProtocol{}
+	for {
+		command, err := protocol.ParseCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(command) == 0 {
+			continue
+		}
+		node.mutex.Lock()
+		node.commands = append(node.commands, command)
+		node.mutex.Unlock()
+
+		reply := "-ERR unknown command\r\n"
+		if strings.EqualFold(command[0], "PING") && (len(command) == 1 || node.handler == nil) {
+			reply = "+PONG\r\n"
+		} else if strings.EqualFold(command[0], "READONLY") {
+			reply = "+OK\r\n"
+		} else if node.handler != nil {
+			reply = node.handler(conn, command)
+		}
+		if reply != "" {
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// received 返回节点收到的命令名为name的命令
+func (node *fakeNode) received(name string) [][]string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	var commands [][]string
+	for _, command := range node.commands {
+		if strings.EqualFold(command[0], name) {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// openConns 返回还没有断开的连接数
+func (node *fakeNode) openConns() int {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.open
+}
+
+// Close 关闭监听和所有连接
+func (node *fakeNode) Close() {
+	node.listener.Close()
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, conn := range node.conns {
+		conn.Close()
+	}
+}
+
+// bulk 格式化批量字符串响应
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// waitFor 在timeout内等待cond成立
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
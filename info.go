@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// infoAveragedSuffixes 求平均值而不是求和的数值字段后缀，比例、百分比和单次耗时在各节点之间相加没有意义
+var infoAveragedSuffixes = []string{"_ratio", "_perc", "_percentage", "_per_call"}
+
+// infoAveragedFields 求平均值的数值字段
+var infoAveragedFields = map[string]bool{
+	"uptime_in_seconds": true,
+	"uptime_in_days":    true,
+	"avg_ttl":           true,
+	"hz":                true,
+	"configured_hz":     true,
+}
+
+// infoPerNodeFields 只对单个节点有意义的数值字段（进程号、端口、时间戳、复制偏移量等），按非数值字段处理
+var infoPerNodeFields = map[string]bool{
+	"process_id":                     true,
+	"tcp_port":                       true,
+	"arch_bits":                      true,
+	"lru_clock":                      true,
+	"server_time_usec":               true,
+	"rdb_last_save_time":             true,
+	"master_repl_offset":             true,
+	"second_repl_offset":             true,
+	"repl_backlog_first_byte_offset": true,
+	"slave_repl_offset":              true,
+	"master_last_io_seconds_ago":     true,
+}
+
+// infoSection INFO响应中的一节，fields按第一次出现的顺序排列
+type infoSection struct {
+	name   string
+	fields []string
+}
+
+// handleInfo 在所有master节点执行INFO，section参数原样传递，合并为一个INFO格式的响应：
+// 数值字段为各节点之和（比例、百分比、单次耗时和运行时间为平均值），db0:keys=...这类复合字段按子字段合并；
+// 非数值字段取第一个响应的节点的值。响应开头的Proxy一节列出参与合并的节点数和各节点取值不同的字段
+func (proxy *RedisClusterProxy) handleInfo(clientConn net.Conn, command []string) error {
+	results := proxy.executeOnNodes(proxy.clusterManager.GetMasterNodes(), command)
+
+	var sections []*infoSection
+	seen := make(map[string]bool)
+	values := make(map[string][]string)
+	firstNode := ""
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			LogWarn("节点 %s 执行INFO失败: %v", result.address, result.err)
+			continue
+		}
+		if firstNode == "" {
+			firstNode = result.address
+		}
+
+		section := &infoSection{}
+		for _, line := range strings.Split(result.value.Str, "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if strings.HasPrefix(line, "#") {
+				name := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+				existing := findInfoSection(sections, name)
+				if existing == nil {
+					existing = &infoSection{name: name}
+					sections = append(sections, existing)
+				}
+				section = existing
+				continue
+			}
+			field, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			if !seen[field] {
+				seen[field] = true
+				section.fields = append(section.fields, field)
+			}
+			values[field] = append(values[field], value)
+		}
+	}
+	if firstNode == "" {
+		if err := failedNodesError("INFO", results); err != nil {
+			return err
+		}
+	}
+
+	var differing []string
+	var body strings.Builder
+	for _, section := range sections {
+		body.WriteString("# " + section.name + "\r\n")
+		for _, field := range section.fields {
+			value, differs := mergeInfoValues(field, values[field])
+			if differs {
+				differing = append(differing, field)
+			}
+			body.WriteString(field + ":" + value + "\r\n")
+		}
+		body.WriteString("\r\n")
+	}
+
+	var header strings.Builder
+	header.WriteString("# Proxy\r\n")
+	fmt.Fprintf(&header, "aggregated_nodes:%d\r\n", len(results)-failed)
+	fmt.Fprintf(&header, "failed_nodes:%d\r\n", failed)
+	header.WriteString("non_numeric_fields_from:" + firstNode + "\r\n")
+	header.WriteString("differing_fields:" + strings.Join(differing, ",") + "\r\n")
+	header.WriteString("\r\n")
+
+	info := strings.TrimSuffix(header.String()+body.String(), "\r\n")
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatBulkString(info)))
+	return err
+}
+
+// findInfoSection 按名称查找INFO的一节
+func findInfoSection(sections []*infoSection, name string) *infoSection {
+	for _, section := range sections {
+		if section.name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// mergeInfoValues 合并一个字段在各节点上的值，返回合并结果以及非数值字段在各节点之间是否不同
+func mergeInfoValues(field string, values []string) (string, bool) {
+	if merged, ok := mergeInfoNumbers(field, values); ok {
+		return merged, false
+	}
+
+	// db0:keys=1,expires=0,avg_ttl=0、cmdstat_get:calls=1,usec=2这类字段按子字段合并
+	if strings.Contains(values[0], "=") {
+		if merged, ok := mergeInfoSubfields(values); ok {
+			return merged, false
+		}
+	}
+
+	for _, value := range values[1:] {
+		if value != values[0] {
+			return values[0], true
+		}
+	}
+	return values[0], false
+}
+
+// mergeInfoSubfields 按子字段合并k=v,k=v格式的值，子字段不都是数值时返回false
+func mergeInfoSubfields(values []string) (string, bool) {
+	var names []string
+	subvalues := make(map[string][]string)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			name, subvalue, ok := strings.Cut(pair, "=")
+			if !ok {
+				return "", false
+			}
+			if _, seen := subvalues[name]; !seen {
+				names = append(names, name)
+			}
+			subvalues[name] = append(subvalues[name], subvalue)
+		}
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		merged, ok := mergeInfoNumbers(name, subvalues[name])
+		if !ok {
+			return "", false
+		}
+		pairs[i] = name + "=" + merged
+	}
+	return strings.Join(pairs, ","), true
+}
+
+// mergeInfoNumbers 合并数值字段：默认求和，比例、百分比等求平均值；有非数值或只对单个节点有意义的字段时返回false
+func mergeInfoNumbers(field string, values []string) (string, bool) {
+	if infoPerNodeFields[field] {
+		return "", false
+	}
+
+	// 整数字段按整数累加，避免字节数等较大的值转换为浮点数后丢失精度
+	var intSum int64
+	var sum float64
+	integral := true
+	for _, value := range values {
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			intSum += number
+		} else {
+			integral = false
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", false
+		}
+		sum += number
+	}
+
+	if isInfoAveragedField(field) {
+		if integral {
+			return strconv.FormatInt(intSum/int64(len(values)), 10), true
+		}
+		return strconv.FormatFloat(sum/float64(len(values)), 'f', 2, 64), true
+	}
+	if integral {
+		return strconv.FormatInt(intSum, 10), true
+	}
+	return strconv.FormatFloat(sum, 'f', 2, 64), true
+}
+
+// isInfoAveragedField 判断数值字段是否求平均值
+func isInfoAveragedField(field string) bool {
+	if infoAveragedFields[field] {
+		return true
+	}
+	for _, suffix := range infoAveragedSuffixes {
+		if strings.HasSuffix(field, suffix) {
+			return true
+		}
+	}
+	return false
+}
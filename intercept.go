@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// handleLocalCommand 处理由代理直接应答、不转发到后端的命令，返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleLocalCommand(session *clientSession, cmdName string, command []string) (bool, error) {
+	switch cmdName {
+	case "CLUSTER":
+		if len(command) < 2 {
+			return false, nil
+		}
+		switch strings.ToUpper(command[1]) {
+		case "INFO":
+			return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(proxy.clusterInfo()))
+		case "MYID":
+			// 每次转发可能到达不同的节点，返回代理自身的ID
+			if len(command) != 2 {
+				return false, nil
+			}
+			return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(proxyNodeID(proxy.currentConfig().ProxyPort)))
+		case "KEYSLOT":
+			// 纯计算，不需要访问后端，集群不可用时也能应答
+			if len(command) != 3 {
+				return false, nil
+			}
+			return true, proxy.writeClient(session, proxy.protocol.FormatInteger(int64(CalculateSlot(command[2]))))
+		}
+	case "CLIENT":
+		return proxy.handleClientCommand(session, command)
+	case "HELLO":
+		return true, proxy.handleHello(session, command)
+	case "READONLY", "READWRITE":
+		return true, proxy.handleReadOnly(session, cmdName, command)
+	case "OBJECT":
+		return proxy.handleObjectEncoding(session, command)
+	case "PROXY":
+		return true, proxy.handleProxyCommand(session, command)
+	case "SELECT":
+		// 集群只有db 0，部分客户端连接后会执行SELECT 0
+		if !proxy.currentConfig().AllowSelectZero || len(command) != 2 {
+			return false, nil
+		}
+		if command[1] == "0" {
+			return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+		}
+		if _, err := strconv.Atoi(command[1]); err != nil {
+			return true, fmt.Errorf("value is not an integer or out of range")
+		}
+		return true, fmt.Errorf("SELECT is not allowed in cluster mode")
+	case "DEBUG":
+		// DEBUG FLUSHALL与FLUSHALL效果相同，FLUSHALL被禁用时一并禁用
+		if len(command) > 1 && strings.EqualFold(command[1], "FLUSHALL") && proxy.isCommandBlocked("FLUSHALL") {
+			return true, fmt.Errorf("命令 'DEBUG FLUSHALL' 已被代理禁用")
+		}
+	case "PING":
+		// 负载均衡器的健康检查不依赖后端节点是否可用
+		if !proxy.currentConfig().LocalPing {
+			return false, nil
+		}
+		if len(command) > 2 {
+			return true, fmt.Errorf("wrong number of arguments for 'ping' command")
+		}
+		if len(command) == 2 {
+			return true, proxy.writeClient(session, proxy.protocol.FormatBulkString(command[1]))
+		}
+		return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("PONG"))
+	case "LOLWUT":
+		return true, proxy.handleLolwut(session, command)
+	case "COMMAND":
+		if len(proxy.currentConfig().BlockedCommands) == 0 && len(proxy.currentConfig().CommandRenames) == 0 {
+			return false, nil
+		}
+		return proxy.handleCommandInfo(session, command)
+	}
+	return false, nil
+}
+
+// lolwutBanner LOLWUT返回的代理标识，后端节点的LOLWUT输出与代理无关，不转发
+const lolwutBanner = "Redis Cluster Proxy v1.0\nBertXin/redisclusterproxy\n\nRedis is real, proxy is proxy\n"
+
+// handleLolwut 处理LOLWUT [VERSION n]，直接返回代理的标识
+func (proxy *RedisClusterProxy) handleLolwut(session *clientSession, command []string) error {
+	if len(command) > 1 {
+		if len(command) != 3 || !strings.EqualFold(command[1], "VERSION") {
+			return fmt.Errorf("syntax error")
+		}
+		if _, err := strconv.Atoi(command[2]); err != nil {
+			return fmt.Errorf("value is not an integer or out of range")
+		}
+	}
+	return proxy.writeClient(session, proxy.protocol.FormatBulkString(lolwutBanner))
+}
+
+// handleProxyCommand 处理代理自身的管理命令
+//
+//	PROXY KEYSLOT key                  返回[slot, 负责该slot的节点地址]，slot未分配时节点地址为nil
+//	PROXY NODE host:port command ...   在指定节点上执行命令并原样返回响应，需要开启proxy_node_command
+//	PROXY STICKY ON|OFF                开启或关闭当前连接的粘性会话
+func (proxy *RedisClusterProxy) handleProxyCommand(session *clientSession, command []string) error {
+	if len(command) < 2 {
+		return fmt.Errorf("wrong number of arguments for 'proxy' command")
+	}
+
+	switch subCommand := strings.ToUpper(command[1]); subCommand {
+	case "KEYSLOT":
+		if len(command) != 3 {
+			return fmt.Errorf("wrong number of arguments for 'proxy|keyslot' command")
+		}
+		slot := CalculateSlot(command[2])
+		node := &RespValue{Type: '$', IsNil: true}
+		if nodeAddr := proxy.clusterManager.GetNodeForSlot(slot); nodeAddr != "" {
+			node = &RespValue{Type: '$', Str: nodeAddr}
+		}
+		reply := &RespValue{Type: '*', Array: []*RespValue{{Type: ':', Int: int64(slot)}, node}}
+		return proxy.writeClient(session, reply.Format())
+	case "STICKY":
+		return proxy.handleProxySticky(session, command)
+	case "NODE":
+		if !proxy.currentConfig().ProxyNodeCommand {
+			return fmt.Errorf("PROXY NODE未开启，请设置proxy_node_command")
+		}
+		if len(command) < 4 {
+			return fmt.Errorf("wrong number of arguments for 'proxy|node' command")
+		}
+		return proxy.handleProxyNode(session, command[2], command[3:])
+	default:
+		return fmt.Errorf("未知的PROXY子命令 '%s'", command[1])
+	}
+}
+
+// handleProxyNode 在集群中的指定节点上执行命令，不跟随重定向，响应原样返回给客户端
+func (proxy *RedisClusterProxy) handleProxyNode(session *clientSession, nodeAddr string, command []string) error {
+	if err := proxy.checkKnownNode(nodeAddr); err != nil {
+		return err
+	}
+
+	// 禁用的命令和未开启的危险命令在指定节点上同样禁止执行
+	if proxy.isCommandBlocked(command[0]) {
+		return fmt.Errorf("命令 '%s' 已被代理禁用", command[0])
+	}
+	if err := proxy.checkDangerousCommand(command); err != nil {
+		return err
+	}
+	if err := proxy.checkCommandPolicy(command); err != nil {
+		return err
+	}
+
+	session.log.Info("PROXY NODE 在节点 %s 执行命令 %s", nodeAddr, command[0])
+	response, err := proxy.executeOnNode(nodeAddr, command)
+	if err != nil {
+		return err
+	}
+	return proxy.writeClient(session, response)
+}
+
+// checkKnownNode 节点不在集群中时返回错误，错误信息列出已知节点
+func (proxy *RedisClusterProxy) checkKnownNode(nodeAddr string) error {
+	nodes := proxy.clusterManager.GetAllNodes()
+	for _, address := range nodes {
+		if address == nodeAddr {
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的节点 %s，已知节点: %s", nodeAddr, strings.Join(nodes, ", "))
+}
+
+// dangerousCommands 默认禁止通过代理执行的危险命令，可以通过allowed_dangerous_commands重新开启。
+// 带子命令的项只禁止该子命令
+var dangerousCommands = []string{
+	"SHUTDOWN",
+	"DEBUG",
+	"FLUSHALL",
+	"FLUSHDB",
+	"CONFIG",
+	"SCRIPT FLUSH",
+	"MONITOR",
+	// 修改集群拓扑的CLUSTER子命令
+	"CLUSTER ADDSLOTS",
+	"CLUSTER ADDSLOTSRANGE",
+	"CLUSTER DELSLOTS",
+	"CLUSTER DELSLOTSRANGE",
+	"CLUSTER FLUSHSLOTS",
+	"CLUSTER SETSLOT",
+	"CLUSTER FAILOVER",
+	"CLUSTER FORGET",
+	"CLUSTER MEET",
+	"CLUSTER REPLICATE",
+	"CLUSTER RESET",
+	"CLUSTER BUMPEPOCH",
+	"CLUSTER SET-CONFIG-EPOCH",
+}
+
+// dangerousCommandEntry 返回命令匹配的危险命令项，不是危险命令时返回空字符串
+func dangerousCommandEntry(command []string) string {
+	name := strings.ToUpper(command[0])
+	subCommand := ""
+	if len(command) > 1 {
+		subCommand = name + " " + strings.ToUpper(command[1])
+	}
+	for _, entry := range dangerousCommands {
+		if entry == name || entry == subCommand {
+			return entry
+		}
+	}
+	return ""
+}
+
+// checkDangerousCommand 危险命令未在allowed_dangerous_commands中开启时返回错误。
+// 允许列表中的项可以是完整的危险命令项（如CLUSTER FAILOVER），也可以是命令名（如CLUSTER，开启它的所有子命令）。
+// 直接使用后端重命名后的名称时按客户端名称检查
+func (proxy *RedisClusterProxy) checkDangerousCommand(command []string) error {
+	if name := proxy.clientCommandName(command[0]); name != command[0] {
+		command = append([]string{name}, command[1:]...)
+	}
+	entry := dangerousCommandEntry(command)
+	if entry == "" {
+		return nil
+	}
+	name, _, _ := strings.Cut(entry, " ")
+	for _, allowed := range proxy.currentConfig().AllowedDangerousCommands {
+		if strings.EqualFold(allowed, entry) || strings.EqualFold(allowed, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command disabled by proxy")
+}
+
+// isCommandBlocked 判断命令是否在配置的禁用列表中
+func (proxy *RedisClusterProxy) isCommandBlocked(cmdName string) bool {
+	for _, blocked := range proxy.currentConfig().BlockedCommands {
+		if strings.EqualFold(blocked, cmdName) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCommandInfo 从后端获取COMMAND系列命令的响应，将重命名的命令还原为客户端使用的名称、
+// 去掉被代理禁用的命令后返回给客户端，返回命令是否已被处理。GETKEYS等其他子命令直接转发
+func (proxy *RedisClusterProxy) handleCommandInfo(session *clientSession, command []string) (bool, error) {
+	subCommand := ""
+	if len(command) > 1 {
+		subCommand = strings.ToUpper(command[1])
+	}
+
+	switch subCommand {
+	case "", "COUNT", "INFO", "DOCS", "LIST":
+	default:
+		return false, nil
+	}
+
+	backendCommand := command
+	switch subCommand {
+	case "COUNT":
+		// 禁用的命令不计入总数，通过完整的命令列表计算
+		backendCommand = []string{"COMMAND"}
+	case "INFO", "DOCS":
+		// 参数中的命令名同样需要替换为后端的名称
+		backendCommand = append([]string{command[0], command[1]}, command[2:]...)
+		for i := 2; i < len(backendCommand); i++ {
+			backendCommand[i] = proxy.backendCommandName(backendCommand[i])
+		}
+	}
+
+	result := proxy.executeParsedOnNode(proxy.clusterManager.GetRandomNode(), backendCommand)
+	if result.err != nil {
+		return true, result.err
+	}
+	reply := result.value
+	if reply.Type != '*' {
+		return true, proxy.writeClient(session, reply.Format())
+	}
+
+	proxy.restoreCommandNames(subCommand, reply)
+
+	filtered := &RespValue{Type: '*', Array: []*RespValue{}}
+	switch subCommand {
+	case "", "COUNT":
+		// 每个元素为[name arity flags ...]
+		for _, entry := range reply.Array {
+			if len(entry.Array) > 0 && proxy.isCommandBlocked(entry.Array[0].Str) {
+				continue
+			}
+			filtered.Array = append(filtered.Array, entry)
+		}
+		if subCommand == "COUNT" {
+			return true, proxy.writeClient(session, proxy.protocol.FormatInteger(int64(len(filtered.Array))))
+		}
+	case "INFO":
+		// 与未知命令一致，禁用的命令返回NULL
+		for _, entry := range reply.Array {
+			if len(entry.Array) > 0 && proxy.isCommandBlocked(entry.Array[0].Str) {
+				entry = &RespValue{Type: '*', IsNil: true}
+			}
+			filtered.Array = append(filtered.Array, entry)
+		}
+	case "DOCS":
+		// RESP2下为name、doc交替排列的数组
+		for i := 0; i+1 < len(reply.Array); i += 2 {
+			if proxy.isCommandBlocked(reply.Array[i].Str) {
+				continue
+			}
+			filtered.Array = append(filtered.Array, reply.Array[i], reply.Array[i+1])
+		}
+	case "LIST":
+		for _, name := range reply.Array {
+			if !proxy.isCommandBlocked(name.Str) {
+				filtered.Array = append(filtered.Array, name)
+			}
+		}
+	}
+	return true, proxy.writeClient(session, filtered.Format())
+}
+
+// restoreCommandNames 将COMMAND系列命令响应中重命名后的命令名还原为客户端使用的名称
+func (proxy *RedisClusterProxy) restoreCommandNames(subCommand string, reply *RespValue) {
+	if len(proxy.currentConfig().CommandRenames) == 0 {
+		return
+	}
+	switch subCommand {
+	case "", "COUNT", "INFO":
+		for _, entry := range reply.Array {
+			if len(entry.Array) > 0 {
+				entry.Array[0].Str = proxy.clientCommandName(entry.Array[0].Str)
+			}
+		}
+	case "DOCS":
+		for i := 0; i < len(reply.Array); i += 2 {
+			reply.Array[i].Str = proxy.clientCommandName(reply.Array[i].Str)
+		}
+	case "LIST":
+		for _, name := range reply.Array {
+			name.Str = proxy.clientCommandName(name.Str)
+		}
+	}
+}
+
+// clusterInfo 根据代理掌握的集群拓扑生成CLUSTER INFO响应内容，格式与Redis一致
+func (proxy *RedisClusterProxy) clusterInfo() string {
+	stats := proxy.clusterManager.GetClusterStats()
+
+	slotsAssigned := stats["slots_assigned"].(int)
+	slotsOK := stats["slots_ok"].(int)
+	state := "ok"
+	if slotsAssigned < 16384 || slotsOK < slotsAssigned {
+		state = "fail"
+	}
+
+	lines := []string{
+		"cluster_enabled:1",
+		"cluster_state:" + state,
+		fmt.Sprintf("cluster_slots_assigned:%d", slotsAssigned),
+		fmt.Sprintf("cluster_slots_ok:%d", slotsOK),
+		"cluster_slots_pfail:0",
+		fmt.Sprintf("cluster_slots_fail:%d", stats["slots_fail"].(int)),
+		fmt.Sprintf("cluster_known_nodes:%d", stats["total_nodes"].(int)),
+		fmt.Sprintf("cluster_size:%d", stats["cluster_size"].(int)),
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// proxyNodeID 根据主机名和代理端口生成稳定的节点ID，格式与Redis节点ID相同（40个十六进制字符）
+func proxyNodeID(port int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d", hostname, port)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// keyslotVectors redis-cli CLUSTER KEYSLOT的结果
+var keyslotVectors = []struct {
+	key  string
+	slot int
+}{
+	{"foo", 12182},
+	{"bar", 5061},
+	{"hello", 866},
+	{"somekey", 11058},
+	{"{foo}.profile", 12182},
+	{"user:{bar}", 5061},
+	{"{hello}{foo}", 866},
+	{"", 0},
+}
+
+// TestClusterKeySlotLocal CLUSTER KEYSLOT由代理计算，不访问后端，所有节点不可用时也能应答
+func TestClusterKeySlotLocal(t *testing.T) {
+	fc := newFakeCluster(t, 2, nil, nil)
+	client := fc.client(t)
+	for _, node := range fc.nodes {
+		node.Close()
+	}
+
+	for _, tt := range keyslotVectors {
+		client.expectReply(fmt.Sprintf(":%d\r\n", tt.slot), "CLUSTER", "KEYSLOT", tt.key)
+	}
+	client.expectReply(fmt.Sprintf(":%d\r\n", CalculateSlot("{user1000}.followers")), "cluster", "keyslot", "{user1000}.following")
+	for _, node := range fc.nodes {
+		for _, command := range node.received("CLUSTER") {
+			if len(command) > 1 && command[1] == "KEYSLOT" {
+				t.Errorf("CLUSTER KEYSLOT不应发送到节点 %s", node.addr)
+			}
+		}
+	}
+}
+
+// TestProxyKeySlot PROXY KEYSLOT返回slot和负责该slot的节点，slot未分配时节点为nil
+func TestProxyKeySlot(t *testing.T) {
+	node := startFakeNode(t, nil)
+	// 只分配0-12000
+	proxy, addr := startTestProxy(t, []string{node.addr}, clusterNodesLine(1, node.addr, "master", "0-12000"), nil)
+	client := dialProxy(t, proxy, addr)
+
+	for _, tt := range keyslotVectors {
+		owner := bulk(node.addr)
+		if tt.slot > 12000 {
+			owner = "$-1\r\n"
+		}
+		client.expectReply(fmt.Sprintf("*2\r\n:%d\r\n%s", tt.slot, owner), "PROXY", "KEYSLOT", tt.key)
+	}
+	client.expectErrorPrefix("ERR wrong number of arguments for 'proxy|keyslot' command", "PROXY", "KEYSLOT")
+}
+
+// TestProxyNodeDisabled 默认不允许PROXY NODE
+func TestProxyNodeDisabled(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	client := tc.client(t)
+	client.expectErrorPrefix("ERR PROXY NODE未开启", "PROXY", "NODE", tc.nodes[0].Addr(), "PING")
+}
+
+// TestProxyNode PROXY NODE在指定节点上执行命令，不按key路由
+func TestProxyNode(t *testing.T) {
+	tc := newTestCluster(t, 3, func(config *Config) {
+		config.ProxyNodeCommand = true
+		config.BlockedCommands = []string{"KEYS"}
+	})
+	client := tc.client(t)
+
+	// 写到不负责该key的节点上，miniredis不返回MOVED，数据留在指定的节点
+	owner := tc.nodeFor("foo")
+	target := tc.nodes[0]
+	if target == owner {
+		target = tc.nodes[1]
+	}
+	client.expectReply("+OK\r\n", "PROXY", "NODE", target.Addr(), "SET", "foo", "direct")
+	if got, _ := target.Get("foo"); got != "direct" {
+		t.Errorf("PROXY NODE应写入指定的节点 %s", target.Addr())
+	}
+	if owner.Exists("foo") {
+		t.Error("PROXY NODE不应按key路由到负责的节点")
+	}
+	client.expectReply(bulk("direct"), "proxy", "node", target.Addr(), "GET", "foo")
+	client.expectReply("$-1\r\n", "GET", "foo")
+
+	client.expectErrorPrefix("ERR wrong number of arguments for 'proxy|node' command", "PROXY", "NODE", target.Addr())
+}
+
+// TestProxyNodeRejects 未知节点返回已知节点列表；禁用的命令和危险命令在指定节点上同样禁止
+func TestProxyNodeRejects(t *testing.T) {
+	tc := newTestCluster(t, 2, func(config *Config) {
+		config.ProxyNodeCommand = true
+		config.BlockedCommands = []string{"KEYS"}
+	})
+	client := tc.client(t)
+	addr := tc.nodes[0].Addr()
+
+	reply := client.do("PROXY", "NODE", "127.0.0.1:1", "PING")
+	if !strings.HasPrefix(reply, "-ERR 未知的节点 127.0.0.1:1，已知节点: ") {
+		t.Fatalf("未知节点的响应 = %q", reply)
+	}
+	for _, node := range tc.nodes {
+		if !strings.Contains(reply, node.Addr()) {
+			t.Errorf("错误信息应列出节点 %s: %q", node.Addr(), reply)
+		}
+	}
+
+	tc.nodes[0].Set("k", "v")
+	client.expectErrorPrefix("ERR 命令 'KEYS' 已被代理禁用", "PROXY", "NODE", addr, "KEYS", "*")
+	client.expectErrorPrefix("ERR command disabled by proxy", "PROXY", "NODE", addr, "FLUSHALL")
+	client.expectErrorPrefix("ERR command disabled by proxy", "PROXY", "NODE", addr, "CLUSTER", "FAILOVER")
+	if !tc.nodes[0].Exists("k") {
+		t.Error("被禁止的FLUSHALL不应发送到节点")
+	}
+}
+
+// TestDangerousCommandsBlocked 危险命令默认被拒绝，在路由之前返回错误，不发送到后端
+func TestDangerousCommandsBlocked(t *testing.T) {
+	fc := newFakeCluster(t, 2, func(command []string) string { return "+OK\r\n" }, nil)
+	client := fc.client(t)
+
+	blocked := [][]string{
+		{"SHUTDOWN"},
+		{"shutdown", "NOSAVE"},
+		{"DEBUG", "SLEEP", "0"},
+		{"FLUSHALL"},
+		{"FLUSHDB", "ASYNC"},
+		{"CONFIG", "SET", "maxmemory", "0"},
+		{"config", "get", "maxmemory"},
+		{"SCRIPT", "FLUSH"},
+		{"script", "flush", "SYNC"},
+		{"CLUSTER", "FAILOVER"},
+		{"cluster", "setslot", "1", "NODE", "abc"},
+		{"CLUSTER", "ADDSLOTS", "1"},
+		{"CLUSTER", "FORGET", "abc"},
+		{"CLUSTER", "MEET", "127.0.0.1", "7000"},
+		{"CLUSTER", "RESET", "HARD"},
+	}
+	for _, command := range blocked {
+		client.expectReply("-ERR command disabled by proxy\r\n", command...)
+	}
+
+	// 只禁止SCRIPT FLUSH和修改拓扑的CLUSTER子命令，普通命令正常转发
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	client.expectReply("+OK\r\n", "SCRIPT", "KILL")
+	client.expectReply(":12182\r\n", "CLUSTER", "KEYSLOT", "foo")
+
+	for _, node := range fc.nodes {
+		for _, name := range []string{"SHUTDOWN", "DEBUG", "FLUSHALL", "FLUSHDB", "CONFIG"} {
+			if got := node.received(name); len(got) != 0 {
+				t.Errorf("节点 %s 不应收到 %s: %q", node.addr, name, got)
+			}
+		}
+		for _, command := range node.received("SCRIPT") {
+			if strings.EqualFold(command[1], "FLUSH") {
+				t.Errorf("节点 %s 不应收到SCRIPT FLUSH", node.addr)
+			}
+		}
+		for _, command := range node.received("CLUSTER") {
+			if !strings.EqualFold(command[1], "NODES") {
+				t.Errorf("节点 %s 不应收到 %q", node.addr, command)
+			}
+		}
+	}
+}
+
+// TestDangerousCommandsBlockedInTransaction 事务中的危险命令被拒绝并使事务在EXEC时失败
+func TestDangerousCommandsBlockedInTransaction(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	client := tc.client(t)
+	tc.nodes[0].Set("foo", "1")
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("-ERR command disabled by proxy\r\n", "FLUSHALL")
+	client.expectErrorPrefix("EXECABORT", "EXEC")
+	if !tc.nodes[0].Exists("foo") {
+		t.Error("事务中被拒绝的FLUSHALL不应执行")
+	}
+}
+
+// TestAllowedDangerousCommands allowed_dangerous_commands按命令名或完整的子命令项重新开启危险命令，不区分大小写
+func TestAllowedDangerousCommands(t *testing.T) {
+	fc := newFakeCluster(t, 1, func(command []string) string { return "+OK\r\n" }, func(config *Config) {
+		config.AllowedDangerousCommands = []string{"flushdb", "cluster failover", "Debug"}
+	})
+	client := fc.client(t)
+
+	client.expectReply("+OK\r\n", "FLUSHDB")
+	client.expectReply("+OK\r\n", "CLUSTER", "FAILOVER", "FORCE")
+	client.expectReply("+OK\r\n", "DEBUG", "SLEEP", "0")
+
+	// 开启CLUSTER FAILOVER不会开启其他CLUSTER子命令
+	client.expectReply("-ERR command disabled by proxy\r\n", "CLUSTER", "RESET")
+	client.expectReply("-ERR command disabled by proxy\r\n", "FLUSHALL")
+
+	node := fc.nodes[0]
+	if len(node.received("FLUSHDB")) != 1 || len(node.received("DEBUG")) != 1 || len(node.received("FLUSHALL")) != 0 {
+		t.Errorf("只有开启的危险命令应发送到节点: FLUSHDB=%d DEBUG=%d FLUSHALL=%d",
+			len(node.received("FLUSHDB")), len(node.received("DEBUG")), len(node.received("FLUSHALL")))
+	}
+}
+
+// TestAllowedDangerousCommandName 允许列表中的命令名开启该命令的所有危险子命令
+func TestAllowedDangerousCommandName(t *testing.T) {
+	fc := newFakeCluster(t, 1, func(command []string) string { return "+OK\r\n" }, func(config *Config) {
+		config.AllowedDangerousCommands = []string{"CLUSTER"}
+	})
+	client := fc.client(t)
+	client.expectReply("+OK\r\n", "CLUSTER", "RESET")
+	client.expectReply("+OK\r\n", "CLUSTER", "FORGET", "abc")
+	client.expectReply("-ERR command disabled by proxy\r\n", "SHUTDOWN")
+}
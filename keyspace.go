@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	keyspaceRelaySyncInterval = 5 * time.Second // 检查master节点变化、增删上游订阅的间隔
+	keyspaceRelayRetryWait    = 1 * time.Second // 上游订阅连接断开后重新连接前的等待时间
+)
+
+// keyspaceRelay 在每个master节点上订阅keyspace_relay_patterns，将收到的消息转发给订阅了相同模式的代理客户端。
+// keyspace通知只在产生事件的节点上发布，而客户端通过代理订阅时只连接到一个节点
+type keyspaceRelay struct {
+	proxy    *RedisClusterProxy
+	patterns []string
+
+	mutex       sync.Mutex
+	upstreams   map[string]chan struct{}             // master节点地址 -> 关闭时停止该节点上的订阅
+	subscribers map[string]map[*relaySubscriber]bool // 模式 -> 订阅该模式的客户端
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// relaySubscriber 通过转发订阅模式的客户端，write与订阅模式下其他消息的写入共用同一个锁
+type relaySubscriber struct {
+	write func(data string) error
+}
+
+// newKeyspaceRelay 创建keyspace通知转发，patterns为空时返回nil
+func newKeyspaceRelay(proxy *RedisClusterProxy, patterns []string) *keyspaceRelay {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &keyspaceRelay{
+		proxy:       proxy,
+		patterns:    patterns,
+		upstreams:   make(map[string]chan struct{}),
+		subscribers: make(map[string]map[*relaySubscriber]bool),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// run 定期按当前的master节点增删上游订阅，直到Close
+func (relay *keyspaceRelay) run() {
+	ticker := time.NewTicker(keyspaceRelaySyncInterval)
+	defer ticker.Stop()
+
+	for {
+		relay.syncUpstreams()
+		select {
+		case <-ticker.C:
+		case <-relay.stopChan:
+			return
+		}
+	}
+}
+
+// Close 停止所有上游订阅
+func (relay *keyspaceRelay) Close() {
+	relay.closeOnce.Do(func() {
+		close(relay.stopChan)
+	})
+}
+
+// syncUpstreams 为新增的master节点建立订阅，停止已不是master的节点上的订阅
+func (relay *keyspaceRelay) syncUpstreams() {
+	masters := make(map[string]bool)
+	for _, nodeAddr := range relay.proxy.clusterManager.GetMasterNodes() {
+		masters[nodeAddr] = true
+	}
+
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	for nodeAddr, stop := range relay.upstreams {
+		if !masters[nodeAddr] {
+			LogInfo("节点 %s 不再是master，停止keyspace通知订阅", nodeAddr)
+			close(stop)
+			delete(relay.upstreams, nodeAddr)
+		}
+	}
+	for nodeAddr := range masters {
+		if _, exists := relay.upstreams[nodeAddr]; !exists {
+			stop := make(chan struct{})
+			relay.upstreams[nodeAddr] = stop
+			go relay.runUpstream(nodeAddr, stop)
+		}
+	}
+}
+
+// runUpstream 在节点上订阅转发的模式并分发收到的消息，连接断开时重新连接，直到stop或stopChan关闭
+func (relay *keyspaceRelay) runUpstream(nodeAddr string, stop chan struct{}) {
+	for {
+		conn, err := net.DialTimeout("tcp", nodeAddr, 5*time.Second)
+		if err != nil {
+			LogWarn("连接节点 %s 订阅keyspace通知失败: %v", nodeAddr, err)
+		} else {
+			// stop关闭时关闭连接，结束读取
+			closed := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+				case <-relay.stopChan:
+				case <-closed:
+				}
+				conn.Close()
+			}()
+			relay.readUpstream(nodeAddr, conn)
+			close(closed)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-relay.stopChan:
+			return
+		case <-time.After(keyspaceRelayRetryWait):
+		}
+	}
+}
+
+// readUpstream 发送PSUBSCRIBE并读取消息，直到连接断开
+func (relay *keyspaceRelay) readUpstream(nodeAddr string, conn net.Conn) {
+	proxy := relay.proxy
+	if err := proxy.sendCommandToBackend(conn, append([]string{"PSUBSCRIBE"}, relay.patterns...)); err != nil {
+		LogWarn("在节点 %s 订阅keyspace通知失败: %v", nodeAddr, err)
+		return
+	}
+	LogDebug("已在节点 %s 订阅keyspace通知: %v", nodeAddr, relay.patterns)
+
+	reader := bufio.NewReaderSize(conn, proxy.currentConfig().GetReadBufferSize())
+	for {
+		message, err := proxy.readResponse(reader)
+		if err != nil {
+			LogDebug("节点 %s 的keyspace通知订阅结束: %v", nodeAddr, err)
+			return
+		}
+		value, err := proxy.protocol.ParseResponse(message)
+		if err != nil || value.Type != '*' || len(value.Array) != 4 || value.Array[0].Str != "pmessage" {
+			continue
+		}
+		relay.dispatch(value.Array[1].Str, message)
+	}
+}
+
+// dispatch 将消息原样转发给订阅了该模式的客户端
+func (relay *keyspaceRelay) dispatch(pattern string, message string) {
+	relay.mutex.Lock()
+	subscribers := make([]*relaySubscriber, 0, len(relay.subscribers[pattern]))
+	for subscriber := range relay.subscribers[pattern] {
+		subscribers = append(subscribers, subscriber)
+	}
+	relay.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		if err := subscriber.write(message); err != nil {
+			LogDebug("转发keyspace通知到客户端失败: %v", err)
+		}
+	}
+}
+
+// isRelayed 判断模式是否由转发订阅
+func (relay *keyspaceRelay) isRelayed(pattern string) bool {
+	if relay == nil {
+		return false
+	}
+	for _, relayed := range relay.patterns {
+		if relayed == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe 记录客户端订阅了模式
+func (relay *keyspaceRelay) subscribe(subscriber *relaySubscriber, pattern string) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	if relay.subscribers[pattern] == nil {
+		relay.subscribers[pattern] = make(map[*relaySubscriber]bool)
+	}
+	relay.subscribers[pattern][subscriber] = true
+}
+
+// unsubscribe 删除客户端对模式的订阅
+func (relay *keyspaceRelay) unsubscribe(subscriber *relaySubscriber, pattern string) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	delete(relay.subscribers[pattern], subscriber)
+}
+
+// relaySession 订阅模式的客户端通过keyspace通知转发订阅的模式。转发的模式不发送到客户端的后端连接，
+// 同一条通知只会从转发送达一次
+type relaySession struct {
+	relay           *keyspaceRelay
+	subscriber      *relaySubscriber
+	patterns        map[string]bool // 通过转发订阅的模式
+	count           atomic.Int64    // patterns的数量，后端读取协程计算订阅总数时读取
+	backendPatterns map[string]bool // 发送到后端连接的模式
+}
+
+// newRelaySession 为订阅模式的客户端创建转发订阅，write为写客户端连接的函数。relay为nil时所有模式都发送到后端
+func newRelaySession(relay *keyspaceRelay, write func(data string) error) *relaySession {
+	return &relaySession{
+		relay:           relay,
+		subscriber:      &relaySubscriber{write: write},
+		patterns:        make(map[string]bool),
+		backendPatterns: make(map[string]bool),
+	}
+}
+
+// filter 在转发中处理PSUBSCRIBE/PUNSUBSCRIBE中由转发订阅的模式，并直接向客户端返回订阅确认，
+// 返回需要发送到后端的命令，没有需要发送的模式时返回nil。backendCount为后端连接上的订阅数
+func (rs *relaySession) filter(command []string, backendCount int64) []string {
+	if rs.relay == nil {
+		return command
+	}
+
+	switch strings.ToUpper(command[0]) {
+	case "PSUBSCRIBE":
+		forward := []string{command[0]}
+		for _, pattern := range command[1:] {
+			if !rs.relay.isRelayed(pattern) {
+				rs.backendPatterns[pattern] = true
+				forward = append(forward, pattern)
+				continue
+			}
+			if !rs.patterns[pattern] {
+				rs.patterns[pattern] = true
+				rs.count.Add(1)
+				rs.relay.subscribe(rs.subscriber, pattern)
+			}
+			rs.confirm("psubscribe", pattern, backendCount)
+		}
+		if len(forward) == 1 && len(command) > 1 {
+			return nil
+		}
+		return forward
+	case "PUNSUBSCRIBE":
+		patterns := command[1:]
+		forwardAll := len(patterns) == 0 && (len(rs.patterns) == 0 || len(rs.backendPatterns) > 0)
+		if len(patterns) == 0 {
+			for pattern := range rs.patterns {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			rs.backendPatterns = make(map[string]bool)
+		}
+
+		forward := []string{command[0]}
+		for _, pattern := range patterns {
+			if !rs.relay.isRelayed(pattern) {
+				delete(rs.backendPatterns, pattern)
+				forward = append(forward, pattern)
+				continue
+			}
+			if rs.patterns[pattern] {
+				delete(rs.patterns, pattern)
+				rs.count.Add(-1)
+				rs.relay.unsubscribe(rs.subscriber, pattern)
+			}
+			rs.confirm("punsubscribe", pattern, backendCount)
+		}
+		if forwardAll {
+			return command
+		}
+		if len(forward) == 1 {
+			return nil
+		}
+		return forward
+	}
+	return command
+}
+
+// confirm 向客户端返回订阅确认，订阅数包括后端连接上的订阅和转发的订阅
+func (rs *relaySession) confirm(kind string, pattern string, backendCount int64) {
+	reply := &RespValue{Type: '*', Array: []*RespValue{
+		{Type: '$', Str: kind},
+		{Type: '$', Str: pattern},
+		{Type: ':', Int: backendCount + rs.count.Load()},
+	}}
+	rs.subscriber.write(reply.Format())
+}
+
+// close 删除客户端的所有转发订阅
+func (rs *relaySession) close() {
+	if rs.relay == nil {
+		return
+	}
+	for pattern := range rs.patterns {
+		rs.relay.unsubscribe(rs.subscriber, pattern)
+	}
+	rs.patterns = make(map[string]bool)
+	rs.count.Store(0)
+}
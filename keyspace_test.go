@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyspaceNode 记录PSUBSCRIBE连接的假节点，notify向这些连接推送keyspace通知
+type keyspaceNode struct {
+	*fakeNode
+
+	mutex       sync.Mutex
+	subscribers []net.Conn
+}
+
+// startKeyspaceNode 启动keyspace通知的假节点
+func startKeyspaceNode(t *testing.T) *keyspaceNode {
+	node := &keyspaceNode{}
+	node.fakeNode = startFakeConnNode(t, func(conn net.Conn, command []string) string {
+		if !strings.EqualFold(command[0], "PSUBSCRIBE") {
+			return "-ERR unknown command\r\n"
+		}
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		node.subscribers = append(node.subscribers, conn)
+		return "*3\r\n" + bulk("psubscribe") + bulk(command[1]) + ":1\r\n"
+	})
+	return node
+}
+
+// subscribed 返回PSUBSCRIBE的连接数
+func (node *keyspaceNode) subscribed() int {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return len(node.subscribers)
+}
+
+// notify 向订阅的连接推送pattern匹配的channel上的消息
+func (node *keyspaceNode) notify(pattern, channel, message string) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, conn := range node.subscribers {
+		conn.Write([]byte("*4\r\n" + bulk("pmessage") + bulk(pattern) + bulk(channel) + bulk(message)))
+	}
+}
+
+// TestKeyspaceRelayExpired 客户端PSUBSCRIBE转发的模式后，任一master节点上的过期事件都转发给客户端
+func TestKeyspaceRelayExpired(t *testing.T) {
+	const pattern = "__keyevent@0__:expired"
+	nodes := []*keyspaceNode{startKeyspaceNode(t), startKeyspaceNode(t)}
+	fc := startFakeMasters(t, []*fakeNode{nodes[0].fakeNode, nodes[1].fakeNode}, func(config *Config) {
+		config.KeyspaceRelayPatterns = []string{pattern}
+	})
+	for _, node := range nodes {
+		if !waitFor(t, 2*time.Second, func() bool { return node.subscribed() == 1 }) {
+			t.Fatalf("代理应在master节点 %s 上订阅 %s", node.addr, pattern)
+		}
+	}
+
+	client := fc.client(t)
+	client.expectReply("*3\r\n"+bulk("psubscribe")+bulk(pattern)+":1\r\n", "PSUBSCRIBE", pattern)
+	for _, node := range nodes {
+		if got := len(node.received("PSUBSCRIBE")); got != 1 {
+			t.Errorf("转发的模式不应发送到客户端的后端连接，节点 %s 收到 %d 次PSUBSCRIBE", node.addr, got)
+		}
+	}
+
+	for i, key := range []string{"session:1", "session:2"} {
+		nodes[i].notify(pattern, pattern, key)
+		want := "*4\r\n" + bulk("pmessage") + bulk(pattern) + bulk(pattern) + bulk(key)
+		if got := client.read(); got != want {
+			t.Errorf("节点 %s 的过期事件应转发给客户端，实际为 %q", nodes[i].addr, got)
+		}
+	}
+}
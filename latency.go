@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindowSize 每条命令保留的最近耗时样本数，百分位按这些样本计算
+const latencyWindowSize = 4096
+
+// LatencyTracker 按命令名记录最近的处理耗时，用于管理接口GET /latency计算百分位
+type LatencyTracker struct {
+	mutex    sync.Mutex
+	commands map[string]*latencyWindow
+}
+
+// latencyWindow 一条命令最近latencyWindowSize个耗时样本的环形缓冲区
+type latencyWindow struct {
+	samples []time.Duration
+	next    int           // 下一个样本写入的位置
+	count   int64         // 重置以来记录的样本总数，可能超过缓冲区大小
+	max     time.Duration // 重置以来的最大耗时，不受缓冲区大小限制
+}
+
+// LatencyStats 一条命令的耗时统计，单位为毫秒
+type LatencyStats struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	P999  float64 `json:"p999_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// newLatencyTracker 创建命令耗时统计
+func newLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{commands: make(map[string]*latencyWindow)}
+}
+
+// Record 记录一条命令的处理耗时
+func (lt *LatencyTracker) Record(command string, duration time.Duration) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	window := lt.commands[command]
+	if window == nil {
+		window = &latencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+		lt.commands[command] = window
+	}
+	if len(window.samples) < latencyWindowSize {
+		window.samples = append(window.samples, duration)
+	} else {
+		window.samples[window.next] = duration
+	}
+	window.next = (window.next + 1) % latencyWindowSize
+	window.count++
+	if duration > window.max {
+		window.max = duration
+	}
+}
+
+// Snapshot 返回各命令的耗时统计，reset为true时返回后清空所有样本
+func (lt *LatencyTracker) Snapshot(reset bool) map[string]LatencyStats {
+	lt.mutex.Lock()
+	windows := lt.commands
+	if reset {
+		lt.commands = make(map[string]*latencyWindow)
+	} else {
+		copied := make(map[string]*latencyWindow, len(windows))
+		for command, window := range windows {
+			copied[command] = &latencyWindow{
+				samples: append([]time.Duration(nil), window.samples...),
+				count:   window.count,
+				max:     window.max,
+			}
+		}
+		windows = copied
+	}
+	lt.mutex.Unlock()
+
+	// 排序在锁外进行，不阻塞命令处理路径上的Record
+	stats := make(map[string]LatencyStats, len(windows))
+	for command, window := range windows {
+		samples := window.samples
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stats[command] = LatencyStats{
+			Count: window.count,
+			P50:   durationMillis(percentile(samples, 0.50)),
+			P95:   durationMillis(percentile(samples, 0.95)),
+			P99:   durationMillis(percentile(samples, 0.99)),
+			P999:  durationMillis(percentile(samples, 0.999)),
+			Max:   durationMillis(window.max),
+		}
+	}
+	return stats
+}
+
+// percentile 返回已排序样本中位于q分位的值（最近秩法），没有样本时返回0
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// durationMillis 将耗时转换为毫秒
+func durationMillis(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
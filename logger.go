@@ -1,11 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // LogLevel 日志级别
@@ -18,31 +24,60 @@ const (
 	ERROR
 )
 
+// String 返回日志级别名称
+func (level LogLevel) String() string {
+	switch level {
+	case DEBUG:
+		return "DEBUG"
+	case INFO:
+		return "INFO"
+	case WARN:
+		return "WARN"
+	case ERROR:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// jsonLogEntry JSON格式的日志行
+type jsonLogEntry struct {
+	Level  string                 `json:"level"`
+	Ts     string                 `json:"ts"`
+	Caller string                 `json:"caller"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
 // Logger 日志管理器
 type Logger struct {
-	level  LogLevel
+	level  atomic.Int32 // 当前日志级别，支持运行时修改
+	json   bool // 是否输出JSON格式
 	logger *log.Logger
-	file   *os.File
+	file   *rotatingFile
 }
 
-// NewLogger 创建新的日志管理器
-func NewLogger(levelStr string, logFile string) *Logger {
-	var level LogLevel
+// parseLogLevel 解析日志级别名称
+func parseLogLevel(levelStr string) LogLevel {
 	switch strings.ToLower(levelStr) {
 	case "debug":
-		level = DEBUG
+		return DEBUG
 	case "info":
-		level = INFO
+		return INFO
 	case "warn":
-		level = WARN
+		return WARN
 	case "error":
-		level = ERROR
+		return ERROR
 	default:
-		level = INFO // 默认为INFO级别
+		return INFO // 默认为INFO级别
 	}
+}
+
+// NewLogger 创建新的日志管理器，format为text或json，maxSizeMB大于0时按大小滚动日志文件
+func NewLogger(levelStr string, logFile string, format string, maxSizeMB int, maxBackups int) *Logger {
 	
 	var writer io.Writer = os.Stdout
-	var file *os.File
+	var file *rotatingFile
 	
 	// 如果指定了日志文件路径
 	if logFile != "" {
@@ -52,7 +87,7 @@ func NewLogger(levelStr string, logFile string) *Logger {
 			log.Printf("创建日志目录失败: %v，将使用控制台输出", err)
 		} else {
 			// 打开或创建日志文件
-			f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			f, err := openRotatingFile(logFile, maxSizeMB, maxBackups)
 			if err != nil {
 				log.Printf("打开日志文件失败: %v，将使用控制台输出", err)
 			} else {
@@ -62,41 +97,92 @@ func NewLogger(levelStr string, logFile string) *Logger {
 		}
 	}
 	
-	logger := log.New(writer, "", log.LstdFlags)
+	jsonFormat := strings.ToLower(format) == "json"
+	flags := log.LstdFlags
+	if jsonFormat {
+		// JSON格式自带时间戳字段
+		flags = 0
+	}
+	logger := log.New(writer, "", flags)
 	
-	return &Logger{
-		level:  level,
+	l := &Logger{
+		json:   jsonFormat,
 		logger: logger,
 		file:   file,
 	}
+	l.SetLevel(levelStr)
+	return l
+}
+
+// SetLevel 修改日志级别
+func (l *Logger) SetLevel(levelStr string) {
+	l.level.Store(int32(parseLogLevel(levelStr)))
+}
+
+// output 按配置的格式输出一行日志，calldepth为相对output的调用者层数，requestID不为空时附加到日志中
+func (l *Logger) output(level LogLevel, calldepth int, requestID string, format string, args ...interface{}) {
+	if LogLevel(l.level.Load()) > level {
+		return
+	}
+
+	if !l.json {
+		prefix := "[" + level.String() + "] "
+		if requestID != "" {
+			prefix += "[req=" + requestID + "] "
+		}
+		l.logger.Printf(prefix+format, args...)
+		return
+	}
+
+	caller := "???"
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+
+	entry := jsonLogEntry{
+		Level:  level.String(),
+		Ts:     time.Now().Format(time.RFC3339Nano),
+		Caller: caller,
+		Msg:    fmt.Sprintf(format, args...),
+		Fields: map[string]interface{}{},
+	}
+	if requestID != "" {
+		entry.Fields["request_id"] = requestID
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Printf("[%s] %s", level.String(), entry.Msg)
+		return
+	}
+	l.logger.Print(string(data))
 }
 
 // Debug 输出调试日志
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= DEBUG {
-		l.logger.Printf("[DEBUG] "+format, args...)
-	}
+	l.output(DEBUG, 2, "", format, args...)
 }
 
 // Info 输出信息日志
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= INFO {
-		l.logger.Printf("[INFO] "+format, args...)
-	}
+	l.output(INFO, 2, "", format, args...)
 }
 
 // Warn 输出警告日志
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= WARN {
-		l.logger.Printf("[WARN] "+format, args...)
-	}
+	l.output(WARN, 2, "", format, args...)
 }
 
 // Error 输出错误日志
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= ERROR {
-		l.logger.Printf("[ERROR] "+format, args...)
+	l.output(ERROR, 2, "", format, args...)
+}
+
+// Rotate 滚动日志文件，输出到控制台时不做任何操作
+func (l *Logger) Rotate() error {
+	if l.file == nil {
+		return nil
 	}
+	return l.file.Rotate()
 }
 
 // Close 关闭日志文件
@@ -106,42 +192,104 @@ func (l *Logger) Close() {
 	}
 }
 
-// 全局日志实例
-var logger *Logger
+// 全局日志实例，重新初始化时可能有其他协程正在输出日志
+var logger atomic.Pointer[Logger]
 
 // InitLogger 初始化全局日志
-func InitLogger(levelStr string, logFile string) {
-	logger = NewLogger(levelStr, logFile)
+func InitLogger(levelStr string, logFile string, format string, maxSizeMB int, maxBackups int) {
+	logger.Store(NewLogger(levelStr, logFile, format, maxSizeMB, maxBackups))
+}
+
+// SetLogLevel 修改全局日志级别
+func SetLogLevel(levelStr string) {
+	if l := logger.Load(); l != nil {
+		l.SetLevel(levelStr)
+	}
+}
+
+// RotateLogger 滚动全局日志文件
+func RotateLogger() {
+	l := logger.Load()
+	if l == nil {
+		return
+	}
+	if err := l.Rotate(); err != nil {
+		LogError("滚动日志文件失败: %v", err)
+		return
+	}
+	LogInfo("日志文件已滚动")
 }
 
 // CloseLogger 关闭全局日志
 func CloseLogger() {
-	if logger != nil {
-		logger.Close()
+	if l := logger.Load(); l != nil {
+		l.Close()
 	}
 }
 
 // 便捷函数
 func LogDebug(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Debug(format, args...)
+	if l := logger.Load(); l != nil {
+		l.output(DEBUG, 2, "", format, args...)
 	}
 }
 
 func LogInfo(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Info(format, args...)
+	if l := logger.Load(); l != nil {
+		l.output(INFO, 2, "", format, args...)
 	}
 }
 
 func LogWarn(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Warn(format, args...)
+	if l := logger.Load(); l != nil {
+		l.output(WARN, 2, "", format, args...)
 	}
 }
 
 func LogError(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Error(format, args...)
+	if l := logger.Load(); l != nil {
+		l.output(ERROR, 2, "", format, args...)
+	}
+}
+
+// requestSeq 请求ID序号
+var requestSeq uint64
+
+// RequestLogger 单个请求的日志记录器，输出的每行日志都带有请求ID，用于关联同一请求的日志。
+// nil或ID为空时与全局日志函数相同
+type RequestLogger struct {
+	id string
+}
+
+// newRequestLogger 创建带有新请求ID的日志记录器
+func newRequestLogger() *RequestLogger {
+	return &RequestLogger{id: strconv.FormatUint(atomic.AddUint64(&requestSeq, 1), 10)}
+}
+
+func (r *RequestLogger) log(level LogLevel, format string, args ...interface{}) {
+	l := logger.Load()
+	if l == nil {
+		return
+	}
+	requestID := ""
+	if r != nil {
+		requestID = r.id
 	}
-}
\ No newline at end of file
+	l.output(level, 3, requestID, format, args...)
+}
+
+func (r *RequestLogger) Debug(format string, args ...interface{}) {
+	r.log(DEBUG, format, args...)
+}
+
+func (r *RequestLogger) Info(format string, args ...interface{}) {
+	r.log(INFO, format, args...)
+}
+
+func (r *RequestLogger) Warn(format string, args ...interface{}) {
+	r.log(WARN, format, args...)
+}
+
+func (r *RequestLogger) Error(format string, args ...interface{}) {
+	r.log(ERROR, format, args...)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile 支持按大小滚动的日志文件
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // 单个文件最大字节数，0表示不按大小滚动
+	maxBackups int   // 最多保留的历史文件数，0表示全部保留
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// openRotatingFile 打开或创建日志文件
+func openRotatingFile(path string, maxSizeMB int, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open 打开日志文件并记录当前大小
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// Write 写入日志，超过大小限制时先滚动文件
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "滚动日志文件失败: %v\n", err)
+		}
+	}
+	if rf.file == nil {
+		return 0, fmt.Errorf("日志文件未打开")
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即滚动日志文件
+func (rf *rotatingFile) Rotate() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	return rf.rotate()
+}
+
+// rotate 将当前文件重命名为带时间戳的历史文件并打开新文件，调用方需持有锁
+func (rf *rotatingFile) rotate() error {
+	if rf.file != nil {
+		rf.file.Close()
+		rf.file = nil
+	}
+
+	backup := fmt.Sprintf("%s.%s", rf.path, time.Now().Format("20060102-150405.000"))
+	if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+		// 重命名失败时继续写入原文件
+		if openErr := rf.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("重命名日志文件失败: %v", err)
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups 删除超出保留数量的历史文件
+func (rf *rotatingFile) removeOldBackups() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil || len(backups) <= rf.maxBackups {
+		return
+	}
+
+	// 时间戳后缀按字典序即为时间顺序
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-rf.maxBackups] {
+		if err := os.Remove(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "删除历史日志文件失败: %v\n", err)
+		}
+	}
+}
+
+// Close 关闭日志文件
+func (rf *rotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
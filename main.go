@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"net"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 	"gopkg.in/yaml.v3"
 )
 
 func main() {
 	// 解析命令行参数
 	configFile := flag.String("config", "config.yaml", "配置文件路径")
+	smokeTest := flag.Bool("test", false, "连接已启动的代理执行PING/SET/GET冒烟测试后退出")
+	disableEncodingCache := flag.Bool("disable-encoding-cache", false, "关闭OBJECT ENCODING结果的本地缓存")
 	flag.Parse()
 
 	// 加载配置
@@ -25,8 +32,15 @@ func main() {
 		log.Fatalf("配置验证失败: %v", err)
 	}
 
+	if *smokeTest {
+		if !runSmokeTest(smokeTestAddress(config)) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 初始化日志系统
-	InitLogger(config.LogLevel, config.LogFile)
+	InitLogger(config.LogLevel, config.LogFile, config.LogFormat, config.LogMaxSizeMB, config.LogMaxBackups)
 	if config.LogFile != "" {
 		LogInfo("日志系统已初始化，级别: %s，文件: %s", config.LogLevel, config.LogFile)
 	} else {
@@ -35,10 +49,20 @@ func main() {
 
 	// 创建代理服务
 	proxy := NewRedisClusterProxy(config)
+	if *disableEncodingCache {
+		proxy.DisableEncodingCache()
+	}
 
-	// 设置信号处理
+	// 设置信号处理，SIGHUP用于重新加载配置
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// 监听配置文件变化，自动重新加载
+	if config.WatchConfig {
+		if err := proxy.WatchConfigFile(*configFile); err != nil {
+			LogError("监听配置文件失败: %v", err)
+		}
+	}
 
 	// 启动代理服务
 	go func() {
@@ -47,8 +71,17 @@ func main() {
 		}
 	}()
 
-	// 等待退出信号
-	<-sigChan
+	// 等待退出信号，SIGHUP重新加载配置，开启log_rotate_on_hup时同时滚动日志文件
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			if proxy.currentConfig().LogRotateOnHUP {
+				RotateLogger()
+			}
+			proxy.ReloadConfigFile(*configFile)
+			continue
+		}
+		break
+	}
 	log.Println("收到退出信号，正在关闭代理服务...")
 	proxy.Stop()
 	log.Println("代理服务已关闭")
@@ -57,10 +90,9 @@ func main() {
 	CloseLogger()
 }
 
-// LoadConfigFromFile 从YAML文件加载配置
-func LoadConfigFromFile(filename string) (*Config, error) {
-	// 默认配置
-	config := &Config{
+// defaultConfig 返回默认配置
+func defaultConfig() *Config {
+	return &Config{
 		ProxyPort: 6379,
 		RedisNodes: []string{
 			"127.0.0.1:7000",
@@ -70,7 +102,25 @@ func LoadConfigFromFile(filename string) (*Config, error) {
 		AutoRedirect: true,
 		LogLevel: "info",
 		LogFile: "", // 默认输出到控制台
+		LogFormat: "text",
+		HealthCheckInterval: 5 * time.Second,
+		ClusterRefreshInterval: 30 * time.Second,
+		MinHealthyNodes: 1,
+		PoolMaxWait: 1 * time.Second,
+		ClusterDownMaxRetries: 3,
+		ClusterDownMaxRetryWait: 1 * time.Second,
+		KeysMaxResults: 100000,
+		EncodingCacheTTL: 1 * time.Second,
+		EncodingCacheMaxKeys: 10000,
+		RateLimitIdleTimeout: 10 * time.Minute,
+		MetricsHistogramBuckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
 	}
+}
+
+// LoadConfigFromFile 从YAML文件加载配置
+func LoadConfigFromFile(filename string) (*Config, error) {
+	// 默认配置
+	config := defaultConfig()
 
 	// 检查配置文件是否存在
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
@@ -99,4 +149,80 @@ func LoadConfigFromFile(filename string) (*Config, error) {
 		config.ProxyPort, config.RedisNodes, config.AutoRedirect)
 	
 	return config, nil
-}
\ No newline at end of file
+}
+
+// parseConfigFile 读取并解析配置文件，未配置的项使用默认值。
+// 与LoadConfigFromFile不同，读取或解析失败时返回错误，用于运行时重新加载配置
+func parseConfigFile(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	config := defaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	return config, nil
+}
+
+// smokeTestAddress 返回冒烟测试连接代理使用的地址，监听所有网卡时连接本机回环地址
+func smokeTestAddress(config *Config) string {
+	host := config.ProxyBindAddress
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(config.ProxyPort))
+}
+
+// runSmokeTest 连接代理依次执行PING、SET、GET并检查响应，用于部署后的冒烟测试
+func runSmokeTest(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		fmt.Printf("连接代理 %s 失败: %v\n", address, err)
+		return false
+	}
+	defer conn.Close()
+
+	protocol := &RedisProtocol{}
+	// 使用与后端连接相同的响应读取，readResponse不依赖代理的运行状态
+	proxy := &RedisClusterProxy{protocol: protocol}
+	reader := bufio.NewReader(conn)
+	steps := []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"SET", "proxy:test:key", "hello", "EX", "10"}, "OK"},
+		{[]string{"GET", "proxy:test:key"}, "hello"},
+	}
+
+	for _, step := range steps {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(protocol.FormatCommand(step.command))); err != nil {
+			fmt.Printf("发送命令 %v 失败: %v\n", step.command, err)
+			return false
+		}
+		response, err := proxy.readResponse(reader)
+		if err != nil {
+			fmt.Printf("读取命令 %v 的响应失败: %v\n", step.command, err)
+			return false
+		}
+		reply, err := protocol.ParseResponse(response)
+		if err != nil {
+			fmt.Printf("解析命令 %v 的响应失败: %v\n", step.command, err)
+			return false
+		}
+		if reply.IsError() || reply.IsNil || reply.Str != step.expected {
+			fmt.Printf("%v -> %q，期望 %q，测试失败\n", step.command, reply.Format(), step.expected)
+			return false
+		}
+		fmt.Printf("%v -> %s\n", step.command, reply.Str)
+	}
+
+	fmt.Printf("代理 %s 冒烟测试通过\n", address)
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newMetricsRegistry 创建代理的Prometheus指标注册表
+func (proxy *RedisClusterProxy) newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&poolCollector{pool: proxy.pool})
+	registry.MustRegister(proxy.rateLimiter.limited)
+	registry.MustRegister(proxy.policyRejected)
+	registry.MustRegister(proxy.commandDuration)
+	registry.MustRegister(proxy.clusterManager.refreshes)
+	return registry
+}
+
+// newCommandDurationHistogram 创建按命令名统计处理耗时的直方图，buckets为空时使用Prometheus的默认分桶
+func newCommandDurationHistogram(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_command_duration_seconds",
+		Help:    "代理处理客户端命令的耗时（秒），包括转发到后端和重定向",
+		Buckets: buckets,
+	}, []string{"command"})
+}
+
+// observeCommandDuration 记录一条命令的处理耗时，写入直方图和GET /latency的耗时统计
+func (proxy *RedisClusterProxy) observeCommandDuration(cmdName string, start time.Time) {
+	duration := time.Since(start)
+	label := commandLabel(cmdName)
+	proxy.commandDuration.WithLabelValues(label).Observe(duration.Seconds())
+	proxy.latency.Record(label, duration)
+}
+
+// commandLabel 返回统计耗时使用的命令名。命令名来自客户端，不在命令表中的命令计为other，避免标签数量无限增长
+func commandLabel(cmdName string) string {
+	if lookupCommand(cmdName) == nil {
+		return "other"
+	}
+	return strings.ToLower(cmdName)
+}
+
+var (
+	poolErrorsDesc = prometheus.NewDesc(
+		"proxy_pool_connection_errors_total",
+		"建立连接失败、池中连接失效及获取连接超时的次数",
+		[]string{"node"}, nil,
+	)
+	poolCreatedDesc = prometheus.NewDesc(
+		"proxy_pool_connections_created_total",
+		"累计新建的后端连接数",
+		[]string{"node"}, nil,
+	)
+	poolConnectionsDesc = prometheus.NewDesc(
+		"proxy_pool_connections",
+		"当前到后端节点的连接数",
+		[]string{"node"}, nil,
+	)
+)
+
+// poolCollector 采集时从连接池读取各节点的统计信息
+type poolCollector struct {
+	pool *ConnectionPool
+}
+
+// Describe 实现prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolErrorsDesc
+	ch <- poolCreatedDesc
+	ch <- poolConnectionsDesc
+}
+
+// Collect 实现prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range c.pool.Stats() {
+		ch <- prometheus.MustNewConstMetric(poolErrorsDesc, prometheus.CounterValue, float64(stats.Errors), stats.Node)
+		ch <- prometheus.MustNewConstMetric(poolCreatedDesc, prometheus.CounterValue, float64(stats.TotalCreated), stats.Node)
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(stats.Connections), stats.Node)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isMonitorCommand 判断命令是否使连接进入MONITOR模式：MONITOR监控所有master节点，
+// PROXY NODE <addr> MONITOR只监控指定节点
+func isMonitorCommand(command []string) bool {
+	if strings.EqualFold(command[0], "MONITOR") {
+		return true
+	}
+	return len(command) == 4 && strings.EqualFold(command[0], "PROXY") && strings.EqualFold(command[1], "NODE") &&
+		strings.EqualFold(command[3], "MONITOR")
+}
+
+// monitorNodes 返回MONITOR需要监控的节点。MONITOR属于危险命令，需要通过allowed_dangerous_commands开启
+func (proxy *RedisClusterProxy) monitorNodes(command []string) ([]string, error) {
+	if err := proxy.checkDangerousCommand([]string{"MONITOR"}); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(command[0], "PROXY") {
+		masters := proxy.clusterManager.GetMasterNodes()
+		if len(masters) == 0 {
+			return nil, fmt.Errorf("没有可用的Redis节点")
+		}
+		return masters, nil
+	}
+
+	if !proxy.currentConfig().ProxyNodeCommand {
+		return nil, fmt.Errorf("PROXY NODE未开启，请设置proxy_node_command")
+	}
+	if err := proxy.checkKnownNode(command[2]); err != nil {
+		return nil, err
+	}
+	return command[2:3], nil
+}
+
+// handleMonitorConnection 处理MONITOR命令：在每个节点上建立独立连接（不来自连接池）执行MONITOR，
+// 将所有节点的输出加上来源节点地址后汇聚到客户端，直到客户端断开、发送QUIT或RESET。
+// 客户端执行RESET时返回true，连接回到普通模式；某个节点执行MONITOR失败时返回错误，连接仍处于普通模式
+func (proxy *RedisClusterProxy) handleMonitorConnection(clientConn net.Conn, clientReader *bufio.Reader, nodes []string) (bool, error) {
+	var backendConns []net.Conn
+	var backendReaders []*bufio.Reader
+	closeAll := func() {
+		for _, conn := range backendConns {
+			conn.Close()
+		}
+	}
+
+	for _, nodeAddr := range nodes {
+		conn, reader, err := proxy.startMonitor(nodeAddr)
+		if err != nil {
+			closeAll()
+			return false, fmt.Errorf("在节点 %s 上执行MONITOR失败: %v", nodeAddr, err)
+		}
+		backendConns = append(backendConns, conn)
+		backendReaders = append(backendReaders, reader)
+	}
+
+	LogInfo("客户端 %s 进入MONITOR模式，监控节点: %v", clientConn.RemoteAddr(), nodes)
+
+	var writeMutex sync.Mutex
+	if _, err := clientConn.Write([]byte(proxy.protocol.FormatSimpleString("OK"))); err != nil {
+		closeAll()
+		return false, nil
+	}
+
+	// 每个节点的输出直接写客户端连接。客户端读取慢时写入阻塞，读取协程随之停止读取后端，
+	// 由TCP流控反压到后端节点，代理不缓存未写出的输出
+	var wg sync.WaitGroup
+	for i, reader := range backendReaders {
+		wg.Add(1)
+		go func(nodeAddr string, reader *bufio.Reader) {
+			defer wg.Done()
+			for {
+				line, err := proxy.readResponse(reader)
+				if err != nil {
+					LogDebug("节点 %s 的MONITOR输出结束: %v", nodeAddr, err)
+					return
+				}
+				writeMutex.Lock()
+				_, err = clientConn.Write([]byte(monitorLineWithNode(line, nodeAddr)))
+				writeMutex.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(nodes[i], reader)
+	}
+
+	// MONITOR模式下等待客户端断开、发送QUIT或RESET，其他命令被忽略
+	reset := false
+	for {
+		command, err := proxy.protocol.ParseCommand(clientReader)
+		if err != nil {
+			break
+		}
+		if len(command) == 0 {
+			continue
+		}
+		cmdName := strings.ToUpper(command[0])
+		if cmdName == "QUIT" || cmdName == "RESET" {
+			// 先停止输出，RESET的响应之后客户端不会再收到MONITOR的输出
+			closeAll()
+			wg.Wait()
+			reply := "OK"
+			if cmdName == "RESET" {
+				reply, reset = "RESET", true
+			}
+			clientConn.Write([]byte(proxy.protocol.FormatSimpleString(reply)))
+			break
+		}
+	}
+
+	closeAll()
+	wg.Wait()
+	LogInfo("客户端 %s 退出MONITOR模式", clientConn.RemoteAddr())
+	return reset, nil
+}
+
+// monitorLineWithNode 在MONITOR输出的一行前加上来源节点地址，如+127.0.0.1:7000 1700000000.123456 [0 ...] "GET" "k"
+func monitorLineWithNode(line string, nodeAddr string) string {
+	if !strings.HasPrefix(line, "+") {
+		return line
+	}
+	return "+" + nodeAddr + " " + line[1:]
+}
+
+// startMonitor 建立到指定节点的独立连接并执行MONITOR
+func (proxy *RedisClusterProxy) startMonitor(nodeAddr string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", nodeAddr, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := proxy.sendCommandToBackend(conn, []string{"MONITOR"}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err := proxy.readResponse(reader)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(response, "+OK") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s", strings.TrimSpace(response))
+	}
+
+	return conn, reader, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// monitorNode 记录MONITOR连接的假节点，feed向这些连接写一行MONITOR输出
+type monitorNode struct {
+	*fakeNode
+
+	mutex    sync.Mutex
+	monitors []net.Conn
+}
+
+// startMonitorNode 启动MONITOR的假节点
+func startMonitorNode(t *testing.T) *monitorNode {
+	node := &monitorNode{}
+	node.fakeNode = startFakeConnNode(t, func(conn net.Conn, command []string) string {
+		if !strings.EqualFold(command[0], "MONITOR") {
+			return "-ERR unknown command\r\n"
+		}
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		node.monitors = append(node.monitors, conn)
+		return "+OK\r\n"
+	})
+	return node
+}
+
+// feed 向MONITOR连接写一行输出
+func (node *monitorNode) feed(line string) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, conn := range node.monitors {
+		conn.Write([]byte("+" + line + "\r\n"))
+	}
+}
+
+// TestMonitorMerge 两个master节点的MONITOR输出加上来源节点后汇聚到同一个客户端连接，客户端断开时关闭后端的MONITOR连接
+func TestMonitorMerge(t *testing.T) {
+	nodes := []*monitorNode{startMonitorNode(t), startMonitorNode(t)}
+	fc := startFakeMasters(t, []*fakeNode{nodes[0].fakeNode, nodes[1].fakeNode}, func(config *Config) {
+		config.AllowedDangerousCommands = []string{"MONITOR"}
+	})
+	before := []int{nodes[0].openConns(), nodes[1].openConns()}
+
+	client := fc.client(t)
+	client.expectReply("+OK\r\n", "MONITOR")
+	for i, node := range nodes {
+		if got := node.openConns(); got != before[i]+1 {
+			t.Fatalf("节点 %s 应有一个MONITOR连接，连接数为 %d，之前为 %d", node.addr, got, before[i])
+		}
+	}
+
+	nodes[0].feed(`1700000000.000001 [0 10.0.0.1:5000] "SET" "foo" "1"`)
+	nodes[1].feed(`1700000000.000002 [0 10.0.0.2:5000] "GET" "bar"`)
+	got := []string{client.read(), client.read()}
+	sort.Strings(got)
+	want := []string{
+		"+" + nodes[0].addr + ` 1700000000.000001 [0 10.0.0.1:5000] "SET" "foo" "1"` + "\r\n",
+		"+" + nodes[1].addr + ` 1700000000.000002 [0 10.0.0.2:5000] "GET" "bar"` + "\r\n",
+	}
+	sort.Strings(want)
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("MONITOR输出应带有来源节点，实际为 %q，应为 %q", got, want)
+	}
+
+	client.conn.Close()
+	for i, node := range nodes {
+		if !waitFor(t, 2*time.Second, func() bool { return node.openConns() == before[i] }) {
+			t.Errorf("客户端断开后应关闭节点 %s 上的MONITOR连接，连接数为 %d，之前为 %d", node.addr, node.openConns(), before[i])
+		}
+	}
+}
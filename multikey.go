@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slotGroup 按slot拆分后的一组key
+type slotGroup struct {
+	slot    int
+	keys    []string
+	indexes []int // key在原命令key列表中的位置
+}
+
+// groupKeysBySlot 按slot对key分组，组的顺序与每个slot第一次出现的顺序一致
+func (proxy *RedisClusterProxy) groupKeysBySlot(keys []string) []*slotGroup {
+	var groups []*slotGroup
+	bySlot := make(map[int]*slotGroup)
+	for i, key := range keys {
+		slot := CalculateSlot(key)
+		group, ok := bySlot[slot]
+		if !ok {
+			group = &slotGroup{slot: slot}
+			bySlot[slot] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, key)
+		group.indexes = append(group.indexes, i)
+	}
+	return groups
+}
+
+// executeOnSlots 执行按slot拆分后的子命令，结果顺序与groups一致。每个节点一个goroutine，
+// 同一节点上的子命令依次执行，避免占满连接池；子命令遇到MOVED时跟随重定向
+func (proxy *RedisClusterProxy) executeOnSlots(cmdName string, groups []*slotGroup, buildCommand func(group *slotGroup) []string) []nodeResult {
+	results := make([]nodeResult, len(groups))
+
+	byNode := make(map[string][]int)
+	for i, group := range groups {
+		nodeAddr := proxy.selectNodeByKey(cmdName, group.keys[0])
+		byNode[nodeAddr] = append(byNode[nodeAddr], i)
+	}
+
+	var wg sync.WaitGroup
+	for nodeAddr, indexes := range byNode {
+		wg.Add(1)
+		go func(nodeAddr string, indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				results[i] = proxy.executeWithMoved(nodeAddr, buildCommand(groups[i]))
+			}
+		}(nodeAddr, indexes)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// executeWithMoved 在指定节点执行命令并解析响应，遇到MOVED时跟随重定向
+func (proxy *RedisClusterProxy) executeWithMoved(nodeAddr string, command []string) nodeResult {
+	for redirect := 0; ; redirect++ {
+		result := proxy.executeParsedOnNode(nodeAddr, command)
+		if result.value == nil || redirect >= 5 {
+			return result
+		}
+		isMoved, _, redirectAddr := proxy.protocol.IsMovedError(result.value.Format())
+		if !isMoved {
+			return result
+		}
+		nodeAddr = redirectAddr
+	}
+}
+
+// handleMultiKeyCommand 处理key分布在多个slot的命令：拆分到各个slot执行后合并结果，返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleMultiKeyCommand(clientConn net.Conn, cmdName string, command []string, keys []string) (bool, error) {
+	switch cmdName {
+	case "DEL", "UNLINK", "EXISTS", "TOUCH":
+		return true, proxy.handleCountFanOut(clientConn, strings.ToUpper(command[0]), keys)
+	case "MGET":
+		return true, proxy.handleMGetFanOut(clientConn, keys)
+	case "MSET":
+		return true, proxy.handleMSetFanOut(clientConn, command, keys)
+	case "MSETNX":
+		// 只有全部key都不存在时才写入，跨节点无法保证原子性
+		return true, fmt.Errorf("MSETNX的key分布在多个slot，无法保证原子性，请使用hash tag使所有key位于同一个slot")
+	case "LMPOP", "ZMPOP":
+		return true, proxy.handleMPopFanOut(clientConn, command, keys)
+	case "BITOP":
+		return true, proxy.handleBitOpFanOut(clientConn, command, keys)
+	case "COPY":
+		return true, proxy.handleCopyCrossSlot(clientConn, command)
+	case "XREAD":
+		return proxy.handleXReadFanOut(clientConn, command, keys)
+	case "PFCOUNT":
+		if proxy.currentConfig().PFCountFanOut {
+			return true, proxy.handlePFCountFanOut(clientConn, keys)
+		}
+	}
+	return false, nil
+}
+
+// handleCountFanOut 将跨slot的DEL/UNLINK/EXISTS/TOUCH拆分为每个slot一条命令并发执行，返回各个结果之和。
+// 有节点失败时返回错误而不是偏小的计数，开启fan_out_best_effort时只累加成功的结果
+func (proxy *RedisClusterProxy) handleCountFanOut(clientConn net.Conn, cmdName string, keys []string) error {
+	groups := proxy.groupKeysBySlot(keys)
+	results := proxy.executeOnSlots(cmdName, groups, func(group *slotGroup) []string {
+		return append([]string{cmdName}, group.keys...)
+	})
+	if !proxy.currentConfig().FanOutBestEffort {
+		if err := failedNodesError(cmdName, results); err != nil {
+			return err
+		}
+	}
+
+	var total int64
+	for _, result := range results {
+		if result.err != nil {
+			LogWarn("%s在节点 %s 执行失败，结果不计入总数: %v", cmdName, result.address, result.err)
+			continue
+		}
+		total += result.value.Int
+	}
+
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(total)))
+	return err
+}
+
+// handleMGetFanOut 将跨slot的MGET拆分为每个slot一条MGET并发执行，按原始参数顺序重组结果。
+// Redis集群即使在同一个节点上也拒绝跨slot的MGET，因此按slot而不是按节点拆分。
+// 节点不可达时对应的key返回nil，开启mget_strict时返回错误
+func (proxy *RedisClusterProxy) handleMGetFanOut(clientConn net.Conn, keys []string) error {
+	groups := proxy.groupKeysBySlot(keys)
+	results := proxy.executeOnSlots("MGET", groups, func(group *slotGroup) []string {
+		return append([]string{"MGET"}, group.keys...)
+	})
+	if proxy.currentConfig().MGetStrict {
+		if err := failedNodesError("MGET", results); err != nil {
+			return err
+		}
+	}
+
+	merged := &RespValue{Type: '*', Array: make([]*RespValue, len(keys))}
+	for i, group := range groups {
+		result := results[i]
+		if result.err != nil {
+			LogWarn("MGET在节点 %s 执行失败，%d个key返回nil: %v", result.address, len(group.keys), result.err)
+		}
+		for j, index := range group.indexes {
+			if result.err == nil && j < len(result.value.Array) {
+				merged.Array[index] = result.value.Array[j]
+			} else {
+				merged.Array[index] = &RespValue{Type: '$', IsNil: true}
+			}
+		}
+	}
+
+	_, err := clientConn.Write([]byte(merged.Format()))
+	return err
+}
+
+// handleMSetFanOut 将跨slot的MSET拆分为每个slot一条MSET并发执行，全部成功时返回OK，
+// 部分失败时返回未写入的key，由客户端重试
+func (proxy *RedisClusterProxy) handleMSetFanOut(clientConn net.Conn, command []string, keys []string) error {
+	if len(command)%2 != 1 {
+		return fmt.Errorf("wrong number of arguments for 'mset' command")
+	}
+
+	groups := proxy.groupKeysBySlot(keys)
+	results := proxy.executeOnSlots("MSET", groups, func(group *slotGroup) []string {
+		subCommand := []string{"MSET"}
+		for _, index := range group.indexes {
+			subCommand = append(subCommand, command[1+2*index], command[2+2*index])
+		}
+		return subCommand
+	})
+
+	var failedKeys []string
+	for i, group := range groups {
+		if results[i].err != nil {
+			failedKeys = append(failedKeys, group.keys...)
+		}
+	}
+	if len(failedKeys) > 0 {
+		return fmt.Errorf("MSET部分失败，以下key未写入: %s; %v", strings.Join(failedKeys, " "), failedNodesError("MSET", results))
+	}
+
+	_, err := clientConn.Write([]byte(proxy.protocol.FormatSimpleString("OK")))
+	return err
+}
+
+// handleMPopFanOut 按key的顺序逐个执行单key的LMPOP/ZMPOP，返回第一个非空的结果，与Redis从第一个非空key弹出的语义一致。
+// 不同slot的key之间无法保证原子性，阻塞版本BLMPOP/BZMPOP不拆分
+func (proxy *RedisClusterProxy) handleMPopFanOut(clientConn net.Conn, command []string, keys []string) error {
+	cmdName := strings.ToUpper(command[0])
+	options := command[2+len(keys):]
+
+	for _, key := range keys {
+		group := &slotGroup{slot: CalculateSlot(key), keys: []string{key}}
+		results := proxy.executeOnSlots(cmdName, []*slotGroup{group}, func(group *slotGroup) []string {
+			subCommand := []string{command[0], "1", key}
+			return append(subCommand, options...)
+		})
+		result := results[0]
+		if result.value != nil && result.value.IsError() {
+			_, err := clientConn.Write([]byte(result.value.Format()))
+			return err
+		}
+		if result.err != nil {
+			return failedNodesError(cmdName, results)
+		}
+		if !result.value.IsNil {
+			_, err := clientConn.Write([]byte(result.value.Format()))
+			return err
+		}
+	}
+
+	nilReply := &RespValue{Type: '*', IsNil: true}
+	_, err := clientConn.Write([]byte(nilReply.Format()))
+	return err
+}
+
+// handleXReadFanOut 将跨slot的XREAD拆分为每个slot一条XREAD，按STREAMS中key的顺序合并结果。
+// 阻塞读取无法在多个节点上同时等待，带BLOCK选项时不拆分
+func (proxy *RedisClusterProxy) handleXReadFanOut(clientConn net.Conn, command []string, keys []string) (bool, error) {
+	streamsPos := -1
+	for i := 1; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "BLOCK":
+			LogDebug("跨slot的XREAD带有BLOCK选项，不进行拆分")
+			return false, nil
+		case "COUNT":
+			i++
+		case "STREAMS":
+			streamsPos = i
+		}
+		if streamsPos >= 0 {
+			break
+		}
+	}
+	if streamsPos < 0 {
+		return false, nil
+	}
+
+	ids := command[streamsPos+1+len(keys):]
+	groups := proxy.groupKeysBySlot(keys)
+	results := proxy.executeOnSlots("XREAD", groups, func(group *slotGroup) []string {
+		subCommand := append([]string{}, command[:streamsPos+1]...)
+		subCommand = append(subCommand, group.keys...)
+		for _, index := range group.indexes {
+			subCommand = append(subCommand, ids[index])
+		}
+		return subCommand
+	})
+	if err := failedNodesError("XREAD", results); err != nil {
+		return true, err
+	}
+
+	// 每个节点返回[[stream [entry ...]] ...]，没有新消息时返回NULL数组
+	streams := make(map[string]*RespValue)
+	for _, result := range results {
+		for _, stream := range result.value.Array {
+			if len(stream.Array) > 0 {
+				streams[stream.Array[0].Str] = stream
+			}
+		}
+	}
+
+	merged := &RespValue{Type: '*'}
+	for _, key := range keys {
+		if stream, ok := streams[key]; ok {
+			merged.Array = append(merged.Array, stream)
+			delete(streams, key)
+		}
+	}
+	if len(merged.Array) == 0 {
+		merged.IsNil = true
+	}
+
+	_, err := clientConn.Write([]byte(merged.Format()))
+	return true, err
+}
+
+// pfcountSeq 临时key序号，保证并发的PFCOUNT使用不同的临时key
+var pfcountSeq uint64
+
+// handlePFCountFanOut 计算分布在多个slot的HyperLogLog的并集基数：从各个节点读取HLL的原始内容，
+// 写入同一个hash tag下的临时key，在一个节点上用多key PFCOUNT合并计数，最后删除临时key
+func (proxy *RedisClusterProxy) handlePFCountFanOut(clientConn net.Conn, keys []string) error {
+	// 每个key单独读取，非HLL类型的key由后端返回WRONGTYPE
+	groups := make([]*slotGroup, len(keys))
+	for i, key := range keys {
+		groups[i] = &slotGroup{slot: CalculateSlot(key), keys: []string{key}, indexes: []int{i}}
+	}
+	results := proxy.executeOnSlots("PFCOUNT", groups, func(group *slotGroup) []string {
+		return []string{"GET", group.keys[0]}
+	})
+	for _, result := range results {
+		if result.value != nil && result.value.IsError() {
+			// 与单节点PFCOUNT一致，直接返回后端的错误，例如WRONGTYPE
+			_, err := clientConn.Write([]byte(result.value.Format()))
+			return err
+		}
+	}
+	if err := failedNodesError("PFCOUNT", results); err != nil {
+		return err
+	}
+
+	tag := fmt.Sprintf("{proxy:pfcount:%d:%d}", time.Now().UnixNano(), atomic.AddUint64(&pfcountSeq, 1))
+	nodeAddr := proxy.selectNodeByKey("PFCOUNT", tag)
+
+	var tempKeys []string
+	defer func() {
+		if len(tempKeys) > 0 {
+			if _, err := proxy.executeOnNode(nodeAddr, append([]string{"DEL"}, tempKeys...)); err != nil {
+				LogWarn("删除PFCOUNT临时key失败: %v", err)
+			}
+		}
+	}()
+
+	for i, result := range results {
+		if result.value.IsNil {
+			// 不存在的key视为空HLL
+			continue
+		}
+		tempKey := fmt.Sprintf("%s:%d", tag, i)
+		setResult := proxy.executeParsedOnNode(nodeAddr, []string{"SET", tempKey, result.value.Str, "PX", "60000"})
+		if setResult.err != nil {
+			return fmt.Errorf("写入PFCOUNT临时key失败: %v", setResult.err)
+		}
+		tempKeys = append(tempKeys, tempKey)
+	}
+
+	if len(tempKeys) == 0 {
+		_, err := clientConn.Write([]byte(proxy.protocol.FormatInteger(0)))
+		return err
+	}
+
+	countResult := proxy.executeParsedOnNode(nodeAddr, append([]string{"PFCOUNT"}, tempKeys...))
+	if countResult.err != nil {
+		return countResult.err
+	}
+	_, err := clientConn.Write([]byte(countResult.value.Format()))
+	return err
+}
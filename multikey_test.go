@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestXReadMultiStream 多个stream的XREAD：带相同hash tag时在一个节点执行，否则按slot拆分并按STREAMS中的顺序合并
+func TestXReadMultiStream(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply(bulk("1-0"), "XADD", "{t}.a", "1-0", "f", "a")
+	client.expectReply(bulk("1-0"), "XADD", "{t}.b", "1-0", "f", "b")
+	tagged := "*2\r\n" +
+		"*2\r\n" + bulk("{t}.a") + "*1\r\n*2\r\n" + bulk("1-0") + "*2\r\n" + bulk("f") + bulk("a") +
+		"*2\r\n" + bulk("{t}.b") + "*1\r\n*2\r\n" + bulk("1-0") + "*2\r\n" + bulk("f") + bulk("b")
+	client.expectReply(tagged, "XREAD", "COUNT", "10", "STREAMS", "{t}.a", "{t}.b", "0", "0")
+
+	// 三个stream分别位于三个节点
+	keys := []string{tc.keyOn(2, "s"), tc.keyOn(0, "s"), tc.keyOn(1, "s")}
+	for i, key := range keys {
+		client.expectReply(bulk("1-0"), "XADD", key, "1-0", "n", string(rune('0'+i)))
+	}
+	var want string
+	for i, key := range keys {
+		want += "*2\r\n" + bulk(key) + "*1\r\n*2\r\n" + bulk("1-0") + "*2\r\n" + bulk("n") + bulk(string(rune('0'+i)))
+	}
+	client.expectReply("*3\r\n"+want, "XREAD", "STREAMS", keys[0], keys[1], keys[2], "0", "0", "0")
+
+	// 只有部分stream有新消息
+	client.expectReply(bulk("2-0"), "XADD", keys[1], "2-0", "n", "new")
+	client.expectReply("*1\r\n*2\r\n"+bulk(keys[1])+"*1\r\n*2\r\n"+bulk("2-0")+"*2\r\n"+bulk("n")+bulk("new"),
+		"XREAD", "STREAMS", keys[0], keys[1], keys[2], "1-0", "1-0", "1-0")
+	client.expectReply("*-1\r\n", "XREAD", "STREAMS", keys[0], keys[1], "1-0", "2-0")
+
+	// 阻塞读取和消费组读取不拆分
+	client.expectErrorPrefix("CROSSSLOT", "XREAD", "BLOCK", "10", "STREAMS", keys[0], keys[1], "$", "$")
+	client.expectErrorPrefix("CROSSSLOT", "XREADGROUP", "GROUP", "g", "c", "STREAMS", keys[0], keys[1], ">", ">")
+}
+
+// TestMGetFanOut 10个key分布在3个master节点，结果按请求中的顺序返回；节点不可用时对应的key返回nil，
+// 开启mget_strict时返回错误
+func TestMGetFanOut(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	var keys []string
+	want := "*10\r\n"
+	for i := 0; i < 10; i++ {
+		key := tc.keyOn(i%3, fmt.Sprintf("mget%d:", i))
+		keys = append(keys, key)
+		tc.nodeFor(key).Set(key, fmt.Sprintf("v%d", i))
+		want += bulk(fmt.Sprintf("v%d", i))
+	}
+	client.expectReply(want, append([]string{"MGET"}, keys...)...)
+
+	down := tc.nodes[1].Addr()
+	tc.nodes[1].Close()
+	got := client.doValue(append([]string{"MGET"}, keys...)...)
+	if len(got.Array) != 10 {
+		t.Fatalf("应返回10个结果，实际为 %v", got)
+	}
+	for i, value := range got.Array {
+		if i%3 == 1 {
+			if !value.IsNil {
+				t.Errorf("不可用节点上的 %s 应返回nil，实际为 %q", keys[i], value.Str)
+			}
+		} else if value.Str != fmt.Sprintf("v%d", i) {
+			t.Errorf("%s 应返回 v%d，实际为 %q", keys[i], i, value.Str)
+		}
+	}
+
+	tc.updateConfig(func(config *Config) { config.MGetStrict = true })
+	if reply := client.do(append([]string{"MGET"}, keys...)...); !strings.HasPrefix(reply, "-ERR ") || !strings.Contains(reply, down) {
+		t.Errorf("mget_strict时应返回列出不可用节点的错误，实际为 %q", reply)
+	}
+}
+
+// TestMSetFanOut 跨slot的MSET全部成功时返回OK，部分节点失败时返回列出未写入的key的错误；跨slot的MSETNX被拒绝
+func TestMSetFanOut(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	keys := []string{tc.keyOn(0, "mset"), tc.keyOn(1, "mset"), tc.keyOn(2, "mset")}
+
+	client.expectReply("+OK\r\n", "MSET", keys[0], "a", keys[1], "b", keys[2], "c")
+	for i, value := range []string{"a", "b", "c"} {
+		if got, _ := tc.nodes[i].Get(keys[i]); got != value {
+			t.Errorf("%s 应写入节点 %d，实际为 %q", keys[i], i, got)
+		}
+	}
+
+	client.expectErrorPrefix("ERR MSETNX的key分布在多个slot", "MSETNX", keys[0], "x", keys[1], "y")
+	if got, _ := tc.nodes[0].Get(keys[0]); got != "a" {
+		t.Errorf("被拒绝的MSETNX不应写入，%s 为 %q", keys[0], got)
+	}
+	client.expectReply(":1\r\n", "MSETNX", "{t}.a", "1", "{t}.b", "2")
+
+	down := tc.nodes[2].Addr()
+	tc.nodes[2].Close()
+	reply := client.do("MSET", keys[0], "x", keys[1], "y", keys[2], "z")
+	if !strings.HasPrefix(reply, "-ERR MSET部分失败") || !strings.Contains(reply, keys[2]) || !strings.Contains(reply, down) {
+		t.Errorf("应返回列出未写入的key和失败节点的错误，实际为 %q", reply)
+	}
+	if strings.Contains(reply, keys[0]+" ") || strings.Contains(reply, keys[1]+" ") {
+		t.Errorf("写入成功的key不应出现在错误中: %q", reply)
+	}
+	if got, _ := tc.nodes[0].Get(keys[0]); got != "x" {
+		t.Errorf("可用节点上的key应已写入，%s 为 %q", keys[0], got)
+	}
+}
+
+// TestCountFanOut 50个key分布在3个节点，DEL/UNLINK/EXISTS/TOUCH返回各节点结果之和；
+// 有节点不可用时返回列出该节点的错误，开启fan_out_best_effort时只累加可用节点的结果
+func TestCountFanOut(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	var keys []string
+	perNode := make([]int, 3)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("count:%d", i)
+		keys = append(keys, key)
+		node := tc.nodeFor(key)
+		// 偶数key存在
+		if i%2 == 0 {
+			node.Set(key, "v")
+			for j := range tc.nodes {
+				if tc.nodes[j] == node {
+					perNode[j]++
+				}
+			}
+		}
+	}
+	for _, count := range perNode {
+		if count == 0 {
+			t.Fatal("key应分布在所有节点上")
+		}
+	}
+
+	client.expectReply(":25\r\n", append([]string{"EXISTS"}, keys...)...)
+	client.expectReply(":25\r\n", append([]string{"TOUCH"}, keys...)...)
+	client.expectReply(":25\r\n", append([]string{"DEL"}, keys...)...)
+	client.expectReply(":0\r\n", append([]string{"UNLINK"}, keys...)...)
+	for i, key := range keys {
+		if i%2 == 0 {
+			tc.nodeFor(key).Set(key, "v")
+		}
+	}
+
+	down := tc.nodes[0].Addr()
+	tc.nodes[0].Close()
+	if reply := client.do(append([]string{"EXISTS"}, keys...)...); !strings.HasPrefix(reply, "-ERR ") || !strings.Contains(reply, down) {
+		t.Errorf("有节点不可用时应返回列出该节点的错误，实际为 %q", reply)
+	}
+
+	tc.updateConfig(func(config *Config) { config.FanOutBestEffort = true })
+	available := perNode[1] + perNode[2]
+	client.expectReply(fmt.Sprintf(":%d\r\n", available), append([]string{"EXISTS"}, keys...)...)
+	client.expectReply(fmt.Sprintf(":%d\r\n", available), append([]string{"UNLINK"}, keys...)...)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// policyAllowListRule 命令不在允许列表中时计数使用的规则名
+const policyAllowListRule = "allow_list"
+
+// newPolicyRejectedCounter 创建命令策略拒绝次数的计数器，rule为命中的deny项或allow_list
+func newPolicyRejectedCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_command_policy_rejected_total",
+		Help: "被命令策略拒绝的命令数",
+	}, []string{"rule"})
+}
+
+// matchPolicyEntry 判断命令是否匹配策略项，策略项为命令名（匹配所有子命令）或"命令|子命令"，不区分大小写
+func matchPolicyEntry(entry string, command []string) bool {
+	name, subCommand, hasSub := strings.Cut(entry, "|")
+	if !strings.EqualFold(name, command[0]) {
+		return false
+	}
+	return !hasSub || (len(command) > 1 && strings.EqualFold(subCommand, command[1]))
+}
+
+// checkCommandPolicy 按命令策略检查命令：先检查deny列表，命中即拒绝；
+// allow列表不为空时只允许其中的命令。被拒绝时返回错误并计数
+func (proxy *RedisClusterProxy) checkCommandPolicy(command []string) error {
+	policy := proxy.currentConfig().CommandPolicy
+
+	for _, entry := range policy.Deny {
+		if matchPolicyEntry(entry, command) {
+			proxy.policyRejected.WithLabelValues(strings.ToUpper(entry)).Inc()
+			return fmt.Errorf("命令 '%s' 被命令策略拒绝", command[0])
+		}
+	}
+
+	if len(policy.Allow) == 0 {
+		return nil
+	}
+	for _, entry := range policy.Allow {
+		if matchPolicyEntry(entry, command) {
+			return nil
+		}
+	}
+	proxy.policyRejected.WithLabelValues(policyAllowListRule).Inc()
+	return fmt.Errorf("命令 '%s' 不在命令策略的允许列表中", command[0])
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMatchPolicyEntry 策略项匹配命令名或"命令|子命令"，不区分大小写
+func TestMatchPolicyEntry(t *testing.T) {
+	tests := []struct {
+		entry   string
+		command []string
+		want    bool
+	}{
+		{"KEYS", []string{"KEYS", "*"}, true},
+		{"keys", []string{"KEYS", "*"}, true},
+		{"KEYS", []string{"keys", "*"}, true},
+		{"KEYS", []string{"SCAN", "0"}, false},
+		{"CONFIG", []string{"CONFIG", "SET", "a", "b"}, true},
+		{"CONFIG|SET", []string{"CONFIG", "SET", "a", "b"}, true},
+		{"config|set", []string{"Config", "Set", "a", "b"}, true},
+		{"CONFIG|SET", []string{"CONFIG", "GET", "a"}, false},
+		{"CONFIG|SET", []string{"CONFIG"}, false},
+		{"CONFIG|SET", []string{"SET", "k", "v"}, false},
+		{"CLIENT|LIST", []string{"CLIENT", "LIST"}, true},
+	}
+	for _, tt := range tests {
+		if got := matchPolicyEntry(tt.entry, tt.command); got != tt.want {
+			t.Errorf("matchPolicyEntry(%q, %q) = %v, 期望 %v", tt.entry, tt.command, got, tt.want)
+		}
+	}
+}
+
+// TestCommandPolicyDeny deny列表中的命令被拒绝，其余命令正常执行
+func TestCommandPolicyDeny(t *testing.T) {
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.CommandPolicy = CommandPolicy{Deny: []string{"keys", "CLIENT|list"}}
+	})
+	client := tc.client(t)
+
+	client.expectReply("-ERR 命令 'KEYS' 被命令策略拒绝\r\n", "KEYS", "*")
+	client.expectReply("-ERR 命令 'client' 被命令策略拒绝\r\n", "client", "LIST")
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	client.expectReply(bulk("bar"), "GET", "foo")
+	// 只禁止CLIENT LIST，其他CLIENT子命令不受影响
+	client.expectReply("$-1\r\n", "CLIENT", "GETNAME")
+
+	if got := testutil.ToFloat64(tc.proxy.policyRejected.WithLabelValues("KEYS")); got != 1 {
+		t.Errorf("KEYS的拒绝次数 = %v, 期望 1", got)
+	}
+	if got := testutil.ToFloat64(tc.proxy.policyRejected.WithLabelValues("CLIENT|LIST")); got != 1 {
+		t.Errorf("CLIENT|LIST的拒绝次数 = %v, 期望 1", got)
+	}
+}
+
+// TestCommandPolicyAllow allow列表不为空时只允许其中的命令，错误信息与deny不同
+func TestCommandPolicyAllow(t *testing.T) {
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.CommandPolicy = CommandPolicy{Allow: []string{"GET", "set", "DEL", "Expire", "CONFIG|GET"}}
+		config.AllowedDangerousCommands = []string{"CONFIG"}
+	})
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	client.expectReply(bulk("bar"), "get", "foo")
+	client.expectReply(":1\r\n", "EXPIRE", "foo", "100")
+	client.expectReply(":1\r\n", "DEL", "foo")
+	client.expectReply("-ERR 命令 'INCR' 不在命令策略的允许列表中\r\n", "INCR", "foo")
+	client.expectReply("-ERR 命令 'CONFIG' 不在命令策略的允许列表中\r\n", "CONFIG", "SET", "maxmemory", "0")
+	if tc.nodes[0].Exists("foo") {
+		t.Error("不在允许列表中的INCR不应执行")
+	}
+
+	if got := testutil.ToFloat64(tc.proxy.policyRejected.WithLabelValues(policyAllowListRule)); got != 2 {
+		t.Errorf("允许列表的拒绝次数 = %v, 期望 2", got)
+	}
+}
+
+// TestCommandPolicyOrder deny优先于allow：同时出现在两个列表中的命令被拒绝，按deny项计数
+func TestCommandPolicyOrder(t *testing.T) {
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.CommandPolicy = CommandPolicy{
+			Deny:  []string{"DEL", "HSET|ignored", "SCRIPT|FLUSH"},
+			Allow: []string{"GET", "SET", "del", "SCRIPT"},
+		}
+		config.AllowedDangerousCommands = []string{"SCRIPT FLUSH"}
+	})
+	client := tc.client(t)
+
+	client.expectReply("-ERR 命令 'DEL' 被命令策略拒绝\r\n", "DEL", "foo")
+	client.expectReply("-ERR 命令 'SCRIPT' 被命令策略拒绝\r\n", "SCRIPT", "FLUSH")
+	// deny中的子命令项不影响allow列表对同名命令的检查
+	client.expectReply("-ERR 命令 'HSET' 不在命令策略的允许列表中\r\n", "HSET", "h", "f", "v")
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+
+	if got := testutil.ToFloat64(tc.proxy.policyRejected.WithLabelValues("DEL")); got != 1 {
+		t.Errorf("DEL的拒绝次数 = %v, 期望 1", got)
+	}
+	if got := testutil.ToFloat64(tc.proxy.policyRejected.WithLabelValues(policyAllowListRule)); got != 1 {
+		t.Errorf("允许列表的拒绝次数 = %v, 期望 1", got)
+	}
+}
+
+// TestCommandPolicyInTransaction 事务中被策略拒绝的命令使事务在EXEC时失败
+func TestCommandPolicyInTransaction(t *testing.T) {
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.CommandPolicy = CommandPolicy{Deny: []string{"INCR"}}
+	})
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "foo", "1")
+	client.expectReply("-ERR 命令 'INCR' 被命令策略拒绝\r\n", "INCR", "foo")
+	client.expectErrorPrefix("EXECABORT", "EXEC")
+	if tc.nodes[0].Exists("foo") {
+		t.Error("失败的事务不应执行")
+	}
+}
+
+// TestCommandPolicyValidate 空的策略项和缺少子命令的策略项在校验配置时报错
+func TestCommandPolicyValidate(t *testing.T) {
+	for _, entry := range []string{"", "CONFIG|", "|SET"} {
+		config := defaultConfig()
+		config.RedisNodes = []string{"127.0.0.1:7000"}
+		config.CommandPolicy = CommandPolicy{Allow: []string{entry}}
+		if err := config.ValidateConfig(); err == nil {
+			t.Errorf("策略项 %q 应校验失败", entry)
+		}
+	}
+
+	config := defaultConfig()
+	config.RedisNodes = []string{"127.0.0.1:7000"}
+	config.CommandPolicy = CommandPolicy{Deny: []string{"KEYS", "config|set"}}
+	if err := config.ValidateConfig(); err != nil {
+		t.Errorf("有效的命令策略校验失败: %v", err)
+	}
+}
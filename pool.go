@@ -1,16 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// errPoolFull 连接数达到上限
+var errPoolFull = errors.New("连接池已满")
+
 // ConnectionPool Redis连接池
 type ConnectionPool struct {
-	pools map[string]*NodePool
-	mutex sync.RWMutex
+	pools   map[string]*NodePool
+	maxWait time.Duration                             // 连接池已满时等待可用连接的最长时间，0表示不等待
+	prepare func(address string, conn net.Conn) error // 新建连接后的初始化（如replica连接上的READONLY），失败时关闭连接
+	mutex   sync.RWMutex
 }
 
 // NodePool 单个节点的连接池
@@ -19,13 +27,38 @@ type NodePool struct {
 	connections chan net.Conn
 	maxSize     int
 	currentSize int
+	maxWait     time.Duration
+	prepare     func(address string, conn net.Conn) error
+	waiters     chan chan net.Conn // 等待可用连接的请求队列
 	mutex       sync.Mutex
+
+	errorCount    int64 // 建立连接失败、池中连接失效及获取连接超时的次数
+	totalCreated  int64 // 累计新建的连接数
+	totalReturned int64 // 累计归还的连接数
+}
+
+// pooledConn 连接池中的连接，记录连接上由代理设置的状态
+type pooledConn struct {
+	net.Conn
+	clientName string // 最近一次通过CLIENT SETNAME设置的名称，新建的连接没有名称
 }
 
-// NewConnectionPool 创建新的连接池
-func NewConnectionPool() *ConnectionPool {
+// PoolStats 单个节点连接池的统计信息
+type PoolStats struct {
+	Node          string `json:"node"`
+	Connections   int    `json:"connections"` // 当前连接数（包括使用中和空闲的连接）
+	Idle          int    `json:"idle"`
+	MaxSize       int    `json:"max_size"`
+	Errors        int64  `json:"errors"`
+	TotalCreated  int64  `json:"total_created"`
+	TotalReturned int64  `json:"total_returned"`
+}
+
+// NewConnectionPool 创建新的连接池，maxWait为连接池已满时等待可用连接的最长时间
+func NewConnectionPool(maxWait time.Duration) *ConnectionPool {
 	return &ConnectionPool{
-		pools: make(map[string]*NodePool),
+		pools:   make(map[string]*NodePool),
+		maxWait: maxWait,
 	}
 }
 
@@ -43,6 +76,9 @@ func (cp *ConnectionPool) GetConnection(address string) (net.Conn, error) {
 				address:     address,
 				connections: make(chan net.Conn, 10),
 				maxSize:     10,
+				maxWait:     cp.maxWait,
+				prepare:     cp.prepare,
+				waiters:     make(chan chan net.Conn, 1024),
 			}
 			cp.pools[address] = pool
 		}
@@ -65,6 +101,32 @@ func (cp *ConnectionPool) ReturnConnection(address string, conn net.Conn) {
 	}
 }
 
+// Stats 返回所有节点连接池的统计信息，按节点地址排序
+func (cp *ConnectionPool) Stats() []PoolStats {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	stats := make([]PoolStats, 0, len(cp.pools))
+	for _, pool := range cp.pools {
+		stats = append(stats, pool.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Node < stats[j].Node })
+	return stats
+}
+
+// DiscardConnection 关闭出错的连接，不再放回池中
+func (cp *ConnectionPool) DiscardConnection(address string, conn net.Conn) {
+	cp.mutex.RLock()
+	pool, exists := cp.pools[address]
+	cp.mutex.RUnlock()
+
+	if exists {
+		pool.DiscardConnection(conn)
+	} else if conn != nil {
+		conn.Close()
+	}
+}
+
 // GetConnection 从节点池获取连接
 func (np *NodePool) GetConnection() (net.Conn, error) {
 	select {
@@ -73,19 +135,116 @@ func (np *NodePool) GetConnection() (net.Conn, error) {
 		if np.isConnectionValid(conn) {
 			return conn, nil
 		}
-		// 连接无效，创建新连接
-		return np.createConnection()
+		// 连接无效，关闭后创建新连接
+		atomic.AddInt64(&np.errorCount, 1)
+		np.DiscardConnection(conn)
 	default:
 		// 池中没有可用连接，创建新连接
-		return np.createConnection()
 	}
+
+	conn, err := np.createConnection()
+	if err == errPoolFull {
+		conn, err = np.waitConnection()
+		if err != nil {
+			atomic.AddInt64(&np.errorCount, 1)
+		}
+	}
+	return conn, err
 }
 
-// ReturnConnection 归还连接到节点池
+// Stats 返回节点连接池的统计信息
+func (np *NodePool) Stats() PoolStats {
+	np.mutex.Lock()
+	current := np.currentSize
+	np.mutex.Unlock()
+
+	return PoolStats{
+		Node:          np.address,
+		Connections:   current,
+		Idle:          len(np.connections),
+		MaxSize:       np.maxSize,
+		Errors:        atomic.LoadInt64(&np.errorCount),
+		TotalCreated:  atomic.LoadInt64(&np.totalCreated),
+		TotalReturned: atomic.LoadInt64(&np.totalReturned),
+	}
+}
+
+// waitConnection 连接池已满时排队等待其他请求归还连接，超过maxWait返回超时错误
+func (np *NodePool) waitConnection() (net.Conn, error) {
+	if np.maxWait <= 0 {
+		return nil, errPoolFull
+	}
+
+	waiter := make(chan net.Conn)
+	select {
+	case np.waiters <- waiter:
+	default:
+		return nil, fmt.Errorf("连接池等待队列已满")
+	}
+
+	timer := time.NewTimer(np.maxWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case conn := <-waiter:
+			if conn != nil {
+				return conn, nil
+			}
+			// 有连接被关闭，释放了连接数配额
+			conn, err := np.createConnection()
+			if err != errPoolFull {
+				return conn, err
+			}
+			// 配额已被其他请求占用，重新排队
+			select {
+			case np.waiters <- waiter:
+			default:
+				return nil, fmt.Errorf("连接池等待队列已满")
+			}
+		case conn, ok := <-np.connections:
+			if !ok {
+				return nil, fmt.Errorf("连接池已关闭")
+			}
+			// 归还连接时恰好没有等待者，连接被放回了池中
+			if np.isConnectionValid(conn) {
+				return conn, nil
+			}
+			np.DiscardConnection(conn)
+		case <-timer.C:
+			return nil, fmt.Errorf("等待 %s 的可用连接超时 (%v)", np.address, np.maxWait)
+		}
+	}
+}
+
+// handOff 将连接直接交给一个正在等待的请求，conn为nil表示通知等待者自行创建连接。
+// 已超时离开的等待者会被跳过，没有等待者时返回false
+func (np *NodePool) handOff(conn net.Conn) bool {
+	for {
+		select {
+		case waiter := <-np.waiters:
+			select {
+			case waiter <- conn:
+				return true
+			default:
+				// 等待者已超时
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// ReturnConnection 归还连接到节点池，有等待者时直接交给等待者
 func (np *NodePool) ReturnConnection(conn net.Conn) {
 	if conn == nil {
 		return
 	}
+	atomic.AddInt64(&np.totalReturned, 1)
+
+	if np.handOff(conn) {
+		return
+	}
 
 	select {
 	case np.connections <- conn:
@@ -96,25 +255,48 @@ func (np *NodePool) ReturnConnection(conn net.Conn) {
 		np.mutex.Lock()
 		np.currentSize--
 		np.mutex.Unlock()
+		np.handOff(nil)
 	}
 }
 
+// DiscardConnection 关闭连接并释放连接数配额，通知一个等待者创建新连接
+func (np *NodePool) DiscardConnection(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+
+	conn.Close()
+	np.mutex.Lock()
+	np.currentSize--
+	np.mutex.Unlock()
+	np.handOff(nil)
+}
+
 // createConnection 创建新的连接
 func (np *NodePool) createConnection() (net.Conn, error) {
 	np.mutex.Lock()
 	defer np.mutex.Unlock()
 
 	if np.currentSize >= np.maxSize {
-		return nil, fmt.Errorf("连接池已满")
+		return nil, errPoolFull
 	}
 
 	conn, err := net.DialTimeout("tcp", np.address, 5*time.Second)
 	if err != nil {
+		atomic.AddInt64(&np.errorCount, 1)
 		return nil, fmt.Errorf("连接Redis节点失败 %s: %v", np.address, err)
 	}
+	if np.prepare != nil {
+		if err := np.prepare(np.address, conn); err != nil {
+			conn.Close()
+			atomic.AddInt64(&np.errorCount, 1)
+			return nil, fmt.Errorf("初始化到节点 %s 的连接失败: %v", np.address, err)
+		}
+	}
 
+	atomic.AddInt64(&np.totalCreated, 1)
 	np.currentSize++
-	return conn, nil
+	return &pooledConn{Conn: conn}, nil
 }
 
 // isConnectionValid 检查连接是否有效
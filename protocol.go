@@ -2,14 +2,24 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 )
 
+// errValueTooLarge 命令参数超过大小限制。超限的参数已被丢弃、命令的其余参数已读完，连接可以继续使用
+var errValueTooLarge = errors.New("value too large")
+
+// maxArrayArgs 命令数组的最大元素个数，与Redis的multibulk长度限制一致，避免按客户端声明的长度直接分配内存
+const maxArrayArgs = 1024 * 1024
+
 // RedisProtocol Redis协议解析器
-type RedisProtocol struct{}
+type RedisProtocol struct {
+	maxKeySize   int // 命令名和第一个参数（大多数命令中为key）的最大字节数，0表示不限制
+	maxValueSize int // 其余参数的最大字节数，0表示不限制
+}
 
 // ParseCommand 解析Redis命令
 func (rp *RedisProtocol) ParseCommand(reader *bufio.Reader) ([]string, error) {
@@ -43,21 +53,37 @@ func (rp *RedisProtocol) parseArrayCommand(reader *bufio.Reader, firstLine strin
 	if count <= 0 {
 		return []string{}, nil
 	}
+	if count > maxArrayArgs {
+		return nil, fmt.Errorf("数组长度超过限制: %d", count)
+	}
 
 	args := make([]string, count)
+	tooLarge := false
 	for i := 0; i < count; i++ {
-		arg, err := rp.parseBulkString(reader)
+		limit := rp.maxValueSize
+		if i <= 1 {
+			limit = rp.maxKeySize
+		}
+		arg, err := rp.parseBulkString(reader, limit)
+		if err == errValueTooLarge {
+			// 继续读取其余参数，保证下一条命令从正确的位置开始解析
+			tooLarge = true
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 		args[i] = arg
 	}
+	if tooLarge {
+		return nil, errValueTooLarge
+	}
 
 	return args, nil
 }
 
-// parseBulkString 解析批量字符串
-func (rp *RedisProtocol) parseBulkString(reader *bufio.Reader) (string, error) {
+// parseBulkString 解析批量字符串，长度超过limit时丢弃数据并返回errValueTooLarge，limit为0表示不限制
+func (rp *RedisProtocol) parseBulkString(reader *bufio.Reader, limit int) (string, error) {
 	line, err := reader.ReadString('\n')
 	if err != nil {
 		return "", err
@@ -77,6 +103,17 @@ func (rp *RedisProtocol) parseBulkString(reader *bufio.Reader) (string, error) {
 	if length == -1 {
 		return "", nil // NULL
 	}
+	if length < -1 {
+		return "", fmt.Errorf("无效的字符串长度: %s", lengthStr)
+	}
+
+	if limit > 0 && length > limit {
+		// 不缓存数据，直接跳过数据和结尾的\r\n
+		if _, err := reader.Discard(length + 2); err != nil {
+			return "", err
+		}
+		return "", errValueTooLarge
+	}
 
 	if length == 0 {
 		// 读取空行
@@ -97,6 +134,135 @@ func (rp *RedisProtocol) parseBulkString(reader *bufio.Reader) (string, error) {
 	return string(data), nil
 }
 
+// RespValue 解析后的Redis响应
+type RespValue struct {
+	Type  byte         // 响应类型: '+', '-', ':', '$', '*'
+	Str   string       // 简单字符串、错误或批量字符串的内容
+	Int   int64        // 整数响应的值
+	Array []*RespValue // 数组响应的元素
+	IsNil bool         // 是否为NULL批量字符串或NULL数组
+}
+
+// ParseResponse 将完整的响应字符串解析为RespValue
+func (rp *RedisProtocol) ParseResponse(response string) (*RespValue, error) {
+	value, rest, err := rp.parseValue(response)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("响应末尾存在多余数据")
+	}
+	return value, nil
+}
+
+// parseValue 解析一个RESP值，返回剩余未解析的数据
+func (rp *RedisProtocol) parseValue(data string) (*RespValue, string, error) {
+	end := strings.Index(data, "\r\n")
+	if end < 1 {
+		return nil, "", fmt.Errorf("无效的响应格式")
+	}
+	line := data[1:end]
+	rest := data[end+2:]
+
+	value := &RespValue{Type: data[0]}
+	switch data[0] {
+	case '+', '-':
+		value.Str = line
+		return value, rest, nil
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("无效的整数响应: %s", line)
+		}
+		value.Int = n
+		return value, rest, nil
+	case '$':
+		length, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, "", fmt.Errorf("无效的字符串长度: %s", line)
+		}
+		if length < 0 {
+			value.IsNil = true
+			return value, rest, nil
+		}
+		if len(rest) < length+2 {
+			return nil, "", fmt.Errorf("批量字符串数据不完整")
+		}
+		value.Str = rest[:length]
+		return value, rest[length+2:], nil
+	case '*':
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, "", fmt.Errorf("无效的数组长度: %s", line)
+		}
+		if count < 0 {
+			value.IsNil = true
+			return value, rest, nil
+		}
+		value.Array = make([]*RespValue, 0, count)
+		for i := 0; i < count; i++ {
+			var element *RespValue
+			element, rest, err = rp.parseValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			value.Array = append(value.Array, element)
+		}
+		return value, rest, nil
+	default:
+		return nil, "", fmt.Errorf("未知的响应类型: %c", data[0])
+	}
+}
+
+// Format 将RespValue格式化为Redis协议字符串
+func (v *RespValue) Format() string {
+	switch v.Type {
+	case '+', '-':
+		return fmt.Sprintf("%c%s\r\n", v.Type, v.Str)
+	case ':':
+		return fmt.Sprintf(":%d\r\n", v.Int)
+	case '$':
+		if v.IsNil {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v.Str), v.Str)
+	case '_':
+		return "_\r\n"
+	case '*', '>', '%':
+		if v.IsNil {
+			return "*-1\r\n"
+		}
+		// RESP3的map（%）中Array依次存放key和value，长度为键值对的数量
+		count := len(v.Array)
+		if v.Type == '%' {
+			count /= 2
+		}
+		var builder strings.Builder
+		builder.WriteString(fmt.Sprintf("%c%d\r\n", v.Type, count))
+		for _, element := range v.Array {
+			builder.WriteString(element.Format())
+		}
+		return builder.String()
+	default:
+		return ""
+	}
+}
+
+// IsError 判断响应是否为错误
+func (v *RespValue) IsError() bool {
+	return v.Type == '-'
+}
+
+// FormatCommand 将命令格式化为Redis协议数组
+func (rp *RedisProtocol) FormatCommand(command []string) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("*%d\r\n", len(command)))
+	for _, arg := range command {
+		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	return builder.String()
+}
+
 // FormatResponse 格式化Redis响应
 func (rp *RedisProtocol) FormatResponse(response string) string {
 	return response
@@ -107,6 +273,27 @@ func (rp *RedisProtocol) FormatError(message string) string {
 	return fmt.Sprintf("-ERR %s\r\n", message)
 }
 
+// FormatCrossSlotError 格式化跨slot错误响应
+func (rp *RedisProtocol) FormatCrossSlotError() string {
+	return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+}
+
+// FormatCrossSlotKeysError 格式化跨slot错误响应，并指出不在同一个slot的两个key
+func (rp *RedisProtocol) FormatCrossSlotKeysError(key string, slot int, otherKey string, otherSlot int) string {
+	return fmt.Sprintf("-CROSSSLOT Keys in request don't hash to the same slot (key '%s' slot %d, key '%s' slot %d)\r\n",
+		key, slot, otherKey, otherSlot)
+}
+
+// FormatBulkString 格式化批量字符串响应
+func (rp *RedisProtocol) FormatBulkString(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// FormatInteger 格式化整数响应
+func (rp *RedisProtocol) FormatInteger(value int64) string {
+	return fmt.Sprintf(":%d\r\n", value)
+}
+
 // FormatSimpleString 格式化简单字符串响应
 func (rp *RedisProtocol) FormatSimpleString(message string) string {
 	return fmt.Sprintf("+%s\r\n", message)
@@ -120,6 +307,9 @@ func (rp *RedisProtocol) IsMovedError(response string) (bool, string, string) {
 		if len(parts) >= 3 {
 			slot := parts[1]
 			address := parts[2]
+			if normalized, err := normalizeNodeAddress(address); err == nil {
+				address = normalized
+			}
 			return true, slot, address
 		}
 	}
@@ -134,6 +324,9 @@ func (rp *RedisProtocol) IsAskError(response string) (bool, string, string) {
 		if len(parts) >= 3 {
 			slot := parts[1]
 			address := parts[2]
+			if normalized, err := normalizeNodeAddress(address); err == nil {
+				address = normalized
+			}
 			return true, slot, address
 		}
 	}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseCommandSizeLimits 超过max_key_size/max_value_size的参数被丢弃，读取位置停在下一条命令的开头
+func TestParseCommandSizeLimits(t *testing.T) {
+	rp := &RedisProtocol{maxKeySize: 8, maxValueSize: 16}
+	tests := []struct {
+		name string
+		args []string
+		err  error
+	}{
+		{"在限制内", []string{"SET", "12345678", strings.Repeat("v", 16)}, nil},
+		{"key超过限制", []string{"SET", "123456789", "v"}, errValueTooLarge},
+		{"value超过限制", []string{"SET", "k", strings.Repeat("v", 17)}, errValueTooLarge},
+		{"后面的参数超过限制", []string{"MSET", "k1", "v1", "k2", strings.Repeat("v", 100)}, errValueTooLarge},
+		{"命令名使用key的限制", []string{"GETRANGEXX", "k"}, errValueTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(formatTestCommand(tt.args) + formatTestCommand([]string{"PING"})))
+			args, err := rp.ParseCommand(reader)
+			if err != tt.err {
+				t.Fatalf("err = %v, 期望 %v", err, tt.err)
+			}
+			if tt.err == nil && !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("args = %q, 期望 %q", args, tt.args)
+			}
+			next, err := rp.ParseCommand(reader)
+			if err != nil || !reflect.DeepEqual(next, []string{"PING"}) {
+				t.Fatalf("下一条命令 = %q, %v, 期望 PING", next, err)
+			}
+		})
+	}
+}
+
+// TestParseCommandUnlimited 限制为0时不检查参数大小
+func TestParseCommandUnlimited(t *testing.T) {
+	rp := &RedisProtocol{}
+	value := strings.Repeat("v", 1<<20)
+	args, err := rp.ParseCommand(bufio.NewReader(strings.NewReader(formatTestCommand([]string{"SET", "k", value}))))
+	if err != nil || len(args) != 3 || args[2] != value {
+		t.Fatalf("ParseCommand = %d个参数, %v", len(args), err)
+	}
+}
+
+// TestParseCommandInvalid 无效的数组长度和批量字符串长度返回协议错误，不按声明的长度分配内存
+func TestParseCommandInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"数组长度超过限制", "*1048577\r\n$4\r\nPING\r\n"},
+		{"数组长度过大", "*9223372036854775807\r\n"},
+		{"数组长度不是整数", "*abc\r\n"},
+		{"负的批量字符串长度", "*2\r\n$3\r\nGET\r\n$-5\r\n"},
+		{"批量字符串长度不是整数", "*1\r\n$x\r\n"},
+		{"缺少批量字符串头", "*1\r\nPING\r\n"},
+	}
+	rp := &RedisProtocol{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := rp.ParseCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if err == nil || err == errValueTooLarge || err == io.EOF {
+				t.Fatalf("ParseCommand(%q) = %q, %v, 期望协议错误", tt.input, args, err)
+			}
+		})
+	}
+}
+
+// TestParseCommandNullArgument $-1表示的NULL参数解析为空字符串
+func TestParseCommandNullArgument(t *testing.T) {
+	rp := &RedisProtocol{}
+	args, err := rp.ParseCommand(bufio.NewReader(strings.NewReader("*2\r\n$4\r\nECHO\r\n$-1\r\n")))
+	if err != nil || !reflect.DeepEqual(args, []string{"ECHO", ""}) {
+		t.Fatalf("ParseCommand = %q, %v", args, err)
+	}
+}
+
+// TestValueTooLargeReply 参数超过限制时客户端收到value too large，连接可以继续使用
+func TestValueTooLargeReply(t *testing.T) {
+	tc := newTestCluster(t, 1, func(config *Config) {
+		config.MaxKeySize = 16
+		config.MaxValueSize = 64
+	})
+	client := tc.client(t)
+
+	client.expectReply("-ERR value too large\r\n", "SET", "k", strings.Repeat("v", 65))
+	client.expectReply("-ERR value too large\r\n", "SET", strings.Repeat("k", 17), "v")
+	client.expectReply("+OK\r\n", "SET", "k", strings.Repeat("v", 64))
+	client.expectReply(bulk(strings.Repeat("v", 64)), "GET", "k")
+	if tc.nodes[0].Exists(strings.Repeat("k", 17)) {
+		t.Fatal("超过限制的命令不应发送到节点")
+	}
+}
+
+// TestProtocolErrorClosesConnection 无效的批量字符串长度返回协议错误并关闭连接，代理继续服务其他客户端
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	client := tc.client(t)
+	if _, err := client.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$-5\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if response := client.read(); response != "-ERR 协议错误\r\n" {
+		t.Fatalf("响应 = %q", response)
+	}
+	if _, err := client.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("连接应被关闭, err = %v", err)
+	}
+
+	tc.client(t).expectReply("+PONG\r\n", "PING")
+}
@@ -2,48 +2,113 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // RedisClusterProxy Redis集群代理
 type RedisClusterProxy struct {
-	config         *Config
-	pool           *ConnectionPool
-	protocol       *RedisProtocol
-	clusterManager *ClusterManager
-	listener       net.Listener
-	running        bool
-	mutex          sync.RWMutex
+	config          atomic.Pointer[Config] // 当前生效的配置，重新加载时整体替换
+	pool            *ConnectionPool
+	protocol        *RedisProtocol
+	clusterManager  *ClusterManager
+	commandKeys     *commandKeysCache
+	scripts         *scriptCache
+	scanCursors     *scanCursorTable
+	rateLimiter     *rateLimiter
+	policyRejected  *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+	latency         *LatencyTracker
+	clients         *clientRegistry
+	encodingCache   *encodingCache
+	keyspaceRelay   *keyspaceRelay // 配置了keyspace_relay_patterns时转发所有master节点的keyspace通知
+	watcher         *configWatcher
+	metrics         *prometheus.Registry
+	adminServer     *http.Server
+	listener        net.Listener
+	cancel          context.CancelFunc // Start时创建，Stop时取消，用于结束后台任务
+	acceptDone      chan struct{}      // Start的接受连接循环退出时关闭
+	handlers        sync.WaitGroup     // 正在运行的handleConnection
+	mutex           sync.RWMutex
+
+	// newRefreshTicker 创建定时检查集群信息的ticker，返回触发通道和停止函数，测试中替换为手动触发
+	newRefreshTicker func(interval time.Duration) (<-chan time.Time, func())
 }
 
 // NewRedisClusterProxy 创建新的Redis集群代理
 func NewRedisClusterProxy(config *Config) *RedisClusterProxy {
-	return &RedisClusterProxy{
-		config:         config,
-		pool:           NewConnectionPool(),
-		protocol:       &RedisProtocol{},
-		clusterManager: NewClusterManager(config),
+	proxy := &RedisClusterProxy{
+		pool:            NewConnectionPool(config.PoolMaxWait),
+		protocol:        &RedisProtocol{maxKeySize: config.MaxKeySize, maxValueSize: config.MaxValueSize},
+		clusterManager:  NewClusterManager(config),
+		commandKeys:     newCommandKeysCache(),
+		scripts:         newScriptCache(),
+		scanCursors:     newScanCursorTable(),
+		rateLimiter:     newRateLimiter(),
+		policyRejected:  newPolicyRejectedCounter(),
+		commandDuration: newCommandDurationHistogram(config.MetricsHistogramBuckets),
+		latency:         newLatencyTracker(),
+		clients:         newClientRegistry(),
+		encodingCache:   newEncodingCache(),
+	}
+	proxy.newRefreshTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+		ticker := time.NewTicker(interval)
+		return ticker.C, ticker.Stop
 	}
+	proxy.keyspaceRelay = newKeyspaceRelay(proxy, config.KeyspaceRelayPatterns)
+	proxy.config.Store(config)
+	proxy.metrics = proxy.newMetricsRegistry()
+	proxy.pool.prepare = proxy.prepareBackendConn
+	return proxy
+}
+
+// currentConfig 返回当前生效的配置
+func (proxy *RedisClusterProxy) currentConfig() *Config {
+	return proxy.config.Load()
 }
 
+// startupCheckTimeout 启动检查中每个节点PING的超时时间
+const startupCheckTimeout = 2 * time.Second
+
 // Start 启动代理服务
 func (proxy *RedisClusterProxy) Start() error {
-	address := proxy.config.GetProxyAddress()
-	listener, err := net.Listen("tcp", address)
+	// 在接受客户端连接之前检查后端节点是否可用
+	if err := proxy.startupCheck(startupCheckTimeout); err != nil {
+		if proxy.currentConfig().StartupHealthCheck {
+			return fmt.Errorf("启动检查失败: %v", err)
+		}
+		LogWarn("启动检查失败，继续启动: %v", err)
+	}
+
+	address := proxy.currentConfig().GetProxyAddress()
+	listener, err := net.Listen(proxy.currentConfig().GetProxyNetwork(), address)
 	if err != nil {
 		return fmt.Errorf("启动代理服务失败: %v", err)
 	}
 
+	// 每次启动创建新的context，Stop之后可以再次Start
+	ctx, cancel := context.WithCancel(context.Background())
+	acceptDone := make(chan struct{})
+	defer close(acceptDone)
+	proxy.mutex.Lock()
 	proxy.listener = listener
-	proxy.running = true
+	proxy.cancel = cancel
+	proxy.acceptDone = acceptDone
+	proxy.mutex.Unlock()
+	proxy.clients.open()
 
 	LogInfo("Redis集群代理启动成功，监听地址: %s", address)
-	LogInfo("后端Redis节点: %v", proxy.config.RedisNodes)
+	LogInfo("后端Redis节点: %v", proxy.currentConfig().RedisNodes)
 
 	// 初始化集群信息
 	LogInfo("正在初始化Redis集群信息...")
@@ -56,42 +121,95 @@ func (proxy *RedisClusterProxy) Start() error {
 	}
 
 	// 启动集群信息定期刷新
-	go proxy.startClusterInfoRefresh()
+	go proxy.startClusterInfoRefresh(ctx)
+
+	// 在所有master节点上订阅转发的keyspace通知，随拓扑变化增删订阅
+	if proxy.keyspaceRelay != nil {
+		go proxy.keyspaceRelay.run()
+	}
+
+	// 定期清理空闲客户端IP的令牌桶
+	go proxy.rateLimiter.run(func() time.Duration { return proxy.currentConfig().RateLimitIdleTimeout })
 
-	for proxy.running {
+	// 启动管理HTTP服务
+	if adminAddress := proxy.currentConfig().AdminAddress; adminAddress != "" {
+		if err := proxy.startAdminServer(adminAddress); err != nil {
+			LogError("启动管理服务失败: %v", err)
+		}
+	}
+
+	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if proxy.running {
-				LogError("接受连接失败: %v", err)
+			if ctx.Err() != nil {
+				break
 			}
+			LogError("接受连接失败: %v", err)
 			continue
 		}
 
-		go proxy.handleConnection(conn)
+		proxy.handlers.Add(1)
+		go func() {
+			defer proxy.handlers.Done()
+			proxy.handleConnection(conn)
+		}()
 	}
 
 	return nil
 }
 
-// startClusterInfoRefresh 启动集群信息定期刷新
-func (proxy *RedisClusterProxy) startClusterInfoRefresh() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// startupCheck 并发向配置中的每个节点发送PING，响应的节点少于MinHealthyNodes时返回错误
+func (proxy *RedisClusterProxy) startupCheck(timeout time.Duration) error {
+	config := proxy.currentConfig()
+	errs := make([]error, len(config.RedisNodes))
+
+	var wg sync.WaitGroup
+	for i, address := range config.RedisNodes {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			errs[i] = pingNode(address, timeout)
+		}(i, address)
+	}
+	wg.Wait()
+
+	healthy := 0
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%v)", config.RedisNodes[i], err))
+			continue
+		}
+		healthy++
+	}
+	if len(failures) > 0 {
+		LogWarn("启动检查中以下节点不可用: %s", strings.Join(failures, ", "))
+	}
+	LogInfo("启动检查完成，%d/%d 个节点可用", healthy, len(config.RedisNodes))
+
+	if healthy < config.MinHealthyNodes {
+		return fmt.Errorf("只有 %d 个节点可用，至少需要 %d 个", healthy, config.MinHealthyNodes)
+	}
+	return nil
+}
+
+// startClusterInfoRefresh 每隔cluster_refresh_interval检查集群信息是否超过cluster_stale_after未更新，
+// 过期时刷新，直到ctx被取消
+func (proxy *RedisClusterProxy) startClusterInfoRefresh(ctx context.Context) {
+	ticks, stop := proxy.newRefreshTicker(proxy.currentConfig().GetClusterRefreshInterval())
+	defer stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if proxy.running && proxy.clusterManager.IsClusterInfoStale() {
-				LogDebug("刷新集群信息...")
-				if err := proxy.clusterManager.RefreshClusterInfo(); err != nil {
+		case <-ticks:
+			if proxy.clusterManager.IsClusterInfoStale() {
+				LogDebug("集群信息已过期，定时刷新...")
+				if err := proxy.clusterManager.refreshFor(refreshTriggerPeriodic); err != nil {
 					LogWarn("刷新集群信息失败: %v", err)
 				}
 			}
-		default:
-			if !proxy.running {
-				return
-			}
-			time.Sleep(1 * time.Second)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -101,28 +219,107 @@ func (proxy *RedisClusterProxy) Stop() {
 	proxy.mutex.Lock()
 	defer proxy.mutex.Unlock()
 
-	proxy.running = false
+	if proxy.cancel != nil {
+		proxy.cancel()
+	}
 	if proxy.listener != nil {
 		proxy.listener.Close()
+		// 接受连接循环退出后不会再启动新的handleConnection
+		<-proxy.acceptDone
+	}
+	proxy.closeClients()
+	if proxy.watcher != nil {
+		proxy.watcher.Close()
+	}
+	if proxy.adminServer != nil {
+		proxy.adminServer.Close()
 	}
 	proxy.pool.Close()
+	proxy.clusterManager.Close()
+	proxy.rateLimiter.Close()
+	if proxy.keyspaceRelay != nil {
+		proxy.keyspaceRelay.Close()
+	}
 }
 
-// handleConnection 处理客户端连接
-func (proxy *RedisClusterProxy) handleConnection(clientConn net.Conn) {
-	defer clientConn.Close()
+// stopClientsTimeout Stop等待handleConnection退出的最长时间，阻塞在没有响应的后端节点上的命令不会随客户端连接关闭而返回
+const stopClientsTimeout = 5 * time.Second
+
+// closeClients 断开所有客户端连接并等待handleConnection退出
+func (proxy *RedisClusterProxy) closeClients() {
+	proxy.clients.closeAll()
+	done := make(chan struct{})
+	go func() {
+		proxy.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopClientsTimeout):
+		LogWarn("等待客户端连接处理结束超时，仍有 %d 个连接未退出", len(proxy.clients.list()))
+	}
+}
 
-	clientReader := bufio.NewReader(clientConn)
+// handleConnection 处理客户端连接
+func (proxy *RedisClusterProxy) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	config := proxy.currentConfig()
+	session := newClientSession(conn, config.GetReadBufferSize(), config.GetWriteBufferSize())
+	clientConn := session.conn
+	clientReader := session.reader
+	// 断开前写出缓冲区中剩余的响应
+	defer clientConn.Flush()
+	if !proxy.clients.add(session) {
+		// 代理正在停止
+		return
+	}
+	defer proxy.clients.remove(session)
+	// 客户端断开时关闭WATCH和粘性会话独占的后端连接
+	defer proxy.releasePinned(session, true)
+	defer proxy.releaseSticky(session)
+	// 处理命令期间持有写锁，CLIENT TRACKING的失效通知只在等待客户端命令时写入，不会插入到响应中
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	defer proxy.releaseTracking(session)
+	session.sticky = config.StickySessions
+	ip := clientIP(clientConn)
 	LogInfo("新客户端连接: %s", clientConn.RemoteAddr())
 
+	// 退出订阅模式时收到的命令，在下一轮循环中按普通命令处理
+	var pending []string
 	for {
+		// 客户端的命令都已处理完时才写出缓冲的响应，流水线中的多个响应合并为一次写入
+		if clientReader.Buffered() == 0 {
+			if err := clientConn.Flush(); err != nil {
+				LogInfo("写入客户端 %s 失败: %v", session.describe(), err)
+				return
+			}
+		}
+
 		// 解析客户端命令
-		command, err := proxy.protocol.ParseCommand(clientReader)
+		command, err := pending, error(nil)
+		pending = nil
+		if command == nil {
+			session.writeMutex.Unlock()
+			command, err = proxy.protocol.ParseCommand(clientReader)
+			session.writeMutex.Lock()
+		}
 		if err != nil {
 			if err == io.EOF {
-				LogInfo("客户端断开连接: %s", clientConn.RemoteAddr())
+				LogInfo("客户端断开连接: %s", session.describe())
+				return
+			}
+			if session.killed.Load() {
+				LogInfo("客户端连接已被CLIENT KILL断开: %s", session.describe())
 				return
 			}
+			if err == errValueTooLarge {
+				LogWarn("客户端 %s 的命令参数超过大小限制，已丢弃", session.describe())
+				session.tx.abort()
+				proxy.sendError(clientConn, err.Error())
+				continue
+			}
 			LogError("解析命令失败: %v", err)
 			proxy.sendError(clientConn, "协议错误")
 			return
@@ -132,32 +329,215 @@ func (proxy *RedisClusterProxy) handleConnection(clientConn net.Conn) {
 			continue
 		}
 
-		LogDebug("收到命令: %v", command)
+		session.log = nil
+		if proxy.currentConfig().TraceRequests {
+			session.log = newRequestLogger()
+		}
+		session.log.Debug("收到命令: %v", command)
+		session.touch(strings.ToLower(command[0]))
+
+		// 超过限流时断开连接，避免客户端继续占用代理和后端资源
+		config = proxy.currentConfig()
+		if !proxy.rateLimiter.Allow(ip, config.RateLimitCommandsPerSecond, config.RateLimitBurstSize) {
+			LogWarn("客户端 %s 超过限流，断开连接", session.describe())
+			clientConn.Write([]byte("-ERR rate limit exceeded\r\n"))
+			return
+		}
+
+		if proxy.isCommandBlocked(command[0]) {
+			session.tx.abort()
+			proxy.sendError(clientConn, fmt.Sprintf("命令 '%s' 已被代理禁用", command[0]))
+			continue
+		}
+		if err := proxy.checkCommandPolicy(command); err != nil {
+			session.tx.abort()
+			proxy.sendError(clientConn, err.Error())
+			continue
+		}
+
+		// MONITOR命令汇聚所有master节点（或PROXY NODE指定节点）的输出，直到客户端断开或执行RESET
+		// MONITOR和订阅模式下由后端读取协程直接写客户端连接，不经过写缓冲
+		if isMonitorCommand(command) && !session.tx.active {
+			nodes, err := proxy.monitorNodes(command)
+			if err != nil {
+				proxy.sendError(clientConn, err.Error())
+				continue
+			}
+			clientConn.Flush()
+			reset, err := proxy.handleMonitorConnection(clientConn.Conn, clientReader, nodes)
+			if err != nil {
+				LogError("处理MONITOR连接失败: %v", err)
+				proxy.sendError(clientConn, err.Error())
+				continue
+			}
+			if !reset {
+				return
+			}
+			proxy.releasePinned(session, false)
+			proxy.releaseTracking(session)
+			session.reset()
+			continue
+		}
+
+		// 订阅命令会使连接进入订阅模式，直到客户端断开、执行RESET或退订全部频道
+		if isSubscribeCommand(strings.ToUpper(command[0])) && !session.tx.active {
+			// 分片订阅的频道校验失败时不进入订阅模式
+			if strings.EqualFold(command[0], "SSUBSCRIBE") {
+				if reply := proxy.shardChannelsError(command, -1); reply != "" {
+					clientConn.Write([]byte(reply))
+					continue
+				}
+			}
+			clientConn.Flush()
+			normal, next, err := proxy.handlePubSubConnection(clientConn.Conn, clientReader, command)
+			if err != nil {
+				LogError("处理订阅连接失败: %v", err)
+				proxy.sendError(clientConn, err.Error())
+			}
+			if !normal {
+				return
+			}
+			if next == nil {
+				proxy.releasePinned(session, false)
+				proxy.releaseTracking(session)
+				session.reset()
+			}
+			pending = next
+			continue
+		}
 
 		// 处理命令
-		err = proxy.handleCommand(clientConn, command)
+		start := time.Now()
+		err = proxy.handleCommand(session, command)
+		proxy.observeCommandDuration(command[0], start)
 		if err != nil {
-			LogError("处理命令失败: %v", err)
+			session.log.Error("客户端 %s 处理命令失败: %v", session.describe(), err)
 			proxy.sendError(clientConn, err.Error())
 		}
 	}
 }
 
 // handleCommand 处理Redis命令
-func (proxy *RedisClusterProxy) handleCommand(clientConn net.Conn, command []string) error {
+func (proxy *RedisClusterProxy) handleCommand(session *clientSession, command []string) error {
 	if len(command) == 0 {
 		return fmt.Errorf("空命令")
 	}
+	clientConn := session.conn
+
+	// 危险命令在路由之前拒绝，不占用后端连接
+	if err := proxy.checkDangerousCommand(command); err != nil {
+		session.log.Warn("拒绝危险命令: %s", command[0])
+		session.tx.abort()
+		return err
+	}
+
+	// RESET清空代理为连接保存的状态（包括进行中的事务），后端连接由连接池共享，不需要转发
+	if strings.ToUpper(command[0]) == "RESET" {
+		proxy.releasePinned(session, false)
+		proxy.releaseSticky(session)
+		proxy.releaseTracking(session)
+		session.reset()
+		return proxy.writeClient(session, proxy.protocol.FormatSimpleString("RESET"))
+	}
+
+	// 写命令可能改变key的编码，粘性会话中的写命令同样需要删除缓存
+	proxy.invalidateEncodingCache(strings.ToUpper(command[0]), command)
+
+	// 粘性会话的命令原样转发到独占的后端连接，PROXY命令仍由代理处理
+	if session.sticky && strings.ToUpper(command[0]) != "PROXY" {
+		return proxy.executeSticky(session, command)
+	}
+
+	// 事务命令及事务中的排队命令
+	if handled, err := proxy.handleTransactionCommand(session, strings.ToUpper(command[0]), command); handled {
+		return err
+	}
+
+	// 由代理直接应答的命令
+	if handled, err := proxy.handleLocalCommand(session, strings.ToUpper(command[0]), command); handled {
+		return err
+	}
+
+	// WATCH之后的命令都在独占的后端连接上执行
+	if session.tx.pinned != nil {
+		return proxy.executeOnPinned(session, command)
+	}
+
+	// WAIT需要在执行了之前写命令的master节点上执行
+	if strings.ToUpper(command[0]) == "WAIT" {
+		return proxy.handleWait(session, command)
+	}
+
+	// 需要发送到所有master节点的命令
+	if handled, err := proxy.handleFanOutCommand(clientConn, strings.ToUpper(command[0]), command); handled {
+		return err
+	}
+
+	// SCAN类命令的游标只在返回它的节点上有效，需要转换游标
+	if handled, err := proxy.handleScanCommand(clientConn, strings.ToUpper(command[0]), command); handled {
+		return err
+	}
+
+	// 转发前检查多key命令的所有key位于同一个slot，避免后端部分执行后才报错；
+	// 支持拆分的命令（见multikey.go）按slot拆分执行，numkeys为0的脚本发送到随机master节点
+	if spec := lookupCommand(command[0]); spec != nil {
+		if err := spec.validateArgs(command); err != nil {
+			return err
+		}
+		keys := spec.extractKeys(command)
+		if i := proxy.clusterManager.firstCrossSlotKey(keys); i > 0 {
+			if handled, err := proxy.handleMultiKeyCommand(clientConn, strings.ToUpper(command[0]), command, keys); handled {
+				return err
+			}
+			session.log.Debug("命令 %s 的key不在同一个slot: %s", command[0], proxy.describeKeySlots(keys))
+			slot, otherSlot := CalculateSlot(keys[0]), CalculateSlot(keys[i])
+			_, err := clientConn.Write([]byte(proxy.protocol.FormatCrossSlotKeysError(keys[0], slot, keys[i], otherSlot)))
+			return err
+		}
+	}
+
+	// 记录脚本内容，用于NOSCRIPT时自动重试
+	proxy.scripts.Remember(command)
 
-	// 选择后端节点（简单轮询，实际应该根据key的hash slot选择）
+	// CLUSTER COUNTKEYSINSLOT/GETKEYSINSLOT只在负责该slot的节点上有意义
+	if isClusterSlotCommand(command) {
+		backendAddr, err := proxy.selectSlotOwner(command[2])
+		if err != nil {
+			return err
+		}
+		return proxy.executeCommandWithRedirect(session, command, backendAddr, 0, 0)
+	}
+
+	// 根据key的hash slot选择后端节点
 	backendAddr := proxy.selectBackendNode(command)
+	spec := lookupCommand(command[0])
+	if spec != nil && spec.hasFlag(cmdWrite) {
+		session.lastWriteNode = backendAddr
+	}
+
+	// 阻塞命令使用独占的后端连接，不占用连接池中的连接
+	if spec != nil && isBlockingCommand(spec, command) {
+		return proxy.executeBlocking(session, command, backendAddr)
+	}
+
+	// 开启CLIENT TRACKING后带key的命令在跟踪会话的节点连接上执行，读取的key才会被跟踪
+	if session.tracking != nil && spec != nil && len(spec.extractKeys(command)) > 0 {
+		return proxy.executeTracked(session, command, backendAddr)
+	}
+
+	// READONLY或read_from开启时带key的只读命令可以发送到replica
+	if spec != nil && spec.hasFlag(cmdReadonly) && len(spec.extractKeys(command)) > 0 {
+		backendAddr = proxy.readNode(session, backendAddr)
+	}
 	
 	// 执行命令并处理重定向
-	return proxy.executeCommandWithRedirect(clientConn, command, backendAddr, 0)
+	return proxy.executeCommandWithRedirect(session, command, backendAddr, 0, 0)
 }
 
-// executeCommandWithRedirect 执行命令并处理重定向
-func (proxy *RedisClusterProxy) executeCommandWithRedirect(clientConn net.Conn, command []string, backendAddr string, redirectCount int) error {
+// executeCommandWithRedirect 执行命令并处理重定向，clusterDownRetry为收到CLUSTERDOWN后已经重试的次数
+func (proxy *RedisClusterProxy) executeCommandWithRedirect(session *clientSession, command []string, backendAddr string, redirectCount int, clusterDownRetry int) error {
+	rlog, clientConn := session.log, session.conn
+
 	// 防止无限重定向
 	if redirectCount > 5 {
 		return fmt.Errorf("重定向次数过多")
@@ -168,16 +548,24 @@ func (proxy *RedisClusterProxy) executeCommandWithRedirect(clientConn net.Conn,
 		cmdName = strings.ToUpper(command[0])
 	}
 	
-	LogDebug("开始执行命令 %s 到节点 %s", cmdName, backendAddr)
+	rlog.Debug("开始执行命令 %s 到节点 %s", cmdName, backendAddr)
 
 	// 获取后端连接
+	start := time.Now()
 	backendConn, err := proxy.pool.GetConnection(backendAddr)
 	if err != nil {
+		rlog.Warn("从连接池获取节点 %s 的连接失败，耗时 %v: %v", backendAddr, time.Since(start), err)
 		return fmt.Errorf("连接后端Redis失败: %v", err)
 	}
-	defer proxy.pool.ReturnConnection(backendAddr, backendConn)
 
-	LogDebug("成功连接到后端节点 %s，发送命令: %v", backendAddr, command)
+	rlog.Debug("成功连接到后端节点 %s，耗时 %v，发送命令: %v", backendAddr, time.Since(start), command)
+
+	// 连接池中的连接由所有客户端共用，每次取出后按需设置客户端的名称
+	if err := proxy.applyClientName(session, backendConn); err != nil {
+		proxy.pool.DiscardConnection(backendAddr, backendConn)
+		return err
+	}
+	defer proxy.pool.ReturnConnection(backendAddr, backendConn)
 
 	// 发送命令到后端
 	err = proxy.sendCommandToBackend(backendConn, command)
@@ -185,31 +573,46 @@ func (proxy *RedisClusterProxy) executeCommandWithRedirect(clientConn net.Conn,
 		return fmt.Errorf("发送命令到后端失败: %v", err)
 	}
 
-	LogDebug("命令已发送到节点 %s，开始读取响应...", backendAddr)
+	rlog.Debug("命令已发送到节点 %s，开始读取响应...", backendAddr)
 
 	// 读取后端响应
 	response, err := proxy.readBackendResponse(backendConn)
 	if err != nil {
-		LogError("读取后端响应失败: %v", err)
+		rlog.Error("读取后端响应失败: %v", err)
 		return fmt.Errorf("读取后端响应失败: %v", err)
 	}
 	
 	// 添加调试日志，对于大响应只显示前面部分
 	if len(response) > 500 {
-		LogDebug("从节点 %s 收到大响应 (长度: %d): %q...", backendAddr, len(response), response[:500])
+		rlog.Debug("从节点 %s 收到大响应 (长度: %d): %q...", backendAddr, len(response), response[:500])
 	} else {
-		LogDebug("从节点 %s 收到完整响应: %q (长度: %d)", backendAddr, response, len(response))
+		rlog.Debug("从节点 %s 收到完整响应: %q (长度: %d)", backendAddr, response, len(response))
+	}
+
+	// 集群故障转移期间返回CLUSTERDOWN，请求刷新拓扑后退避重试。刷新由eventRefresher在后台完成，
+	// 重试时拓扑可能还没有更新，重试的响应同样处理MOVED/ASK
+	if strings.HasPrefix(response, "-CLUSTERDOWN") {
+		proxy.clusterManager.RequestRefresh("CLUSTERDOWN")
+		if clusterDownRetry < proxy.currentConfig().ClusterDownMaxRetries {
+			wait := clusterDownBackoff(clusterDownRetry, proxy.currentConfig().ClusterDownMaxRetryWait)
+			rlog.Warn("节点 %s 返回CLUSTERDOWN，%v后第%d次重试", backendAddr, wait, clusterDownRetry+1)
+			time.Sleep(wait)
+
+			// 故障转移完成后slot可能已由新的master负责
+			return proxy.executeCommandWithRedirect(session, command, proxy.selectBackendNode(command), redirectCount, clusterDownRetry+1)
+		}
 	}
 
 	// 检查是否是MOVED重定向
 	if isMoved, slot, redirectAddr := proxy.protocol.IsMovedError(response); isMoved {
-		LogInfo("收到MOVED重定向: slot=%s, 目标地址=%s", slot, redirectAddr)
+		rlog.Info("收到MOVED重定向: slot=%s, 目标地址=%s", slot, redirectAddr)
+		proxy.clusterManager.RequestRefresh("MOVED")
 		
 		// 选择是否自动重定向还是返回重定向响应给客户端
 		if proxy.shouldAutoRedirect(command) {
 			// 自动重定向到正确的节点
-			LogInfo("自动重定向到节点: %s", redirectAddr)
-			return proxy.executeCommandWithRedirect(clientConn, command, redirectAddr, redirectCount+1)
+			rlog.Info("自动重定向到节点: %s", redirectAddr)
+			return proxy.executeCommandWithRedirect(session, command, redirectAddr, redirectCount+1, clusterDownRetry)
 		} else {
 			// 直接返回重定向响应给客户端
 			_, err = clientConn.Write([]byte(response))
@@ -219,12 +622,18 @@ func (proxy *RedisClusterProxy) executeCommandWithRedirect(clientConn net.Conn,
 
 	// 检查是否是ASK重定向
 	if isAsk, slot, redirectAddr := proxy.protocol.IsAskError(response); isAsk {
-		LogInfo("收到ASK重定向: slot=%s, 目标地址=%s", slot, redirectAddr)
+		rlog.Info("收到ASK重定向: slot=%s, 目标地址=%s", slot, redirectAddr)
+		proxy.clusterManager.RequestRefresh("ASK")
 		
-		// ASK重定向通常需要先发送ASKING命令
-		if proxy.shouldAutoRedirect(command) {
-			LogInfo("自动处理ASK重定向到节点: %s", redirectAddr)
-			return proxy.handleAskRedirect(clientConn, command, redirectAddr, redirectCount+1)
+		// ASK重定向通常需要先发送ASKING命令。resharding期间ASK只对单次请求有效，
+		// 集群信息显示该slot正在迁移到目标节点时，即使关闭了自动重定向也由代理处理
+		migrating := false
+		if slotNum, err := strconv.Atoi(slot); err == nil {
+			migrating = proxy.clusterManager.IsSlotMigratingTo(slotNum, redirectAddr)
+		}
+		if proxy.shouldAutoRedirect(command) || migrating {
+			rlog.Info("自动处理ASK重定向到节点: %s", redirectAddr)
+			return proxy.handleAskRedirect(session, command, redirectAddr, redirectCount+1)
 		} else {
 			// 直接返回重定向响应给客户端
 			_, err = clientConn.Write([]byte(response))
@@ -232,11 +641,67 @@ func (proxy *RedisClusterProxy) executeCommandWithRedirect(clientConn net.Conn,
 		}
 	}
 
+	// 节点没有缓存脚本时，使用代理缓存的脚本内容改写为EVAL在同一节点重试
+	if strings.HasPrefix(response, "-NOSCRIPT") {
+		if evalCommand, ok := proxy.scripts.RewriteAsEval(command); ok {
+			rlog.Info("节点 %s 返回NOSCRIPT，改写为%s重试", backendAddr, evalCommand[0])
+			return proxy.executeCommandWithRedirect(session, evalCommand, backendAddr, redirectCount+1, clusterDownRetry)
+		}
+	}
+
 	// 普通响应，直接转发给客户端
 	_, err = clientConn.Write([]byte(response))
 	return err
 }
 
+// describeKeySlots 列出每个key及其slot，用于跨slot请求的日志
+func (proxy *RedisClusterProxy) describeKeySlots(keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s(slot %d)", key, CalculateSlot(key))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// clusterDownBackoff 计算CLUSTERDOWN第retry次重试前的等待时间：从50ms开始倍增，不超过maxWait
+func clusterDownBackoff(retry int, maxWait time.Duration) time.Duration {
+	wait := 50 * time.Millisecond
+	for i := 0; i < retry && wait < maxWait; i++ {
+		wait *= 2
+	}
+	if maxWait > 0 && wait > maxWait {
+		wait = maxWait
+	}
+	return wait
+}
+
+// executeOnNode 在指定节点上执行命令并返回原始响应，不处理重定向
+func (proxy *RedisClusterProxy) executeOnNode(nodeAddr string, command []string) (string, error) {
+	return proxy.executeOnNodeWithin(nodeAddr, command, 60*time.Second)
+}
+
+// executeOnNodeWithin 在指定节点上执行命令，timeout为命令发送和读取响应的超时时间
+func (proxy *RedisClusterProxy) executeOnNodeWithin(nodeAddr string, command []string, timeout time.Duration) (string, error) {
+	backendConn, err := proxy.pool.GetConnection(nodeAddr)
+	if err != nil {
+		return "", fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+
+	if err := proxy.sendCommandToBackend(backendConn, command); err != nil {
+		proxy.pool.DiscardConnection(nodeAddr, backendConn)
+		return "", fmt.Errorf("发送命令到后端失败: %v", err)
+	}
+
+	response, err := proxy.readBackendResponseWithin(backendConn, timeout)
+	if err != nil {
+		proxy.pool.DiscardConnection(nodeAddr, backendConn)
+		return "", fmt.Errorf("读取后端响应失败: %v", err)
+	}
+
+	proxy.pool.ReturnConnection(nodeAddr, backendConn)
+	return response, nil
+}
+
 // selectBackendNode 选择后端节点
 func (proxy *RedisClusterProxy) selectBackendNode(command []string) string {
 	if len(command) == 0 {
@@ -244,99 +709,66 @@ func (proxy *RedisClusterProxy) selectBackendNode(command []string) string {
 	}
 
 	cmdName := strings.ToUpper(command[0])
-	
-	// 根据命令类型选择节点
-	switch cmdName {
-	// 字符串操作命令
-	case "GET", "SET", "GETSET", "SETNX", "SETEX", "PSETEX", "MGET", "MSET", "MSETNX",
-		 "INCR", "DECR", "INCRBY", "DECRBY", "INCRBYFLOAT", "APPEND", "STRLEN",
-		 "GETRANGE", "SETRANGE", "GETBIT", "SETBIT", "BITCOUNT", "BITOP":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 哈希操作命令
-	case "HGET", "HSET", "HSETNX", "HMGET", "HMSET", "HGETALL", "HKEYS", "HVALS",
-		 "HLEN", "HEXISTS", "HDEL", "HINCRBY", "HINCRBYFLOAT", "HSCAN":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 列表操作命令
-	case "LPUSH", "RPUSH", "LPOP", "RPOP", "LLEN", "LRANGE", "LTRIM", "LINDEX",
-		 "LSET", "LREM", "LINSERT", "BLPOP", "BRPOP", "BRPOPLPUSH", "RPOPLPUSH":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 集合操作命令
-	case "SADD", "SREM", "SMEMBERS", "SCARD", "SISMEMBER", "SRANDMEMBER", "SPOP",
-		 "SMOVE", "SINTER", "SINTERSTORE", "SUNION", "SUNIONSTORE", "SDIFF", "SDIFFSTORE", "SSCAN":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 有序集合操作命令
-	case "ZADD", "ZREM", "ZSCORE", "ZINCRBY", "ZCARD", "ZCOUNT", "ZRANGE", "ZREVRANGE",
-		 "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANK", "ZREVRANK", "ZREMRANGEBYRANK",
-		 "ZREMRANGEBYSCORE", "ZUNIONSTORE", "ZINTERSTORE", "ZSCAN":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 通用key操作命令
-	case "DEL", "EXISTS", "EXPIRE", "EXPIREAT", "TTL", "PTTL", "PERSIST", "TYPE",
-		 "RENAME", "RENAMENX", "MOVE", "DUMP", "RESTORE", "SORT", "TOUCH":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// HyperLogLog命令
-	case "PFADD", "PFCOUNT", "PFMERGE":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 位图操作命令
-	case "BITFIELD":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 流操作命令
-	case "XADD", "XREAD", "XREADGROUP", "XPENDING", "XCLAIM", "XACK", "XGROUP",
-		 "XINFO", "XLEN", "XRANGE", "XREVRANGE", "XTRIM", "XDEL":
-		return proxy.selectNodeByKey(cmdName, command)
-		
-	// 集群管理和信息命令
-	case "CLUSTER", "INFO", "PING", "TIME", "COMMAND", "CONFIG", "CLIENT",
-		 "MEMORY", "LATENCY", "SLOWLOG", "MONITOR", "DEBUG", "SHUTDOWN":
-		// 这些命令可以发送到任意节点
-		LogDebug("集群管理命令 %s 路由到随机节点", cmdName)
-		return proxy.clusterManager.GetRandomNode()
-		
-	// 事务命令
-	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
-		// 事务命令需要在同一个连接上执行，这里简化处理
-		LogDebug("事务命令 %s 路由到随机节点", cmdName)
-		return proxy.clusterManager.GetRandomNode()
-		
-	// 发布订阅命令
-	case "PUBLISH", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PUBSUB":
-		LogDebug("发布订阅命令 %s 路由到随机节点", cmdName)
-		return proxy.clusterManager.GetRandomNode()
-		
-	// 脚本命令
-	case "EVAL", "EVALSHA", "SCRIPT":
-		// 脚本命令可能涉及多个key，这里简化处理
-		LogDebug("脚本命令 %s 路由到随机节点", cmdName)
-		return proxy.clusterManager.GetRandomNode()
-		
-	default:
+
+	// 根据命令表选择节点
+	spec := lookupCommand(cmdName)
+	if spec == nil {
+		// 未知命令（模块命令或新命令），尝试通过COMMAND GETKEYS获取key
+		if key, ok := proxy.commandKeys.firstKey(proxy, command); ok {
+			return proxy.selectNodeByKey(cmdName, key)
+		}
 		// 其他命令，发送到随机节点
 		LogWarn("未知命令 %s，路由到随机节点", cmdName)
 		return proxy.clusterManager.GetRandomNode()
 	}
+
+	keys := spec.extractKeys(command)
+	if len(keys) > 0 {
+		return proxy.selectNodeByKey(cmdName, keys[0])
+	}
+
+	// 不包含key的命令可以发送到任意节点
+	LogDebug("命令 %s 不包含key，路由到随机节点", cmdName)
+	return proxy.clusterManager.GetRandomNode()
+}
+
+// isClusterSlotCommand 判断是否为以slot为参数、需要发送到slot所在节点的CLUSTER子命令
+func isClusterSlotCommand(command []string) bool {
+	if len(command) < 3 || !strings.EqualFold(command[0], "CLUSTER") {
+		return false
+	}
+	switch strings.ToUpper(command[1]) {
+	case "COUNTKEYSINSLOT", "GETKEYSINSLOT":
+		return true
+	}
+	return false
+}
+
+// selectSlotOwner 解析slot参数并返回负责该slot的节点，slot未分配时返回错误
+func (proxy *RedisClusterProxy) selectSlotOwner(slotArg string) (string, error) {
+	slot, err := strconv.Atoi(slotArg)
+	if err != nil || slot < 0 || slot >= 16384 {
+		return "", fmt.Errorf("Invalid slot")
+	}
+	nodeAddr := proxy.clusterManager.GetNodeForSlot(slot)
+	if nodeAddr == "" {
+		return "", fmt.Errorf("slot %d 没有分配给任何节点", slot)
+	}
+	LogDebug("slot %d 的CLUSTER命令路由到节点: %s", slot, nodeAddr)
+	return nodeAddr, nil
 }
 
 // selectNodeByKey 根据key选择节点
-func (proxy *RedisClusterProxy) selectNodeByKey(cmdName string, command []string) string {
-	if len(command) > 1 {
-		key := command[1]
-		nodeAddr := proxy.clusterManager.GetNodeForKey(key)
-		if nodeAddr != "" {
-			LogDebug("命令 %s key=%s 路由到节点: %s", cmdName, key, nodeAddr)
-			return nodeAddr
-		}
+func (proxy *RedisClusterProxy) selectNodeByKey(cmdName string, key string) string {
+	nodeAddr := proxy.clusterManager.GetNodeForKey(key)
+	if nodeAddr != "" {
+		LogDebug("命令 %s key=%s 路由到节点: %s", cmdName, key, nodeAddr)
+		return nodeAddr
 	}
 	
 	// 如果没有找到合适的节点，使用配置中的第一个节点
-	if len(proxy.config.RedisNodes) > 0 {
-		return proxy.config.RedisNodes[0]
+	if len(proxy.currentConfig().RedisNodes) > 0 {
+		return proxy.currentConfig().RedisNodes[0]
 	}
 	
 	return ""
@@ -344,25 +776,27 @@ func (proxy *RedisClusterProxy) selectNodeByKey(cmdName string, command []string
 
 // sendCommandToBackend 发送命令到后端Redis
 func (proxy *RedisClusterProxy) sendCommandToBackend(conn net.Conn, command []string) error {
-	// 构建Redis协议格式的命令
-	var cmdBuilder strings.Builder
-	cmdBuilder.WriteString(fmt.Sprintf("*%d\r\n", len(command)))
-	
-	for _, arg := range command {
-		cmdBuilder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
-	}
-
-	_, err := conn.Write([]byte(cmdBuilder.String()))
+	_, err := conn.Write([]byte(proxy.protocol.FormatCommand(proxy.renameForBackend(command))))
 	return err
 }
 
 // readBackendResponse 读取后端响应
 func (proxy *RedisClusterProxy) readBackendResponse(conn net.Conn) (string, error) {
 	// 设置读取超时，对于COMMAND命令需要更长的超时时间
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	return proxy.readBackendResponseWithin(conn, 60*time.Second)
+}
+
+// readBackendResponseWithin 在timeout内读取后端响应
+func (proxy *RedisClusterProxy) readBackendResponseWithin(conn net.Conn, timeout time.Duration) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
 	defer conn.SetReadDeadline(time.Time{})
 
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReaderSize(conn, proxy.currentConfig().GetReadBufferSize())
+	return proxy.readResponse(reader)
+}
+
+// readResponse 从reader中读取一个完整的响应，用于需要复用reader的长连接
+func (proxy *RedisClusterProxy) readResponse(reader *bufio.Reader) (string, error) {
 	var response strings.Builder
 
 	// 读取第一行
@@ -519,7 +953,7 @@ func (proxy *RedisClusterProxy) readArrayResponse(reader *bufio.Reader, firstLin
 // shouldAutoRedirect 判断是否应该自动重定向
 func (proxy *RedisClusterProxy) shouldAutoRedirect(command []string) bool {
 	// 使用配置中的AutoRedirect选项
-	if !proxy.config.AutoRedirect {
+	if !proxy.currentConfig().AutoRedirect {
 		return false
 	}
 	
@@ -536,12 +970,19 @@ func (proxy *RedisClusterProxy) shouldAutoRedirect(command []string) bool {
 }
 
 // handleAskRedirect 处理ASK重定向
-func (proxy *RedisClusterProxy) handleAskRedirect(clientConn net.Conn, command []string, redirectAddr string, redirectCount int) error {
+func (proxy *RedisClusterProxy) handleAskRedirect(session *clientSession, command []string, redirectAddr string, redirectCount int) error {
+	clientConn := session.conn
+
 	// 获取后端连接
 	backendConn, err := proxy.pool.GetConnection(redirectAddr)
 	if err != nil {
 		return fmt.Errorf("连接重定向节点失败: %v", err)
 	}
+
+	if err := proxy.applyClientName(session, backendConn); err != nil {
+		proxy.pool.DiscardConnection(redirectAddr, backendConn)
+		return err
+	}
 	defer proxy.pool.ReturnConnection(redirectAddr, backendConn)
 
 	// 发送ASKING命令
@@ -577,6 +1018,12 @@ func (proxy *RedisClusterProxy) handleAskRedirect(clientConn net.Conn, command [
 	return err
 }
 
+// writeClient 向客户端写入响应
+func (proxy *RedisClusterProxy) writeClient(session *clientSession, response string) error {
+	_, err := session.conn.Write([]byte(response))
+	return err
+}
+
 // sendError 发送错误响应
 func (proxy *RedisClusterProxy) sendError(conn net.Conn, message string) {
 	errorResponse := proxy.protocol.FormatError(message)
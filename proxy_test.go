@@ -0,0 +1,885 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEvalRouting 脚本按numkeys之后的key路由到slot所在的节点，key不在同一个slot时拒绝
+func TestEvalRouting(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	// 带相同hash tag的两个key位于同一个slot
+	script := "redis.call('SET', KEYS[1], ARGV[1]); return redis.call('SET', KEYS[2], ARGV[2])"
+	client.expectReply("+OK\r\n", "EVAL", script, "2", "{user1000}.a", "{user1000}.b", "1", "2")
+	node := tc.nodeFor("{user1000}.a")
+	if got, _ := node.Get("{user1000}.a"); got != "1" {
+		t.Errorf("{user1000}.a 应写入slot所在的节点，实际为 %q", got)
+	}
+	if got, _ := node.Get("{user1000}.b"); got != "2" {
+		t.Errorf("{user1000}.b 应写入slot所在的节点，实际为 %q", got)
+	}
+
+	// foo和bar位于不同的slot，脚本不会被执行
+	client.expectErrorPrefix("CROSSSLOT", "EVAL", script, "2", "foo", "bar", "1", "2")
+	for _, node := range tc.nodes {
+		if node.Exists("foo") || node.Exists("bar") {
+			t.Errorf("跨slot的脚本不应在节点 %s 上执行", node.Addr())
+		}
+	}
+
+	// 只读脚本同样按key路由
+	key := tc.keyOn(2, "ro")
+	tc.nodes[2].Set(key, "value")
+	client.expectReply(bulk("value"), "EVAL_RO", "return redis.call('GET', KEYS[1])", "1", key)
+
+	// EVALSHA和EVALSHA_RO按相同的规则路由
+	sha := parseTestResponse(t, client.do("SCRIPT", "LOAD", "return redis.call('GET', KEYS[1])")).Str
+	client.expectReply(bulk("value"), "EVALSHA", sha, "1", key)
+	client.expectReply(bulk("value"), "EVALSHA_RO", sha, "1", key)
+	client.expectErrorPrefix("CROSSSLOT", "EVALSHA", sha, "2", "foo", "bar")
+
+	// numkeys为0的脚本发送到任意master节点
+	client.expectReply(":1\r\n", "EVAL", "return 1", "0")
+
+	// numkeys超过参数个数
+	if got := client.do("EVAL", script, "3", "a"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("numkeys超过参数个数应返回错误，实际为 %q", got)
+	}
+}
+
+// TestNumKeysCrossSlot ZUNIONSTORE等命令的目标key和源key位于同一个slot时在slot所在的节点执行，否则返回CROSSSLOT
+func TestNumKeysCrossSlot(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	node := tc.nodeFor("{z}.a")
+	node.ZAdd("{z}.a", 1, "x")
+	node.ZAdd("{z}.b", 2, "y")
+
+	client.expectReply(":2\r\n", "ZUNIONSTORE", "{z}.dst", "2", "{z}.a", "{z}.b")
+	if members, _ := node.ZMembers("{z}.dst"); len(members) != 2 {
+		t.Errorf("{z}.dst 应在slot所在的节点上包含2个成员，实际为 %v", members)
+	}
+	client.expectReply(":1\r\n", "ZINTERSTORE", "{z}.inter", "1", "{z}.a")
+	client.expectReply("*2\r\n$1\r\nx\r\n$1\r\ny\r\n", "ZUNION", "2", "{z}.a", "{z}.b")
+
+	for _, command := range [][]string{
+		{"ZUNIONSTORE", "dst", "2", "foo", "bar"},
+		// 源key在同一个slot，目标key不在
+		{"ZINTERSTORE", "foo", "2", "{z}.a", "{z}.b"},
+		{"ZDIFFSTORE", "{z}.dst", "2", "{z}.a", "foo"},
+		{"ZUNION", "2", "foo", "bar"},
+		{"ZINTER", "2", "foo", "bar"},
+		{"ZDIFF", "2", "foo", "bar"},
+		{"SINTERCARD", "2", "foo", "bar"},
+	} {
+		client.expectErrorPrefix("CROSSSLOT", command...)
+	}
+	if node := tc.nodeFor("foo"); node.Exists("foo") {
+		t.Error("跨slot的命令不应被执行")
+	}
+}
+
+// TestGeoStoreRouting GEO命令的STORE目标key与源key在同一个slot时在slot所在的节点执行，否则返回CROSSSLOT
+func TestGeoStoreRouting(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	client.expectReply(":2\r\n", "GEOADD", "{g}.src", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+
+	client.expectReply(":2\r\n", "GEORADIUS", "{g}.src", "15", "37", "200", "km", "STORE", "{g}.dst")
+	if members, _ := tc.nodeFor("{g}.src").ZMembers("{g}.dst"); len(members) != 2 {
+		t.Errorf("STORE的目标key应写入源key所在的节点，实际为 %v", members)
+	}
+
+	for _, command := range [][]string{
+		{"GEORADIUS", "{g}.src", "15", "37", "200", "km", "STORE", "dst"},
+		{"GEORADIUS", "{g}.src", "15", "37", "200", "km", "STOREDIST", "dst"},
+		{"GEORADIUSBYMEMBER", "{g}.src", "Palermo", "200", "km", "STORE", "dst"},
+		{"GEOSEARCHSTORE", "dst", "{g}.src", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km"},
+	} {
+		client.expectErrorPrefix("CROSSSLOT", command...)
+	}
+	if tc.nodeFor("dst").Exists("dst") {
+		t.Error("跨slot的命令不应被执行")
+	}
+}
+
+// TestSortRouting SORT按源key路由，STORE目标key不在同一个slot或BY/GET模式没有相同的hash tag时由代理拒绝。
+// miniredis不支持SORT，使用假节点
+func TestSortRouting(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string {
+		if len(command) > 2 && strings.EqualFold(command[len(command)-2], "STORE") {
+			return ":3\r\n"
+		}
+		return "*1\r\n" + bulk("1")
+	}, nil)
+	client := fc.client(t)
+
+	client.expectReply("*1\r\n"+bulk("1"), "SORT", "{u}.list")
+	client.expectReply(":3\r\n", "SORT", "{u}.list", "BY", "{u}.weight_*", "GET", "{u}.obj_*", "STORE", "{u}.sorted")
+	client.expectReply("*1\r\n"+bulk("1"), "SORT_RO", "{u}.list", "GET", "#")
+	owner := fc.nodeFor("{u}.list")
+	for _, node := range fc.nodes {
+		want := 0
+		if node == owner {
+			want = 2
+		}
+		if got := len(node.received("SORT")); got != want {
+			t.Errorf("节点 %s 收到 %d 次SORT，应为 %d", node.addr, got, want)
+		}
+	}
+
+	client.expectErrorPrefix("CROSSSLOT", "SORT", "{u}.list", "STORE", "dst")
+	for _, command := range [][]string{
+		{"SORT", "{u}.list", "BY", "weight_*"},
+		{"SORT", "{u}.list", "GET", "{v}.obj_*"},
+		{"SORT_RO", "{u}.list", "GET", "obj_*->field"},
+	} {
+		if got := client.do(command...); !strings.HasPrefix(got, "-ERR 集群模式下SORT") {
+			t.Errorf("%v: 应返回模式错误，实际为 %q", command, got)
+		}
+	}
+	if got := len(owner.received("SORT")) + len(owner.received("SORT_RO")); got != 3 {
+		t.Errorf("被拒绝的命令不应发送到后端，共收到 %d 次", got)
+	}
+}
+
+// TestSetRouting SET带有任意选项时都按key路由，选项原样传递到后端
+func TestSetRouting(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	// value所在的slot与key不同
+	key, value := tc.keyOn(0, "key"), tc.keyOn(1, "value")
+	client.expectReply("+OK\r\n", "SET", key, value, "EX", "100", "NX")
+	node := tc.nodeFor(key)
+	if got, _ := node.Get(key); got != value {
+		t.Errorf("SET应写入key所在的节点，实际为 %q", got)
+	}
+	if ttl := node.TTL(key); ttl.Seconds() != 100 {
+		t.Errorf("EX选项应传递到后端，TTL为 %v", ttl)
+	}
+
+	client.expectReply(bulk(value), "SET", key, "new", "XX", "GET", "KEEPTTL")
+	if ttl := node.TTL(key); ttl.Seconds() != 100 {
+		t.Errorf("KEEPTTL应保留TTL，实际为 %v", ttl)
+	}
+	// key已存在，NX不写入，GET返回当前值
+	client.expectReply(bulk("new"), "SET", key, "other", "NX", "GET")
+	client.expectErrorPrefix("ERR syntax error", "SET", key, "v", "NX", "XX")
+}
+
+// TestKeyedAdminRouting OBJECT、MEMORY USAGE和DEBUG OBJECT按command[2]的key路由，没有key的子命令发送到任意节点
+func TestKeyedAdminRouting(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string {
+		return "+OK\r\n"
+	}, func(config *Config) {
+		config.AllowedDangerousCommands = []string{"DEBUG"}
+	})
+	client := fc.client(t)
+
+	// 选择一个与所有子命令名都不在同一个节点的key，按子命令路由会选错节点
+	subcommands := []string{"ENCODING", "REFCOUNT", "IDLETIME", "FREQ", "USAGE", "OBJECT"}
+	var key string
+	var owner *fakeNode
+	for i := 0; owner == nil; i++ {
+		key, owner = fmt.Sprintf("profile:%d", i), fc.nodeFor(fmt.Sprintf("profile:%d", i))
+		for _, name := range subcommands {
+			if fc.nodeFor(name) == owner {
+				owner = nil
+				break
+			}
+		}
+	}
+	for _, command := range [][]string{
+		{"OBJECT", "ENCODING", key},
+		{"OBJECT", "REFCOUNT", key},
+		{"OBJECT", "IDLETIME", key},
+		{"OBJECT", "FREQ", key},
+		{"MEMORY", "USAGE", key},
+		{"MEMORY", "USAGE", key, "SAMPLES", "0"},
+		{"DEBUG", "OBJECT", key},
+	} {
+		client.expectReply("+OK\r\n", command...)
+		for _, node := range fc.nodes {
+			received := node.received(command[0])
+			sent := len(received) > 0 && reflect.DeepEqual(received[len(received)-1], command)
+			if sent != (node == owner) {
+				t.Errorf("%v: 节点 %s 收到命令为 %v，应只发送到key所在的节点 %s", command, node.addr, sent, owner.addr)
+			}
+		}
+	}
+
+	for _, command := range [][]string{
+		{"MEMORY", "STATS"},
+		{"MEMORY", "DOCTOR"},
+		{"OBJECT", "HELP"},
+	} {
+		client.expectReply("+OK\r\n", command...)
+		count := 0
+		for _, node := range fc.nodes {
+			for _, received := range node.received(command[0]) {
+				if reflect.DeepEqual(received, command) {
+					count++
+				}
+			}
+		}
+		if count != 1 {
+			t.Errorf("%v: 应发送到一个节点，实际发送 %d 次", command, count)
+		}
+	}
+}
+
+// TestNewerCommandRouting GETDEL、GETEX、COPY、LMPOP等较新的命令按key路由到slot所在的节点，
+// 需要在同一个slot的多key命令跨slot时返回CROSSSLOT
+func TestNewerCommandRouting(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string {
+		return "+OK\r\n"
+	}, nil)
+	client := fc.client(t)
+	owner := fc.nodeFor("{n}.a")
+
+	for _, command := range [][]string{
+		{"GETDEL", "{n}.a"},
+		{"GETEX", "{n}.a", "EX", "10"},
+		{"SMISMEMBER", "{n}.a", "m1", "m2"},
+		{"COPY", "{n}.a", "{n}.b", "REPLACE"},
+		{"LMOVE", "{n}.a", "{n}.b", "LEFT", "RIGHT"},
+		{"BLMOVE", "{n}.a", "{n}.b", "LEFT", "RIGHT", "1"},
+		{"ZRANGESTORE", "{n}.b", "{n}.a", "0", "-1"},
+		{"SINTERCARD", "2", "{n}.a", "{n}.b", "LIMIT", "1"},
+		{"LMPOP", "2", "{n}.a", "{n}.b", "LEFT", "COUNT", "2"},
+		{"ZMPOP", "2", "{n}.a", "{n}.b", "MIN"},
+		{"BLMPOP", "1", "2", "{n}.a", "{n}.b", "RIGHT"},
+		{"BZMPOP", "1", "2", "{n}.a", "{n}.b", "MAX", "COUNT", "1"},
+	} {
+		client.expectReply("+OK\r\n", command...)
+		for _, node := range fc.nodes {
+			received := node.received(command[0])
+			sent := len(received) > 0 && reflect.DeepEqual(received[len(received)-1], command)
+			if sent != (node == owner) {
+				t.Errorf("%v: 节点 %s 收到命令为 %v，应只发送到key所在的节点 %s", command, node.addr, sent, owner.addr)
+			}
+		}
+	}
+
+	// 跨slot的COPY改为DUMP/RESTORE，LMPOP/ZMPOP按slot依次尝试，见multikey.go
+	for _, command := range [][]string{
+		{"LMOVE", "foo", "bar", "LEFT", "RIGHT"},
+		{"BLMOVE", "foo", "bar", "LEFT", "RIGHT", "1"},
+		{"ZRANGESTORE", "foo", "bar", "0", "-1"},
+		{"SINTERCARD", "2", "foo", "bar"},
+		{"BLMPOP", "1", "2", "foo", "bar", "LEFT"},
+		{"BZMPOP", "1", "2", "foo", "bar", "MIN"},
+	} {
+		before := len(owner.received(command[0]))
+		client.expectErrorPrefix("CROSSSLOT", command...)
+		after := 0
+		for _, node := range fc.nodes {
+			after += len(node.received(command[0]))
+		}
+		if after != before {
+			t.Errorf("%v: 跨slot的命令不应发送到后端", command)
+		}
+	}
+}
+
+// TestTwoKeyCrossSlot RENAME、SMOVE等两个key的命令：带相同hash tag时在slot所在的节点执行，
+// 否则返回列出两个key及其slot的CROSSSLOT错误
+func TestTwoKeyCrossSlot(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	node := tc.nodeFor("{k}.src")
+
+	node.Set("{k}.src", "v")
+	client.expectReply("+OK\r\n", "RENAME", "{k}.src", "{k}.dst")
+	if got, _ := node.Get("{k}.dst"); got != "v" || node.Exists("{k}.src") {
+		t.Errorf("RENAME应在key所在的节点执行，{k}.dst为 %q", got)
+	}
+	client.expectReply(":0\r\n", "RENAMENX", "{k}.dst", "{k}.dst")
+	node.SAdd("{k}.set", "m")
+	client.expectReply(":1\r\n", "SMOVE", "{k}.set", "{k}.other", "m")
+	node.Lpush("{k}.list", "x")
+	client.expectReply(bulk("x"), "RPOPLPUSH", "{k}.list", "{k}.list2")
+	client.expectReply(bulk("x"), "BRPOPLPUSH", "{k}.list2", "{k}.list", "1")
+	if list, _ := node.List("{k}.list"); len(list) != 1 {
+		t.Errorf("BRPOPLPUSH应在key所在的节点执行，{k}.list为 %v", list)
+	}
+
+	src, dst := tc.keyOn(0, "src"), tc.keyOn(1, "dst")
+	want := fmt.Sprintf("-CROSSSLOT Keys in request don't hash to the same slot (key '%s' slot %d, key '%s' slot %d)\r\n",
+		src, CalculateSlot(src), dst, CalculateSlot(dst))
+	tc.nodes[0].Set(src, "v")
+	for _, command := range [][]string{
+		{"RENAME", src, dst},
+		{"RENAMENX", src, dst},
+		{"SMOVE", src, dst, "m"},
+		{"RPOPLPUSH", src, dst},
+		{"BRPOPLPUSH", src, dst, "1"},
+	} {
+		client.expectReply(want, command...)
+	}
+	client.expectReply(fmt.Sprintf("-CROSSSLOT Keys in request don't hash to the same slot (key '%s' slot %d, key '%s' slot %d)\r\n",
+		dst, CalculateSlot(dst), src, CalculateSlot(src)),
+		"GEOSEARCHSTORE", dst, src, "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km")
+	if !tc.nodes[0].Exists(src) {
+		t.Error("跨slot的RENAME不应执行")
+	}
+}
+
+// TestCrossSlotPreflight 各类型的多key命令在转发之前检查所有key的slot，跨slot时返回CROSSSLOT且不访问后端；
+// 支持拆分执行的MGET/MSET/DEL不受影响（哈希命令都只有一个key）
+func TestCrossSlotPreflight(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "MGET":
+			reply := fmt.Sprintf("*%d\r\n", len(command)-1)
+			for range command[1:] {
+				reply += "$-1\r\n"
+			}
+			return reply
+		case "DEL":
+			return fmt.Sprintf(":%d\r\n", len(command)-1)
+		}
+		return "+OK\r\n"
+	}, nil)
+	client := fc.client(t)
+	a, b := "foo", "bar"
+	if fc.nodeFor(a) == fc.nodeFor(b) {
+		t.Fatal("foo和bar应位于不同的节点")
+	}
+
+	commands := [][]string{
+		// 字符串
+		{"LCS", a, b},
+		// 通用key命令，可以作用于hash等任意类型
+		{"RENAME", a, b},
+		{"RENAMENX", a, b},
+		// 列表和集合
+		{"LMOVE", a, b, "LEFT", "LEFT"},
+		{"SINTER", a, b},
+		{"SUNIONSTORE", a, b},
+		{"SDIFF", a, b},
+		// 有序集合
+		{"ZUNIONSTORE", a, "1", b},
+		{"ZINTER", "2", a, b},
+		{"ZRANGESTORE", a, b, "0", "-1"},
+		// stream
+		{"XREADGROUP", "GROUP", "g", "c", "STREAMS", a, b, ">", ">"},
+		{"XREAD", "BLOCK", "0", "STREAMS", a, b, "0", "0"},
+		// HyperLogLog
+		{"PFMERGE", a, b},
+		// 脚本
+		{"EVAL", "return 1", "2", a, b},
+	}
+	for _, command := range commands {
+		client.expectErrorPrefix("CROSSSLOT", command...)
+	}
+	for _, node := range fc.nodes {
+		for _, command := range commands {
+			if received := node.received(command[0]); len(received) > 0 {
+				t.Errorf("%s: 跨slot的命令不应发送到节点 %s", command[0], node.addr)
+			}
+		}
+	}
+
+	// 拆分执行的命令分别发送到两个key所在的节点
+	client.expectReply("*2\r\n$-1\r\n$-1\r\n", "MGET", a, b)
+	client.expectReply("+OK\r\n", "MSET", a, "1", b, "2")
+	client.expectReply(":2\r\n", "DEL", a, b)
+	for _, name := range []string{"MGET", "MSET", "DEL"} {
+		if len(fc.nodeFor(a).received(name)) != 1 || len(fc.nodeFor(b).received(name)) != 1 {
+			t.Errorf("%s 应拆分到两个key所在的节点", name)
+		}
+	}
+}
+
+// TestGeoRouting GEO命令按command[1]的key路由到slot所在的节点（miniredis不支持GEOHASH）
+func TestGeoRouting(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	key := tc.keyOn(2, "geo")
+
+	client.expectReply(":2\r\n", "GEOADD", key, "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	if !tc.nodes[2].Exists(key) {
+		t.Fatal("GEOADD应写入key所在的节点")
+	}
+	client.expectReply(bulk("166.2742"), "GEODIST", key, "Palermo", "Catania", "km")
+	if got := client.doValue("GEOPOS", key, "Palermo"); len(got.Array) != 1 || got.Array[0].IsNil {
+		t.Errorf("GEOPOS应返回坐标，实际为 %v", got)
+	}
+	for _, command := range [][]string{
+		{"GEORADIUS", key, "15", "37", "200", "km"},
+		{"GEORADIUS_RO", key, "15", "37", "200", "km"},
+		{"GEORADIUSBYMEMBER", key, "Palermo", "200", "km"},
+		{"GEORADIUSBYMEMBER_RO", key, "Palermo", "200", "km"},
+		{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"},
+	} {
+		if got := client.doValue(command...); len(got.Array) != 2 {
+			t.Errorf("%v: 应返回2个成员，实际为 %v", command, got)
+		}
+	}
+}
+
+// TestClusterSlotCommands CLUSTER COUNTKEYSINSLOT/GETKEYSINSLOT发送到负责该slot的节点，slot未分配时返回错误
+func TestClusterSlotCommands(t *testing.T) {
+	handler := func(command []string) string { return ":7\r\n" }
+	low, high := startFakeNode(t, handler), startFakeNode(t, handler)
+	// 16001-16383未分配
+	topology := clusterNodesLine(1, low.addr, "master", "0-8000") + "\n" + clusterNodesLine(2, high.addr, "master", "8001-16000")
+	proxy, addr := startTestProxy(t, []string{low.addr, high.addr}, topology, nil)
+	client := dialProxy(t, proxy, addr)
+
+	tests := []struct {
+		command []string
+		owner   *fakeNode
+	}{
+		{[]string{"CLUSTER", "COUNTKEYSINSLOT", "100"}, low},
+		{[]string{"CLUSTER", "COUNTKEYSINSLOT", "8001"}, high},
+		{[]string{"cluster", "getkeysinslot", "8000", "10"}, low},
+		{[]string{"CLUSTER", "GETKEYSINSLOT", "16000", "10"}, high},
+	}
+	for _, tt := range tests {
+		client.expectReply(":7\r\n", tt.command...)
+		for _, node := range []*fakeNode{low, high} {
+			received := node.received(tt.command[0])
+			sent := len(received) > 0 && reflect.DeepEqual(received[len(received)-1], tt.command)
+			if sent != (node == tt.owner) {
+				t.Errorf("%v: 节点 %s 收到命令为 %v，应只发送到负责该slot的节点 %s", tt.command, node.addr, sent, tt.owner.addr)
+			}
+		}
+	}
+
+	client.expectErrorPrefix("ERR slot 16001 没有分配给任何节点", "CLUSTER", "COUNTKEYSINSLOT", "16001")
+	client.expectErrorPrefix("ERR slot 16383 没有分配给任何节点", "CLUSTER", "GETKEYSINSLOT", "16383", "10")
+	client.expectErrorPrefix("ERR Invalid slot", "CLUSTER", "COUNTKEYSINSLOT", "16384")
+	client.expectErrorPrefix("ERR Invalid slot", "CLUSTER", "COUNTKEYSINSLOT", "abc")
+	// 代理启动时发送的CLUSTER NODES不计算在内
+	sent := 0
+	for _, node := range []*fakeNode{low, high} {
+		for _, command := range node.received("CLUSTER") {
+			if isClusterSlotCommand(command) {
+				sent++
+			}
+		}
+	}
+	if sent != len(tests) {
+		t.Errorf("无效或未分配的slot不应发送到后端，共发送 %d 次", sent)
+	}
+}
+
+// TestStoreKeyCrossSlot 带目标key的命令在源key和目标key不在同一个slot时返回CROSSSLOT，在同一个slot时按源key路由
+func TestStoreKeyCrossSlot(t *testing.T) {
+	fc := newFakeCluster(t, 3, func(command []string) string { return ":1\r\n" }, nil)
+	client := fc.client(t)
+
+	tests := []struct {
+		name    string
+		command []string
+		cross   bool
+	}{
+		{"SORT STORE不同slot", []string{"SORT", "foo", "STORE", "bar"}, true},
+		{"SORT store小写", []string{"sort", "foo", "LIMIT", "0", "10", "store", "bar"}, true},
+		{"SORT STORE同一个slot", []string{"SORT", "{foo}.src", "ALPHA", "STORE", "{foo}.dst"}, false},
+		{"SORT没有STORE", []string{"SORT", "foo", "DESC", "LIMIT", "0", "5"}, false},
+		{"SORT源key名为store", []string{"SORT", "store", "ALPHA"}, false},
+		{"SORT LIMIT参数不是STORE", []string{"SORT", "foo", "LIMIT", "0", "1", "ALPHA"}, false},
+		{"GEOSEARCHSTORE不同slot", []string{"GEOSEARCHSTORE", "bar", "foo", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"}, true},
+		{"GEOSEARCHSTORE同一个slot", []string{"GEOSEARCHSTORE", "{foo}.dst", "{foo}.src", "FROMMEMBER", "m", "BYBOX", "1", "1", "km"}, false},
+		{"GEORADIUS STORE不同slot", []string{"GEORADIUS", "foo", "0", "0", "1", "km", "STORE", "bar"}, true},
+		{"GEORADIUS STOREDIST不同slot", []string{"GEORADIUS", "foo", "0", "0", "1", "km", "storedist", "bar"}, true},
+		{"GEORADIUS STORE同一个slot", []string{"GEORADIUS", "{foo}.src", "0", "0", "1", "km", "STORE", "{foo}.dst"}, false},
+		{"GEORADIUSBYMEMBER STORE不同slot", []string{"GEORADIUSBYMEMBER", "foo", "m", "1", "km", "STORE", "bar"}, true},
+		{"GEORADIUSBYMEMBER STORE同一个slot", []string{"GEORADIUSBYMEMBER", "{foo}.src", "m", "1", "km", "STORE", "{foo}.dst"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := strings.ToUpper(tt.command[0])
+			before := make(map[*fakeNode]int)
+			for _, node := range fc.nodes {
+				before[node] = len(node.received(name))
+			}
+
+			if tt.cross {
+				client.expectErrorPrefix("CROSSSLOT", tt.command...)
+			} else {
+				client.expectReply(":1\r\n", tt.command...)
+			}
+
+			owner := fc.nodeFor(tt.command[1])
+			if name == "GEOSEARCHSTORE" {
+				owner = fc.nodeFor(tt.command[2])
+			}
+			for _, node := range fc.nodes {
+				want := 0
+				if !tt.cross && node == owner {
+					want = 1
+				}
+				if got := len(node.received(name)) - before[node]; got != want {
+					t.Errorf("节点 %s 收到 %d 次 %s，应为 %d", node.addr, got, name, want)
+				}
+			}
+		})
+	}
+}
+
+// startObjectCluster 启动按slot返回MOVED的假集群，SET保存value，OBJECT REFCOUNT对存在的key返回1，对不存在的key返回nil
+func startObjectCluster(t *testing.T, n int) *fakeCluster {
+	nodes := make([]*fakeNode, n)
+	for i := range nodes {
+		i := i
+		var mutex sync.Mutex
+		values := make(map[string]string)
+		nodes[i] = startFakeNode(t, func(command []string) string {
+			if len(command) < 2 {
+				return "-ERR wrong number of arguments\r\n"
+			}
+			key := command[1]
+			if strings.EqualFold(command[0], "OBJECT") && len(command) == 3 {
+				key = command[2]
+			}
+			slot := CalculateSlot(key)
+			// 与startFakeMasters相同，节点依次平均分配所有slot
+			owner := 0
+			for owner+1 < n && slot >= (owner+1)*16384/n {
+				owner++
+			}
+			if owner != i {
+				return fmt.Sprintf("-MOVED %d %s\r\n", slot, nodes[owner].addr)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch strings.ToUpper(command[0]) {
+			case "SET":
+				values[key] = command[2]
+				return "+OK\r\n"
+			case "OBJECT":
+				if _, exists := values[key]; !exists {
+					return "$-1\r\n"
+				}
+				if strings.EqualFold(command[1], "FREQ") {
+					return ":0\r\n"
+				}
+				return ":1\r\n"
+			}
+			return "-ERR unknown command\r\n"
+		})
+	}
+	return startFakeMasters(t, nodes, nil)
+}
+
+// TestObjectRefcount OBJECT REFCOUNT发送到key所在的节点并原样返回整数响应，不经过MOVED重定向
+func TestObjectRefcount(t *testing.T) {
+	fc := startObjectCluster(t, 3)
+	client := fc.client(t)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	reply := client.doValue("OBJECT", "REFCOUNT", "foo")
+	if reply.Type != ':' || reply.Int < 1 {
+		t.Fatalf("OBJECT REFCOUNT应返回不小于1的整数，实际为 %+v", reply)
+	}
+	client.expectReply("$-1\r\n", "OBJECT", "REFCOUNT", "missing")
+
+	owner := fc.nodeFor("foo")
+	for _, node := range fc.nodes {
+		want := 0
+		if node == owner {
+			want = 1
+		}
+		count := 0
+		for _, command := range node.received("OBJECT") {
+			if command[2] == "foo" {
+				count++
+			}
+		}
+		if count != want {
+			t.Errorf("节点 %s 收到 %d 次OBJECT REFCOUNT foo，应为 %d", node.addr, count, want)
+		}
+	}
+}
+
+// TestObjectFreq OBJECT FREQ与REFCOUNT一样由subcommandKey按command[2]的key路由，只发送到key所在的节点
+func TestObjectFreq(t *testing.T) {
+	fc := startObjectCluster(t, 3)
+	client := fc.client(t)
+
+	for _, key := range []string{"foo", "bar"} {
+		client.expectReply("+OK\r\n", "SET", key, "value")
+		client.expectReply(":0\r\n", "OBJECT", "FREQ", key)
+	}
+
+	for _, key := range []string{"foo", "bar"} {
+		owner := fc.nodeFor(key)
+		for _, node := range fc.nodes {
+			want := 0
+			if node == owner {
+				want = 1
+			}
+			count := 0
+			for _, command := range node.received("OBJECT") {
+				if strings.EqualFold(command[1], "FREQ") && command[2] == key {
+					count++
+				}
+			}
+			if count != want {
+				t.Errorf("节点 %s 收到 %d 次OBJECT FREQ %s，应为 %d", node.addr, count, key, want)
+			}
+		}
+	}
+}
+
+// TestClusterDownRetry 收到CLUSTERDOWN后退避重试，重试的响应同样处理MOVED；
+// 重试期间只通过RequestRefresh请求刷新，不在每次重试前同步刷新集群信息
+func TestClusterDownRetry(t *testing.T) {
+	target := startFakeNode(t, func(command []string) string { return bulk("from-target") })
+	var mutex sync.Mutex
+	downs := 0
+	source := startFakeNode(t, func(command []string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if command[1] == "down" || downs == 0 {
+			downs++
+			return "-CLUSTERDOWN The cluster is down\r\n"
+		}
+		return "-MOVED 12182 " + target.addr + "\r\n"
+	})
+	client := startFakeMasters(t, []*fakeNode{source}, func(config *Config) {
+		config.ClusterDownMaxRetries = 3
+		config.ClusterDownMaxRetryWait = 20 * time.Millisecond
+	}).client(t)
+
+	t.Run("重试后跟随MOVED", func(t *testing.T) {
+		client.expectReply(bulk("from-target"), "GET", "foo")
+		if got := len(target.received("GET")); got != 1 {
+			t.Errorf("重试收到的MOVED应重定向到目标节点，目标节点收到 %d 次GET", got)
+		}
+	})
+
+	t.Run("重试次数用完返回CLUSTERDOWN", func(t *testing.T) {
+		// 等待上一个子测试触发的刷新的最小间隔结束
+		time.Sleep(eventRefreshMinInterval)
+		before, gets := clusterNodesRequests(source, target), len(source.received("GET"))
+		client.expectReply("-CLUSTERDOWN The cluster is down\r\n", "GET", "down")
+		if got := len(source.received("GET")) - gets; got != 4 {
+			t.Errorf("CLUSTERDOWN应重试3次，节点共收到 %d 次GET", got)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if got := clusterNodesRequests(source, target) - before; got != 1 {
+			t.Errorf("重试期间的刷新请求应合并为1次CLUSTER NODES，实际为 %d", got)
+		}
+	})
+}
+
+// manualTicker 替换代理的newRefreshTicker，记录请求的间隔，由测试手动触发
+type manualTicker struct {
+	ticks chan time.Time
+
+	mutex     sync.Mutex
+	intervals []time.Duration
+	stopped   int
+}
+
+// install 替换proxy创建ticker的函数
+func (m *manualTicker) install(proxy *RedisClusterProxy) {
+	m.ticks = make(chan time.Time)
+	proxy.newRefreshTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.intervals = append(m.intervals, interval)
+		return m.ticks, func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.stopped++
+		}
+	}
+}
+
+// state 返回请求过的间隔和停止次数
+func (m *manualTicker) state() ([]time.Duration, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]time.Duration(nil), m.intervals...), m.stopped
+}
+
+// startRefreshNode 启动CLUSTER NODES返回自身负责所有slot的假节点
+func startRefreshNode(t *testing.T) *fakeNode {
+	var node *fakeNode
+	node = startFakeNode(t, func(command []string) string {
+		return bulk(clusterNodesLine(1, node.addr, "master", "0-16383"))
+	})
+	return node
+}
+
+// TestClusterInfoRefreshLoop 定时检查按cluster_refresh_interval创建ticker，只在集群信息超过cluster_stale_after未更新时刷新，
+// ctx取消后立即退出
+func TestClusterInfoRefreshLoop(t *testing.T) {
+	node := startRefreshNode(t)
+	proxy := NewRedisClusterProxy(&Config{
+		RedisNodes:             []string{node.addr},
+		ClusterRefreshInterval: time.Second,
+		ClusterStaleAfter:      time.Second,
+	})
+	t.Cleanup(proxy.clusterManager.Close)
+	ticker := &manualTicker{}
+	ticker.install(proxy)
+	setTopology(proxy, clusterNodesLine(1, node.addr, "master", "0-16383"))
+	cm := proxy.clusterManager
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		proxy.startClusterInfoRefresh(ctx)
+		close(done)
+	}()
+
+	// ticks没有缓冲，第二次发送完成时第一次已经处理完
+	tick := func() {
+		ticker.ticks <- time.Now()
+		ticker.ticks <- time.Now()
+	}
+	tick()
+	if intervals, _ := ticker.state(); !reflect.DeepEqual(intervals, []time.Duration{time.Second}) {
+		t.Errorf("ticker的间隔应为1s，实际为 %v", intervals)
+	}
+	if got := clusterNodesRequests(node); got != 0 {
+		t.Errorf("集群信息未过期时不应刷新，收到 %d 次CLUSTER NODES", got)
+	}
+
+	// 模拟距上次更新已经过了2秒
+	cm.mutex.Lock()
+	cm.lastUpdate = time.Now().Add(-2 * time.Second)
+	cm.mutex.Unlock()
+	tick()
+	if got := clusterNodesRequests(node); got != 1 {
+		t.Errorf("集群信息过期后应刷新一次，收到 %d 次CLUSTER NODES", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("ctx取消后定时检查没有立即退出")
+	}
+	if _, stopped := ticker.state(); stopped != 1 {
+		t.Errorf("退出时应停止ticker，停止次数为 %d", stopped)
+	}
+}
+
+// TestStartAfterStop Stop立即结束定时检查，之后再次Start使用新的context重新开始定时检查
+func TestStartAfterStop(t *testing.T) {
+	node := startRefreshNode(t)
+	port := freePort(t)
+	proxy := NewRedisClusterProxy(&Config{
+		ProxyPort:        port,
+		ProxyBindAddress: "127.0.0.1",
+		RedisNodes:       []string{node.addr},
+	})
+	ticker := &manualTicker{}
+	ticker.install(proxy)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	for run := 1; run <= 2; run++ {
+		result := make(chan error, 1)
+		go func() { result <- proxy.Start() }()
+		if !waitFor(t, time.Second, func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+			}
+			return err == nil
+		}) {
+			t.Fatalf("第%d次Start后代理没有在 %s 上启动", run, addr)
+		}
+		if !waitFor(t, time.Second, func() bool { intervals, _ := ticker.state(); return len(intervals) == run }) {
+			t.Fatalf("第%d次Start后应启动定时检查", run)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, stopped := ticker.state(); stopped != run-1 {
+			t.Errorf("第%d次Start后定时检查应一直运行到Stop，已停止 %d 次", run, stopped)
+		}
+
+		proxy.Stop()
+		if !waitFor(t, 100*time.Millisecond, func() bool { _, stopped := ticker.state(); return stopped == run }) {
+			t.Errorf("第%d次Stop后定时检查没有立即退出", run)
+		}
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Errorf("第%d次Start返回错误: %v", run, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("第%d次Stop后Start没有返回", run)
+		}
+	}
+}
+
+// TestStopClosesClients Stop断开已接受的客户端连接并等待handleConnection退出，包括阻塞在阻塞命令上的连接
+func TestStopClosesClients(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	idle, blocked := tc.client(t), tc.client(t)
+	idle.expectReply("+PONG\r\n", "PING")
+	blocked.send("BLPOP", "queue", "0")
+	if !waitFor(t, time.Second, func() bool { return len(tc.proxy.clients.list()) == 2 }) {
+		t.Fatal("代理应记录两个客户端连接")
+	}
+
+	tc.proxy.Stop()
+	if got := len(tc.proxy.clients.list()); got != 0 {
+		t.Errorf("Stop返回后所有handleConnection应已退出，仍有 %d 个连接", got)
+	}
+	expectClosed(t, idle)
+	expectClosed(t, blocked)
+}
+
+// TestDumpRestoreBinary DUMP的序列化值经代理原样返回，RESTORE到另一个节点上的key后逐字节相同，包括\r\n和NUL字节
+func TestDumpRestoreBinary(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	if tc.nodeFor("foo") == tc.nodeFor("bar") {
+		t.Fatal("foo和bar应位于不同的节点")
+	}
+
+	value := "head\r\n\x00mid\r\n$3\r\n*1\x00\xff\xfetail\r\n"
+	client.expectReply("+OK\r\n", "SET", "foo", value)
+	dumped := client.doValue("DUMP", "foo")
+	if dumped.Type != '$' || dumped.IsNil {
+		t.Fatalf("DUMP应返回序列化值，实际为 %+v", dumped)
+	}
+
+	client.expectReply("+OK\r\n", "RESTORE", "bar", "0", dumped.Str)
+	if got := client.doValue("GET", "bar"); got.Str != value {
+		t.Errorf("RESTORE的值应与原值逐字节相同，实际为 %q，应为 %q", got.Str, value)
+	}
+	if got, err := tc.nodeFor("bar").Get("bar"); err != nil || got != value {
+		t.Errorf("bar所在节点上的值为 %q（%v），应为 %q", got, err, value)
+	}
+	if got := client.doValue("DUMP", "bar"); got.Str != dumped.Str {
+		t.Errorf("RESTORE后的DUMP应与原来的序列化值相同，实际为 %q，应为 %q", got.Str, dumped.Str)
+	}
+	client.expectErrorPrefix("BUSYKEY", "RESTORE", "bar", "0", dumped.Str)
+}
+
+// TestRunSmokeTest 冒烟测试通过代理依次执行PING、SET、GET，连接失败时返回false
+func TestRunSmokeTest(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	if !runSmokeTest(tc.addr) {
+		t.Error("代理正常时冒烟测试应通过")
+	}
+	if got, _ := tc.nodeFor("proxy:test:key").Get("proxy:test:key"); got != "hello" {
+		t.Errorf("冒烟测试应通过代理写入proxy:test:key，实际为 %q", got)
+	}
+
+	tc.proxy.Stop()
+	if runSmokeTest(tc.addr) {
+		t.Error("代理停止后冒烟测试应失败")
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isSubscribeCommand 判断命令是否会使连接进入订阅模式
+func isSubscribeCommand(cmdName string) bool {
+	switch cmdName {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+		return true
+	}
+	return false
+}
+
+const (
+	pubSubSyncPayload  = "redisclusterproxy-pubsub-sync" // 代理内部发送的PING的参数前缀，用于确认后端已返回之前所有订阅命令的响应，这个PING的响应不转发给客户端
+	pubSubMaxRedirects = 5                               // 分片订阅连续收到MOVED的最大次数
+)
+
+// pubSubSyncTimeout 等待内部PING响应的最长时间，测试中缩短
+var pubSubSyncTimeout = 5 * time.Second
+
+// subscriptionCount 从订阅管理命令的响应（[kind, channel, count]）中取出当前订阅数，其他消息返回false
+func subscriptionCount(value *RespValue) (int64, bool) {
+	if value.Type != '*' || len(value.Array) != 3 || value.Array[2].Type != ':' {
+		return 0, false
+	}
+	switch strings.ToLower(value.Array[0].Str) {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		return value.Array[2].Int, true
+	}
+	return 0, false
+}
+
+// pubSubSyncReply 判断消息是否为代理内部PING的响应并返回PING的参数。订阅模式下PING返回[pong, payload]，
+// 退订全部频道后后端连接回到普通模式，PING直接返回payload
+func pubSubSyncReply(value *RespValue) (string, bool) {
+	payload := value
+	if value.Type == '*' {
+		if len(value.Array) != 2 || !strings.EqualFold(value.Array[0].Str, "pong") {
+			return "", false
+		}
+		payload = value.Array[1]
+	}
+	if payload.Type != '$' || !strings.HasPrefix(payload.Str, pubSubSyncPayload) {
+		return "", false
+	}
+	return payload.Str, true
+}
+
+// shardChannelsError 校验SSUBSCRIBE/SUNSUBSCRIBE的频道：所有频道必须位于同一个slot，slot不为-1时还必须位于该slot。
+// 返回需要写给客户端的错误响应，校验通过时返回空字符串
+func (proxy *RedisClusterProxy) shardChannelsError(command []string, slot int) string {
+	if strings.EqualFold(command[0], "SSUBSCRIBE") && len(command) < 2 {
+		return proxy.protocol.FormatError("wrong number of arguments for 'ssubscribe' command")
+	}
+	if len(command) < 2 {
+		return ""
+	}
+	channels := command[1:]
+	if i := proxy.clusterManager.firstCrossSlotKey(channels); i > 0 {
+		return proxy.protocol.FormatCrossSlotKeysError(channels[0], CalculateSlot(channels[0]), channels[i], CalculateSlot(channels[i]))
+	}
+	if slot >= 0 && CalculateSlot(channels[0]) != slot {
+		return proxy.protocol.FormatError(fmt.Sprintf("分片订阅的频道必须与已订阅的频道位于同一个slot %d", slot))
+	}
+	return ""
+}
+
+// pubSubBackend 订阅模式独占的后端连接。分片订阅收到MOVED时，转发协程将连接替换为新节点的连接，
+// 并在新节点上重新订阅该连接的全部分片频道
+type pubSubBackend struct {
+	mutex    sync.Mutex
+	conn     net.Conn
+	node     string
+	closed   bool
+	channels map[string]bool // 客户端请求订阅的分片频道
+}
+
+// send 发送订阅管理命令，同时记录分片频道
+func (b *pubSubBackend) send(proxy *RedisClusterProxy, command []string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch strings.ToUpper(command[0]) {
+	case "SSUBSCRIBE":
+		for _, channel := range command[1:] {
+			b.channels[channel] = true
+		}
+	case "SUNSUBSCRIBE":
+		if len(command) == 1 {
+			b.channels = make(map[string]bool)
+		}
+		for _, channel := range command[1:] {
+			delete(b.channels, channel)
+		}
+	}
+	return proxy.sendCommandToBackend(b.conn, command)
+}
+
+// close 关闭后端连接，之后不再重新连接
+func (b *pubSubBackend) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.conn.Close()
+}
+
+// resubscribe 连接到新的节点并重新订阅全部分片频道，关闭原来的连接
+func (b *pubSubBackend) resubscribe(proxy *RedisClusterProxy, nodeAddr string) (*bufio.Reader, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil, fmt.Errorf("订阅连接已关闭")
+	}
+
+	conn, err := net.DialTimeout("tcp", nodeAddr, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	b.conn.Close()
+	b.conn, b.node = conn, nodeAddr
+
+	if len(b.channels) > 0 {
+		channels := make([]string, 0, len(b.channels))
+		for channel := range b.channels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+		if err := proxy.sendCommandToBackend(conn, append([]string{"SSUBSCRIBE"}, channels...)); err != nil {
+			return nil, fmt.Errorf("重新订阅分片频道失败: %v", err)
+		}
+	}
+	return bufio.NewReaderSize(conn, proxy.currentConfig().GetReadBufferSize()), nil
+}
+
+// handlePubSubConnection 处理进入订阅模式的客户端连接
+// 为客户端建立独立的后端连接（不使用连接池），转发订阅管理命令，并将推送消息流式转发给客户端。
+// SSUBSCRIBE进入分片订阅模式，后端连接为频道所在slot的master节点，同一连接只能订阅同一个slot的分片频道，
+// 也不能与SUBSCRIBE/PSUBSCRIBE混用。
+// 返回连接是否回到普通模式，以及需要按普通命令处理的命令：客户端执行RESET时取消所有订阅，不返回命令；
+// 退订全部频道后订阅数为0时，收到的第一条非订阅命令使连接回到普通模式并返回该命令
+func (proxy *RedisClusterProxy) handlePubSubConnection(clientConn net.Conn, clientReader *bufio.Reader, command []string) (bool, []string, error) {
+	// 分片订阅的频道在进入订阅模式前已校验
+	sharded := strings.EqualFold(command[0], "SSUBSCRIBE")
+	shardSlot := -1
+	var nodeAddr string
+	if sharded {
+		shardSlot = CalculateSlot(command[1])
+		nodeAddr = proxy.selectNodeByKey("SSUBSCRIBE", command[1])
+	} else {
+		nodeAddr = proxy.clusterManager.GetRandomNode()
+	}
+	if nodeAddr == "" {
+		return false, nil, fmt.Errorf("没有可用的Redis节点")
+	}
+
+	backendConn, err := net.DialTimeout("tcp", nodeAddr, 5*time.Second)
+	if err != nil {
+		return false, nil, fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	backend := &pubSubBackend{conn: backendConn, node: nodeAddr, channels: make(map[string]bool)}
+	defer backend.close()
+
+	LogInfo("客户端 %s 进入订阅模式，后端节点: %s", clientConn.RemoteAddr(), nodeAddr)
+
+	// 后端读取协程和命令处理循环都会写客户端连接
+	var writeMutex sync.Mutex
+	writeClient := func(data string) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		_, err := clientConn.Write([]byte(data))
+		return err
+	}
+
+	// keyspace_relay_patterns中的模式由代理在所有master节点上订阅，不发送到这个后端连接
+	relayed := newRelaySession(proxy.keyspaceRelay, writeClient)
+	defer relayed.close()
+
+	// 持续读取后端推送的消息并转发给客户端，同时按订阅管理命令的响应记录当前订阅数
+	var resetting atomic.Bool
+	var subscriptions atomic.Int64
+	// 每次同步使用不同的PING参数，超时的同步迟到的响应不会被当作下一次同步的响应
+	var syncSeq int
+	var syncExpected atomic.Value
+	syncExpected.Store("")
+	synced := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		backendReader := bufio.NewReaderSize(backendConn, proxy.currentConfig().GetReadBufferSize())
+		redirects := 0
+		for {
+			message, err := proxy.readResponse(backendReader)
+			if err != nil {
+				if err != io.EOF {
+					LogDebug("订阅连接读取后端消息结束: %v", err)
+				}
+				// 后端断开时关闭客户端连接，结束命令处理循环；RESET或退出订阅模式时主动关闭后端连接，客户端连接继续使用
+				if !resetting.Load() {
+					clientConn.Close()
+				}
+				return
+			}
+
+			// 频道所在的slot已迁移到其他节点，在新节点上重新订阅
+			if isMoved, slot, redirectAddr := proxy.protocol.IsMovedError(message); sharded && isMoved && redirects < pubSubMaxRedirects {
+				redirects++
+				LogInfo("客户端 %s 的分片订阅收到MOVED重定向: slot=%s，改为订阅节点 %s", clientConn.RemoteAddr(), slot, redirectAddr)
+				reader, err := backend.resubscribe(proxy, redirectAddr)
+				if err != nil {
+					LogWarn("客户端 %s 重新订阅分片频道失败: %v", clientConn.RemoteAddr(), err)
+					if !resetting.Load() {
+						clientConn.Close()
+					}
+					return
+				}
+				backendReader = reader
+				continue
+			}
+			redirects = 0
+
+			if value, err := proxy.protocol.ParseResponse(message); err == nil {
+				if payload, ok := pubSubSyncReply(value); ok {
+					if payload == syncExpected.Load().(string) {
+						select {
+						case synced <- struct{}{}:
+						default:
+						}
+					}
+					continue
+				}
+				if count, ok := subscriptionCount(value); ok {
+					subscriptions.Store(count)
+					// 客户端看到的订阅数包括转发的订阅
+					if n := relayed.count.Load(); n > 0 {
+						value.Array[2].Int = count + n
+						message = value.Format()
+					}
+				}
+			}
+			if err := writeClient(message); err != nil {
+				LogDebug("转发订阅消息到客户端失败: %v", err)
+				return
+			}
+		}
+	}()
+
+	// closeBackend 关闭后端连接并等待转发协程退出，客户端连接继续使用
+	closeBackend := func() {
+		resetting.Store(true)
+		backend.close()
+		<-done
+	}
+
+	// syncBackend 等待后端返回之前所有订阅命令的响应，确保订阅数是最新的
+	syncBackend := func() bool {
+		syncSeq++
+		payload := fmt.Sprintf("%s-%d", pubSubSyncPayload, syncSeq)
+		syncExpected.Store(payload)
+		// 丢弃更换参数之前已经到达的响应
+		select {
+		case <-synced:
+		default:
+		}
+		if err := backend.send(proxy, []string{"PING", payload}); err != nil {
+			return false
+		}
+		select {
+		case <-synced:
+			return true
+		case <-done:
+			return false
+		case <-time.After(pubSubSyncTimeout):
+			return false
+		}
+	}
+
+	for {
+		if command = relayed.filter(command, subscriptions.Load()); command != nil {
+			if err := backend.send(proxy, command); err != nil {
+				return false, nil, fmt.Errorf("发送订阅命令到后端失败: %v", err)
+			}
+		}
+
+		// 读取下一个客户端命令，订阅模式下只允许订阅管理命令
+		for {
+			command, err = proxy.protocol.ParseCommand(clientReader)
+			if err != nil {
+				backend.close()
+				<-done
+				LogInfo("订阅客户端断开连接: %s", clientConn.RemoteAddr())
+				return false, nil, nil
+			}
+			if len(command) == 0 {
+				continue
+			}
+
+			cmdName := strings.ToUpper(command[0])
+			switch cmdName {
+			case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+				if sharded {
+					writeClient(proxy.protocol.FormatError(fmt.Sprintf("分片订阅模式下不能执行 '%s'", strings.ToLower(cmdName))))
+					continue
+				}
+			case "SSUBSCRIBE", "SUNSUBSCRIBE":
+				if !sharded {
+					writeClient(proxy.protocol.FormatError(fmt.Sprintf("普通订阅模式下不能执行 '%s'", strings.ToLower(cmdName))))
+					continue
+				}
+				if reply := proxy.shardChannelsError(command, shardSlot); reply != "" {
+					writeClient(reply)
+					continue
+				}
+			case "PING":
+			case "QUIT":
+				writeClient(proxy.protocol.FormatSimpleString("OK"))
+				backend.close()
+				<-done
+				return false, nil, nil
+			case "RESET":
+				// 关闭订阅使用的后端连接即取消所有订阅，等转发协程退出后再应答，避免与推送消息交错
+				closeBackend()
+				LogInfo("客户端 %s 执行RESET，退出订阅模式", clientConn.RemoteAddr())
+				return true, nil, writeClient(proxy.protocol.FormatSimpleString("RESET"))
+			default:
+				// 已退订全部频道时连接回到普通模式，该命令由调用方按普通命令处理
+				if syncBackend() && subscriptions.Load()+relayed.count.Load() == 0 {
+					closeBackend()
+					LogInfo("客户端 %s 已退订全部频道，退出订阅模式", clientConn.RemoteAddr())
+					return true, command, nil
+				}
+				writeClient(proxy.protocol.FormatError(fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmdName))))
+				continue
+			}
+			break
+		}
+	}
+}
+
+// handlePubSubIntrospection 处理PUBSUB CHANNELS/NUMSUB/NUMPAT/SHARDCHANNELS/SHARDNUMSUB，返回命令是否已被处理。
+// 客户端的订阅分布在各个节点上（订阅模式随机选择节点），CHANNELS/NUMSUB/NUMPAT需要汇总所有节点，
+// 分片订阅只在master节点上，SHARDCHANNELS/SHARDNUMSUB汇总所有master节点
+func (proxy *RedisClusterProxy) handlePubSubIntrospection(clientConn net.Conn, command []string) (bool, error) {
+	if len(command) < 2 {
+		return false, nil
+	}
+
+	subCommand := strings.ToUpper(command[1])
+	var nodes []string
+	switch subCommand {
+	case "CHANNELS", "NUMSUB", "NUMPAT":
+		nodes = proxy.clusterManager.GetAllNodes()
+	case "SHARDCHANNELS", "SHARDNUMSUB":
+		nodes = proxy.clusterManager.GetMasterNodes()
+	default:
+		return false, nil
+	}
+
+	values, err := proxy.pubSubNodeValues("PUBSUB "+subCommand, nodes, command)
+	if err != nil {
+		return true, err
+	}
+
+	var merged *RespValue
+	switch subCommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		// 去重后按频道名排序
+		seen := make(map[string]bool)
+		var channels []string
+		for _, value := range values {
+			for _, channel := range value.Array {
+				if !seen[channel.Str] {
+					seen[channel.Str] = true
+					channels = append(channels, channel.Str)
+				}
+			}
+		}
+		sort.Strings(channels)
+		merged = &RespValue{Type: '*', Array: make([]*RespValue, len(channels))}
+		for i, channel := range channels {
+			merged.Array[i] = &RespValue{Type: '$', Str: channel}
+		}
+	case "NUMSUB", "SHARDNUMSUB":
+		// 响应为[channel, count, ...]，按参数顺序累加每个频道的订阅数
+		channels := command[2:]
+		merged = &RespValue{Type: '*', Array: make([]*RespValue, 0, len(channels)*2)}
+		for i, channel := range channels {
+			var count int64
+			for _, value := range values {
+				if 2*i+1 < len(value.Array) {
+					count += value.Array[2*i+1].Int
+				}
+			}
+			merged.Array = append(merged.Array, &RespValue{Type: '$', Str: channel}, &RespValue{Type: ':', Int: count})
+		}
+	case "NUMPAT":
+		var count int64
+		for _, value := range values {
+			count += value.Int
+		}
+		merged = &RespValue{Type: ':', Int: count}
+	}
+
+	_, err = clientConn.Write([]byte(merged.Format()))
+	return true, err
+}
+
+// pubSubNodeValues 在nodes上执行PUBSUB子命令，返回成功节点的响应。
+// 有节点失败时返回错误，开启fan_out_best_effort时跳过失败的节点
+func (proxy *RedisClusterProxy) pubSubNodeValues(name string, nodes []string, command []string) ([]*RespValue, error) {
+	results := proxy.executeOnNodes(nodes, command)
+	if !proxy.currentConfig().FanOutBestEffort {
+		if err := failedNodesError(name, results); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]*RespValue, 0, len(results))
+	for _, result := range results {
+		if result.err != nil {
+			LogWarn("%s在节点 %s 执行失败，结果不计入汇总: %v", name, result.address, result.err)
+			continue
+		}
+		values = append(values, result.value)
+	}
+	return values, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pubSubNode 应答PUBSUB CHANNELS/NUMSUB/NUMPAT/SHARDCHANNELS/SHARDNUMSUB的假节点，
+// numsub为每个频道在该节点上的订阅数，shard为分片频道
+func pubSubNode(t *testing.T, numsub map[string]int, numpat int, shard []string) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		if !strings.EqualFold(command[0], "PUBSUB") || len(command) < 2 {
+			return "-ERR unknown command\r\n"
+		}
+		var builder strings.Builder
+		switch strings.ToUpper(command[1]) {
+		case "CHANNELS":
+			fmt.Fprintf(&builder, "*%d\r\n", len(numsub))
+			for channel := range numsub {
+				builder.WriteString(bulk(channel))
+			}
+		case "SHARDCHANNELS":
+			fmt.Fprintf(&builder, "*%d\r\n", len(shard))
+			for _, channel := range shard {
+				builder.WriteString(bulk(channel))
+			}
+		case "NUMSUB", "SHARDNUMSUB":
+			fmt.Fprintf(&builder, "*%d\r\n", 2*len(command[2:]))
+			for _, channel := range command[2:] {
+				fmt.Fprintf(&builder, "%s:%d\r\n", bulk(channel), numsub[channel])
+			}
+		case "NUMPAT":
+			fmt.Fprintf(&builder, ":%d\r\n", numpat)
+		}
+		return builder.String()
+	})
+}
+
+// startPubSubCluster 三个master节点的频道有重叠，第一个master有一个replica
+func startPubSubCluster(t *testing.T, configure func(config *Config)) (*fakeCluster, *fakeNode) {
+	nodes := []*fakeNode{
+		pubSubNode(t, map[string]int{"news": 2, "alerts": 1}, 1, []string{"orders"}),
+		pubSubNode(t, map[string]int{"news": 3, "chat": 1}, 0, []string{"orders", "payments"}),
+		pubSubNode(t, map[string]int{"alerts": 4}, 2, nil),
+	}
+	replica := pubSubNode(t, map[string]int{"replica-only": 5}, 1, []string{"stale"})
+
+	fc := &fakeCluster{nodes: nodes}
+	topology := clusterNodesLine(1, nodes[0].addr, "master", "0-5460") + "\n" +
+		clusterNodesLine(2, nodes[1].addr, "master", "5461-10922") + "\n" +
+		clusterNodesLine(3, nodes[2].addr, "master", "10923-16383") + "\n" +
+		clusterNodesLine(4, replica.addr, "slave", "1")
+	fc.proxy, fc.addr = startTestProxy(t, []string{nodes[0].addr, nodes[1].addr, nodes[2].addr, replica.addr}, topology, configure)
+	return fc, replica
+}
+
+// TestPubSubAggregation CHANNELS返回所有节点去重后的频道，NUMSUB按频道累加，NUMPAT求和；分片订阅只汇总master节点
+func TestPubSubAggregation(t *testing.T) {
+	fc, replica := startPubSubCluster(t, nil)
+	client := fc.client(t)
+
+	channels := func(reply *RespValue) []string {
+		var names []string
+		for _, channel := range reply.Array {
+			names = append(names, channel.Str)
+		}
+		return names
+	}
+	if got := channels(client.doValue("PUBSUB", "CHANNELS")); !reflect.DeepEqual(got, []string{"alerts", "chat", "news", "replica-only"}) {
+		t.Errorf("PUBSUB CHANNELS = %q", got)
+	}
+	client.expectReply("*6\r\n"+bulk("news")+":5\r\n"+bulk("alerts")+":5\r\n"+bulk("nobody")+":0\r\n", "PUBSUB", "NUMSUB", "news", "alerts", "nobody")
+	client.expectReply("*0\r\n", "PUBSUB", "NUMSUB")
+	client.expectReply(":4\r\n", "pubsub", "numpat")
+
+	if got := channels(client.doValue("PUBSUB", "SHARDCHANNELS")); !reflect.DeepEqual(got, []string{"orders", "payments"}) {
+		t.Errorf("PUBSUB SHARDCHANNELS = %q", got)
+	}
+	for _, command := range replica.received("PUBSUB") {
+		if strings.HasPrefix(strings.ToUpper(command[1]), "SHARD") {
+			t.Errorf("分片订阅的命令不应发送到replica: %q", command)
+		}
+	}
+	for _, node := range fc.nodes {
+		if got := len(node.received("PUBSUB")); got != 5 {
+			t.Errorf("master节点 %s 应收到5次PUBSUB，实际为 %d", node.addr, got)
+		}
+	}
+}
+
+// TestPubSubNodeFailure 有节点失败时默认返回错误，开启fan_out_best_effort时只汇总成功的节点
+func TestPubSubNodeFailure(t *testing.T) {
+	fc, _ := startPubSubCluster(t, nil)
+	client := fc.client(t)
+	fc.nodes[2].Close()
+	for _, command := range [][]string{{"PUBSUB", "CHANNELS"}, {"PUBSUB", "NUMSUB", "news"}, {"PUBSUB", "NUMPAT"}} {
+		client.expectErrorPrefix("ERR PUBSUB "+command[1], command...)
+	}
+
+	fc, _ = startPubSubCluster(t, func(config *Config) {
+		config.FanOutBestEffort = true
+	})
+	client = fc.client(t)
+	fc.nodes[2].Close()
+	client.expectReply(":2\r\n", "PUBSUB", "NUMPAT")
+	client.expectReply("*4\r\n"+bulk("news")+":5\r\n"+bulk("alerts")+":1\r\n", "PUBSUB", "NUMSUB", "news", "alerts")
+}
+
+// TestPubSubDelivery 通过代理订阅的客户端收到另一个客户端PUBLISH的消息，退订后回到普通模式
+func TestPubSubDelivery(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	subscriber, publisher := tc.client(t), tc.client(t)
+
+	subscriber.expectReply("*3\r\n"+bulk("subscribe")+bulk("news")+":1\r\n", "SUBSCRIBE", "news")
+	subscriber.expectReply("*3\r\n"+bulk("psubscribe")+bulk("ne*")+":2\r\n", "PSUBSCRIBE", "ne*")
+	publisher.expectReply(":2\r\n", "PUBLISH", "news", "hello\r\nworld")
+	// 频道和模式的消息都会收到，顺序由后端决定
+	got := []string{subscriber.read(), subscriber.read()}
+	sort.Strings(got)
+	want := []string{
+		"*3\r\n" + bulk("message") + bulk("news") + bulk("hello\r\nworld"),
+		"*4\r\n" + bulk("pmessage") + bulk("ne*") + bulk("news") + bulk("hello\r\nworld"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("订阅的客户端应收到message和pmessage，实际为 %q", got)
+	}
+	publisher.expectReply(":0\r\n", "PUBLISH", "other", "ignored")
+
+	subscriber.expectReply("*3\r\n"+bulk("unsubscribe")+bulk("news")+":1\r\n", "UNSUBSCRIBE", "news")
+	subscriber.expectReply("*3\r\n"+bulk("punsubscribe")+bulk("ne*")+":0\r\n", "PUNSUBSCRIBE", "ne*")
+	subscriber.expectReply("+OK\r\n", "SET", "foo", "bar")
+	publisher.expectReply(":0\r\n", "PUBLISH", "news", "after")
+}
+
+// TestPubSubStaleSync 超时的内部PING迟到的响应不会被下一次同步当作自己的响应：
+// 否则下一次同步提前返回，还没有收到SUBSCRIBE的响应就认为已退订全部频道，错误地回到普通模式
+func TestPubSubStaleSync(t *testing.T) {
+	defer func(timeout time.Duration) { pubSubSyncTimeout = timeout }(pubSubSyncTimeout)
+	pubSubSyncTimeout = 300 * time.Millisecond
+
+	pings := 0
+	var mutex sync.Mutex
+	node := startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "SUBSCRIBE":
+			if command[1] == "slow" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return "*3\r\n" + bulk("subscribe") + bulk(command[1]) + ":1\r\n"
+		case "UNSUBSCRIBE":
+			return "*3\r\n" + bulk("unsubscribe") + bulk(command[1]) + ":0\r\n"
+		case "PING":
+			mutex.Lock()
+			pings++
+			first := pings == 1
+			mutex.Unlock()
+			if first {
+				// 第一次同步超时后才返回
+				time.Sleep(500 * time.Millisecond)
+			}
+			return bulk(command[1])
+		}
+		return bulk("value")
+	})
+	client := startFakeMasters(t, []*fakeNode{node}, nil).client(t)
+
+	client.expectReply("*3\r\n"+bulk("subscribe")+bulk("news")+":1\r\n", "SUBSCRIBE", "news")
+	client.expectReply("*3\r\n"+bulk("unsubscribe")+bulk("news")+":0\r\n", "UNSUBSCRIBE", "news")
+	client.expectErrorPrefix("ERR Can't execute 'get'", "GET", "x")
+
+	client.send("SUBSCRIBE", "slow")
+	client.send("GET", "y")
+	if got := client.read(); got != "*3\r\n"+bulk("subscribe")+bulk("slow")+":1\r\n" {
+		t.Errorf("应先收到SUBSCRIBE的响应，实际为 %q", got)
+	}
+	if got := client.read(); !strings.HasPrefix(got, "-ERR Can't execute 'get'") {
+		t.Errorf("订阅数为1时GET应被拒绝，实际为 %q", got)
+	}
+	if got := node.received("GET"); len(got) != 0 {
+		t.Errorf("订阅模式下GET不应发送到后端，实际收到 %q", got)
+	}
+}
+
+// shardNode 模拟分片订阅的假节点：SSUBSCRIBE记录订阅的连接，SPUBLISH向订阅的连接推送smessage；
+// movedTo不为空时表示slot已迁移，两者都返回MOVED
+type shardNode struct {
+	*fakeNode
+
+	mutex       sync.Mutex
+	movedTo     string
+	subscribers map[string][]net.Conn
+}
+
+// startShardNode 启动分片订阅的假节点
+func startShardNode(t *testing.T, movedTo string) *shardNode {
+	node := &shardNode{movedTo: movedTo, subscribers: make(map[string][]net.Conn)}
+	node.fakeNode = startFakeConnNode(t, node.handle)
+	return node
+}
+
+// handle 应答一条命令
+func (node *shardNode) handle(conn net.Conn, command []string) string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	name := strings.ToUpper(command[0])
+	if node.movedTo != "" && (name == "SSUBSCRIBE" || name == "SPUBLISH") {
+		return fmt.Sprintf("-MOVED %d %s\r\n", CalculateSlot(command[1]), node.movedTo)
+	}
+	switch name {
+	case "SSUBSCRIBE":
+		var reply strings.Builder
+		for i, channel := range command[1:] {
+			node.subscribers[channel] = append(node.subscribers[channel], conn)
+			fmt.Fprintf(&reply, "*3\r\n%s%s:%d\r\n", bulk("ssubscribe"), bulk(channel), i+1)
+		}
+		return reply.String()
+	case "SPUBLISH":
+		for _, subscriber := range node.subscribers[command[1]] {
+			subscriber.Write([]byte("*3\r\n" + bulk("smessage") + bulk(command[1]) + bulk(command[2])))
+		}
+		return fmt.Sprintf(":%d\r\n", len(node.subscribers[command[1]]))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// TestShardedPubSubDelivery SSUBSCRIBE在频道所在slot的master节点上订阅，SPUBLISH的消息推送给订阅的客户端；
+// 频道所在的slot已迁移时，代理在MOVED指向的节点上重新订阅，之后的消息从新节点推送
+func TestShardedPubSubDelivery(t *testing.T) {
+	smessage := "*3\r\n" + bulk("smessage") + bulk("orders") + bulk("hello")
+
+	t.Run("频道所在的节点", func(t *testing.T) {
+		node := startShardNode(t, "")
+		fc := startFakeMasters(t, []*fakeNode{node.fakeNode}, nil)
+		subscriber, publisher := fc.client(t), fc.client(t)
+
+		subscriber.expectReply("*3\r\n"+bulk("ssubscribe")+bulk("orders")+":1\r\n", "SSUBSCRIBE", "orders")
+		publisher.expectReply(":1\r\n", "SPUBLISH", "orders", "hello")
+		if got := subscriber.read(); got != smessage {
+			t.Errorf("订阅的客户端应收到smessage，实际为 %q", got)
+		}
+	})
+
+	t.Run("MOVED后重新订阅", func(t *testing.T) {
+		target := startShardNode(t, "")
+		source := startShardNode(t, target.addr)
+		fc := startFakeMasters(t, []*fakeNode{source.fakeNode}, nil)
+		subscriber, publisher := fc.client(t), fc.client(t)
+
+		// MOVED不转发给客户端，客户端收到新节点的订阅响应
+		subscriber.expectReply("*3\r\n"+bulk("ssubscribe")+bulk("orders")+":1\r\n", "SSUBSCRIBE", "orders")
+		if got := target.received("SSUBSCRIBE"); len(got) != 1 || !reflect.DeepEqual(got[0], []string{"SSUBSCRIBE", "orders"}) {
+			t.Errorf("应在新节点上重新订阅orders，新节点收到 %q", got)
+		}
+		publisher.expectReply(":1\r\n", "SPUBLISH", "orders", "hello")
+		if got := subscriber.read(); got != smessage {
+			t.Errorf("重新订阅后应收到新节点推送的smessage，实际为 %q", got)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateLimitEvictInterval 清理空闲令牌桶的检查间隔
+const rateLimitEvictInterval = time.Minute
+
+// tokenBucket 单个客户端IP的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // 上次收到命令并补充令牌的时间
+}
+
+// rateLimiter 按客户端IP限制每秒执行的命令数
+type rateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	limited *prometheus.CounterVec // 每个IP被限流的次数
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// newRateLimiter 创建限流器
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_rate_limited_total",
+			Help: "因超过限流被拒绝的命令数",
+		}, []string{"ip"}),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Allow 从ip的令牌桶中取出一个令牌，桶为空时返回false。
+// rate为每秒补充的令牌数，小于等于0表示不限流；burst为桶的容量，小于等于0时与rate相同
+func (rl *rateLimiter) Allow(ip string, rate int, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	now := time.Now()
+	rl.mutex.Lock()
+	bucket, exists := rl.buckets[ip]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		rl.buckets[ip] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * float64(rate)
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	rl.mutex.Unlock()
+
+	if !allowed {
+		rl.limited.WithLabelValues(ip).Inc()
+	}
+	return allowed
+}
+
+// evictIdle 删除超过idleTimeout没有收到命令的令牌桶及其限流计数，避免内存随客户端IP数量无限增长
+func (rl *rateLimiter) evictIdle(idleTimeout time.Duration) {
+	deadline := time.Now().Add(-idleTimeout)
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	for ip, bucket := range rl.buckets {
+		if bucket.last.Before(deadline) {
+			delete(rl.buckets, ip)
+			rl.limited.DeleteLabelValues(ip)
+		}
+	}
+}
+
+// run 定期清理空闲的令牌桶，直到Close被调用
+func (rl *rateLimiter) run(idleTimeout func() time.Duration) {
+	ticker := time.NewTicker(rateLimitEvictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.evictIdle(idleTimeout())
+		case <-rl.stopChan:
+			return
+		}
+	}
+}
+
+// Close 停止清理空闲令牌桶
+func (rl *rateLimiter) Close() {
+	rl.closeOnce.Do(func() {
+		close(rl.stopChan)
+	})
+}
+
+// clientIP 返回客户端连接的IP地址
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
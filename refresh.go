@@ -0,0 +1,66 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventRefreshMinInterval 由MOVED/ASK/CLUSTERDOWN触发的拓扑刷新的最小间隔，期间的事件合并为一次刷新
+const eventRefreshMinInterval = 2 * time.Second
+
+// 拓扑刷新的触发方式，用于proxy_cluster_refresh_total的trigger标签
+const (
+	refreshTriggerPeriodic = "periodic" // 定时检查发现集群信息过期
+	refreshTriggerEvent    = "event"    // 后端返回MOVED/ASK/CLUSTERDOWN
+)
+
+// newRefreshCounter 创建拓扑刷新次数的计数器，result为ok或error
+func newRefreshCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_cluster_refresh_total",
+		Help: "集群拓扑的刷新次数",
+	}, []string{"trigger", "result"})
+}
+
+// RequestRefresh 请求尽快刷新集群信息，reason为触发刷新的响应类型。不阻塞调用方，
+// 刷新进行中或等待最小间隔期间收到的请求合并为一次
+func (cm *ClusterManager) RequestRefresh(reason string) {
+	select {
+	case cm.refreshEvents <- reason:
+	default:
+	}
+}
+
+// eventRefresher 处理RequestRefresh的请求：收到请求后立即刷新，之后至少等待eventRefreshMinInterval再处理下一个请求。
+// 定时刷新仍然保留，用于没有请求时发现拓扑变化
+func (cm *ClusterManager) eventRefresher() {
+	for {
+		select {
+		case reason := <-cm.refreshEvents:
+			LogInfo("后端返回%s，立即刷新集群信息", reason)
+			if err := cm.refreshFor(refreshTriggerEvent); err != nil {
+				LogWarn("刷新集群信息失败: %v", err)
+			}
+		case <-cm.stopChan:
+			return
+		}
+
+		select {
+		case <-time.After(eventRefreshMinInterval):
+		case <-cm.stopChan:
+			return
+		}
+	}
+}
+
+// refreshFor 刷新集群信息并按触发方式计数
+func (cm *ClusterManager) refreshFor(trigger string) error {
+	err := cm.RefreshClusterInfo()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	cm.refreshes.WithLabelValues(trigger, result).Inc()
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reshardNodes 两个假节点，CLUSTER NODES返回当前的拓扑，source对已迁移的key返回MOVED到target
+type reshardNodes struct {
+	source, target *fakeNode
+
+	mutex    sync.Mutex
+	topology string
+	migrated map[string]bool
+}
+
+// startReshardNodes 启动假节点，初始时source负责所有slot
+func startReshardNodes(t *testing.T) *reshardNodes {
+	r := &reshardNodes{migrated: make(map[string]bool)}
+	r.target = startFakeNode(t, func(command []string) string {
+		if strings.EqualFold(command[0], "CLUSTER") {
+			return r.clusterNodes()
+		}
+		return bulk("from-target")
+	})
+	r.source = startFakeNode(t, func(command []string) string {
+		if strings.EqualFold(command[0], "CLUSTER") {
+			return r.clusterNodes()
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.migrated[command[1]] {
+			return fmt.Sprintf("-MOVED %d %s\r\n", CalculateSlot(command[1]), r.target.addr)
+		}
+		return bulk("from-source")
+	})
+	r.migrate(nil, "0-16383", "")
+	return r
+}
+
+// migrate 将keys标记为已迁移，并更新CLUSTER NODES返回的拓扑
+func (r *reshardNodes) migrate(keys []string, sourceSlots, targetSlots string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, key := range keys {
+		r.migrated[key] = true
+	}
+	r.topology = clusterNodesLine(1, r.source.addr, "master", sourceSlots) + "\n" +
+		clusterNodesLine(2, r.target.addr, "master", targetSlots)
+}
+
+// clusterNodes 返回当前拓扑的CLUSTER NODES响应
+func (r *reshardNodes) clusterNodes() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return bulk(r.topology)
+}
+
+// TestEventRefreshWithinDebounce 最小刷新间隔内收到的MOVED不会丢失，间隔结束后再刷新一次，slot表更新到新的节点
+func TestEventRefreshWithinDebounce(t *testing.T) {
+	r := startReshardNodes(t)
+	proxy, addr := startTestProxy(t, []string{r.source.addr, r.target.addr}, r.topology, nil)
+	client := dialProxy(t, proxy, addr)
+	cm := proxy.clusterManager
+
+	// 第一次MOVED立即刷新，开始最小刷新间隔
+	r.migrate([]string{"bar"}, "0-5060 5062-16383", "5061")
+	client.expectReply(bulk("from-target"), "GET", "bar")
+	if !waitFor(t, time.Second, func() bool { return cm.GetNodeForKey("bar") == r.target.addr }) {
+		t.Fatal("第一次MOVED后slot表应立即更新")
+	}
+	first := time.Now()
+
+	// 间隔内的MOVED仍然自动重定向，刷新推迟到间隔结束
+	r.migrate([]string{"bar", "foo"}, "0-5060 5062-12181 12183-16383", "5061 12182")
+	client.expectReply(bulk("from-target"), "GET", "foo")
+	if cm.GetNodeForKey("foo") != r.source.addr {
+		t.Error("最小刷新间隔内不应再次刷新")
+	}
+	if !waitFor(t, eventRefreshMinInterval+time.Second, func() bool { return cm.GetNodeForKey("foo") == r.target.addr }) {
+		t.Fatal("最小刷新间隔内收到的MOVED应在间隔结束后更新slot表")
+	}
+	if elapsed := time.Since(first); elapsed < eventRefreshMinInterval-100*time.Millisecond {
+		t.Errorf("第二次刷新应等待最小刷新间隔，实际间隔 %v", elapsed)
+	}
+	client.expectReply(bulk("from-target"), "GET", "foo")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configReloadDebounce 配置文件变化后等待的时间，避免在文件写入过程中重新加载
+const configReloadDebounce = 500 * time.Millisecond
+
+// ReloadConfigFile 重新加载配置文件，失败时记录日志并保留当前配置
+func (proxy *RedisClusterProxy) ReloadConfigFile(filename string) {
+	LogInfo("重新加载配置文件: %s", filename)
+	if err := proxy.ReloadConfig(filename); err != nil {
+		LogError("重新加载配置失败，继续使用当前配置: %v", err)
+		return
+	}
+	LogInfo("配置重新加载成功")
+}
+
+// ReloadConfig 读取、校验并应用新的配置。监听地址、节点列表、日志文件和连接池等
+// 在启动时使用的配置需要重启才能生效，这些配置保留原值
+func (proxy *RedisClusterProxy) ReloadConfig(filename string) error {
+	newConfig, err := parseConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := newConfig.ValidateConfig(); err != nil {
+		return fmt.Errorf("配置验证失败: %v", err)
+	}
+
+	oldConfig := proxy.currentConfig()
+	restartOnly := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"proxy_port", &oldConfig.ProxyPort, &newConfig.ProxyPort},
+		{"proxy_bind_address", &oldConfig.ProxyBindAddress, &newConfig.ProxyBindAddress},
+		{"redis_nodes", &oldConfig.RedisNodes, &newConfig.RedisNodes},
+		{"log_file", &oldConfig.LogFile, &newConfig.LogFile},
+		{"log_format", &oldConfig.LogFormat, &newConfig.LogFormat},
+		{"log_max_size_mb", &oldConfig.LogMaxSizeMB, &newConfig.LogMaxSizeMB},
+		{"log_max_backups", &oldConfig.LogMaxBackups, &newConfig.LogMaxBackups},
+		{"health_check_interval", &oldConfig.HealthCheckInterval, &newConfig.HealthCheckInterval},
+		{"cluster_refresh_interval", &oldConfig.ClusterRefreshInterval, &newConfig.ClusterRefreshInterval},
+		{"cluster_stale_after", &oldConfig.ClusterStaleAfter, &newConfig.ClusterStaleAfter},
+		{"topology_change_webhook_url", &oldConfig.TopologyChangeWebhookURL, &newConfig.TopologyChangeWebhookURL},
+		{"pool_max_wait", &oldConfig.PoolMaxWait, &newConfig.PoolMaxWait},
+		{"max_key_size", &oldConfig.MaxKeySize, &newConfig.MaxKeySize},
+		{"max_value_size", &oldConfig.MaxValueSize, &newConfig.MaxValueSize},
+		{"admin_address", &oldConfig.AdminAddress, &newConfig.AdminAddress},
+		{"metrics_histogram_buckets", &oldConfig.MetricsHistogramBuckets, &newConfig.MetricsHistogramBuckets},
+		{"watch_config", &oldConfig.WatchConfig, &newConfig.WatchConfig},
+		{"keyspace_relay_patterns", &oldConfig.KeyspaceRelayPatterns, &newConfig.KeyspaceRelayPatterns},
+	}
+	for _, option := range restartOnly {
+		oldValue, newValue := reflect.ValueOf(option.old).Elem(), reflect.ValueOf(option.new).Elem()
+		if !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			LogWarn("配置项 %s 需要重启才能生效，继续使用原值", option.name)
+			newValue.Set(oldValue)
+		}
+	}
+
+	SetLogLevel(newConfig.LogLevel)
+	proxy.config.Store(newConfig)
+	return nil
+}
+
+// configWatcher 监听配置文件变化
+type configWatcher struct {
+	watcher   *fsnotify.Watcher
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// WatchConfigFile 监听配置文件，文件变化后重新加载配置
+func (proxy *RedisClusterProxy) WatchConfigFile(filename string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建文件监听失败: %v", err)
+	}
+
+	// 监听所在目录而不是文件本身，编辑器保存时常以重命名的方式替换文件
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("解析配置文件路径失败: %v", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("监听配置文件目录失败: %v", err)
+	}
+
+	cw := &configWatcher{watcher: watcher, stopChan: make(chan struct{})}
+	// 与Stop读取watcher互斥，重复调用时关闭之前的监听
+	proxy.mutex.Lock()
+	if proxy.watcher != nil {
+		proxy.watcher.Close()
+	}
+	proxy.watcher = cw
+	proxy.mutex.Unlock()
+	go cw.run(path, func() { proxy.ReloadConfigFile(filename) })
+
+	LogInfo("已开启配置文件监听: %s", path)
+	return nil
+}
+
+// run 处理文件事件，同一文件的连续事件合并为一次重新加载
+func (cw *configWatcher) run(path string, reload func()) {
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-cw.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+			LogDebug("配置文件发生变化: %s", event)
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(configReloadDebounce, reload)
+		case err, ok := <-cw.watcher.Errors:
+			if !ok {
+				return
+			}
+			LogWarn("配置文件监听出错: %v", err)
+		case <-cw.stopChan:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Close 停止监听
+func (cw *configWatcher) Close() {
+	cw.closeOnce.Do(func() {
+		close(cw.stopChan)
+		cw.watcher.Close()
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+)
+
+// backendCommandName 返回客户端命令名在后端节点上使用的名称，没有配置重命名时原样返回
+func (proxy *RedisClusterProxy) backendCommandName(cmdName string) string {
+	for name, renamed := range proxy.currentConfig().CommandRenames {
+		if strings.EqualFold(name, cmdName) {
+			return renamed
+		}
+	}
+	return cmdName
+}
+
+// clientCommandName 将后端节点上重命名后的命令名还原为客户端使用的名称，用于COMMAND系列命令的响应
+func (proxy *RedisClusterProxy) clientCommandName(backendName string) string {
+	for name, renamed := range proxy.currentConfig().CommandRenames {
+		if strings.EqualFold(renamed, backendName) {
+			return strings.ToLower(name)
+		}
+	}
+	return backendName
+}
+
+// renameForBackend 返回发送到后端的命令。路由、key提取等都使用客户端的命令名，
+// 只在写入后端连接前替换命令名，不修改传入的命令
+func (proxy *RedisClusterProxy) renameForBackend(command []string) []string {
+	if len(command) == 0 || len(proxy.currentConfig().CommandRenames) == 0 {
+		return command
+	}
+	renamed := proxy.backendCommandName(command[0])
+	if renamed == command[0] {
+		return command
+	}
+	return append([]string{renamed}, command[1:]...)
+}
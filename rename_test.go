@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// renamedNode 模拟使用rename-command的后端节点：FLUSHALL、CONFIG和GET只能用重命名后的名称执行
+func renamedNode(t *testing.T) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "FLUSHALL_7F3A":
+			return "+OK\r\n"
+		case "CONFIG_7F3A":
+			if len(command) > 1 && strings.EqualFold(command[1], "GET") {
+				return "*2\r\n" + bulk(command[2]) + bulk("100mb")
+			}
+			return "+OK\r\n"
+		case "GET_7F3A":
+			return bulk("value")
+		case "COMMAND":
+			return "*2\r\n*1\r\n" + bulk("flushall_7f3a") + "*1\r\n" + bulk("set")
+		}
+		return "-ERR unknown command '" + command[0] + "'\r\n"
+	})
+}
+
+// renameConfig 配置命令重命名并开启重命名的危险命令
+func renameConfig(config *Config) {
+	config.CommandRenames = map[string]string{"FLUSHALL": "FLUSHALL_7F3A", "config": "CONFIG_7F3A", "GET": "GET_7F3A"}
+	config.AllowedDangerousCommands = []string{"FLUSHALL", "CONFIG"}
+}
+
+// TestRenamedFlushAll 客户端使用FLUSHALL，代理在每个master节点上执行重命名后的命令
+func TestRenamedFlushAll(t *testing.T) {
+	nodes := []*fakeNode{renamedNode(t), renamedNode(t), renamedNode(t)}
+	client := startFakeMasters(t, nodes, renameConfig).client(t)
+
+	client.expectReply("+OK\r\n", "flushall", "ASYNC")
+	for _, node := range nodes {
+		if got := node.received("FLUSHALL_7F3A"); len(got) != 1 || !reflect.DeepEqual(got[0], []string{"FLUSHALL_7F3A", "ASYNC"}) {
+			t.Errorf("节点 %s 应收到重命名后的FLUSHALL ASYNC，实际为 %q", node.addr, got)
+		}
+		if got := node.received("FLUSHALL"); len(got) != 0 {
+			t.Errorf("节点 %s 不应收到原名称的FLUSHALL", node.addr)
+		}
+	}
+}
+
+// TestRenamedConfig 带子命令的CONFIG重命名后子命令和参数原样保留
+func TestRenamedConfig(t *testing.T) {
+	nodes := []*fakeNode{renamedNode(t), renamedNode(t)}
+	client := startFakeMasters(t, nodes, renameConfig).client(t)
+
+	client.expectReply("*2\r\n"+bulk("maxmemory")+bulk("100mb"), "CONFIG", "GET", "maxmemory")
+	client.expectReply("+OK\r\n", "Config", "SET", "maxmemory", "200mb")
+
+	var received [][]string
+	for _, node := range nodes {
+		received = append(received, node.received("CONFIG_7F3A")...)
+		if got := node.received("CONFIG"); len(got) != 0 {
+			t.Errorf("节点 %s 不应收到原名称的CONFIG: %q", node.addr, got)
+		}
+	}
+	want := [][]string{{"CONFIG_7F3A", "GET", "maxmemory"}, {"CONFIG_7F3A", "SET", "maxmemory", "200mb"}}
+	if !reflect.DeepEqual(received, want) && !reflect.DeepEqual(received, [][]string{want[1], want[0]}) {
+		t.Errorf("节点收到的CONFIG命令 = %q, 期望 %q", received, want)
+	}
+}
+
+// TestRenamedDangerousCommandBlocked 重命名的命令按客户端的名称检查危险命令，未开启时不发送到后端
+func TestRenamedDangerousCommandBlocked(t *testing.T) {
+	node := renamedNode(t)
+	client := startFakeMasters(t, []*fakeNode{node}, func(config *Config) {
+		config.CommandRenames = map[string]string{"FLUSHALL": "FLUSHALL_7F3A"}
+	}).client(t)
+
+	client.expectReply("-ERR command disabled by proxy\r\n", "FLUSHALL")
+	client.expectReply("-ERR command disabled by proxy\r\n", "FLUSHALL_7F3A")
+	if got := node.received("FLUSHALL_7F3A"); len(got) != 0 {
+		t.Errorf("未开启的FLUSHALL不应发送到节点: %q", got)
+	}
+}
+
+// TestRenamedCommandRouting 重命名的命令按原命令的key位置路由
+func TestRenamedCommandRouting(t *testing.T) {
+	nodes := []*fakeNode{renamedNode(t), renamedNode(t), renamedNode(t)}
+	fc := startFakeMasters(t, nodes, renameConfig)
+	client := fc.client(t)
+
+	for _, key := range []string{"foo", "bar", "hello"} {
+		client.expectReply(bulk("value"), "GET", key)
+		owner := fc.nodeFor(key)
+		got := owner.received("GET_7F3A")
+		if len(got) == 0 || got[len(got)-1][1] != key {
+			t.Errorf("GET %s应发送到负责该key的节点 %s，实际收到 %q", key, owner.addr, got)
+		}
+	}
+}
+
+// TestRenamedCommandInfo COMMAND响应中重命名后的命令名还原为客户端使用的名称
+func TestRenamedCommandInfo(t *testing.T) {
+	client := startFakeMasters(t, []*fakeNode{renamedNode(t)}, renameConfig).client(t)
+
+	reply := client.doValue("COMMAND")
+	var names []string
+	for _, entry := range reply.Array {
+		names = append(names, entry.Array[0].Str)
+	}
+	if !reflect.DeepEqual(names, []string{"flushall", "set"}) {
+		t.Errorf("COMMAND中的命令名 = %q, 期望 [flushall set]", names)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// replicaPrepareTimeout 在新建的replica连接上执行READONLY的超时时间
+const replicaPrepareTimeout = 5 * time.Second
+
+// prepareBackendConn 初始化连接池新建的连接：到replica的连接先执行READONLY，否则replica对所有命令返回MOVED。
+// 节点在连接建立之后才变为replica时，读命令收到MOVED后按重定向发送到master
+func (proxy *RedisClusterProxy) prepareBackendConn(address string, conn net.Conn) error {
+	if !proxy.clusterManager.IsReplica(address) {
+		return nil
+	}
+
+	if err := proxy.sendCommandToBackend(conn, []string{"READONLY"}); err != nil {
+		return fmt.Errorf("发送READONLY失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(replicaPrepareTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("读取READONLY响应失败: %v", err)
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		return fmt.Errorf("READONLY响应错误: %s", strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// handleReadOnly 处理READONLY/READWRITE，只改变代理为该连接选择读节点的方式，不转发到共用的后端连接
+func (proxy *RedisClusterProxy) handleReadOnly(session *clientSession, cmdName string, command []string) error {
+	if len(command) != 1 {
+		return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmdName))
+	}
+	if cmdName == "READONLY" {
+		session.readFrom = "replica"
+	} else {
+		session.readFrom = "master"
+	}
+	return proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+}
+
+// readNode 按连接的READONLY/READWRITE或全局的read_from为带key的只读命令选择节点，masterAddr为key所在的节点。
+// 从master读取时，master不健康则改用它的健康replica；写命令不经过这里，仍然发送到master
+func (proxy *RedisClusterProxy) readNode(session *clientSession, masterAddr string) string {
+	mode := session.readFrom
+	if mode == "" {
+		mode = proxy.currentConfig().ReadFrom
+	}
+
+	nodeAddr := proxy.clusterManager.GetReadNode(masterAddr, mode)
+	if nodeAddr != masterAddr {
+		session.log.Debug("只读命令发送到replica节点 %s（master %s）", nodeAddr, masterAddr)
+	}
+	return nodeAddr
+}
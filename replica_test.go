@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// replicaNode 对GET返回节点名称、对SET返回OK的假节点
+func replicaNode(t *testing.T, name string) *fakeNode {
+	return startFakeNode(t, func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "GET":
+			return bulk(name)
+		case "SET":
+			return "+OK\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+}
+
+// TestReadFrom 按read_from和连接的READONLY/READWRITE选择GET的节点：发送到replica的连接先执行READONLY，
+// SET始终发送到master，没有健康的replica时读命令仍发送到master
+func TestReadFrom(t *testing.T) {
+	tests := []struct {
+		name           string
+		readFrom       string
+		session        string // 连接上先执行的READONLY或READWRITE
+		replicaFailed  bool
+		wantGetReplies []string
+	}{
+		{"默认", "", "", false, []string{"master"}},
+		{"master", "master", "", false, []string{"master"}},
+		{"replica", "replica", "", false, []string{"replica"}},
+		{"both", "both", "", false, []string{"master", "replica"}},
+		{"replica没有健康的replica", "replica", "", true, []string{"master"}},
+		{"both没有健康的replica", "both", "", true, []string{"master"}},
+		{"READONLY", "master", "READONLY", false, []string{"replica"}},
+		{"READWRITE", "replica", "READWRITE", false, []string{"master"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master, replica := replicaNode(t, "master"), replicaNode(t, "replica")
+			replicaLine := clusterNodesLine(2, replica.addr, "slave", "1")
+			if tt.replicaFailed {
+				replicaLine = strings.Replace(replicaLine, " slave ", " slave,fail ", 1)
+			}
+			topology := clusterNodesLine(1, master.addr, "master", "0-16383") + "\n" + replicaLine
+			proxy, addr := startTestProxy(t, []string{master.addr, replica.addr}, topology, func(config *Config) {
+				config.ReadFrom = tt.readFrom
+			})
+			client := dialProxy(t, proxy, addr)
+			if tt.session != "" {
+				client.expectReply("+OK\r\n", tt.session)
+			}
+
+			// both在master和replica之间随机选择，多次读取覆盖两个节点
+			seen := make(map[string]bool)
+			for i := 0; i < 30; i++ {
+				seen[client.doValue("GET", "foo").Str] = true
+			}
+			var got []string
+			for name := range seen {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantGetReplies) {
+				t.Errorf("GET应发送到 %v，实际为 %v", tt.wantGetReplies, got)
+			}
+
+			client.expectReply("+OK\r\n", "SET", "foo", "bar")
+			if got := replica.received("SET"); len(got) != 0 {
+				t.Errorf("SET不应发送到replica，replica收到 %q", got)
+			}
+			if got := master.received("SET"); len(got) != 1 {
+				t.Errorf("SET应发送到master，master收到 %q", got)
+			}
+
+			// 到replica的连接在第一条命令之前执行READONLY，到master的连接不执行
+			if seen["replica"] {
+				var names []string
+				replica.mutex.Lock()
+				for _, command := range replica.commands {
+					names = append(names, strings.ToUpper(command[0]))
+				}
+				replica.mutex.Unlock()
+				if i := indexOf(names, "READONLY"); i < 0 || i > indexOf(names, "GET") {
+					t.Errorf("replica应在GET之前收到READONLY，实际收到 %v", names)
+				}
+			}
+			if got := master.received("READONLY"); len(got) != 0 {
+				t.Errorf("master不应收到READONLY，实际收到 %d 次", len(got))
+			}
+		})
+	}
+}
+
+// indexOf 返回name在names中第一次出现的位置，不存在时返回-1
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// scanCursorShift 代理游标中节点编号所在的位，低位保存节点返回的原始游标
+const scanCursorShift = 48
+
+// scanCursorTable 节点地址与游标中节点编号的映射。
+// 后端返回的游标只在返回它的节点上有效，代理将节点编号编码进游标，
+// 下一次SCAN时据此找回节点；编号在代理运行期间保持不变
+type scanCursorTable struct {
+	mutex     sync.Mutex
+	ids       map[string]uint64
+	addresses []string
+}
+
+// newScanCursorTable 创建游标映射表
+func newScanCursorTable() *scanCursorTable {
+	return &scanCursorTable{ids: make(map[string]uint64)}
+}
+
+// Encode 将节点地址和节点游标编码为代理游标，编码结果总是非0
+func (t *scanCursorTable) Encode(nodeAddr string, cursor uint64) (string, error) {
+	if cursor >= 1<<scanCursorShift {
+		return "", fmt.Errorf("节点 %s 返回的游标 %d 超出代理游标范围", nodeAddr, cursor)
+	}
+
+	t.mutex.Lock()
+	id, exists := t.ids[nodeAddr]
+	if !exists {
+		t.addresses = append(t.addresses, nodeAddr)
+		id = uint64(len(t.addresses))
+		t.ids[nodeAddr] = id
+	}
+	t.mutex.Unlock()
+
+	if id >= 1<<(64-scanCursorShift) {
+		return "", fmt.Errorf("游标映射的节点数超出范围")
+	}
+	return strconv.FormatUint(id<<scanCursorShift|cursor, 10), nil
+}
+
+// Decode 将代理游标还原为节点地址和节点游标
+func (t *scanCursorTable) Decode(cursor string) (string, uint64, error) {
+	value, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的游标")
+	}
+	id := value >> scanCursorShift
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if id == 0 || id > uint64(len(t.addresses)) {
+		return "", 0, fmt.Errorf("无效的游标")
+	}
+	return t.addresses[id-1], value & (1<<scanCursorShift - 1), nil
+}
+
+// handleScanCommand 处理SCAN/HSCAN/SSCAN/ZSCAN，转换后端节点的游标，返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleScanCommand(clientConn net.Conn, cmdName string, command []string) (bool, error) {
+	switch cmdName {
+	case "SCAN":
+		if len(command) < 2 {
+			return false, nil
+		}
+		return true, proxy.handleScan(clientConn, command)
+	case "HSCAN", "SSCAN", "ZSCAN":
+		if len(command) < 3 {
+			return false, nil
+		}
+		return true, proxy.handleKeyScan(clientConn, command)
+	}
+	return false, nil
+}
+
+// handleScan 依次遍历所有master节点，一个节点遍历结束后游标指向下一个节点，
+// 所有节点都遍历结束时返回游标0
+func (proxy *RedisClusterProxy) handleScan(clientConn net.Conn, command []string) error {
+	masters := proxy.clusterManager.GetMasterNodes()
+	if len(masters) == 0 {
+		return fmt.Errorf("没有可用的master节点")
+	}
+
+	nodeAddr, nodeCursor := masters[0], uint64(0)
+	if command[1] != "0" {
+		var err error
+		if nodeAddr, nodeCursor, err = proxy.scanCursors.Decode(command[1]); err != nil {
+			return err
+		}
+	}
+
+	value, err := proxy.executeScanOnNode(nodeAddr, nodeCursor, command, 1)
+	if err != nil || value.IsError() {
+		return proxy.writeScanFailure(clientConn, value, err)
+	}
+
+	next := value.Array[0].Str
+	if next == "0" {
+		// 当前节点已遍历完，从下一个master节点的游标0继续；拓扑变化导致节点不在列表中时结束遍历
+		for i, address := range masters {
+			if address == nodeAddr && i+1 < len(masters) {
+				if next, err = proxy.scanCursors.Encode(masters[i+1], 0); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	} else if next, err = proxy.encodeNodeCursor(nodeAddr, next); err != nil {
+		return err
+	}
+
+	value.Array[0] = &RespValue{Type: '$', Str: next}
+	_, err = clientConn.Write([]byte(value.Format()))
+	return err
+}
+
+// handleKeyScan 处理HSCAN/SSCAN/ZSCAN，游标0按key路由，后续游标发送到返回它的节点
+func (proxy *RedisClusterProxy) handleKeyScan(clientConn net.Conn, command []string) error {
+	nodeAddr, nodeCursor := "", uint64(0)
+	if command[2] == "0" {
+		nodeAddr = proxy.selectNodeByKey(strings.ToUpper(command[0]), command[1])
+	} else {
+		var err error
+		if nodeAddr, nodeCursor, err = proxy.scanCursors.Decode(command[2]); err != nil {
+			return err
+		}
+	}
+
+	value, err := proxy.executeScanOnNode(nodeAddr, nodeCursor, command, 2)
+	if err != nil || value.IsError() {
+		return proxy.writeScanFailure(clientConn, value, err)
+	}
+
+	if next := value.Array[0].Str; next != "0" {
+		if next, err = proxy.encodeNodeCursor(nodeAddr, next); err != nil {
+			return err
+		}
+		value.Array[0] = &RespValue{Type: '$', Str: next}
+	}
+	_, err = clientConn.Write([]byte(value.Format()))
+	return err
+}
+
+// executeScanOnNode 将command中cursorPos位置的游标替换为节点游标后在节点上执行，
+// 并检查响应是否为[游标, 元素列表]格式
+func (proxy *RedisClusterProxy) executeScanOnNode(nodeAddr string, nodeCursor uint64, command []string, cursorPos int) (*RespValue, error) {
+	nodeCommand := append([]string(nil), command...)
+	nodeCommand[cursorPos] = strconv.FormatUint(nodeCursor, 10)
+
+	response, err := proxy.executeOnNode(nodeAddr, nodeCommand)
+	if err != nil {
+		return nil, err
+	}
+	value, err := proxy.protocol.ParseResponse(response)
+	if err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if !value.IsError() && (value.Type != '*' || len(value.Array) != 2) {
+		return nil, fmt.Errorf("节点 %s 返回了无法识别的%s响应", nodeAddr, strings.ToUpper(command[0]))
+	}
+	return value, nil
+}
+
+// encodeNodeCursor 将节点返回的游标字符串编码为代理游标
+func (proxy *RedisClusterProxy) encodeNodeCursor(nodeAddr string, cursor string) (string, error) {
+	value, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("节点 %s 返回了无效的游标 %q", nodeAddr, cursor)
+	}
+	return proxy.scanCursors.Encode(nodeAddr, value)
+}
+
+// writeScanFailure 将后端的错误响应原样返回给客户端，执行失败时返回错误
+func (proxy *RedisClusterProxy) writeScanFailure(clientConn net.Conn, value *RespValue, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = clientConn.Write([]byte(value.Format()))
+	return err
+}
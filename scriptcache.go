@@ -0,0 +1,112 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// maxCachedScripts 脚本缓存的最大条目数
+const maxCachedScripts = 1024
+
+// scriptEntry 缓存的脚本
+type scriptEntry struct {
+	sha1   string
+	script string
+}
+
+// scriptCache 记录经过代理的Lua脚本（sha1到脚本内容的LRU缓存），
+// 用于在节点返回NOSCRIPT时将EVALSHA改写为EVAL重试
+type scriptCache struct {
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的脚本在前
+	mutex   sync.Mutex
+}
+
+// newScriptCache 创建脚本缓存
+func newScriptCache() *scriptCache {
+	return &scriptCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// scriptSHA1 计算脚本的sha1，与Redis的计算方式一致
+func scriptSHA1(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// Remember 从EVAL/EVAL_RO/SCRIPT LOAD命令中记录脚本内容
+func (sc *scriptCache) Remember(command []string) {
+	cmdName := strings.ToUpper(command[0])
+	switch {
+	case (cmdName == "EVAL" || cmdName == "EVAL_RO") && len(command) > 1:
+		sc.add(command[1])
+	case cmdName == "SCRIPT" && len(command) > 2 && strings.ToUpper(command[1]) == "LOAD":
+		sc.add(command[2])
+	}
+}
+
+// add 添加脚本到缓存，超过容量时淘汰最久未使用的脚本
+func (sc *scriptCache) add(script string) {
+	sha := scriptSHA1(script)
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if element, exists := sc.entries[sha]; exists {
+		sc.order.MoveToFront(element)
+		return
+	}
+
+	sc.entries[sha] = sc.order.PushFront(&scriptEntry{sha1: sha, script: script})
+	if sc.order.Len() > maxCachedScripts {
+		oldest := sc.order.Back()
+		sc.order.Remove(oldest)
+		delete(sc.entries, oldest.Value.(*scriptEntry).sha1)
+	}
+}
+
+// Get 根据sha1获取脚本内容
+func (sc *scriptCache) Get(sha string) (string, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	element, exists := sc.entries[strings.ToLower(sha)]
+	if !exists {
+		return "", false
+	}
+	sc.order.MoveToFront(element)
+	return element.Value.(*scriptEntry).script, true
+}
+
+// RewriteAsEval 将EVALSHA/EVALSHA_RO命令改写为使用缓存脚本的EVAL/EVAL_RO命令
+func (sc *scriptCache) RewriteAsEval(command []string) ([]string, bool) {
+	if len(command) < 2 {
+		return nil, false
+	}
+
+	var evalName string
+	switch strings.ToUpper(command[0]) {
+	case "EVALSHA":
+		evalName = "EVAL"
+	case "EVALSHA_RO":
+		evalName = "EVAL_RO"
+	default:
+		return nil, false
+	}
+
+	script, ok := sc.Get(command[1])
+	if !ok {
+		return nil, false
+	}
+
+	rewritten := make([]string, len(command))
+	copy(rewritten, command)
+	rewritten[0] = evalName
+	rewritten[1] = script
+	return rewritten, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestScriptCacheRewrite 记录EVAL和SCRIPT LOAD的脚本，EVALSHA改写为使用缓存脚本的EVAL
+func TestScriptCacheRewrite(t *testing.T) {
+	sc := newScriptCache()
+	sc.Remember([]string{"eval", "return 1", "0"})
+	sc.Remember([]string{"SCRIPT", "load", "return 2"})
+	sc.Remember([]string{"GET", "return 3"})
+
+	tests := []struct {
+		command []string
+		want    []string
+	}{
+		{[]string{"EVALSHA", scriptSHA1("return 1"), "0"}, []string{"EVAL", "return 1", "0"}},
+		{[]string{"evalsha_ro", scriptSHA1("return 2"), "1", "k"}, []string{"EVAL_RO", "return 2", "1", "k"}},
+		// sha1不区分大小写
+		{[]string{"EVALSHA", "E0E1F9FABFC9D4800C877A703B823AC0578FF8DB", "0"}, []string{"EVAL", "return 1", "0"}},
+		{[]string{"EVALSHA", scriptSHA1("return 3"), "0"}, nil},
+		{[]string{"EVAL", "return 1", "0"}, nil},
+		{[]string{"EVALSHA"}, nil},
+	}
+	for _, test := range tests {
+		got, ok := sc.RewriteAsEval(test.command)
+		if ok != (test.want != nil) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("RewriteAsEval(%v) = %v, %v，应为 %v", test.command, got, ok, test.want)
+		}
+	}
+}
+
+// TestScriptCacheEviction 超过容量时淘汰最久未使用的脚本
+func TestScriptCacheEviction(t *testing.T) {
+	sc := newScriptCache()
+	sc.add("return 0")
+	for i := 1; i < maxCachedScripts; i++ {
+		sc.add(fmt.Sprintf("return %d", i))
+	}
+	// 访问第一个脚本后，最久未使用的是第二个
+	if _, ok := sc.Get(scriptSHA1("return 0")); !ok {
+		t.Fatal("缓存未满时脚本不应被淘汰")
+	}
+	sc.add("return new")
+
+	if _, ok := sc.Get(scriptSHA1("return 0")); !ok {
+		t.Error("最近使用的脚本不应被淘汰")
+	}
+	if _, ok := sc.Get(scriptSHA1("return 1")); ok {
+		t.Error("最久未使用的脚本应被淘汰")
+	}
+	if sc.order.Len() != maxCachedScripts {
+		t.Errorf("缓存条目数应为 %d，实际为 %d", maxCachedScripts, sc.order.Len())
+	}
+}
+
+// TestNoScriptRetry 脚本经节点A执行后，对节点B上的key执行EVALSHA，代理自动改写为EVAL重试
+func TestNoScriptRetry(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	client := tc.client(t)
+	script := "return redis.call('GET', KEYS[1])"
+	sha := scriptSHA1(script)
+
+	keyA, keyB := tc.keyOn(0, "a"), tc.keyOn(1, "b")
+	tc.nodes[0].Set(keyA, "on-a")
+	tc.nodes[1].Set(keyB, "on-b")
+	client.expectReply(bulk("on-a"), "EVAL", script, "1", keyA)
+
+	client.expectReply(bulk("on-b"), "EVALSHA", sha, "1", keyB)
+	client.expectReply(bulk("on-b"), "EVALSHA_RO", sha, "1", keyB)
+
+	// 代理没有缓存的脚本原样返回NOSCRIPT
+	client.expectErrorPrefix("NOSCRIPT", "EVALSHA", scriptSHA1("return 'unknown'"), "1", keyB)
+}
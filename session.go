@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientSession 客户端连接的会话状态
+type clientSession struct {
+	conn   *bufferedConn // 写入客户端的响应先进入缓冲区，由handleConnection刷新
+	reader *bufio.Reader
+	tx     txState        // 事务状态
+	log    *RequestLogger // 当前命令的日志记录器，开启trace_requests时带有请求ID
+
+	lastWriteNode string // 最近一条写命令发送到的节点，WAIT发送到该节点
+	readFrom      string // READONLY设为replica，READWRITE设为master，为空时使用配置的read_from
+
+	sticky          bool        // 是否处于粘性会话模式，命令原样转发到独占的后端连接
+	stickyConn      *pinnedConn // 粘性会话独占的后端连接，不来自连接池
+	stickyReplyOff  bool        // 粘性会话执行了CLIENT REPLY OFF
+	stickySkipReply bool        // 粘性会话执行了CLIENT REPLY SKIP，下一条命令没有响应
+
+	id      int64       // 代理分配的客户端ID，CLIENT LIST中显示
+	created time.Time   // 连接建立的时间
+	stats   clientStats // CLIENT LIST/INFO显示的统计信息
+
+	nameMutex sync.Mutex // 保护name，CLIENT LIST会从其他连接读取
+	name      string     // CLIENT SETNAME设置的名称
+
+	killed atomic.Bool // 连接已被CLIENT KILL断开
+
+	resp3      bool             // 客户端通过HELLO 3切换到了RESP3
+	tracking   *trackingSession // CLIENT TRACKING ON之后的跟踪状态
+	writeMutex sync.Mutex       // handleConnection处理命令期间持有，失效通知只在等待客户端命令时写入
+}
+
+// clientStats 客户端连接的统计信息，命令处理路径上只做原子操作，CLIENT LIST从其他连接读取
+type clientStats struct {
+	lastCommand atomic.Value // 最近执行的命令名
+	lastActive  atomic.Int64 // 最近收到命令的时间，UnixNano
+	commands    atomic.Int64 // 处理的命令数
+	bytesIn     atomic.Int64 // 从客户端读取的字节数
+	bytesOut    atomic.Int64 // 写入客户端的字节数
+}
+
+// reset 清空代理为连接保存的状态，用于RESET命令
+func (session *clientSession) reset() {
+	session.tx.reset()
+	session.lastWriteNode = ""
+	session.readFrom = ""
+	session.setName("")
+	session.resp3 = false
+}
+
+// setName 设置连接的名称，空字符串表示清除
+func (session *clientSession) setName(name string) {
+	session.nameMutex.Lock()
+	session.name = name
+	session.nameMutex.Unlock()
+}
+
+// clientName 返回CLIENT SETNAME设置的名称
+func (session *clientSession) clientName() string {
+	session.nameMutex.Lock()
+	defer session.nameMutex.Unlock()
+	return session.name
+}
+
+// describe 返回用于日志的客户端描述：客户端地址，设置了名称时带上名称
+func (session *clientSession) describe() string {
+	if name := session.clientName(); name != "" {
+		return fmt.Sprintf("%s(%s)", session.conn.RemoteAddr(), name)
+	}
+	return session.conn.RemoteAddr().String()
+}
+
+// kill 关闭客户端连接，阻塞在读取客户端命令上的handleConnection随之返回。可以从其他连接调用
+func (session *clientSession) kill() {
+	session.killed.Store(true)
+	session.conn.Conn.Close()
+}
+
+// touch 记录连接收到的命令，用于CLIENT LIST的cmd、idle和tot-cmds字段
+func (session *clientSession) touch(cmdName string) {
+	session.stats.lastCommand.Store(cmdName)
+	session.stats.lastActive.Store(time.Now().UnixNano())
+	session.stats.commands.Add(1)
+}
+
+// countingConn 统计读写字节数的客户端连接
+type countingConn struct {
+	net.Conn
+	stats *clientStats
+}
+
+// Read 实现net.Conn，累计读取的字节数
+func (c *countingConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	c.stats.bytesIn.Add(int64(n))
+	return n, err
+}
+
+// Write 实现net.Conn，累计写入的字节数
+func (c *countingConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	c.stats.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// bufferedConn 带写缓冲的客户端连接，多个小响应合并为一次写入。内嵌的Conn为不经过缓冲的连接
+type bufferedConn struct {
+	net.Conn
+	writer *bufio.Writer
+}
+
+// Write 将数据写入缓冲区，缓冲区满时才写入连接
+func (c *bufferedConn) Write(data []byte) (int, error) {
+	return c.writer.Write(data)
+}
+
+// Flush 将缓冲区中的数据写入连接
+func (c *bufferedConn) Flush() error {
+	return c.writer.Flush()
+}
+
+// newClientSession 为客户端连接创建会话，readBufferSize和writeBufferSize为客户端连接的读写缓冲区大小
+func newClientSession(conn net.Conn, readBufferSize int, writeBufferSize int) *clientSession {
+	session := &clientSession{created: time.Now()}
+	session.stats.lastActive.Store(session.created.UnixNano())
+
+	counted := &countingConn{Conn: conn, stats: &session.stats}
+	session.conn = &bufferedConn{Conn: counted, writer: bufio.NewWriterSize(counted, writeBufferSize)}
+	session.reader = bufio.NewReaderSize(counted, readBufferSize)
+	return session
+}
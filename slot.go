@@ -0,0 +1,40 @@
+package main
+
+import "strings"
+
+// CalculateSlot 计算key对应的slot (0-16383)，与Redis Cluster的算法一致
+func CalculateSlot(key string) int {
+	return int(CRC16([]byte(hashKeyPart(key)))) % 16384
+}
+
+// hashKeyPart 返回key中参与slot计算的部分：第一个'{'之后存在'}'且两者之间不为空时为hash tag内容，
+// 否则为整个key。例如"{user1000}.following"使用"user1000"，而"{}foo"、"foo{"使用整个key
+func hashKeyPart(key string) string {
+	start := strings.Index(key, "{")
+	if start == -1 {
+		return key
+	}
+	end := strings.Index(key[start+1:], "}")
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// CRC16 实现Redis Cluster使用的CRC16-CCITT (XMODEM)算法
+func CRC16(data []byte) uint16 {
+	var crc uint16 = 0x0000
+	polynomial := uint16(0x1021)
+
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+// TestCRC16 Redis Cluster规范中的CRC16校验值
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		{"123456789", 0x31C3},
+		{"", 0},
+		{"A", 0x58E5},
+	}
+	for _, tt := range tests {
+		if got := CRC16([]byte(tt.data)); got != tt.want {
+			t.Errorf("CRC16(%q) = 0x%04X，应为 0x%04X", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestCalculateSlot 与CLUSTER KEYSLOT的结果一致
+func TestCalculateSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"somekey", 11058},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := CalculateSlot(tt.key); got != tt.want {
+			t.Errorf("CalculateSlot(%q) = %d，应为 %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestHashTag 按Redis Cluster规范提取hash tag
+func TestHashTag(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"{user1000}.following", "user1000"},
+		{"{user1000}.followers", "user1000"},
+		{"foo{bar}", "bar"},
+		// 空的{}不是hash tag，使用整个key
+		{"foo{}{bar}", "foo{}{bar}"},
+		{"{}", "{}"},
+		// 第一个'{'之后到第一个'}'之间的内容
+		{"foo{{bar}}zap", "{bar"},
+		{"foo{bar}{zap}", "bar"},
+		// 没有闭合的'}'
+		{"{", "{"},
+		{"foo{bar", "foo{bar"},
+		{"}foo{", "}foo{"},
+		{"foo}bar{baz}", "baz"},
+		{"", ""},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		if got := hashKeyPart(tt.key); got != tt.want {
+			t.Errorf("hashKeyPart(%q) = %q，应为 %q", tt.key, got, tt.want)
+		}
+		if got, want := CalculateSlot(tt.key), int(CRC16([]byte(tt.want)))%16384; got != want {
+			t.Errorf("CalculateSlot(%q) = %d，应与 %q 相同（%d）", tt.key, got, tt.want, want)
+		}
+	}
+
+	if CalculateSlot("{user1000}.following") != CalculateSlot("{user1000}.followers") {
+		t.Error("带相同hash tag的key应位于同一个slot")
+	}
+	if CalculateSlot("foo{}{bar}") == CalculateSlot("bar") {
+		t.Error("foo{}{bar}应使用整个key计算slot")
+	}
+}
+
+// TestCalculateSlotRange 所有key的slot都在0-16383之间
+func TestCalculateSlotRange(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		key := string(rune(i))
+		if slot := CalculateSlot(key); slot < 0 || slot >= 16384 {
+			t.Fatalf("CalculateSlot(%q) = %d 超出范围", key, slot)
+		}
+	}
+}
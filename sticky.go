@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// stickyDialTimeout 粘性会话建立独占后端连接的超时时间
+const stickyDialTimeout = 5 * time.Second
+
+// handleProxySticky 处理PROXY STICKY ON|OFF，切换当前连接的粘性会话模式
+func (proxy *RedisClusterProxy) handleProxySticky(session *clientSession, command []string) error {
+	if len(command) != 3 {
+		return fmt.Errorf("wrong number of arguments for 'proxy|sticky' command")
+	}
+
+	switch strings.ToUpper(command[2]) {
+	case "ON":
+		if session.tx.active || session.tx.pinned != nil {
+			return fmt.Errorf("事务或WATCH期间不能开启粘性会话")
+		}
+		session.sticky = true
+	case "OFF":
+		proxy.releaseSticky(session)
+		session.sticky = false
+	default:
+		return fmt.Errorf("syntax error")
+	}
+	return proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+}
+
+// executeSticky 将命令原样转发到客户端独占的后端连接。第一次转发时按sticky_node或命令的key选择节点建立连接，
+// 收到MOVED/ASK时改为连接到重定向的节点并重新发送命令
+func (proxy *RedisClusterProxy) executeSticky(session *clientSession, command []string) error {
+	for redirect := 0; ; redirect++ {
+		if session.stickyConn == nil {
+			nodeAddr := proxy.currentConfig().StickyNode
+			if nodeAddr == "" {
+				nodeAddr = proxy.selectBackendNode(command)
+			}
+			if err := proxy.pinSticky(session, nodeAddr); err != nil {
+				return err
+			}
+		}
+		pinned := session.stickyConn
+
+		// CLIENT REPLY OFF/SKIP之后后端不返回响应，只发送命令
+		if !session.stickyExpectsReply(command) {
+			if err := proxy.sendCommandToBackend(pinned.conn, command); err != nil {
+				proxy.releaseSticky(session)
+				return fmt.Errorf("发送命令到后端失败: %v", err)
+			}
+			return nil
+		}
+
+		response, err := proxy.executeBatch(pinned.conn, pinned.reader, [][]string{command})
+		if err != nil {
+			proxy.releaseSticky(session)
+			return err
+		}
+
+		if redirect < 5 {
+			if isMoved, slot, redirectAddr := proxy.protocol.IsMovedError(response); isMoved {
+				session.log.Info("粘性会话收到MOVED重定向: slot=%s，改为连接节点 %s", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("MOVED")
+				proxy.releaseSticky(session)
+				if err := proxy.pinSticky(session, redirectAddr); err != nil {
+					return err
+				}
+				continue
+			}
+			if isAsk, slot, redirectAddr := proxy.protocol.IsAskError(response); isAsk {
+				session.log.Info("粘性会话收到ASK重定向: slot=%s，改为连接节点 %s", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("ASK")
+				proxy.releaseSticky(session)
+				if err := proxy.pinSticky(session, redirectAddr); err != nil {
+					return err
+				}
+				response, err = proxy.executeBatch(session.stickyConn.conn, session.stickyConn.reader, [][]string{{"ASKING"}, command})
+				if err != nil {
+					proxy.releaseSticky(session)
+					return err
+				}
+			}
+		}
+		return proxy.writeClient(session, response)
+	}
+}
+
+// pinSticky 为粘性会话建立到nodeAddr的独占连接。连接不经过连接池，客户端断开或关闭粘性会话时关闭
+func (proxy *RedisClusterProxy) pinSticky(session *clientSession, nodeAddr string) error {
+	conn, err := net.DialTimeout("tcp", nodeAddr, stickyDialTimeout)
+	if err != nil {
+		return fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	session.stickyConn = &pinnedConn{
+		node:   nodeAddr,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, proxy.currentConfig().GetReadBufferSize()),
+	}
+	session.log.Debug("粘性会话独占节点 %s 的后端连接", nodeAddr)
+	return nil
+}
+
+// releaseSticky 关闭粘性会话的独占连接，后端连接上的状态（如CLIENT REPLY）随之失效
+func (proxy *RedisClusterProxy) releaseSticky(session *clientSession) {
+	if session.stickyConn == nil {
+		return
+	}
+	session.stickyConn.conn.Close()
+	session.stickyConn = nil
+	session.stickyReplyOff = false
+	session.stickySkipReply = false
+}
+
+// stickyExpectsReply 按CLIENT REPLY的状态判断后端是否会返回该命令的响应
+func (session *clientSession) stickyExpectsReply(command []string) bool {
+	if len(command) == 3 && strings.EqualFold(command[0], "CLIENT") && strings.EqualFold(command[1], "REPLY") {
+		switch strings.ToUpper(command[2]) {
+		case "ON":
+			session.stickyReplyOff = false
+			session.stickySkipReply = false
+			return true
+		case "OFF":
+			session.stickyReplyOff = true
+			return false
+		case "SKIP":
+			// SKIP本身和下一条命令都没有响应
+			session.stickySkipReply = true
+			return false
+		}
+	}
+
+	if session.stickyReplyOff {
+		return false
+	}
+	if session.stickySkipReply {
+		session.stickySkipReply = false
+		return false
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStickyPinning 粘性会话的命令原样转发到同一个独占的后端连接，不按key路由，也不占用连接池
+func TestStickyPinning(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "PROXY", "STICKY", "ON")
+	owner := tc.nodeFor("foo")
+	before := owner.TotalConnectionCount()
+	client.expectReply("+OK\r\n", "SET", "foo", "1")
+	// 之后的命令都在foo所在的节点上执行，miniredis不返回MOVED
+	client.expectReply("+OK\r\n", "SET", "bar", "2")
+	client.expectReply(bulk("2"), "GET", "bar")
+	client.expectReply("+OK\r\n", "CLIENT", "SETNAME", "sticky-app")
+	client.expectReply(bulk("sticky-app"), "CLIENT", "GETNAME")
+
+	if !owner.Exists("bar") {
+		t.Errorf("粘性会话的命令应发送到独占连接的节点 %s", owner.Addr())
+	}
+	if got := owner.TotalConnectionCount() - before; got != 1 {
+		t.Errorf("粘性会话应只建立一个后端连接，实际新建 %d 个", got)
+	}
+	for _, stats := range tc.proxy.pool.Stats() {
+		if stats.TotalCreated != 0 {
+			t.Errorf("粘性会话不应使用连接池，连接池统计: %+v", stats)
+		}
+	}
+
+	// PROXY命令仍由代理处理
+	client.expectReply("*2\r\n:12182\r\n"+bulk(owner.Addr()), "PROXY", "KEYSLOT", "foo")
+}
+
+// TestStickyNode 配置了sticky_node时粘性会话连接到该节点
+func TestStickyNode(t *testing.T) {
+	tc := newTestCluster(t, 2, func(config *Config) {
+		config.StickySessions = true
+	})
+	tc.updateConfig(func(config *Config) { config.StickyNode = tc.nodes[0].Addr() })
+	client := tc.client(t)
+
+	key := tc.keyOn(1, "k")
+	client.expectReply("+OK\r\n", "SET", key, "v")
+	if !tc.nodes[0].Exists(key) {
+		t.Errorf("粘性会话应连接到sticky_node %s", tc.nodes[0].Addr())
+	}
+}
+
+// TestStickyRepinOnMoved 粘性会话收到MOVED时改为连接重定向的节点并重新发送命令，之后的命令发送到新节点，并请求刷新集群信息
+func TestStickyRepinOnMoved(t *testing.T) {
+	target := startFakeNode(t, func(command []string) string {
+		return bulk("from-target")
+	})
+	source := startFakeNode(t, func(command []string) string {
+		return "-MOVED 12182 " + target.addr + "\r\n"
+	})
+	fc := startFakeMasters(t, []*fakeNode{source, target}, func(config *Config) {
+		config.StickySessions = true
+	})
+	updateProxyConfig(fc.proxy, func(config *Config) { config.StickyNode = source.addr })
+	client := fc.client(t)
+	before := clusterNodesRequests(source, target)
+
+	client.expectReply(bulk("from-target"), "GET", "foo")
+	client.expectReply(bulk("from-target"), "GET", "other")
+	if got := source.received("GET"); !reflect.DeepEqual(got, [][]string{{"GET", "foo"}}) {
+		t.Errorf("原节点应只收到第一条命令，实际为 %q", got)
+	}
+	if got := target.received("GET"); !reflect.DeepEqual(got, [][]string{{"GET", "foo"}, {"GET", "other"}}) {
+		t.Errorf("重定向后的命令应发送到新节点，实际为 %q", got)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return clusterNodesRequests(source, target) > before }) {
+		t.Error("粘性会话收到MOVED后应刷新集群信息")
+	}
+}
+
+// TestStickyCleanup 客户端断开、PROXY STICKY OFF和RESET时关闭独占的后端连接
+func TestStickyCleanup(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	node := tc.nodes[0]
+	idle := node.CurrentConnectionCount()
+
+	closed := func() bool { return node.CurrentConnectionCount() == idle }
+	for _, end := range [][]string{{"PROXY", "STICKY", "OFF"}, {"RESET"}, nil} {
+		client := tc.client(t)
+		client.expectReply("+OK\r\n", "PROXY", "STICKY", "ON")
+		client.expectReply("+PONG\r\n", "PING")
+		if got := node.CurrentConnectionCount(); got != idle+1 {
+			t.Fatalf("粘性会话应独占一个后端连接，节点连接数为 %d，之前为 %d", got, idle)
+		}
+
+		name := "断开"
+		if end != nil {
+			name = strings.Join(end, " ")
+			client.do(end...)
+		} else {
+			client.conn.Close()
+		}
+		if !waitFor(t, 2*time.Second, closed) {
+			t.Errorf("%s之后独占的后端连接应被关闭，节点连接数为 %d", name, node.CurrentConnectionCount())
+		}
+		idle = node.CurrentConnectionCount()
+	}
+}
+
+// TestStickyInvalidatesEncodingCache 粘性会话中的写命令同样删除OBJECT ENCODING缓存，其他客户端不会读到过期的编码
+func TestStickyInvalidatesEncodingCache(t *testing.T) {
+	var mutex sync.Mutex
+	values := make(map[string]string)
+	node := startFakeNode(t, func(command []string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch strings.ToUpper(command[0]) {
+		case "SET":
+			values[command[1]] = command[2]
+			return "+OK\r\n"
+		case "OBJECT":
+			if _, err := strconv.Atoi(values[command[2]]); err == nil {
+				return bulk("int")
+			}
+			return bulk("embstr")
+		}
+		return "-ERR unknown command\r\n"
+	})
+	fc := startFakeMasters(t, []*fakeNode{node}, func(config *Config) {
+		config.EncodingCacheTTL = time.Minute
+	})
+	client := fc.client(t)
+	sticky := fc.client(t)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "1")
+	client.expectReply(bulk("int"), "OBJECT", "ENCODING", "foo")
+	client.expectReply(bulk("int"), "OBJECT", "ENCODING", "foo")
+	if got := len(node.received("OBJECT")); got != 1 {
+		t.Fatalf("第二次OBJECT ENCODING应命中缓存，节点收到 %d 次", got)
+	}
+
+	sticky.expectReply("+OK\r\n", "PROXY", "STICKY", "ON")
+	sticky.expectReply("+OK\r\n", "SET", "foo", "text")
+	client.expectReply(bulk("embstr"), "OBJECT", "ENCODING", "foo")
+	if got := len(node.received("OBJECT")); got != 2 {
+		t.Errorf("粘性会话写入后OBJECT ENCODING应重新发送到节点，节点收到 %d 次", got)
+	}
+}
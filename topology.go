@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	topologyWebhookAttempts = 3                      // webhook的最大发送次数
+	topologyWebhookBackoff  = 500 * time.Millisecond // 第一次重试前的等待时间，之后每次翻倍
+	topologyWebhookTimeout  = 5 * time.Second        // 单次请求的超时时间
+	topologyWebhookQueue    = 16                     // 等待发送的拓扑变化数量，队列满时丢弃新的通知
+)
+
+// topologyChange 集群拓扑变化，作为webhook的JSON请求体
+type topologyChange struct {
+	Timestamp    time.Time    `json:"timestamp"`
+	AddedNodes   []string     `json:"added_nodes"`
+	RemovedNodes []string     `json:"removed_nodes"`
+	SlotChanges  []slotChange `json:"slot_changes"`
+}
+
+// slotChange 一段连续的slot从一个节点转移到另一个节点，from或to为空表示slot之前或之后未分配
+type slotChange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// empty 判断拓扑是否没有变化
+func (change *topologyChange) empty() bool {
+	return len(change.AddedNodes) == 0 && len(change.RemovedNodes) == 0 && len(change.SlotChanges) == 0
+}
+
+// diffTopology 比较刷新前后的节点地址和slot分布
+func diffTopology(oldNodes, newNodes map[string]bool, oldSlots, newSlots *[16384]string) *topologyChange {
+	change := &topologyChange{
+		Timestamp:    time.Now(),
+		AddedNodes:   []string{},
+		RemovedNodes: []string{},
+		SlotChanges:  []slotChange{},
+	}
+	for address := range newNodes {
+		if !oldNodes[address] {
+			change.AddedNodes = append(change.AddedNodes, address)
+		}
+	}
+	for address := range oldNodes {
+		if !newNodes[address] {
+			change.RemovedNodes = append(change.RemovedNodes, address)
+		}
+	}
+	sort.Strings(change.AddedNodes)
+	sort.Strings(change.RemovedNodes)
+
+	// 相邻且来源和目标相同的slot合并为一段
+	for slot := 0; slot < len(oldSlots); slot++ {
+		if oldSlots[slot] == newSlots[slot] {
+			continue
+		}
+		last := len(change.SlotChanges) - 1
+		if last >= 0 && change.SlotChanges[last].End == slot-1 &&
+			change.SlotChanges[last].From == oldSlots[slot] && change.SlotChanges[last].To == newSlots[slot] {
+			change.SlotChanges[last].End = slot
+			continue
+		}
+		change.SlotChanges = append(change.SlotChanges, slotChange{Start: slot, End: slot, From: oldSlots[slot], To: newSlots[slot]})
+	}
+	return change
+}
+
+// topologyNotifier 在后台将拓扑变化POST到webhook，发送失败不影响拓扑刷新
+type topologyNotifier struct {
+	url      string
+	client   *http.Client
+	changes  chan *topologyChange
+	stopChan <-chan struct{}
+}
+
+// newTopologyNotifier 创建拓扑变化通知器并启动后台发送协程，stopChan关闭时停止
+func newTopologyNotifier(url string, stopChan <-chan struct{}) *topologyNotifier {
+	notifier := &topologyNotifier{
+		url:      url,
+		client:   &http.Client{Timeout: topologyWebhookTimeout},
+		changes:  make(chan *topologyChange, topologyWebhookQueue),
+		stopChan: stopChan,
+	}
+	go notifier.run()
+	return notifier
+}
+
+// notify 将拓扑变化放入发送队列，不阻塞调用方，队列已满时丢弃
+func (n *topologyNotifier) notify(change *topologyChange) {
+	select {
+	case n.changes <- change:
+	default:
+		LogWarn("拓扑变化通知队列已满，丢弃本次通知")
+	}
+}
+
+// run 依次发送队列中的拓扑变化
+func (n *topologyNotifier) run() {
+	for {
+		select {
+		case change := <-n.changes:
+			n.deliver(change)
+		case <-n.stopChan:
+			return
+		}
+	}
+}
+
+// deliver 发送一次拓扑变化，失败时按指数退避重试，最多发送topologyWebhookAttempts次
+func (n *topologyNotifier) deliver(change *topologyChange) {
+	body, err := json.Marshal(change)
+	if err != nil {
+		LogError("序列化拓扑变化失败: %v", err)
+		return
+	}
+
+	wait := topologyWebhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(body)
+		if err == nil {
+			LogDebug("拓扑变化通知已发送到 %s", n.url)
+			return
+		}
+		if attempt >= topologyWebhookAttempts {
+			LogError("拓扑变化通知发送失败，已重试%d次: %v", attempt-1, err)
+			return
+		}
+		LogWarn("拓扑变化通知发送失败，%v后第%d次重试: %v", wait, attempt, err)
+
+		select {
+		case <-time.After(wait):
+		case <-n.stopChan:
+			return
+		}
+		wait *= 2
+	}
+}
+
+// post 向webhook发送一次请求，非2xx状态码视为失败
+func (n *topologyNotifier) post(body []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nodeAddresses 返回当前拓扑中所有节点的地址，调用方需持有锁
+func (cm *ClusterManager) nodeAddresses() map[string]bool {
+	addresses := make(map[string]bool, len(cm.nodes))
+	for _, node := range cm.nodes {
+		addresses[node.Address] = true
+	}
+	return addresses
+}
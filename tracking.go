@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	trackingDialTimeout = 5 * time.Second        // 建立跟踪连接的超时时间
+	trackingChannel     = "__redis__:invalidate" // 后端以RESP2发送失效通知的频道
+	proxyVersion        = "1.0.0"                // HELLO返回的代理版本
+)
+
+// trackingSession 开启CLIENT TRACKING的客户端的跟踪状态。代理与后端之间使用RESP2，客户端访问的每个节点上
+// 建立两个独占的连接：一个订阅__redis__:invalidate接收失效通知，另一个以REDIRECT指向它开启CLIENT TRACKING
+// 并执行客户端的命令。失效通知由读取协程转换为RESP3的invalidate推送消息写给客户端
+type trackingSession struct {
+	options []string // CLIENT TRACKING ON之后的选项（不含REDIRECT），原样用于每个节点
+	caching []string // 客户端执行的CLIENT CACHING，与下一条命令一起发送到执行该命令的节点
+
+	nodes  map[string]*trackingNode
+	closed atomic.Bool // 已关闭，读取协程不再写客户端连接
+}
+
+// trackingNode 跟踪会话在一个节点上的连接
+type trackingNode struct {
+	data       *pinnedConn // 开启了CLIENT TRACKING的连接，执行客户端的命令
+	invalidate net.Conn    // 订阅失效通知的连接
+	broken     atomic.Bool // 订阅连接已断开，下次使用时重新建立
+}
+
+// close 关闭节点上的连接
+func (node *trackingNode) close() {
+	node.data.conn.Close()
+	node.invalidate.Close()
+}
+
+// handleHello 处理HELLO [protover [AUTH username password] [SETNAME clientname]]，由代理直接应答，
+// 不能转发到连接池中的连接，否则该连接切换到RESP3后会影响其他客户端。只有开启client_tracking时才接受协议版本3，
+// 此时除推送消息外的响应仍为后端返回的RESP2格式
+func (proxy *RedisClusterProxy) handleHello(session *clientSession, command []string) error {
+	resp3 := session.resp3
+	if len(command) > 1 {
+		version, err := strconv.Atoi(command[1])
+		if err != nil {
+			return fmt.Errorf("Protocol version is not an integer or out of range")
+		}
+		if version != 2 && (version != 3 || !proxy.currentConfig().ClientTracking) {
+			return proxy.writeClient(session, "-NOPROTO unsupported protocol version\r\n")
+		}
+		resp3 = version == 3
+	}
+
+	name, hasName := "", false
+	for i := 2; i < len(command); i++ {
+		switch strings.ToUpper(command[i]) {
+		case "AUTH":
+			if i+2 >= len(command) {
+				return fmt.Errorf("Syntax error in HELLO option 'auth'")
+			}
+			return fmt.Errorf("代理不支持HELLO的AUTH选项")
+		case "SETNAME":
+			if i+1 >= len(command) {
+				return fmt.Errorf("Syntax error in HELLO option 'setname'")
+			}
+			if !validClientName(command[i+1]) {
+				return fmt.Errorf("Client names cannot contain spaces, newlines or special characters.")
+			}
+			name, hasName = command[i+1], true
+			i++
+		default:
+			return fmt.Errorf("Syntax error in HELLO option '%s'", command[i])
+		}
+	}
+
+	if hasName {
+		session.setName(name)
+	}
+	if session.resp3 && !resp3 {
+		// RESP2连接收不到推送消息
+		proxy.releaseTracking(session)
+	}
+	session.resp3 = resp3
+
+	proto := int64(2)
+	if resp3 {
+		proto = 3
+	}
+	reply := &RespValue{Type: '*', Array: []*RespValue{
+		{Type: '$', Str: "server"}, {Type: '$', Str: "redisclusterproxy"},
+		{Type: '$', Str: "version"}, {Type: '$', Str: proxyVersion},
+		{Type: '$', Str: "proto"}, {Type: ':', Int: proto},
+		{Type: '$', Str: "id"}, {Type: ':', Int: session.id},
+		{Type: '$', Str: "mode"}, {Type: '$', Str: "cluster"},
+		{Type: '$', Str: "role"}, {Type: '$', Str: "master"},
+		{Type: '$', Str: "modules"}, {Type: '*', Array: []*RespValue{}},
+	}}
+	if resp3 {
+		reply.Type = '%'
+	}
+	return proxy.writeClient(session, reply.Format())
+}
+
+// handleClientTracking 处理CLIENT TRACKING ON|OFF [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]。
+// 客户端需要先通过HELLO 3切换到RESP3，失效通知以推送消息写到客户端连接上，不支持REDIRECT选项
+func (proxy *RedisClusterProxy) handleClientTracking(session *clientSession, command []string) error {
+	if len(command) < 3 {
+		return fmt.Errorf("wrong number of arguments for 'client|tracking' command")
+	}
+
+	switch strings.ToUpper(command[2]) {
+	case "OFF":
+		proxy.releaseTracking(session)
+		return proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+	case "ON":
+	default:
+		return fmt.Errorf("syntax error")
+	}
+
+	options := command[3:]
+	bcast, optIn, optOut, hasPrefix := false, false, false, false
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(options[i]) {
+		case "BCAST":
+			bcast = true
+		case "OPTIN":
+			optIn = true
+		case "OPTOUT":
+			optOut = true
+		case "NOLOOP":
+		case "PREFIX":
+			if i+1 >= len(options) {
+				return fmt.Errorf("syntax error")
+			}
+			hasPrefix = true
+			i++
+		case "REDIRECT":
+			return fmt.Errorf("代理不支持CLIENT TRACKING的REDIRECT选项，请通过HELLO 3切换到RESP3接收失效通知")
+		default:
+			return fmt.Errorf("syntax error")
+		}
+	}
+	if hasPrefix && !bcast {
+		return fmt.Errorf("PREFIX option requires BCAST mode to be enabled")
+	}
+	if optIn && optOut {
+		return fmt.Errorf("You can't use both OPTIN and OPTOUT")
+	}
+	if bcast && (optIn || optOut) {
+		return fmt.Errorf("OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if !session.resp3 {
+		return fmt.Errorf("CLIENT TRACKING需要先通过HELLO 3切换到RESP3，代理不支持RESP2的REDIRECT方式")
+	}
+
+	// 重新开启时按新的选项建立跟踪，之前节点上的跟踪随连接关闭而失效
+	proxy.releaseTracking(session)
+	session.tracking = &trackingSession{
+		options: options,
+		nodes:   make(map[string]*trackingNode),
+	}
+
+	// BCAST模式下任一master节点上修改匹配前缀的key都要通知客户端，必须在所有master节点上开启跟踪
+	if bcast {
+		for _, nodeAddr := range proxy.clusterManager.GetMasterNodes() {
+			if _, err := proxy.trackingNode(session, nodeAddr); err != nil {
+				proxy.releaseTracking(session)
+				return fmt.Errorf("BCAST模式需要在所有master节点开启跟踪，节点 %s 失败: %v", nodeAddr, err)
+			}
+		}
+	}
+
+	session.log.Info("客户端 %s 开启CLIENT TRACKING，选项: %v", session.describe(), options)
+	return proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+}
+
+// handleClientCaching 处理CLIENT CACHING YES|NO。执行下一条命令的节点在执行时才确定，
+// 代理记录该命令，与下一条命令一起发送
+func (proxy *RedisClusterProxy) handleClientCaching(session *clientSession, command []string) error {
+	if len(command) != 3 {
+		return fmt.Errorf("wrong number of arguments for 'client|caching' command")
+	}
+	tracking := session.tracking
+	optIn, optOut := false, false
+	if tracking != nil {
+		for _, option := range tracking.options {
+			optIn = optIn || strings.EqualFold(option, "OPTIN")
+			optOut = optOut || strings.EqualFold(option, "OPTOUT")
+		}
+	}
+	if !optIn && !optOut {
+		return fmt.Errorf("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+
+	switch strings.ToUpper(command[2]) {
+	case "YES":
+		if !optIn {
+			return fmt.Errorf("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+	case "NO":
+		if !optOut {
+			return fmt.Errorf("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+	default:
+		return fmt.Errorf("syntax error")
+	}
+	tracking.caching = command
+	return proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+}
+
+// executeTracked 在跟踪会话的节点连接上执行命令，收到MOVED/ASK时改为在重定向的节点上执行
+func (proxy *RedisClusterProxy) executeTracked(session *clientSession, command []string, nodeAddr string) error {
+	tracking := session.tracking
+	batch := [][]string{command}
+	if tracking.caching != nil {
+		batch = [][]string{tracking.caching, command}
+		tracking.caching = nil
+	}
+
+	for redirect := 0; ; redirect++ {
+		node, err := proxy.trackingNode(session, nodeAddr)
+		if err != nil {
+			return err
+		}
+		response, err := proxy.executeBatch(node.data.conn, node.data.reader, batch)
+		if err != nil {
+			// 该节点上跟踪的key不会再收到失效通知，通知客户端清空全部缓存
+			proxy.dropTrackingNode(session, nodeAddr)
+			proxy.writeClient(session, formatInvalidatePush(nil))
+			return err
+		}
+
+		if redirect < 5 && proxy.shouldAutoRedirect(command) {
+			if isMoved, slot, redirectAddr := proxy.protocol.IsMovedError(response); isMoved {
+				session.log.Info("跟踪连接收到MOVED重定向: slot=%s，改为在节点 %s 执行", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("MOVED")
+				nodeAddr = redirectAddr
+				continue
+			}
+			if isAsk, slot, redirectAddr := proxy.protocol.IsAskError(response); isAsk {
+				session.log.Info("跟踪连接收到ASK重定向: slot=%s，改为在节点 %s 执行", slot, redirectAddr)
+				proxy.clusterManager.RequestRefresh("ASK")
+				// ASKING只对紧随其后的一条命令有效，放在CLIENT CACHING之后
+				nodeAddr = redirectAddr
+				batch = append(batch[:len(batch)-1:len(batch)-1], []string{"ASKING"}, command)
+				continue
+			}
+		}
+		return proxy.writeClient(session, response)
+	}
+}
+
+// trackingNode 返回跟踪会话在节点上的连接，不存在或订阅连接已断开时重新建立
+func (proxy *RedisClusterProxy) trackingNode(session *clientSession, nodeAddr string) (*trackingNode, error) {
+	tracking := session.tracking
+	if node := tracking.nodes[nodeAddr]; node != nil {
+		if !node.broken.Load() {
+			return node, nil
+		}
+		proxy.dropTrackingNode(session, nodeAddr)
+	}
+
+	node, err := proxy.openTrackingNode(session, nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	tracking.nodes[nodeAddr] = node
+	session.log.Debug("客户端 %s 在节点 %s 上开启跟踪", session.describe(), nodeAddr)
+	return node, nil
+}
+
+// openTrackingNode 在节点上建立订阅失效通知的连接和开启了CLIENT TRACKING REDIRECT的连接，并启动读取失效通知的协程
+func (proxy *RedisClusterProxy) openTrackingNode(session *clientSession, nodeAddr string) (*trackingNode, error) {
+	readBufferSize := proxy.currentConfig().GetReadBufferSize()
+
+	invalidate, err := net.DialTimeout("tcp", nodeAddr, trackingDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	invalidateReader := bufio.NewReaderSize(invalidate, readBufferSize)
+	reply, err := proxy.executeBatch(invalidate, invalidateReader, [][]string{{"CLIENT", "ID"}})
+	if err != nil || !strings.HasPrefix(reply, ":") {
+		invalidate.Close()
+		return nil, fmt.Errorf("获取订阅连接的CLIENT ID失败: %v %s", err, strings.TrimSpace(reply))
+	}
+	redirectID := strings.TrimSpace(reply[1:])
+	if _, err := proxy.executeBatch(invalidate, invalidateReader, [][]string{{"SUBSCRIBE", trackingChannel}}); err != nil {
+		invalidate.Close()
+		return nil, fmt.Errorf("订阅%s失败: %v", trackingChannel, err)
+	}
+
+	data, err := net.DialTimeout("tcp", nodeAddr, trackingDialTimeout)
+	if err != nil {
+		invalidate.Close()
+		return nil, fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+	node := &trackingNode{
+		data:       &pinnedConn{node: nodeAddr, conn: data, reader: bufio.NewReaderSize(data, readBufferSize)},
+		invalidate: invalidate,
+	}
+	if err := proxy.applyClientName(session, data); err != nil {
+		node.close()
+		return nil, err
+	}
+	trackingCommand := append([]string{"CLIENT", "TRACKING", "ON", "REDIRECT", redirectID}, session.tracking.options...)
+	reply, err = proxy.executeBatch(data, node.data.reader, [][]string{trackingCommand})
+	if err != nil {
+		node.close()
+		return nil, err
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		node.close()
+		return nil, fmt.Errorf("%s", strings.TrimPrefix(strings.TrimSpace(reply), "-ERR "))
+	}
+
+	go proxy.forwardInvalidations(session, session.tracking, node, invalidateReader)
+	return node, nil
+}
+
+// forwardInvalidations 读取节点推送的失效通知，转换为invalidate推送消息写给客户端，直到订阅连接关闭。
+// 连接意外断开时发送key为nil的通知使客户端清空全部缓存，节点在下次使用时重新建立
+func (proxy *RedisClusterProxy) forwardInvalidations(session *clientSession, tracking *trackingSession, node *trackingNode, reader *bufio.Reader) {
+	for {
+		message, err := proxy.readResponse(reader)
+		if err != nil {
+			node.broken.Store(true)
+			proxy.writePush(session, tracking, formatInvalidatePush(nil))
+			return
+		}
+		value, err := proxy.protocol.ParseResponse(message)
+		if err != nil || value.Type != '*' || len(value.Array) != 3 || value.Array[0].Str != "message" {
+			continue
+		}
+
+		keys := value.Array[2]
+		if keys.Type != '*' || keys.IsNil {
+			keys = nil
+		}
+		if err := proxy.writePush(session, tracking, formatInvalidatePush(keys)); err != nil {
+			LogDebug("写入客户端 %s 失效通知失败: %v", session.describe(), err)
+		}
+	}
+}
+
+// writePush 在客户端等待命令时写入推送消息。处理命令期间handleConnection持有写锁，推送消息不会插入到响应中
+func (proxy *RedisClusterProxy) writePush(session *clientSession, tracking *trackingSession, push string) error {
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	if tracking.closed.Load() {
+		return nil
+	}
+	if _, err := session.conn.Write([]byte(push)); err != nil {
+		return err
+	}
+	return session.conn.Flush()
+}
+
+// formatInvalidatePush 格式化RESP3的失效通知，keys为nil表示清空全部缓存（FLUSHALL或跟踪连接断开）
+func formatInvalidatePush(keys *RespValue) string {
+	if keys == nil {
+		keys = &RespValue{Type: '_'}
+	}
+	push := &RespValue{Type: '>', Array: []*RespValue{{Type: '$', Str: "invalidate"}, keys}}
+	return push.Format()
+}
+
+// dropTrackingNode 关闭跟踪会话在节点上的连接
+func (proxy *RedisClusterProxy) dropTrackingNode(session *clientSession, nodeAddr string) {
+	if node := session.tracking.nodes[nodeAddr]; node != nil {
+		node.close()
+		delete(session.tracking.nodes, nodeAddr)
+	}
+}
+
+// releaseTracking 关闭客户端的跟踪会话，用于CLIENT TRACKING OFF、RESET和客户端断开，调用时持有写锁
+func (proxy *RedisClusterProxy) releaseTracking(session *clientSession) {
+	tracking := session.tracking
+	if tracking == nil {
+		return
+	}
+	session.tracking = nil
+	tracking.closed.Store(true)
+	for _, node := range tracking.nodes {
+		node.close()
+	}
+}
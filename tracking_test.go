@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trackingHandler 应答跟踪连接初始化命令（CLIENT ID、SUBSCRIBE、CLIENT TRACKING）的假节点handler，其他命令交给handler
+func trackingHandler(handler func(command []string) string) func(command []string) string {
+	return func(command []string) string {
+		switch strings.ToUpper(command[0]) {
+		case "CLIENT":
+			switch strings.ToUpper(command[1]) {
+			case "ID":
+				return ":7\r\n"
+			case "TRACKING", "CACHING":
+				return "+OK\r\n"
+			}
+		case "SUBSCRIBE":
+			return "*3\r\n" + bulk("subscribe") + bulk(command[1]) + ":1\r\n"
+		}
+		return handler(command)
+	}
+}
+
+// startTrackingClient 建立开启了RESP3和CLIENT TRACKING的客户端连接
+func startTrackingClient(t *testing.T, fc *fakeCluster) *testClient {
+	client := fc.client(t)
+	// readResponse不解析RESP3的map，逐个读取HELLO响应的7对字段
+	if reply := client.do("HELLO", "3"); reply != "%7\r\n" {
+		t.Fatalf("HELLO 3应返回map，实际为 %q", reply)
+	}
+	for i := 0; i < 14; i++ {
+		client.read()
+	}
+	client.expectReply("+OK\r\n", "CLIENT", "TRACKING", "ON")
+	return client
+}
+
+// TestTrackingMovedRefresh 跟踪连接收到MOVED时在重定向的节点上执行，并请求刷新集群信息
+func TestTrackingMovedRefresh(t *testing.T) {
+	target := startFakeNode(t, trackingHandler(func(command []string) string {
+		return bulk("from-target")
+	}))
+	source := startFakeNode(t, trackingHandler(func(command []string) string {
+		return "-MOVED 12182 " + target.addr + "\r\n"
+	}))
+	fc := startFakeMasters(t, []*fakeNode{source}, func(config *Config) {
+		config.ClientTracking = true
+	})
+	client := startTrackingClient(t, fc)
+	before := clusterNodesRequests(source, target)
+
+	client.expectReply(bulk("from-target"), "GET", "foo")
+	if got := target.received("GET"); len(got) != 1 {
+		t.Errorf("GET应在重定向的节点上执行，实际收到 %q", got)
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return clusterNodesRequests(source, target) > before }) {
+		t.Error("跟踪连接收到MOVED后应刷新集群信息")
+	}
+}
+
+// invalidationNode 模拟Redis的CLIENT TRACKING REDIRECT：记录开启了跟踪的连接读取过的key，
+// 任一连接SET该key时向订阅__redis__:invalidate的连接发送RESP2格式的失效通知
+type invalidationNode struct {
+	*fakeNode
+
+	mutex      sync.Mutex
+	subscriber net.Conn
+	tracking   map[net.Conn]bool
+	tracked    map[string]bool
+	values     map[string]string
+}
+
+// startInvalidationNode 启动模拟失效通知的假节点
+func startInvalidationNode(t *testing.T) *invalidationNode {
+	node := &invalidationNode{
+		tracking: make(map[net.Conn]bool),
+		tracked:  make(map[string]bool),
+		values:   make(map[string]string),
+	}
+	node.fakeNode = startFakeConnNode(t, node.handle)
+	return node
+}
+
+// handle 应答一条命令
+func (node *invalidationNode) handle(conn net.Conn, command []string) string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	switch strings.ToUpper(command[0]) {
+	case "CLIENT":
+		switch strings.ToUpper(command[1]) {
+		case "ID":
+			return ":7\r\n"
+		case "TRACKING":
+			node.tracking[conn] = strings.EqualFold(command[2], "ON")
+			return "+OK\r\n"
+		}
+	case "SUBSCRIBE":
+		node.subscriber = conn
+		return "*3\r\n" + bulk("subscribe") + bulk(command[1]) + ":1\r\n"
+	case "GET":
+		if node.tracking[conn] {
+			node.tracked[command[1]] = true
+		}
+		value, exists := node.values[command[1]]
+		if !exists {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "SET":
+		node.values[command[1]] = command[2]
+		if node.tracked[command[1]] && node.subscriber != nil {
+			delete(node.tracked, command[1])
+			node.subscriber.Write([]byte("*3\r\n" + bulk("message") + bulk(trackingChannel) + "*1\r\n" + bulk(command[1])))
+		}
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// TestTrackingInvalidationPush 开启跟踪的客户端读取key后，另一个客户端修改该key，失效通知以RESP3推送消息写给前者
+func TestTrackingInvalidationPush(t *testing.T) {
+	node := startInvalidationNode(t)
+	fc := startFakeMasters(t, []*fakeNode{node.fakeNode}, func(config *Config) {
+		config.ClientTracking = true
+	})
+	tracker := startTrackingClient(t, fc)
+	other := fc.client(t)
+
+	other.expectReply("+OK\r\n", "SET", "foo", "v1")
+	tracker.expectReply(bulk("v1"), "GET", "foo")
+	other.expectReply("+OK\r\n", "SET", "foo", "v2")
+
+	// readResponse不解析RESP3的推送类型，逐个读取推送消息的两个元素
+	if got := tracker.read(); got != ">2\r\n" {
+		t.Fatalf("应收到invalidate推送消息，实际为 %q", got)
+	}
+	if got := tracker.read(); got != bulk("invalidate") {
+		t.Errorf("推送消息类型应为invalidate，实际为 %q", got)
+	}
+	if got := tracker.read(); got != "*1\r\n"+bulk("foo") {
+		t.Errorf("失效的key应为[foo]，实际为 %q", got)
+	}
+
+	// 读取新值后重新跟踪，推送消息不影响后续命令的响应
+	tracker.expectReply(bulk("v2"), "GET", "foo")
+}
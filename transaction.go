@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// txState 客户端连接的事务状态
+type txState struct {
+	active  bool   // 是否处于MULTI之后
+	node    string // 事务执行的节点
+	slot    int    // 事务中key所在的slot，hasSlot为false时表示还没有带key的命令
+	hasSlot bool
+	pinned  *pinnedConn // WATCH之后客户端独占的后端连接
+	aborted bool        // 排队时有命令被拒绝，EXEC返回EXECABORT
+	queued  [][]string  // MULTI之后排队的命令
+}
+
+// pinnedConn 由一个客户端独占的后端连接。WATCH、之后的命令以及MULTI/EXEC
+// 必须在同一个后端连接上执行，乐观锁才有意义；粘性会话也使用独占的连接
+type pinnedConn struct {
+	node   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// reset 清空事务状态，独占的后端连接需要先通过releasePinned归还
+func (tx *txState) reset() {
+	tx.active = false
+	tx.node = ""
+	tx.slot = 0
+	tx.hasSlot = false
+	tx.pinned = nil
+	tx.aborted = false
+	tx.queued = nil
+}
+
+// abort 事务中的命令在排队时被拒绝，与Redis一样标记事务，EXEC时放弃执行
+func (tx *txState) abort() {
+	if tx.active {
+		tx.aborted = true
+	}
+}
+
+// handleTransactionCommand 处理事务相关命令以及事务中的排队命令，返回命令是否已被处理
+func (proxy *RedisClusterProxy) handleTransactionCommand(session *clientSession, cmdName string, command []string) (bool, error) {
+	tx := &session.tx
+
+	switch cmdName {
+	case "WATCH":
+		if tx.active {
+			return true, fmt.Errorf("WATCH inside MULTI is not allowed")
+		}
+		if len(command) < 2 {
+			return true, fmt.Errorf("wrong number of arguments for 'watch' command")
+		}
+		return true, proxy.handleWatch(session, command)
+	case "UNWATCH":
+		if !tx.active {
+			proxy.releasePinned(session, false)
+			tx.reset()
+			return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+		}
+	case "MULTI":
+		if tx.active {
+			return true, fmt.Errorf("MULTI calls can not be nested")
+		}
+		tx.active = true
+		return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+	case "DISCARD":
+		if !tx.active {
+			return true, fmt.Errorf("DISCARD without MULTI")
+		}
+		// DISCARD同时取消WATCH
+		proxy.releasePinned(session, false)
+		tx.reset()
+		return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("OK"))
+	case "EXEC":
+		if !tx.active {
+			return true, fmt.Errorf("EXEC without MULTI")
+		}
+		if tx.aborted {
+			proxy.releasePinned(session, false)
+			tx.reset()
+			return true, proxy.writeClient(session, "-EXECABORT Transaction discarded because of previous errors.\r\n")
+		}
+		return true, proxy.execTransaction(session)
+	}
+
+	if !tx.active {
+		return false, nil
+	}
+
+	// 参数校验失败的命令不进入事务
+	if spec := lookupCommand(cmdName); spec != nil {
+		if err := spec.validateArgs(command); err != nil {
+			tx.abort()
+			return true, err
+		}
+	}
+
+	// 事务中的命令先在代理排队，第一个带key的命令决定事务节点，事务只能在同一个节点上执行
+	if crossSlot := proxy.bindTransactionSlot(tx, command); crossSlot != "" {
+		tx.abort()
+		return true, proxy.writeClient(session, crossSlot)
+	}
+	tx.queued = append(tx.queued, command)
+	return true, proxy.writeClient(session, proxy.protocol.FormatSimpleString("QUEUED"))
+}
+
+// bindTransactionSlot 检查命令的key是否与事务已有的key在同一个slot，事务还没有key时由该命令决定事务的slot和节点。
+// key不在同一个slot时返回CROSSSLOT错误响应，命令不会进入事务
+func (proxy *RedisClusterProxy) bindTransactionSlot(tx *txState, command []string) string {
+	spec := lookupCommand(strings.ToUpper(command[0]))
+	if spec == nil {
+		return ""
+	}
+	keys := spec.extractKeys(command)
+	if len(keys) == 0 {
+		return ""
+	}
+
+	if !tx.hasSlot {
+		tx.slot = CalculateSlot(keys[0])
+		tx.hasSlot = true
+		tx.node = proxy.selectBackendNode(command)
+	}
+	for _, key := range keys {
+		if CalculateSlot(key) != tx.slot {
+			LogDebug("事务命令 %s 的key %s 不在事务的slot %d", command[0], key, tx.slot)
+			return proxy.protocol.FormatCrossSlotError()
+		}
+	}
+	return ""
+}
+
+// handleWatch 在被WATCH的key所在节点上独占一个后端连接并执行WATCH，之后该客户端的命令都通过这个连接执行，
+// 直到EXEC、DISCARD、UNWATCH或客户端断开
+func (proxy *RedisClusterProxy) handleWatch(session *clientSession, command []string) error {
+	tx := &session.tx
+	if crossSlot := proxy.bindTransactionSlot(tx, command); crossSlot != "" {
+		if tx.pinned == nil {
+			tx.reset()
+		}
+		return proxy.writeClient(session, crossSlot)
+	}
+
+	if tx.pinned == nil {
+		conn, err := proxy.pool.GetConnection(tx.node)
+		if err != nil {
+			tx.reset()
+			return fmt.Errorf("连接后端Redis失败: %v", err)
+		}
+		tx.pinned = &pinnedConn{node: tx.node, conn: conn, reader: bufio.NewReaderSize(conn, proxy.currentConfig().GetReadBufferSize())}
+		session.log.Debug("WATCH独占节点 %s 的后端连接", tx.node)
+	}
+	return proxy.executeOnPinned(session, command)
+}
+
+// executeOnPinned 在客户端独占的后端连接上执行命令，key必须与WATCH的key位于同一个slot。
+// 独占的连接不处理MOVED/ASK重定向，重定向响应直接返回给客户端
+func (proxy *RedisClusterProxy) executeOnPinned(session *clientSession, command []string) error {
+	if crossSlot := proxy.bindTransactionSlot(&session.tx, command); crossSlot != "" {
+		return proxy.writeClient(session, crossSlot)
+	}
+
+	response, err := proxy.executeBatchOnPinned(session, [][]string{command})
+	if err != nil {
+		return err
+	}
+	return proxy.writeClient(session, response)
+}
+
+// executeBatchOnPinned 在独占的后端连接上依次执行一批命令并返回最后一个命令的响应。
+// 连接出错时关闭连接并清空事务状态，WATCH随之失效
+func (proxy *RedisClusterProxy) executeBatchOnPinned(session *clientSession, batch [][]string) (string, error) {
+	pinned := session.tx.pinned
+	response, err := proxy.executeBatch(pinned.conn, pinned.reader, batch)
+	if err != nil {
+		proxy.releasePinned(session, true)
+		session.tx.reset()
+		return "", err
+	}
+	return response, nil
+}
+
+// releasePinned 归还客户端独占的后端连接。discard为false时先执行UNWATCH再放回连接池，
+// 为true时（客户端断开或连接出错）直接关闭连接
+func (proxy *RedisClusterProxy) releasePinned(session *clientSession, discard bool) {
+	pinned := session.tx.pinned
+	if pinned == nil {
+		return
+	}
+	session.tx.pinned = nil
+
+	if !discard {
+		if _, err := proxy.executeBatch(pinned.conn, pinned.reader, [][]string{{"UNWATCH"}}); err == nil {
+			proxy.pool.ReturnConnection(pinned.node, pinned.conn)
+			return
+		}
+	}
+	proxy.pool.DiscardConnection(pinned.node, pinned.conn)
+}
+
+// executeBatch 将一批命令一次性写入后端连接，依次读取每个命令的响应并返回最后一个响应
+func (proxy *RedisClusterProxy) executeBatch(conn net.Conn, reader *bufio.Reader, batch [][]string) (string, error) {
+	var builder strings.Builder
+	for _, command := range batch {
+		builder.WriteString(proxy.protocol.FormatCommand(proxy.renameForBackend(command)))
+	}
+	if _, err := conn.Write([]byte(builder.String())); err != nil {
+		return "", fmt.Errorf("发送命令到后端失败: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var response string
+	for range batch {
+		var err error
+		response, err = proxy.readResponse(reader)
+		if err != nil {
+			return "", fmt.Errorf("读取后端响应失败: %v", err)
+		}
+	}
+	return response, nil
+}
+
+// execTransaction 将MULTI、排队命令和EXEC作为一批命令发送到事务节点，并返回EXEC的结果。
+// 执行过WATCH时使用独占的连接，EXEC之后后端自动取消WATCH，连接放回连接池
+func (proxy *RedisClusterProxy) execTransaction(session *clientSession) error {
+	tx := &session.tx
+	defer tx.reset()
+
+	batch := make([][]string, 0, len(tx.queued)+2)
+	batch = append(batch, []string{"MULTI"})
+	batch = append(batch, tx.queued...)
+	batch = append(batch, []string{"EXEC"})
+
+	if pinned := tx.pinned; pinned != nil {
+		LogDebug("在节点 %s 的独占连接上执行事务，共 %d 个命令", pinned.node, len(tx.queued))
+		response, err := proxy.executeBatchOnPinned(session, batch)
+		if err != nil {
+			return fmt.Errorf("执行事务失败: %v", err)
+		}
+		tx.pinned = nil
+		proxy.pool.ReturnConnection(pinned.node, pinned.conn)
+		return proxy.writeClient(session, response)
+	}
+
+	nodeAddr := tx.node
+	if nodeAddr == "" {
+		nodeAddr = proxy.clusterManager.GetRandomNode()
+	}
+
+	LogDebug("在节点 %s 上执行事务，共 %d 个命令", nodeAddr, len(tx.queued))
+
+	backendConn, err := proxy.pool.GetConnection(nodeAddr)
+	if err != nil {
+		return fmt.Errorf("连接后端Redis失败: %v", err)
+	}
+
+	// 只把EXEC的结果返回给客户端
+	response, err := proxy.executeBatch(backendConn, bufio.NewReaderSize(backendConn, proxy.currentConfig().GetReadBufferSize()), batch)
+	if err != nil {
+		proxy.pool.DiscardConnection(nodeAddr, backendConn)
+		return fmt.Errorf("执行事务失败: %v", err)
+	}
+	proxy.pool.ReturnConnection(nodeAddr, backendConn)
+
+	return proxy.writeClient(session, response)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTransactionSingleNode 事务的排队命令和EXEC在第一个带key的命令所在的节点上执行
+func TestTransactionSingleNode(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "{user1000}.visits", "1")
+	client.expectReply("+QUEUED\r\n", "INCR", "{user1000}.visits")
+	client.expectReply("+QUEUED\r\n", "GET", "{user1000}.visits")
+	client.expectReply("*3\r\n+OK\r\n:2\r\n"+bulk("2"), "EXEC")
+
+	owner := tc.nodeFor("{user1000}.visits")
+	if got, _ := owner.Get("{user1000}.visits"); got != "2" {
+		t.Errorf("事务应在key所在的节点 %s 上执行，值为 %q", owner.Addr(), got)
+	}
+
+	// 事务结束后恢复按命令路由
+	client.expectReply("+OK\r\n", "SET", "foo", "bar")
+	if !tc.nodeFor("foo").Exists("foo") {
+		t.Error("EXEC之后的命令应按key路由")
+	}
+}
+
+// TestTransactionKeylessPrefix 没有key的命令不决定事务节点，由之后第一个带key的命令决定
+func TestTransactionKeylessPrefix(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "PING")
+	client.expectReply("+QUEUED\r\n", "SET", "mykey", "v")
+	client.expectReply("*2\r\n+PONG\r\n+OK\r\n", "EXEC")
+	if !tc.nodeFor("mykey").Exists("mykey") {
+		t.Error("事务应在mykey所在的节点上执行")
+	}
+}
+
+// TestTransactionCrossSlot 与事务的slot不同的命令返回CROSSSLOT，事务在EXEC时失败且不执行任何命令
+func TestTransactionCrossSlot(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "foo", "1")
+	client.expectErrorPrefix("CROSSSLOT", "SET", "bar", "1")
+	client.expectErrorPrefix("CROSSSLOT", "MSET", "foo", "1", "bar", "2")
+	client.expectErrorPrefix("EXECABORT", "EXEC")
+	for _, node := range tc.nodes {
+		if node.Exists("foo") || node.Exists("bar") {
+			t.Errorf("失败的事务不应在节点 %s 上执行", node.Addr())
+		}
+	}
+
+	// 同一个slot的不同key可以在一个事务中
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "{foo}.a", "1")
+	client.expectReply("+QUEUED\r\n", "SET", "{foo}.b", "2")
+	client.expectReply("*2\r\n+OK\r\n+OK\r\n", "EXEC")
+}
+
+// TestTransactionDiscard DISCARD清空排队的命令
+func TestTransactionDiscard(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "foo", "1")
+	client.expectReply("+OK\r\n", "DISCARD")
+	client.expectReply("-ERR EXEC without MULTI\r\n", "EXEC")
+	if tc.nodeFor("foo").Exists("foo") {
+		t.Error("DISCARD之后排队的命令不应执行")
+	}
+
+	// DISCARD之后可以开始新的事务，slot不受之前的事务限制
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "bar", "1")
+	client.expectReply("*1\r\n+OK\r\n", "EXEC")
+}
+
+// TestExecWithoutMulti 没有MULTI时EXEC返回错误，不转发到后端
+func TestExecWithoutMulti(t *testing.T) {
+	tc := newTestCluster(t, 2, nil)
+	client := tc.client(t)
+	client.expectReply("-ERR EXEC without MULTI\r\n", "EXEC")
+	client.expectReply("-ERR EXEC without MULTI\r\n", "exec")
+	client.expectReply("+PONG\r\n", "PING")
+}
+
+// TestWatchConcurrentModification WATCH的key在EXEC之前被其他客户端修改时EXEC返回nil，事务不执行
+func TestWatchConcurrentModification(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+	other := tc.client(t)
+
+	client.expectReply("+OK\r\n", "SET", "foo", "1")
+	client.expectReply("+OK\r\n", "WATCH", "foo")
+	client.expectReply(bulk("1"), "GET", "foo")
+	other.expectReply("+OK\r\n", "SET", "foo", "changed")
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "foo", "2")
+	client.expectReply("*-1\r\n", "EXEC")
+	if got, _ := tc.nodeFor("foo").Get("foo"); got != "changed" {
+		t.Errorf("WATCH的key被修改后事务不应执行，foo = %q", got)
+	}
+
+	// 没有并发修改时事务正常执行
+	client.expectReply("+OK\r\n", "WATCH", "foo")
+	client.expectReply("+OK\r\n", "MULTI")
+	client.expectReply("+QUEUED\r\n", "SET", "foo", "3")
+	client.expectReply("*1\r\n+OK\r\n", "EXEC")
+	if got, _ := tc.nodeFor("foo").Get("foo"); got != "3" {
+		t.Errorf("foo = %q, 期望 3", got)
+	}
+}
+
+// TestWatchCrossSlot WATCH期间其他slot的key返回CROSSSLOT，同一slot的key在独占连接上执行
+func TestWatchCrossSlot(t *testing.T) {
+	tc := newTestCluster(t, 3, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "WATCH", "{foo}.a")
+	client.expectErrorPrefix("CROSSSLOT", "GET", "bar")
+	client.expectErrorPrefix("CROSSSLOT", "WATCH", "bar")
+	client.expectReply("+OK\r\n", "WATCH", "{foo}.b")
+	client.expectReply("$-1\r\n", "GET", "{foo}.b")
+	client.expectReply("+OK\r\n", "UNWATCH")
+
+	// UNWATCH之后恢复按命令路由
+	client.expectReply("$-1\r\n", "GET", "bar")
+}
+
+// TestWatchDisconnectReleasesConnection 客户端WATCH之后断开时，独占的后端连接被关闭，不占用连接池
+func TestWatchDisconnectReleasesConnection(t *testing.T) {
+	tc := newTestCluster(t, 1, nil)
+	client := tc.client(t)
+
+	client.expectReply("+OK\r\n", "WATCH", "foo")
+	inUse := func() int {
+		stats := tc.proxy.pool.Stats()
+		if len(stats) == 0 {
+			return 0
+		}
+		return stats[0].Connections - stats[0].Idle
+	}
+	if got := inUse(); got != 1 {
+		t.Fatalf("WATCH应独占一个后端连接，使用中的连接数为 %d", got)
+	}
+
+	client.conn.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return inUse() == 0 }) {
+		t.Fatalf("客户端断开后独占的连接应被释放，连接池统计: %+v", tc.proxy.pool.Stats())
+	}
+
+	// UNWATCH、EXEC和DISCARD归还独占的连接
+	other := tc.client(t)
+	other.expectReply("+OK\r\n", "WATCH", "foo")
+	other.expectReply("+OK\r\n", "UNWATCH")
+	other.expectReply("+OK\r\n", "WATCH", "foo")
+	other.expectReply("+OK\r\n", "MULTI")
+	other.expectReply("+OK\r\n", "DISCARD")
+	other.expectReply("+OK\r\n", "WATCH", "foo")
+	other.expectReply("+OK\r\n", "MULTI")
+	other.expectReply("*0\r\n", "EXEC")
+	if got := inUse(); got != 0 {
+		t.Errorf("事务结束后不应有独占的连接，使用中的连接数为 %d", got)
+	}
+}
+
+// TestTxStateAbort abort只在事务中标记事务，reset清空所有状态
+func TestTxStateAbort(t *testing.T) {
+	var tx txState
+	tx.abort()
+	if tx.aborted {
+		t.Error("不在事务中时abort不应标记事务")
+	}
+
+	tx.active = true
+	tx.hasSlot = true
+	tx.slot = 12182
+	tx.node = "127.0.0.1:7000"
+	tx.queued = [][]string{{"SET", "foo", "1"}}
+	tx.abort()
+	if !tx.aborted {
+		t.Error("事务中abort应标记事务")
+	}
+
+	tx.reset()
+	if tx.active || tx.aborted || tx.hasSlot || tx.slot != 0 || tx.node != "" || tx.queued != nil || tx.pinned != nil {
+		t.Errorf("reset之后状态应为空: %+v", tx)
+	}
+}
+
+// TestTransactionStateMachine 事务命令在各状态下的响应与Redis一致
+func TestTransactionStateMachine(t *testing.T) {
+	type step struct {
+		command []string
+		reply   string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"没有MULTI时EXEC", []step{
+			{[]string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+		}},
+		{"没有MULTI时DISCARD", []step{
+			{[]string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+		}},
+		{"嵌套MULTI返回错误但保留事务", []step{
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"SET", "foo", "1"}, "+QUEUED\r\n"},
+			{[]string{"MULTI"}, "-ERR MULTI calls can not be nested\r\n"},
+			{[]string{"INCR", "foo"}, "+QUEUED\r\n"},
+			{[]string{"EXEC"}, "*2\r\n+OK\r\n:2\r\n"},
+		}},
+		{"排队时参数校验失败导致EXECABORT", []step{
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"SET", "foo", "1"}, "+QUEUED\r\n"},
+			{[]string{"EVAL", "return 1", "2", "foo"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+			{[]string{"INCR", "foo"}, "+QUEUED\r\n"},
+			{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+			{[]string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+			{[]string{"GET", "foo"}, "$-1\r\n"},
+		}},
+		{"EXECABORT之后可以开始新事务", []step{
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"EVAL", "return 1", "2", "foo"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+			{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"SET", "foo", "1"}, "+QUEUED\r\n"},
+			{[]string{"EXEC"}, "*1\r\n+OK\r\n"},
+		}},
+		{"DISCARD清除EXECABORT标记", []step{
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"EVAL", "return 1", "2", "foo"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+			{[]string{"DISCARD"}, "+OK\r\n"},
+			{[]string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"EXEC"}, "*0\r\n"},
+		}},
+		{"事务中WATCH返回错误但不放弃事务", []step{
+			{[]string{"MULTI"}, "+OK\r\n"},
+			{[]string{"WATCH", "foo"}, "-ERR WATCH inside MULTI is not allowed\r\n"},
+			{[]string{"SET", "foo", "1"}, "+QUEUED\r\n"},
+			{[]string{"EXEC"}, "*1\r\n+OK\r\n"},
+		}},
+		{"命令名不区分大小写", []step{
+			{[]string{"multi"}, "+OK\r\n"},
+			{[]string{"set", "foo", "1"}, "+QUEUED\r\n"},
+			{[]string{"Multi"}, "-ERR MULTI calls can not be nested\r\n"},
+			{[]string{"exec"}, "*1\r\n+OK\r\n"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestCluster(t, 1, nil).client(t)
+			for _, step := range tt.steps {
+				client.expectReply(step.reply, step.command...)
+			}
+		})
+	}
+}